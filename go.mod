@@ -15,7 +15,7 @@ require (
 	github.com/ashanbrown/makezero v0.0.0-20210520155254-b6261585ddde // indirect
 	github.com/aws/aws-sdk-go v1.38.45 // indirect
 	github.com/aws/aws-sdk-go-v2/feature/s3/manager v1.2.1 // indirect
-	github.com/cenkalti/backoff/v4 v4.1.0 // indirect
+	github.com/cenkalti/backoff/v4 v4.1.0
 	github.com/charithe/durationcheck v0.0.7 // indirect
 	github.com/chavacava/garif v0.0.0-20210405164556-e8a0a408d6af // indirect
 	github.com/cockroachdb/cockroach-go v2.0.1+incompatible // indirect
@@ -60,10 +60,10 @@ require (
 	github.com/kr/pretty v0.2.1 // indirect
 	github.com/kshvakov/clickhouse v1.3.4 // indirect
 	github.com/ktrysmt/go-bitbucket v0.9.12 // indirect
-	github.com/lib/pq v1.10.2 // indirect
+	github.com/lib/pq v1.10.2
 	github.com/magiconair/properties v1.8.5 // indirect
 	github.com/mattn/go-runewidth v0.0.12 // indirect
-	github.com/mattn/go-sqlite3 v1.14.7 // indirect
+	github.com/mattn/go-sqlite3 v1.14.7
 	github.com/mitchellh/mapstructure v1.4.1 // indirect
 	github.com/mongodb/mongo-go-driver v0.1.0 // indirect
 	github.com/mutecomm/go-sqlcipher/v4 v4.4.2 // indirect
@@ -93,7 +93,7 @@ require (
 	go.mongodb.org/mongo-driver v1.5.2 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a // indirect
-	golang.org/x/net v0.0.0-20210521195947-fe42d452be8f // indirect
+	golang.org/x/net v0.0.0-20210521195947-fe42d452be8f
 	golang.org/x/sys v0.0.0-20210521203332-0cec03c779c1 // indirect
 	golang.org/x/text v0.3.6 // indirect
 	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba // indirect
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Compile-time check for ensuring Graph implements graph.Graph.
+var _ graph.Graph = (*Graph)(nil)
+
+// Stats contains the counters maintained by a caching Graph.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// Graph is a graph.Graph decorator that serves FindLink calls from a
+// size-bounded LRU cache. Cached links are invalidated when they are mutated
+// through the decorator; mutations applied by other clients of the wrapped
+// graph only become visible once the cached entries expire.
+type Graph struct {
+	graph.Graph
+
+	mu    sync.Mutex
+	lru   *lruCache
+	gen   uint64
+	nowFn func() time.Time
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// NewGraph returns a new Graph that caches up to size links from g for at
+// most ttl each. A zero ttl disables expiration.
+func NewGraph(g graph.Graph, size int, ttl time.Duration) *Graph {
+	return &Graph{
+		Graph: g,
+		lru:   newLRUCache(size, ttl),
+		nowFn: time.Now,
+	}
+}
+
+// Stats returns the current values of the cache counters.
+func (g *Graph) Stats() Stats {
+	return Stats{
+		Hits:      atomic.LoadUint64(&g.hits),
+		Misses:    atomic.LoadUint64(&g.misses),
+		Evictions: atomic.LoadUint64(&g.evictions),
+	}
+}
+
+// FindLink looks up a link by its ID, consulting the cache first.
+func (g *Graph) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	g.mu.Lock()
+	link, found := g.lru.get(id, g.nowFn())
+	gen := g.gen
+	g.mu.Unlock()
+
+	if found {
+		atomic.AddUint64(&g.hits, 1)
+		return copyLink(link), nil
+	}
+	atomic.AddUint64(&g.misses, 1)
+
+	link, err := g.Graph.FindLink(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Links resolved through an alias are not cached as they would need
+	// to be invalidated whenever the canonical link changes.
+	if link.ID != id {
+		return link, nil
+	}
+
+	// Skip populating the cache if an invalidation took place while the
+	// link was being fetched as the result might already be stale.
+	g.mu.Lock()
+	if g.gen == gen {
+		if evicted := g.lru.put(id, copyLink(link), g.nowFn()); evicted != 0 {
+			atomic.AddUint64(&g.evictions, uint64(evicted))
+		}
+	}
+	g.mu.Unlock()
+	return link, nil
+}
+
+// UpsertLink upserts the link to the wrapped graph and invalidates any
+// cached copy of it.
+func (g *Graph) UpsertLink(ctx context.Context, link *graph.Link) error {
+	err := g.Graph.UpsertLink(ctx, link)
+	g.invalidate(link.ID)
+	return err
+}
+
+// UpsertLinks upserts a batch of links to the wrapped graph and invalidates
+// any cached copies of them.
+func (g *Graph) UpsertLinks(ctx context.Context, links []*graph.Link) error {
+	err := g.Graph.UpsertLinks(ctx, links)
+	ids := make([]uuid.UUID, len(links))
+	for i, link := range links {
+		ids[i] = link.ID
+	}
+	g.invalidate(ids...)
+	return err
+}
+
+// RemoveLink removes the link from the wrapped graph and invalidates any
+// cached copy of it.
+func (g *Graph) RemoveLink(ctx context.Context, id uuid.UUID) error {
+	err := g.Graph.RemoveLink(ctx, id)
+	g.invalidate(id)
+	return err
+}
+
+// AddAlias adds the alias to the wrapped graph and invalidates the cached
+// copy of the alias link so that lookups get resolved to the canonical link.
+func (g *Graph) AddAlias(ctx context.Context, aliasID, canonicalID uuid.UUID) error {
+	err := g.Graph.AddAlias(ctx, aliasID, canonicalID)
+	g.invalidate(aliasID)
+	return err
+}
+
+// RemoveStaleEdges removes the stale edges from the wrapped graph. As
+// crawlers call it after re-crawling the source link, the cached copy of
+// the source link is invalidated as well.
+func (g *Graph) RemoveStaleEdges(ctx context.Context, fromID uuid.UUID, updatedBefore time.Time) error {
+	err := g.Graph.RemoveStaleEdges(ctx, fromID, updatedBefore)
+	g.invalidate(fromID)
+	return err
+}
+
+// invalidate drops the cached entries for the specified link IDs.
+func (g *Graph) invalidate(ids ...uuid.UUID) {
+	g.mu.Lock()
+	g.gen++
+	for _, id := range ids {
+		g.lru.remove(id)
+	}
+	g.mu.Unlock()
+}
+
+func copyLink(link *graph.Link) *graph.Link {
+	lCopy := new(graph.Link)
+	*lCopy = *link
+	return lCopy
+}
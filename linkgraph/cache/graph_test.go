@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/graph/graphtest"
+	"github.com/kyteproject/search-engine/linkgraph/store/memory"
+	"testing"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(CachingGraphTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type CachingGraphTestSuite struct {
+	graphtest.SuiteBase
+	backend *memory.InMemoryGraph
+	g       *Graph
+	now     time.Time
+}
+
+func (s *CachingGraphTestSuite) SetUpTest(c *gc.C) {
+	s.backend = memory.NewInMemoryGraph()
+	s.g = NewGraph(s.backend, 2, time.Minute)
+	s.now = time.Now()
+	s.g.nowFn = func() time.Time { return s.now }
+	s.SetGraph(s.g)
+}
+
+func (s *CachingGraphTestSuite) TestCacheHitsAndMisses(c *gc.C) {
+	link := &graph.Link{URL: "https://example.com"}
+	c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+
+	for i := 0; i < 3; i++ {
+		got, err := s.g.FindLink(context.TODO(), link.ID)
+		c.Assert(err, gc.IsNil)
+		c.Assert(got, gc.DeepEquals, link)
+
+		// Mutating the returned link must not affect the cached copy.
+		got.URL = "https://mutated.example.com"
+	}
+	c.Assert(s.g.Stats(), gc.Equals, Stats{Hits: 2, Misses: 1})
+
+	// Changes applied directly to the backend are masked by the cache.
+	c.Assert(s.backend.UpsertLink(context.TODO(), &graph.Link{ID: link.ID, URL: link.URL, ETag: "v2"}), gc.IsNil)
+	got, err := s.g.FindLink(context.TODO(), link.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.ETag, gc.Equals, "")
+}
+
+func (s *CachingGraphTestSuite) TestTTLExpiry(c *gc.C) {
+	link := &graph.Link{URL: "https://example.com"}
+	c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+	_, err := s.g.FindLink(context.TODO(), link.ID)
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(s.backend.UpsertLink(context.TODO(), &graph.Link{ID: link.ID, URL: link.URL, ETag: "v2"}), gc.IsNil)
+	s.now = s.now.Add(time.Minute)
+
+	got, err := s.g.FindLink(context.TODO(), link.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.ETag, gc.Equals, "v2")
+	c.Assert(s.g.Stats(), gc.Equals, Stats{Misses: 2})
+}
+
+func (s *CachingGraphTestSuite) TestLRUEviction(c *gc.C) {
+	links := []*graph.Link{
+		{URL: "https://example.com/a"},
+		{URL: "https://example.com/b"},
+		{URL: "https://example.com/c"},
+	}
+	c.Assert(s.g.UpsertLinks(context.TODO(), links), gc.IsNil)
+
+	// Access order: a, b, a, c. Fetching c evicts b as it is the least
+	// recently used entry.
+	for _, index := range []int{0, 1, 0, 2} {
+		_, err := s.g.FindLink(context.TODO(), links[index].ID)
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(s.g.Stats(), gc.Equals, Stats{Hits: 1, Misses: 3, Evictions: 1})
+	c.Assert(s.g.lru.len(), gc.Equals, 2)
+
+	for _, index := range []int{0, 2, 1} {
+		_, err := s.g.FindLink(context.TODO(), links[index].ID)
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(s.g.Stats(), gc.Equals, Stats{Hits: 3, Misses: 4, Evictions: 2})
+}
+
+func (s *CachingGraphTestSuite) TestInvalidation(c *gc.C) {
+	link := &graph.Link{URL: "https://example.com"}
+	c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+
+	specs := []struct {
+		descr  string
+		mutate func() error
+		verify func(*graph.Link, error)
+	}{
+		{
+			descr: "upsert link",
+			mutate: func() error {
+				return s.g.UpsertLink(context.TODO(), &graph.Link{ID: link.ID, URL: link.URL, ETag: "v2"})
+			},
+			verify: func(got *graph.Link, err error) {
+				c.Assert(err, gc.IsNil)
+				c.Assert(got.ETag, gc.Equals, "v2")
+			},
+		},
+		{
+			descr: "upsert links",
+			mutate: func() error {
+				return s.g.UpsertLinks(context.TODO(), []*graph.Link{{ID: link.ID, URL: link.URL, ETag: "v3"}})
+			},
+			verify: func(got *graph.Link, err error) {
+				c.Assert(err, gc.IsNil)
+				c.Assert(got.ETag, gc.Equals, "v3")
+			},
+		},
+		{
+			descr: "remove stale edges",
+			mutate: func() error {
+				if err := s.backend.UpsertLink(context.TODO(), &graph.Link{ID: link.ID, URL: link.URL, ETag: "v4"}); err != nil {
+					return err
+				}
+				return s.g.RemoveStaleEdges(context.TODO(), link.ID, time.Now())
+			},
+			verify: func(got *graph.Link, err error) {
+				c.Assert(err, gc.IsNil)
+				c.Assert(got.ETag, gc.Equals, "v4")
+			},
+		},
+		{
+			descr: "remove link",
+			mutate: func() error {
+				return s.g.RemoveLink(context.TODO(), link.ID)
+			},
+			verify: func(_ *graph.Link, err error) {
+				c.Assert(err, gc.ErrorMatches, ".*not found")
+			},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.descr)
+
+		// Prime the cache and make sure that the next lookup is a hit.
+		_, _ = s.g.FindLink(context.TODO(), link.ID)
+		_, _ = s.g.FindLink(context.TODO(), link.ID)
+		hits := s.g.Stats().Hits
+
+		c.Assert(spec.mutate(), gc.IsNil)
+		spec.verify(s.g.FindLink(context.TODO(), link.ID))
+		c.Assert(s.g.Stats().Hits, gc.Equals, hits)
+	}
+}
+
+func (s *CachingGraphTestSuite) TestAliasesAreNotCached(c *gc.C) {
+	links := []*graph.Link{
+		{URL: "https://example.com/old"},
+		{URL: "https://example.com/new"},
+	}
+	c.Assert(s.g.UpsertLinks(context.TODO(), links), gc.IsNil)
+
+	// Cache the alias link before it becomes an alias.
+	_, err := s.g.FindLink(context.TODO(), links[0].ID)
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(s.g.AddAlias(context.TODO(), links[0].ID, links[1].ID), gc.IsNil)
+	got, err := s.g.FindLink(context.TODO(), links[0].ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.ID, gc.Equals, links[1].ID)
+
+	// Updates to the canonical link must be visible through the alias.
+	c.Assert(s.g.UpsertLink(context.TODO(), &graph.Link{ID: links[1].ID, URL: links[1].URL, ETag: "v2"}), gc.IsNil)
+	got, err = s.g.FindLink(context.TODO(), links[0].ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.ETag, gc.Equals, "v2")
+	c.Assert(s.g.Stats().Hits, gc.Equals, uint64(0))
+}
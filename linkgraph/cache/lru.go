@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"container/list"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"time"
+)
+
+// lruEntry is stored in the linked list of an lruCache.
+type lruEntry struct {
+	id        uuid.UUID
+	link      *graph.Link
+	expiresAt time.Time
+}
+
+// lruCache is a size-bounded cache of links that evicts the least recently
+// used entries first. Entries also expire once their TTL elapses. It is not
+// safe for concurrent use.
+type lruCache struct {
+	size int
+	ttl  time.Duration
+
+	order   *list.List
+	entries map[uuid.UUID]*list.Element
+}
+
+// newLRUCache returns a new lruCache that holds up to size entries for at
+// most ttl each. A zero ttl disables expiration.
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[uuid.UUID]*list.Element, size),
+	}
+}
+
+// get returns the link cached for id if it exists and has not expired.
+func (c *lruCache) get(id uuid.UUID, now time.Time) (*graph.Link, bool) {
+	elem := c.entries[id]
+	if elem == nil {
+		return nil, false
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if c.ttl > 0 && !now.Before(entry.expiresAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.link, true
+}
+
+// put adds a link to the cache and returns the number of entries that had
+// to be evicted to make room for it.
+func (c *lruCache) put(id uuid.UUID, link *graph.Link, now time.Time) int {
+	expiresAt := now.Add(c.ttl)
+	if elem := c.entries[id]; elem != nil {
+		entry := elem.Value.(*lruEntry)
+		entry.link, entry.expiresAt = link, expiresAt
+		c.order.MoveToFront(elem)
+		return 0
+	}
+
+	c.entries[id] = c.order.PushFront(&lruEntry{id: id, link: link, expiresAt: expiresAt})
+
+	var evicted int
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+		evicted++
+	}
+	return evicted
+}
+
+// remove drops the entry for id from the cache.
+func (c *lruCache) remove(id uuid.UUID) {
+	if elem := c.entries[id]; elem != nil {
+		c.removeElement(elem)
+	}
+}
+
+// len returns the number of cached entries, including expired ones that
+// have not been purged yet.
+func (c *lruCache) len() int {
+	return c.order.Len()
+}
+
+func (c *lruCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*lruEntry).id)
+}
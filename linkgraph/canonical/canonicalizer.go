@@ -0,0 +1,278 @@
+package canonical
+
+import (
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/net/idna"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DefaultTrackingParams contains the query parameters that are commonly used
+// for tracking purposes and do not affect the content of a page. Entries
+// ending with "*" match any parameter that starts with the entry's prefix.
+var DefaultTrackingParams = []string{
+	"utm_*",
+	"gclid",
+	"dclid",
+	"fbclid",
+	"msclkid",
+	"yclid",
+	"igshid",
+	"mc_cid",
+	"mc_eid",
+	"_ga",
+	"_hsenc",
+	"_hsmi",
+}
+
+// defaultPorts maps URL schemes to their default port.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// hostProfile converts internationalized host names to their ASCII form. It
+// does not enforce the STD3 rules so that host names that contain
+// underscores are still accepted.
+var hostProfile = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false),
+)
+
+// Canonicalizer converts URLs into a canonical form so that URLs that point
+// to the same resource map to the same string.
+type Canonicalizer struct {
+	trackingParams   map[string]bool
+	trackingPrefixes []string
+}
+
+// NewCanonicalizer returns a new Canonicalizer that strips the specified
+// tracking parameters from the query string of URLs. Parameter names are
+// matched case-insensitively; names ending with "*" are treated as prefixes.
+func NewCanonicalizer(trackingParams []string) *Canonicalizer {
+	c := &Canonicalizer{trackingParams: make(map[string]bool)}
+	for _, param := range trackingParams {
+		param = strings.ToLower(param)
+		if strings.HasSuffix(param, "*") {
+			c.trackingPrefixes = append(c.trackingPrefixes, strings.TrimSuffix(param, "*"))
+			continue
+		}
+		c.trackingParams[param] = true
+	}
+	return c
+}
+
+// Canonicalize returns the canonical form of rawURL by applying the
+// following rules:
+//   - the scheme and host are converted to lower case
+//   - internationalized host names are converted to their punycode form
+//   - default ports are removed
+//   - dot segments are removed from the path and percent-encodings are
+//     normalized
+//   - tracking parameters are removed and the remaining query parameters
+//     are sorted by name
+//   - the fragment is removed
+//
+// If rawURL cannot be parsed, Canonicalize returns graph.ErrInvalidURL.
+func (c *Canonicalizer) Canonicalize(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if err := graph.ValidateURL(rawURL); err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", graph.ErrInvalidURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	if u.Host != "" {
+		if u.Host, err = canonicalHost(u.Scheme, u.Hostname(), u.Port()); err != nil {
+			return "", err
+		}
+	}
+
+	// Dot segments are resolved on the escaped path so that escaped
+	// slashes are not mistaken for segment separators.
+	escapedPath := removeDotSegments(normalizePercentEncoding(u.EscapedPath()))
+	if escapedPath == "" && u.Host != "" {
+		escapedPath = "/"
+	}
+	if u.Path, err = url.PathUnescape(escapedPath); err != nil {
+		return "", graph.ErrInvalidURL
+	}
+	u.RawPath = escapedPath
+
+	u.RawQuery = c.canonicalQuery(u.RawQuery)
+	u.ForceQuery = false
+	u.Fragment, u.RawFragment = "", ""
+
+	return u.String(), nil
+}
+
+// canonicalHost returns the canonical host[:port] representation for a URL
+// with the specified scheme.
+func canonicalHost(scheme, hostname, port string) (string, error) {
+	hostname = strings.TrimSuffix(hostname, ".")
+	if hostname == "" {
+		return "", graph.ErrInvalidURL
+	}
+
+	if ip := net.ParseIP(hostname); ip != nil {
+		if ip.To4() == nil {
+			hostname = "[" + ip.String() + "]"
+		} else {
+			hostname = ip.String()
+		}
+	} else {
+		asciiHost, err := hostProfile.ToASCII(hostname)
+		if err != nil {
+			return "", graph.ErrInvalidURL
+		}
+		hostname = strings.ToLower(asciiHost)
+	}
+
+	if port == "" || port == defaultPorts[scheme] {
+		return hostname, nil
+	}
+	return hostname + ":" + port, nil
+}
+
+// canonicalQuery removes tracking parameters from rawQuery and sorts the
+// remaining parameters by name. The relative order of values for the same
+// parameter is retained and the parameters are otherwise left untouched.
+func (c *Canonicalizer) canonicalQuery(rawQuery string) string {
+	type param struct{ name, raw string }
+
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+
+		name := raw
+		if index := strings.IndexByte(raw, '='); index != -1 {
+			name = raw[:index]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+
+		if !c.isTrackingParam(name) {
+			params = append(params, param{name: name, raw: normalizePercentEncoding(raw)})
+		}
+	}
+
+	sort.SliceStable(params, func(l, r int) bool { return params[l].name < params[r].name })
+
+	var sb strings.Builder
+	for i, p := range params {
+		if i > 0 {
+			sb.WriteByte('&')
+		}
+		sb.WriteString(p.raw)
+	}
+	return sb.String()
+}
+
+// isTrackingParam returns true if name matches one of the configured
+// tracking parameters.
+func (c *Canonicalizer) isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	if c.trackingParams[name] {
+		return true
+	}
+	for _, prefix := range c.trackingPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeDotSegments implements the remove_dot_segments algorithm from
+// RFC 3986, section 5.2.4.
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+
+	var out []string
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		switch seg {
+		case ".":
+			// A trailing dot segment leaves a trailing slash behind.
+			if i == len(segments)-1 {
+				out = append(out, "")
+			}
+		case "..":
+			// Never remove the empty segment that precedes the leading
+			// slash of an absolute path.
+			if len(out) > 1 || (len(out) == 1 && out[0] != "") {
+				out = out[:len(out)-1]
+			}
+			if i == len(segments)-1 {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+
+	cleaned := strings.Join(out, "/")
+	if strings.HasPrefix(path, "/") && !strings.HasPrefix(cleaned, "/") {
+		cleaned = "/" + cleaned
+	}
+	return cleaned
+}
+
+// normalizePercentEncoding decodes percent-encoded unreserved characters and
+// converts the hex digits of the remaining percent-encodings to upper case.
+func normalizePercentEncoding(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			sb.WriteByte(s[i])
+			continue
+		}
+
+		if b := unhex(s[i+1])<<4 | unhex(s[i+2]); isUnreserved(b) {
+			sb.WriteByte(b)
+		} else {
+			sb.WriteByte('%')
+			sb.WriteString(strings.ToUpper(s[i+1 : i+3]))
+		}
+		i += 2
+	}
+	return sb.String()
+}
+
+func isHex(b byte) bool {
+	return ('0' <= b && b <= '9') || ('a' <= b && b <= 'f') || ('A' <= b && b <= 'F')
+}
+
+func unhex(b byte) byte {
+	switch {
+	case '0' <= b && b <= '9':
+		return b - '0'
+	case 'a' <= b && b <= 'f':
+		return b - 'a' + 10
+	default:
+		return b - 'A' + 10
+	}
+}
+
+// isUnreserved returns true if b is an unreserved character as defined by
+// RFC 3986, section 2.3.
+func isUnreserved(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') ||
+		b == '-' || b == '.' || b == '_' || b == '~'
+}
@@ -0,0 +1,93 @@
+package canonical
+
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+)
+
+// Compile-time check for ensuring Graph implements graph.Graph.
+var _ graph.Graph = (*Graph)(nil)
+
+// Graph is a graph.Graph decorator that canonicalizes link URLs before they
+// are passed to the wrapped graph. This ensures that URLs which only differ
+// in their representation are mapped to the same link.
+type Graph struct {
+	graph.Graph
+	c *Canonicalizer
+}
+
+// NewGraph returns a new Graph that canonicalizes URLs using c before
+// delegating to g.
+func NewGraph(g graph.Graph, c *Canonicalizer) *Graph {
+	return &Graph{Graph: g, c: c}
+}
+
+// UpsertLink canonicalizes the link URL and upserts the link to the wrapped
+// graph. Once the call returns, link.URL contains the canonical URL.
+func (g *Graph) UpsertLink(ctx context.Context, link *graph.Link) error {
+	canonicalURL, err := g.c.Canonicalize(link.URL)
+	if err != nil {
+		return xerrors.Errorf("upsert link: %w", err)
+	}
+
+	link.URL = canonicalURL
+	return g.Graph.UpsertLink(ctx, link)
+}
+
+// UpsertLinks canonicalizes the URLs of a batch of links and upserts them to
+// the wrapped graph. Links whose URLs cannot be canonicalized are reported
+// via a *graph.BatchError.
+func (g *Graph) UpsertLinks(ctx context.Context, links []*graph.Link) error {
+	var (
+		errs  []error
+		valid = make([]int, 0, len(links))
+	)
+	for i, link := range links {
+		canonicalURL, err := g.c.Canonicalize(link.URL)
+		if err != nil {
+			if errs == nil {
+				errs = make([]error, len(links))
+			}
+			errs[i] = xerrors.Errorf("upsert links: %w", err)
+			continue
+		}
+
+		link.URL = canonicalURL
+		valid = append(valid, i)
+	}
+
+	// Avoid copying the batch in the common case where all URLs are valid.
+	if errs == nil {
+		return g.Graph.UpsertLinks(ctx, links)
+	}
+
+	batch := make([]*graph.Link, len(valid))
+	for j, i := range valid {
+		batch[j] = links[i]
+	}
+
+	if err := g.Graph.UpsertLinks(ctx, batch); err != nil {
+		batchErr, ok := err.(*graph.BatchError)
+		if !ok {
+			return err
+		}
+
+		// Map the errors of the wrapped graph back to the original
+		// batch indices.
+		for j, i := range valid {
+			errs[i] = batchErr.Errors[j]
+		}
+	}
+	return &graph.BatchError{Errors: errs}
+}
+
+// FindLinkByURL canonicalizes url and looks up the link with the resulting
+// URL in the wrapped graph.
+func (g *Graph) FindLinkByURL(ctx context.Context, url string) (*graph.Link, error) {
+	canonicalURL, err := g.c.Canonicalize(url)
+	if err != nil {
+		return nil, xerrors.Errorf("find link by URL: %w", err)
+	}
+	return g.Graph.FindLinkByURL(ctx, canonicalURL)
+}
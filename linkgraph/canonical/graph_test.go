@@ -0,0 +1,53 @@
+package canonical
+
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/graph/graphtest"
+	"github.com/kyteproject/search-engine/linkgraph/store/memory"
+	"golang.org/x/xerrors"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(CanonicalGraphTestSuite))
+
+type CanonicalGraphTestSuite struct {
+	graphtest.SuiteBase
+	g *Graph
+}
+
+func (s *CanonicalGraphTestSuite) SetUpTest(c *gc.C) {
+	s.g = NewGraph(memory.NewInMemoryGraph(), NewCanonicalizer(DefaultTrackingParams))
+	s.SetGraph(s.g)
+}
+
+func (s *CanonicalGraphTestSuite) TestEquivalentURLsMapToSameLink(c *gc.C) {
+	link := &graph.Link{URL: "HTTP://Example.com:80/a#top"}
+	c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+	c.Assert(link.URL, gc.Equals, "http://example.com/a")
+
+	other := &graph.Link{URL: "http://example.com/a?utm_source=feed"}
+	c.Assert(s.g.UpsertLink(context.TODO(), other), gc.IsNil)
+	c.Assert(other.ID, gc.Equals, link.ID)
+
+	found, err := s.g.FindLinkByURL(context.TODO(), "http://EXAMPLE.com/./a")
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.ID, gc.Equals, link.ID)
+}
+
+func (s *CanonicalGraphTestSuite) TestUpsertLinksWithInvalidURLs(c *gc.C) {
+	links := []*graph.Link{
+		{URL: "http://example.com/a#x"},
+		{URL: "http://[::1/"},
+		{URL: "http://example.com/a#y"},
+	}
+	err := s.g.UpsertLinks(context.TODO(), links)
+	batchErr, ok := err.(*graph.BatchError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("expected a *graph.BatchError; got %T", err))
+	c.Assert(batchErr.Errors, gc.HasLen, len(links))
+	c.Assert(batchErr.Errors[0], gc.IsNil)
+	c.Assert(xerrors.Is(batchErr.Errors[1], graph.ErrInvalidURL), gc.Equals, true)
+	c.Assert(batchErr.Errors[2], gc.IsNil)
+	c.Assert(links[0].ID, gc.Equals, links[2].ID)
+}
@@ -0,0 +1,39 @@
+package graph
+
+import (
+	"encoding/base64"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+// Cursor is an opaque token that marks a position within a link or edge
+// iteration. Passing it to LinksAfter or EdgesAfter resumes the iteration
+// right after the item that was current when the cursor was obtained. The
+// zero value marks the beginning of an iteration.
+type Cursor string
+
+// NewCursor returns a cursor positioned right after the item with the
+// specified ID. It is meant to be used by Graph implementations; clients
+// should treat cursors as opaque values.
+func NewCursor(id uuid.UUID) Cursor {
+	return Cursor(base64.RawURLEncoding.EncodeToString(id[:]))
+}
+
+// ID decodes the ID of the item that the cursor points after. Calling ID on
+// the zero Cursor returns uuid.Nil.
+func (c Cursor) ID() (uuid.UUID, error) {
+	if c == "" {
+		return uuid.Nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(string(c))
+	if err != nil {
+		return uuid.Nil, xerrors.Errorf("decode cursor: %w", ErrInvalidCursor)
+	}
+
+	id, err := uuid.FromBytes(data)
+	if err != nil {
+		return uuid.Nil, xerrors.Errorf("decode cursor: %w", ErrInvalidCursor)
+	}
+	return id, nil
+}
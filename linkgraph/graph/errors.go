@@ -1,6 +1,12 @@
 package graph
 
-import "golang.org/x/xerrors"
+import (
+	"fmt"
+	"github.com/kyteproject/search-engine/linkgraph/partition"
+	"golang.org/x/xerrors"
+	"net/url"
+	"unicode/utf8"
+)
 
 var (
 	// ErrNotFound is returned when a link or edge lookup fails.
@@ -9,4 +15,95 @@ var (
 	// ErrUnknownEdgeLinks is returned when attempting to create an edge
 	// with an invalid source and/or destination ID
 	ErrUnknownEdgeLinks = xerrors.New("unknown source and/or destination for edge")
+
+	// ErrInvalidCursor is returned when attempting to resume an iteration
+	// with a malformed cursor.
+	ErrInvalidCursor = xerrors.New("invalid cursor")
+
+	// ErrInvalidURL is returned when attempting to upsert a link with an
+	// empty or malformed URL.
+	ErrInvalidURL = xerrors.New("invalid URL")
+
+	// ErrLinkIDInUse is returned when attempting to create a link with an
+	// ID that is already assigned to a link with a different URL.
+	ErrLinkIDInUse = xerrors.New("link ID already in use")
+
+	// ErrEdgeIDInUse is returned when attempting to create an edge with an
+	// ID that is already assigned to an edge between a different pair of
+	// links.
+	ErrEdgeIDInUse = xerrors.New("edge ID already in use")
+
+	// ErrInvalidLinkID is returned when attempting to create a link with
+	// the reserved all-ones ID.
+	ErrInvalidLinkID = xerrors.New("invalid link ID")
+
+	// ErrConflict is returned when an operation could not be applied due to
+	// a conflicting concurrent operation. Retrying the operation may succeed.
+	ErrConflict = xerrors.New("conflicting operation")
+
+	// ErrUnavailable is returned when the graph backend is temporarily
+	// unreachable or unable to serve requests.
+	ErrUnavailable = xerrors.New("graph backend unavailable")
+
+	// ErrAliasCycle is returned when attempting to add an alias that would
+	// make a link an alias of itself.
+	ErrAliasCycle = xerrors.New("alias would introduce a cycle")
 )
+
+// IsRetryable returns true if err indicates a transient failure and the
+// operation that caused it can be safely retried.
+func IsRetryable(err error) bool {
+	return xerrors.Is(err, ErrConflict) || xerrors.Is(err, ErrUnavailable)
+}
+
+// ValidateURL returns ErrInvalidURL if rawURL is empty, is not valid UTF-8
+// or cannot be parsed as a URL.
+func ValidateURL(rawURL string) error {
+	if rawURL == "" || !utf8.ValidString(rawURL) {
+		return ErrInvalidURL
+	}
+	if _, err := url.Parse(rawURL); err != nil {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+// ValidateLink returns ErrInvalidURL if the URL of link is invalid and
+// ErrInvalidLinkID if link carries the partition.MaxUUID ID. That ID is
+// reserved as the exclusive upper bound of ID ranges, so a link using it could never be
+// returned by Links or have its edges returned by Edges.
+func ValidateLink(link *Link) error {
+	if err := ValidateURL(link.URL); err != nil {
+		return err
+	}
+	if link.ID == partition.MaxUUID {
+		return ErrInvalidLinkID
+	}
+	return nil
+}
+
+// BatchError is returned by the batch upsert methods when one or more items
+// of a batch could not be processed. Errors is aligned with the submitted
+// batch and contains a nil entry for every item that was upserted
+// successfully.
+type BatchError struct {
+	Errors []error
+}
+
+// Error implements the error interface.
+func (e *BatchError) Error() string {
+	var (
+		failed   int
+		firstErr error
+	)
+	for _, err := range e.Errors {
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		failed++
+	}
+	return fmt.Sprintf("%d of %d batch items failed; first error: %v", failed, len(e.Errors), firstErr)
+}
@@ -0,0 +1,61 @@
+package graph
+
+import "time"
+
+// EventType describes the kind of mutation captured by an Event.
+type EventType uint8
+
+const (
+	// LinkUpserted is emitted when a link is created or updated.
+	LinkUpserted EventType = iota + 1
+
+	// LinkRemoved is emitted when a link is removed from the graph.
+	LinkRemoved
+
+	// EdgeUpserted is emitted when an edge is created or updated.
+	EdgeUpserted
+
+	// EdgeRemoved is emitted when an edge is removed from the graph, either
+	// explicitly or because one of its links was removed.
+	EdgeRemoved
+)
+
+// String implements fmt.Stringer for EventType.
+func (t EventType) String() string {
+	switch t {
+	case LinkUpserted:
+		return "link_upserted"
+	case LinkRemoved:
+		return "link_removed"
+	case EdgeUpserted:
+		return "edge_upserted"
+	case EdgeRemoved:
+		return "edge_removed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a mutation that was applied to the graph.
+type Event struct {
+	Type      EventType
+	Timestamp time.Time
+
+	// Link is populated for link events and contains the state of the
+	// link right after the mutation was applied.
+	Link *Link
+
+	// Edge is populated for edge events and contains the state of the
+	// edge right after the mutation was applied.
+	Edge *Edge
+}
+
+// EventIterator is implemented by objects that can iterate the stream of
+// graph mutation events. Calls to Next block until a new event becomes
+// available, the iterator is closed or its context is cancelled.
+type EventIterator interface {
+	Iterator
+
+	// Event returns the currently fetched event.
+	Event() *Event
+}
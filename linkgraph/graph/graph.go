@@ -1,6 +1,7 @@
 package graph
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"time"
 )
@@ -10,6 +11,71 @@ type Link struct {
 	ID          uuid.UUID
 	URL         string
 	RetrievedAt time.Time
+
+	// ETag and LastModified hold the validators returned by the remote
+	// server the last time the link was fetched. The crawler sends them
+	// back as If-None-Match / If-Modified-Since headers.
+	ETag         string
+	LastModified time.Time
+
+	// ContentHash is a digest of the most recently retrieved content.
+	ContentHash string
+
+	// HTTPStatus is the status code returned by the most recent fetch
+	// attempt and FailureCount the number of consecutive failed attempts.
+	HTTPStatus   int
+	FailureCount int
+
+	// Status describes where the link is in its crawl lifecycle and
+	// NextCrawlAt when the link should be crawled next.
+	Status      LinkStatus
+	NextCrawlAt time.Time
+}
+
+// LinkStatus describes the crawl lifecycle state of a link.
+type LinkStatus uint8
+
+const (
+	// LinkStatusPending is assigned to links that have not been crawled yet.
+	LinkStatusPending LinkStatus = iota
+
+	// LinkStatusCrawled is assigned to links that were successfully crawled.
+	LinkStatusCrawled
+
+	// LinkStatusFailed is assigned to links whose most recent crawl attempt
+	// failed. Failed links are retried once they become due.
+	LinkStatusFailed
+
+	// LinkStatusBlocked is assigned to links that must not be crawled, e.g.
+	// because they are disallowed by robots.txt.
+	LinkStatusBlocked
+
+	// LinkStatusGone is assigned to links that no longer exist.
+	LinkStatusGone
+)
+
+// String implements fmt.Stringer for LinkStatus.
+func (s LinkStatus) String() string {
+	switch s {
+	case LinkStatusPending:
+		return "pending"
+	case LinkStatusCrawled:
+		return "crawled"
+	case LinkStatusFailed:
+		return "failed"
+	case LinkStatusBlocked:
+		return "blocked"
+	case LinkStatusGone:
+		return "gone"
+	default:
+		return "unknown"
+	}
+}
+
+// IsCrawlable returns true if links with this status can be scheduled for
+// crawling.
+func (s LinkStatus) IsCrawlable() bool {
+	return s != LinkStatusBlocked && s != LinkStatusGone
 }
 
 // Edge describes a graph edge that originates from Source and terminates at Destination
@@ -18,36 +84,163 @@ type Edge struct {
 	Source      uuid.UUID
 	Destination uuid.UUID
 	UpdatedAt   time.Time
+
+	// AnchorText is the text of the anchor element that points to the
+	// destination link.
+	AnchorText string
+
+	// Nofollow, Sponsored and UGC reflect the values of the rel attribute
+	// of the anchor element.
+	Nofollow  bool
+	Sponsored bool
+	UGC       bool
+
+	// Weight is a relative score that allows ranking algorithms to discount
+	// or boost the edge.
+	Weight float64
 }
 
+// Graph is implemented by objects that can mutate or query a link graph. All
+// methods accept a context that can be used to cancel long-running operations
+// or impose a deadline on them.
 type Graph interface {
-	// UpsertLink creates a new link or updates an existing link.
-	UpsertLink(link *Link) error
+	// UpsertLink creates a new link or updates an existing link. The crawl
+	// metadata of an existing link is only overwritten if the provided
+	// RetrievedAt value is not older than the one already stored. Links
+	// with an invalid URL are rejected with ErrInvalidURL.
+	//
+	// New links keep the ID provided by the caller, if any, which allows
+	// links to be copied between graphs without changing their IDs. If that
+	// ID is already assigned to a link with a different URL, ErrLinkIDInUse
+	// is returned. The all-ones ID is reserved and rejected with
+	// ErrInvalidLinkID. Upserting a link whose URL already exists always sets
+	// its ID to the ID of the existing link.
+	UpsertLink(ctx context.Context, link *Link) error
 
-	// FindLink looks up a link by its ID.
-	FindLink(id uuid.UUID) (*Link, error)
+	// UpsertLinks creates or updates a batch of links. Once the call
+	// returns, the ID of each link is set to the ID assigned by the graph.
+	// If some of the links could not be upserted, a *BatchError with the
+	// per-item errors is returned.
+	UpsertLinks(ctx context.Context, links []*Link) error
+
+	// FindLink looks up a link by its ID. If the ID belongs to an alias, the
+	// canonical link is returned instead.
+	FindLink(ctx context.Context, id uuid.UUID) (*Link, error)
+
+	// FindLinkByURL looks up a link by its URL. If the URL belongs to an
+	// alias, the canonical link is returned instead.
+	FindLinkByURL(ctx context.Context, url string) (*Link, error)
+
+	// RemoveLink removes the link with the specified ID together with any
+	// edges that originate from or point to it and any aliases that
+	// involve it.
+	RemoveLink(ctx context.Context, id uuid.UUID) error
+
+	// AddAlias records that the link with aliasID refers to the same
+	// document as the link with canonicalID, e.g. because it redirects to
+	// it or declares it as its canonical URL. Aliases are resolved
+	// transitively; adding an alias that would introduce a cycle returns
+	// ErrAliasCycle.
+	AddAlias(ctx context.Context, aliasID, canonicalID uuid.UUID) error
 
 	// Links returns an iterator for the set of links whose IDs belong to the
 	// [fromID, toID) range and were retrieved before the provided timestamp.
-	Links(fromID, toID uuid.UUID, retrievedBefore time.Time) (LinkIterator, error)
+	// Links are returned in ascending ID order.
+	Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (LinkIterator, error)
+
+	// LinksAfter behaves like Links but resumes the iteration right after
+	// the position marked by the provided cursor.
+	LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after Cursor) (LinkIterator, error)
 
-	// UpsertEdge creates a new edge or updates an existing edge.
-	UpsertEdge(edge *Edge) error
+	// LinksDueForCrawl returns an iterator for the set of links whose IDs
+	// belong to the [fromID, toID) range and whose NextCrawlAt value is
+	// before the provided timestamp. Blocked and gone links are never
+	// returned. Links are returned in ascending NextCrawlAt order.
+	LinksDueForCrawl(ctx context.Context, fromID, toID uuid.UUID, dueBefore time.Time) (LinkIterator, error)
+
+	// UpsertEdge creates a new edge or updates an existing edge. The
+	// attributes of an existing edge are replaced by the provided values.
+	//
+	// Like links, new edges keep the ID provided by the caller, if any. If
+	// that ID is already assigned to an edge between a different pair of
+	// links, ErrEdgeIDInUse is returned. Upserting an edge between two
+	// links that are already connected always sets its ID to the ID of the
+	// existing edge.
+	UpsertEdge(ctx context.Context, edge *Edge) error
+
+	// UpsertEdges creates or updates a batch of edges. Once the call
+	// returns, the ID of each edge is set to the ID assigned by the graph.
+	// If some of the edges could not be upserted, a *BatchError with the
+	// per-item errors is returned.
+	UpsertEdges(ctx context.Context, edges []*Edge) error
 
 	// Edges returns an iterator for the set of edges whose source vertex IDs
 	// belong to the [fromID, toID) range and were updated before the provided
-	// timestamp.
-	Edges(fromID, toID uuid.UUID, updatedBefore time.Time) (EdgeIterator, error)
+	// timestamp. Edges are returned in ascending ID order.
+	Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (EdgeIterator, error)
+
+	// EdgesAfter behaves like Edges but resumes the iteration right after
+	// the position marked by the provided cursor.
+	EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after Cursor) (EdgeIterator, error)
+
+	// InEdges returns an iterator for the set of edges that point to the
+	// specified destination link and were updated before the provided
+	// timestamp. Edges are returned in ascending ID order.
+	InEdges(ctx context.Context, dstID uuid.UUID, updatedBefore time.Time) (EdgeIterator, error)
+
+	// CanonicalEdges behaves like Edges but rewrites the destination of
+	// each edge that points to an alias to the ID of its canonical link.
+	CanonicalEdges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (EdgeIterator, error)
 
 	// RemoveStaleEdges removes any edge that originates from the specified
 	// link ID and was updated before the specified timestamp.
-	RemoveStaleEdges(fromID uuid.UUID, updatedBefore time.Time) error
+	RemoveStaleEdges(ctx context.Context, fromID uuid.UUID, updatedBefore time.Time) error
+
+	// Snapshot returns a read-only, point-in-time view of the graph. Callers
+	// must close the snapshot once they no longer need it.
+	Snapshot(ctx context.Context) (Snapshot, error)
+
+	// Watch returns an iterator for the stream of mutation events that
+	// occur after the provided timestamp. Past events are replayed on a
+	// best-effort basis as implementations may only retain a bounded
+	// history of events.
+	Watch(ctx context.Context, since time.Time) (EventIterator, error)
+}
+
+// Snapshot is implemented by objects that provide a read-only, point-in-time
+// view of a link graph. Lookups and iterators obtained from a snapshot are
+// not affected by any mutations applied to the graph after the snapshot was
+// taken and remain consistent for the lifetime of the snapshot.
+type Snapshot interface {
+	// FindLink looks up a link by its ID.
+	FindLink(ctx context.Context, id uuid.UUID) (*Link, error)
+
+	// Links returns an iterator for the set of links whose IDs belong to the
+	// [fromID, toID) range and were retrieved before the provided timestamp.
+	Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (LinkIterator, error)
+
+	// LinksAfter behaves like Links but resumes the iteration right after
+	// the position marked by the provided cursor.
+	LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after Cursor) (LinkIterator, error)
+
+	// Edges returns an iterator for the set of edges whose source vertex IDs
+	// belong to the [fromID, toID) range and were updated before the provided
+	// timestamp.
+	Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (EdgeIterator, error)
+
+	// EdgesAfter behaves like Edges but resumes the iteration right after
+	// the position marked by the provided cursor.
+	EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after Cursor) (EdgeIterator, error)
+
+	// Close releases any resources associated with the snapshot.
+	Close() error
 }
 
 // Iterator is implemented by graph objects that can be iterated.
 type Iterator interface {
-	// Next advances the iterator. If no more items are available or an
-	// error occurs, calls to Next() return false.
+	// Next advances the iterator. If no more items are available, an
+	// error occurs or the context used to create the iterator is
+	// cancelled, calls to Next() return false.
 	Next() bool
 
 	// Error returns the last error encountered by the iterator.
@@ -63,6 +256,10 @@ type LinkIterator interface {
 
 	// Link returns the currently fetched link object.
 	Link() *Link
+
+	// Cursor returns a cursor that can be used to resume the iteration
+	// right after the currently fetched link.
+	Cursor() Cursor
 }
 
 // EdgeIterator is implemented by objects that can iterate the graph edges.
@@ -71,4 +268,8 @@ type EdgeIterator interface {
 
 	// Edge returns the currently fetched edge objects.
 	Edge() *Edge
+
+	// Cursor returns a cursor that can be used to resume the iteration
+	// right after the currently fetched edge.
+	Cursor() Cursor
 }
@@ -0,0 +1,83 @@
+package graphtest
+
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/partition"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	gc "gopkg.in/check.v1"
+)
+
+// MaxTime is used for iterating links and edges regardless of their
+// timestamps.
+var MaxTime = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// GraphDump holds the links, edges and aliases of a graph. Aliases maps the
+// ID of each aliased link to the ID of its canonical link.
+type GraphDump struct {
+	Links   []*graph.Link
+	Edges   []*graph.Edge
+	Aliases map[uuid.UUID]uuid.UUID
+}
+
+// DumpGraph collects the contents of g.
+func DumpGraph(c *gc.C, g graph.Graph) GraphDump {
+	dump := GraphDump{Aliases: make(map[uuid.UUID]uuid.UUID)}
+
+	linkIt, err := g.Links(context.TODO(), partition.MinUUID, partition.MaxUUID, MaxTime)
+	c.Assert(err, gc.IsNil)
+	for linkIt.Next() {
+		link := linkIt.Link()
+		dump.Links = append(dump.Links, link)
+
+		resolved, err := g.FindLink(context.TODO(), link.ID)
+		c.Assert(err, gc.IsNil)
+		if resolved.ID != link.ID {
+			dump.Aliases[link.ID] = resolved.ID
+		}
+	}
+	c.Assert(linkIt.Error(), gc.IsNil)
+	c.Assert(linkIt.Close(), gc.IsNil)
+
+	edgeIt, err := g.Edges(context.TODO(), partition.MinUUID, partition.MaxUUID, MaxTime)
+	c.Assert(err, gc.IsNil)
+	for edgeIt.Next() {
+		dump.Edges = append(dump.Edges, edgeIt.Edge())
+	}
+	c.Assert(edgeIt.Error(), gc.IsNil)
+	c.Assert(edgeIt.Close(), gc.IsNil)
+	return dump
+}
+
+// AssertSameGraph compares two graph dumps regardless of the order of their
+// links and edges. As persisted graphs do not preserve the location of
+// timestamps, times are compared in UTC.
+func AssertSameGraph(c *gc.C, got, exp GraphDump) {
+	c.Assert(normalizeDump(got), gc.DeepEquals, normalizeDump(exp))
+}
+
+func normalizeDump(d GraphDump) GraphDump {
+	out := GraphDump{Aliases: d.Aliases}
+	if out.Aliases == nil {
+		out.Aliases = make(map[uuid.UUID]uuid.UUID)
+	}
+	for _, link := range d.Links {
+		lCopy := *link
+		lCopy.RetrievedAt = lCopy.RetrievedAt.UTC()
+		lCopy.LastModified = lCopy.LastModified.UTC()
+		lCopy.NextCrawlAt = lCopy.NextCrawlAt.UTC()
+		out.Links = append(out.Links, &lCopy)
+	}
+	for _, edge := range d.Edges {
+		eCopy := *edge
+		eCopy.UpdatedAt = eCopy.UpdatedAt.UTC()
+		out.Edges = append(out.Edges, &eCopy)
+	}
+
+	sort.Slice(out.Links, func(i, j int) bool { return out.Links[i].ID.String() < out.Links[j].ID.String() })
+	sort.Slice(out.Edges, func(i, j int) bool { return out.Edges[i].ID.String() < out.Edges[j].ID.String() })
+	return out
+}
@@ -1,9 +1,11 @@
 package graphtest
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/kyteproject/search-engine/linkgraph/graph"
-	"math/big"
+	"github.com/kyteproject/search-engine/linkgraph/partition"
 	"sort"
 	"sync"
 	"time"
@@ -32,7 +34,7 @@ func (s *SuiteBase) TestUpsertLink(c *gc.C) {
 		RetrievedAt: time.Now().Add(-10 * time.Hour),
 	}
 
-	err := s.g.UpsertLink(original)
+	err := s.g.UpsertLink(context.TODO(), original)
 	c.Assert(err, gc.IsNil)
 	c.Assert(original.ID, gc.Not(gc.Equals), uuid.Nil, gc.Commentf("expected a linkID to be assigned to the new link"))
 
@@ -43,11 +45,11 @@ func (s *SuiteBase) TestUpsertLink(c *gc.C) {
 		URL:         "https://example.com",
 		RetrievedAt: accessedAt,
 	}
-	err = s.g.UpsertLink(existing)
+	err = s.g.UpsertLink(context.TODO(), existing)
 	c.Assert(err, gc.IsNil)
 	c.Assert(existing.ID, gc.Equals, original.ID, gc.Commentf("link ID changed while upserting"))
 
-	stored, err := s.g.FindLink(existing.ID)
+	stored, err := s.g.FindLink(context.TODO(), existing.ID)
 	c.Assert(err, gc.IsNil)
 	c.Assert(stored.RetrievedAt, gc.Equals, accessedAt, gc.Commentf("last accessed timestamp was not updated"))
 
@@ -57,11 +59,11 @@ func (s *SuiteBase) TestUpsertLink(c *gc.C) {
 		URL:         existing.URL,
 		RetrievedAt: time.Now().Add(-10 * time.Hour).UTC(),
 	}
-	err = s.g.UpsertLink(sameURL)
+	err = s.g.UpsertLink(context.TODO(), sameURL)
 	c.Assert(err, gc.IsNil)
 	c.Assert(sameURL.ID, gc.Equals, existing.ID)
 
-	stored, err = s.g.FindLink(existing.ID)
+	stored, err = s.g.FindLink(context.TODO(), existing.ID)
 	c.Assert(err, gc.IsNil)
 	c.Assert(stored.RetrievedAt, gc.Equals, accessedAt, gc.Commentf("last accessed timestamp was overwritten with an older value"))
 
@@ -70,7 +72,7 @@ func (s *SuiteBase) TestUpsertLink(c *gc.C) {
 	dup := &graph.Link{
 		URL: "foo",
 	}
-	err = s.g.UpsertLink(dup)
+	err = s.g.UpsertLink(context.TODO(), dup)
 	c.Assert(err, gc.IsNil)
 	c.Assert(dup.ID, gc.Not(gc.Equals), uuid.Nil, gc.Commentf("expected a linkID to be assigned to the new link"))
 }
@@ -83,20 +85,240 @@ func (s *SuiteBase) TestFindLink(c *gc.C) {
 		RetrievedAt: time.Now().Truncate(time.Second).UTC(),
 	}
 
-	err := s.g.UpsertLink(link)
+	err := s.g.UpsertLink(context.TODO(), link)
 	c.Assert(err, gc.IsNil)
 	c.Assert(link.ID, gc.Not(gc.Equals), uuid.Nil, gc.Commentf("expected a linkID to be assigned to the new link"))
 
 	// Lookup link by ID
-	other, err := s.g.FindLink(link.ID)
+	other, err := s.g.FindLink(context.TODO(), link.ID)
 	c.Assert(err, gc.IsNil)
 	c.Assert(other, gc.DeepEquals, link, gc.Commentf("lookup by ID returned the wrong link"))
 
 	// Lookup link by unknown ID
-	_, err = s.g.FindLink(uuid.Nil)
+	_, err = s.g.FindLink(context.TODO(), uuid.Nil)
 	c.Assert(xerrors.Is(err, graph.ErrNotFound), gc.Equals, true)
 }
 
+// TestFindLinkByURL verifies the link lookup by URL logic.
+func (s *SuiteBase) TestFindLinkByURL(c *gc.C) {
+	link := &graph.Link{
+		URL:         "https://example.com",
+		RetrievedAt: time.Now().Truncate(time.Second).UTC(),
+	}
+	c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+
+	other, err := s.g.FindLinkByURL(context.TODO(), link.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(other, gc.DeepEquals, link, gc.Commentf("lookup by URL returned the wrong link"))
+
+	_, err = s.g.FindLinkByURL(context.TODO(), "https://example.com/unknown")
+	c.Assert(xerrors.Is(err, graph.ErrNotFound), gc.Equals, true)
+
+	// Lookups must not match removed links.
+	c.Assert(s.g.RemoveLink(context.TODO(), link.ID), gc.IsNil)
+	_, err = s.g.FindLinkByURL(context.TODO(), link.URL)
+	c.Assert(xerrors.Is(err, graph.ErrNotFound), gc.Equals, true)
+}
+
+// TestUpsertLinkCrawlMetadata verifies that the crawl metadata of a link is
+// persisted and only overwritten by upserts that are not older than the
+// stored link.
+func (s *SuiteBase) TestUpsertLinkCrawlMetadata(c *gc.C) {
+	retrievedAt := time.Now().Truncate(time.Second).UTC()
+	link := &graph.Link{
+		URL:          "https://example.com",
+		RetrievedAt:  retrievedAt,
+		ETag:         `"abc123"`,
+		LastModified: retrievedAt.Add(-time.Hour),
+		ContentHash:  "d41d8cd98f00b204e9800998ecf8427e",
+		HTTPStatus:   200,
+	}
+	c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+
+	stored, err := s.g.FindLink(context.TODO(), link.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(stored, gc.DeepEquals, link, gc.Commentf("crawl metadata was not persisted"))
+
+	// Upserting an older copy of the link (e.g. a link discovered while
+	// crawling another page) must not clobber the crawl metadata.
+	discovered := &graph.Link{URL: link.URL}
+	c.Assert(s.g.UpsertLink(context.TODO(), discovered), gc.IsNil)
+	c.Assert(discovered.ID, gc.Equals, link.ID)
+
+	stored, err = s.g.FindLink(context.TODO(), link.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(stored, gc.DeepEquals, link, gc.Commentf("crawl metadata was overwritten by an older link"))
+
+	// A failed fetch attempt with a newer timestamp updates the metadata.
+	failed := &graph.Link{
+		URL:          link.URL,
+		RetrievedAt:  retrievedAt.Add(time.Minute),
+		ETag:         link.ETag,
+		LastModified: link.LastModified,
+		ContentHash:  link.ContentHash,
+		HTTPStatus:   503,
+		FailureCount: 1,
+	}
+	c.Assert(s.g.UpsertLink(context.TODO(), failed), gc.IsNil)
+
+	stored, err = s.g.FindLink(context.TODO(), link.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(stored, gc.DeepEquals, failed, gc.Commentf("crawl metadata was not updated"))
+
+	// The metadata should also be returned by the link iterators.
+	it, err := s.partitionedLinkIterator(c, 0, 1, time.Now().Add(time.Hour))
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.Next(), gc.Equals, true)
+	c.Assert(it.Link(), gc.DeepEquals, failed)
+	c.Assert(it.Next(), gc.Equals, false)
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+}
+
+// TestLinksDueForCrawl verifies that links can be queried by their crawl
+// schedule and that blocked or gone links are excluded.
+func (s *SuiteBase) TestLinksDueForCrawl(c *gc.C) {
+	now := time.Now().Truncate(time.Second).UTC()
+	links := []*graph.Link{
+		{URL: "pending", NextCrawlAt: now.Add(-time.Hour)},
+		{URL: "crawled", Status: graph.LinkStatusCrawled, RetrievedAt: now, NextCrawlAt: now.Add(-2 * time.Hour)},
+		{URL: "failed", Status: graph.LinkStatusFailed, RetrievedAt: now, NextCrawlAt: now.Add(-time.Minute)},
+		{URL: "not-due", Status: graph.LinkStatusCrawled, RetrievedAt: now, NextCrawlAt: now.Add(time.Hour)},
+		{URL: "blocked", Status: graph.LinkStatusBlocked, RetrievedAt: now},
+		{URL: "gone", Status: graph.LinkStatusGone, RetrievedAt: now},
+	}
+	c.Assert(s.g.UpsertLinks(context.TODO(), links), gc.IsNil)
+
+	stored, err := s.g.FindLink(context.TODO(), links[2].ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(stored.Status, gc.Equals, graph.LinkStatusFailed)
+	c.Assert(stored.NextCrawlAt, gc.Equals, links[2].NextCrawlAt)
+
+	from, to := partition.MinUUID, partition.MaxUUID
+	it, err := s.g.LinksDueForCrawl(context.TODO(), from, to, now)
+	c.Assert(err, gc.IsNil)
+	var got []string
+	for it.Next() {
+		got = append(got, it.Link().URL)
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+
+	// Links should be returned in the order they are due.
+	c.Assert(got, gc.DeepEquals, []string{"crawled", "pending", "failed"})
+}
+
+// TestInvalidURL verifies that links with an invalid URL are rejected.
+func (s *SuiteBase) TestInvalidURL(c *gc.C) {
+	for _, rawURL := range []string{"", "http://[::1", "http://example.com/\x7f", "http://example.com/\xff"} {
+		err := s.g.UpsertLink(context.TODO(), &graph.Link{URL: rawURL})
+		c.Assert(xerrors.Is(err, graph.ErrInvalidURL), gc.Equals, true, gc.Commentf("URL %q: got error %v", rawURL, err))
+		c.Assert(graph.IsRetryable(err), gc.Equals, false)
+	}
+
+	links := []*graph.Link{{URL: "http://example.com"}, {URL: ""}}
+	err := s.g.UpsertLinks(context.TODO(), links)
+	batchErr, ok := err.(*graph.BatchError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("expected a *graph.BatchError; got %T", err))
+	c.Assert(batchErr.Errors[0], gc.IsNil)
+	c.Assert(xerrors.Is(batchErr.Errors[1], graph.ErrInvalidURL), gc.Equals, true)
+	c.Assert(links[0].ID, gc.Not(gc.Equals), uuid.Nil)
+}
+
+// TestUpsertLinkWithID verifies that new links keep the ID provided by the
+// caller.
+func (s *SuiteBase) TestUpsertLinkWithID(c *gc.C) {
+	link := &graph.Link{ID: uuid.New(), URL: "https://example.com"}
+	id := link.ID
+	c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+	c.Assert(link.ID, gc.Equals, id)
+
+	stored, err := s.g.FindLink(context.TODO(), id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(stored.URL, gc.Equals, link.URL)
+
+	// Upserting an existing URL with a different ID yields the ID of the
+	// existing link.
+	sameURL := &graph.Link{ID: uuid.New(), URL: link.URL}
+	c.Assert(s.g.UpsertLink(context.TODO(), sameURL), gc.IsNil)
+	c.Assert(sameURL.ID, gc.Equals, id)
+
+	// Reusing the ID for a different URL is not allowed.
+	err = s.g.UpsertLink(context.TODO(), &graph.Link{ID: id, URL: "https://example.com/other"})
+	c.Assert(xerrors.Is(err, graph.ErrLinkIDInUse), gc.Equals, true, gc.Commentf("got error: %v", err))
+
+	links := []*graph.Link{
+		{ID: uuid.New(), URL: "https://example.com/a"},
+		{ID: id, URL: "https://example.com/b"},
+	}
+	expID := links[0].ID
+	err = s.g.UpsertLinks(context.TODO(), links)
+	batchErr, ok := err.(*graph.BatchError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("expected a *graph.BatchError; got %T", err))
+	c.Assert(batchErr.Errors[0], gc.IsNil)
+	c.Assert(xerrors.Is(batchErr.Errors[1], graph.ErrLinkIDInUse), gc.Equals, true)
+	c.Assert(links[0].ID, gc.Equals, expID)
+
+	_, err = s.g.FindLinkByURL(context.TODO(), "https://example.com/b")
+	c.Assert(xerrors.Is(err, graph.ErrNotFound), gc.Equals, true)
+}
+
+// TestUpsertLinkWithReservedID verifies that the all-ones ID, which no ID
+// range can include, cannot be assigned to a link.
+func (s *SuiteBase) TestUpsertLinkWithReservedID(c *gc.C) {
+	err := s.g.UpsertLink(context.TODO(), &graph.Link{ID: partition.MaxUUID, URL: "https://example.com"})
+	c.Assert(xerrors.Is(err, graph.ErrInvalidLinkID), gc.Equals, true, gc.Commentf("got error: %v", err))
+
+	links := []*graph.Link{
+		{URL: "https://example.com/a"},
+		{ID: partition.MaxUUID, URL: "https://example.com/b"},
+	}
+	err = s.g.UpsertLinks(context.TODO(), links)
+	batchErr, ok := err.(*graph.BatchError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("expected a *graph.BatchError; got %T", err))
+	c.Assert(batchErr.Errors[0], gc.IsNil)
+	c.Assert(xerrors.Is(batchErr.Errors[1], graph.ErrInvalidLinkID), gc.Equals, true)
+
+	_, err = s.g.FindLink(context.TODO(), partition.MaxUUID)
+	c.Assert(xerrors.Is(err, graph.ErrNotFound), gc.Equals, true)
+}
+
+// TestUpsertLinks verifies the batch link upsert logic.
+func (s *SuiteBase) TestUpsertLinks(c *gc.C) {
+	existing := &graph.Link{URL: "https://example.com/0", RetrievedAt: time.Now().Truncate(time.Second).UTC()}
+	c.Assert(s.g.UpsertLink(context.TODO(), existing), gc.IsNil)
+
+	numLinks := 300
+	links := make([]*graph.Link, numLinks)
+	for i := 0; i < numLinks; i++ {
+		links[i] = &graph.Link{URL: fmt.Sprintf("https://example.com/%d", i)}
+	}
+
+	// Include the same URL twice to verify that duplicates within a
+	// batch are handled correctly.
+	links = append(links, &graph.Link{URL: "https://example.com/1"})
+
+	c.Assert(s.g.UpsertLinks(context.TODO(), links), gc.IsNil)
+
+	seen := make(map[uuid.UUID]string)
+	for i, link := range links {
+		c.Assert(link.ID, gc.Not(gc.Equals), uuid.Nil, gc.Commentf("expected a linkID to be assigned to link %d", i))
+		if url, exists := seen[link.ID]; exists {
+			c.Assert(link.URL, gc.Equals, url, gc.Commentf("same ID assigned to links with different URLs"))
+		}
+		seen[link.ID] = link.URL
+
+		stored, err := s.g.FindLink(context.TODO(), link.ID)
+		c.Assert(err, gc.IsNil)
+		c.Assert(stored.URL, gc.Equals, link.URL)
+	}
+	c.Assert(seen, gc.HasLen, numLinks)
+
+	c.Assert(links[0].ID, gc.Equals, existing.ID, gc.Commentf("expected existing link to be updated"))
+	c.Assert(links[0].RetrievedAt, gc.Equals, existing.RetrievedAt, gc.Commentf("last accessed timestamp was overwritten with an older value"))
+	c.Assert(links[numLinks].ID, gc.Equals, links[1].ID)
+}
+
 // TestConcurrentLinkIterators verifies that multiple clients can concurrently
 // access the store.
 func (s *SuiteBase) TestConcurrentLinkIterators(c *gc.C) {
@@ -108,7 +330,7 @@ func (s *SuiteBase) TestConcurrentLinkIterators(c *gc.C) {
 
 	for i := 0; i < numLinks; i++ {
 		link := &graph.Link{URL: fmt.Sprint(i)}
-		c.Assert(s.g.UpsertLink(link), gc.IsNil)
+		c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
 	}
 
 	wg.Add(numIterators)
@@ -158,7 +380,7 @@ func (s *SuiteBase) TestLinkIteratorTimeFilter(c *gc.C) {
 	linkInsertTimes := make([]time.Time, len(linkUUIDs))
 	for i := 0; i < len(linkUUIDs); i++ {
 		link := &graph.Link{URL: fmt.Sprint(i), RetrievedAt: time.Now()}
-		c.Assert(s.g.UpsertLink(link), gc.IsNil)
+		c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
 		linkUUIDs[i] = link.ID
 		linkInsertTimes[i] = time.Now()
 	}
@@ -191,7 +413,7 @@ func (s *SuiteBase) TestPartitionedLinkIterators(c *gc.C) {
 	numLinks := 100
 	numPartitions := 10
 	for i := 0; i < numLinks; i++ {
-		c.Assert(s.g.UpsertLink(&graph.Link{URL: fmt.Sprint(i)}), gc.IsNil)
+		c.Assert(s.g.UpsertLink(context.TODO(), &graph.Link{URL: fmt.Sprint(i)}), gc.IsNil)
 	}
 
 	// Check with both odd and even partition counts to check for rounding-related bugs.
@@ -228,7 +450,7 @@ func (s *SuiteBase) TestUpsertEdge(c *gc.C) {
 	linkUUIDs := make([]uuid.UUID, 3)
 	for i := 0; i < 3; i++ {
 		link := &graph.Link{URL: fmt.Sprint(i)}
-		c.Assert(s.g.UpsertLink(link), gc.IsNil)
+		c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
 		linkUUIDs[i] = link.ID
 	}
 
@@ -238,7 +460,7 @@ func (s *SuiteBase) TestUpsertEdge(c *gc.C) {
 		Destination: linkUUIDs[1],
 	}
 
-	err := s.g.UpsertEdge(edge)
+	err := s.g.UpsertEdge(context.TODO(), edge)
 	c.Assert(err, gc.IsNil)
 	c.Assert(edge.ID, gc.Not(gc.Equals), uuid.Nil, gc.Commentf("expected an edgeID to be assigned to the new edge"))
 	c.Assert(edge.UpdatedAt.IsZero(), gc.Equals, false, gc.Commentf("UpdatedAt field not set"))
@@ -249,7 +471,7 @@ func (s *SuiteBase) TestUpsertEdge(c *gc.C) {
 		Source:      linkUUIDs[0],
 		Destination: linkUUIDs[1],
 	}
-	err = s.g.UpsertEdge(other)
+	err = s.g.UpsertEdge(context.TODO(), other)
 	c.Assert(err, gc.IsNil)
 	c.Assert(other.ID, gc.Equals, edge.ID, gc.Commentf("edge ID changed while upserting"))
 	c.Assert(other.UpdatedAt, gc.Not(gc.Equals), edge.UpdatedAt, gc.Commentf("UpdatedAt field not modified"))
@@ -259,10 +481,151 @@ func (s *SuiteBase) TestUpsertEdge(c *gc.C) {
 		Source:      linkUUIDs[0],
 		Destination: uuid.New(),
 	}
-	err = s.g.UpsertEdge(bogus)
+	err = s.g.UpsertEdge(context.TODO(), bogus)
 	c.Assert(xerrors.Is(err, graph.ErrUnknownEdgeLinks), gc.Equals, true)
 }
 
+// TestUpsertEdgeWithID verifies that new edges keep the ID provided by the
+// caller.
+func (s *SuiteBase) TestUpsertEdgeWithID(c *gc.C) {
+	links := make([]*graph.Link, 3)
+	for i := range links {
+		links[i] = &graph.Link{URL: fmt.Sprint(i)}
+	}
+	c.Assert(s.g.UpsertLinks(context.TODO(), links), gc.IsNil)
+
+	edge := &graph.Edge{ID: uuid.New(), Source: links[0].ID, Destination: links[1].ID}
+	id := edge.ID
+	c.Assert(s.g.UpsertEdge(context.TODO(), edge), gc.IsNil)
+	c.Assert(edge.ID, gc.Equals, id)
+	s.assertStoredEdge(c, edge)
+
+	// Upserting an edge between connected links with a different ID
+	// yields the ID of the existing edge.
+	sameLinks := &graph.Edge{ID: uuid.New(), Source: links[0].ID, Destination: links[1].ID}
+	c.Assert(s.g.UpsertEdge(context.TODO(), sameLinks), gc.IsNil)
+	c.Assert(sameLinks.ID, gc.Equals, id)
+
+	// Reusing the ID for a different pair of links is not allowed.
+	err := s.g.UpsertEdge(context.TODO(), &graph.Edge{ID: id, Source: links[0].ID, Destination: links[2].ID})
+	c.Assert(xerrors.Is(err, graph.ErrEdgeIDInUse), gc.Equals, true, gc.Commentf("got error: %v", err))
+
+	edges := []*graph.Edge{
+		{ID: uuid.New(), Source: links[2].ID, Destination: links[0].ID},
+		{ID: id, Source: links[0].ID, Destination: links[2].ID},
+	}
+	expID := edges[0].ID
+	err = s.g.UpsertEdges(context.TODO(), edges)
+	batchErr, ok := err.(*graph.BatchError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("expected a *graph.BatchError; got %T", err))
+	c.Assert(batchErr.Errors[0], gc.IsNil)
+	c.Assert(xerrors.Is(batchErr.Errors[1], graph.ErrEdgeIDInUse), gc.Equals, true)
+	c.Assert(edges[0].ID, gc.Equals, expID)
+	s.assertStoredEdge(c, edges[0])
+}
+
+// TestUpsertEdgeAttributes verifies that edge attributes are persisted and
+// replaced when an existing edge is upserted.
+func (s *SuiteBase) TestUpsertEdgeAttributes(c *gc.C) {
+	src := &graph.Link{URL: "src"}
+	c.Assert(s.g.UpsertLink(context.TODO(), src), gc.IsNil)
+	dst := &graph.Link{URL: "dst"}
+	c.Assert(s.g.UpsertLink(context.TODO(), dst), gc.IsNil)
+
+	edge := &graph.Edge{
+		Source:      src.ID,
+		Destination: dst.ID,
+		AnchorText:  "a sponsored link",
+		Nofollow:    true,
+		Sponsored:   true,
+		Weight:      0.25,
+	}
+	c.Assert(s.g.UpsertEdge(context.TODO(), edge), gc.IsNil)
+	s.assertStoredEdge(c, edge)
+
+	// Upserting the edge again should replace its attributes.
+	updated := &graph.Edge{
+		Source:      src.ID,
+		Destination: dst.ID,
+		AnchorText:  "a user comment",
+		UGC:         true,
+		Weight:      1.5,
+	}
+	c.Assert(s.g.UpsertEdges(context.TODO(), []*graph.Edge{updated}), gc.IsNil)
+	c.Assert(updated.ID, gc.Equals, edge.ID)
+	c.Assert(updated.AnchorText, gc.Equals, "a user comment")
+	c.Assert(updated.Nofollow, gc.Equals, false)
+	c.Assert(updated.Sponsored, gc.Equals, false)
+	c.Assert(updated.UGC, gc.Equals, true)
+	c.Assert(updated.Weight, gc.Equals, 1.5)
+	s.assertStoredEdge(c, updated)
+}
+
+// assertStoredEdge verifies that the edge returned by the edge iterator
+// matches exp.
+func (s *SuiteBase) assertStoredEdge(c *gc.C, exp *graph.Edge) {
+	from, to := partition.MinUUID, partition.MaxUUID
+	it, err := s.g.Edges(context.TODO(), from, to, time.Now().Add(time.Hour))
+	c.Assert(err, gc.IsNil)
+
+	var found bool
+	for it.Next() {
+		if edge := it.Edge(); edge.ID == exp.ID {
+			c.Assert(edge, gc.DeepEquals, exp)
+			found = true
+		}
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+	c.Assert(found, gc.Equals, true, gc.Commentf("edge %v not found", exp.ID))
+}
+
+// TestUpsertEdges verifies the batch edge upsert logic.
+func (s *SuiteBase) TestUpsertEdges(c *gc.C) {
+	numLinks := 300
+	links := make([]*graph.Link, numLinks)
+	for i := 0; i < numLinks; i++ {
+		links[i] = &graph.Link{URL: fmt.Sprint(i)}
+	}
+	c.Assert(s.g.UpsertLinks(context.TODO(), links), gc.IsNil)
+
+	existing := &graph.Edge{Source: links[0].ID, Destination: links[1].ID}
+	c.Assert(s.g.UpsertEdge(context.TODO(), existing), gc.IsNil)
+
+	edges := make([]*graph.Edge, numLinks-1)
+	for i := 0; i < len(edges); i++ {
+		edges[i] = &graph.Edge{Source: links[0].ID, Destination: links[i+1].ID}
+	}
+	c.Assert(s.g.UpsertEdges(context.TODO(), edges), gc.IsNil)
+
+	seen := make(map[uuid.UUID]bool)
+	for i, edge := range edges {
+		c.Assert(edge.ID, gc.Not(gc.Equals), uuid.Nil, gc.Commentf("expected an edgeID to be assigned to edge %d", i))
+		c.Assert(edge.UpdatedAt.IsZero(), gc.Equals, false, gc.Commentf("UpdatedAt field not set"))
+		c.Assert(seen[edge.ID], gc.Equals, false, gc.Commentf("same ID assigned to different edges"))
+		seen[edge.ID] = true
+	}
+	c.Assert(edges[0].ID, gc.Equals, existing.ID, gc.Commentf("expected existing edge to be updated"))
+
+	// Submit a batch where only some of the edges reference unknown links.
+	bogus := []*graph.Edge{
+		{Source: links[1].ID, Destination: links[2].ID},
+		{Source: links[1].ID, Destination: uuid.New()},
+		{Source: links[2].ID, Destination: links[1].ID},
+	}
+	err := s.g.UpsertEdges(context.TODO(), bogus)
+	c.Assert(err, gc.NotNil)
+
+	batchErr, ok := err.(*graph.BatchError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("expected a *graph.BatchError; got %T", err))
+	c.Assert(batchErr.Errors, gc.HasLen, len(bogus))
+	c.Assert(batchErr.Errors[0], gc.IsNil)
+	c.Assert(xerrors.Is(batchErr.Errors[1], graph.ErrUnknownEdgeLinks), gc.Equals, true)
+	c.Assert(batchErr.Errors[2], gc.IsNil)
+	c.Assert(bogus[0].ID, gc.Not(gc.Equals), uuid.Nil)
+	c.Assert(bogus[2].ID, gc.Not(gc.Equals), uuid.Nil)
+}
+
 // TestConcurrentEdgeIterators verifies that multiple clients can concurrently
 // access the store.
 func (s *SuiteBase) TestConcurrentEdgeIterators(c *gc.C) {
@@ -275,11 +638,11 @@ func (s *SuiteBase) TestConcurrentEdgeIterators(c *gc.C) {
 
 	for i := 0; i < numEdges*2; i++ {
 		link := &graph.Link{URL: fmt.Sprint(i)}
-		c.Assert(s.g.UpsertLink(link), gc.IsNil)
+		c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
 		linkUUIDs[i] = link.ID
 	}
 	for i := 0; i < numEdges; i++ {
-		c.Assert(s.g.UpsertEdge(&graph.Edge{
+		c.Assert(s.g.UpsertEdge(context.TODO(), &graph.Edge{
 			Source:      linkUUIDs[0],
 			Destination: linkUUIDs[i],
 		}), gc.IsNil)
@@ -332,7 +695,7 @@ func (s *SuiteBase) TestEdgeIteratorTimeFilter(c *gc.C) {
 	linkInsertTimes := make([]time.Time, len(linkUUIDs))
 	for i := 0; i < len(linkUUIDs); i++ {
 		link := &graph.Link{URL: fmt.Sprint(i)}
-		c.Assert(s.g.UpsertLink(link), gc.IsNil)
+		c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
 		linkUUIDs[i] = link.ID
 		linkInsertTimes[i] = time.Now()
 	}
@@ -341,7 +704,7 @@ func (s *SuiteBase) TestEdgeIteratorTimeFilter(c *gc.C) {
 	edgeInsertTimes := make([]time.Time, len(linkUUIDs))
 	for i := 0; i < len(linkUUIDs); i++ {
 		edge := &graph.Edge{Source: linkUUIDs[0], Destination: linkUUIDs[i]}
-		c.Assert(s.g.UpsertEdge(edge), gc.IsNil)
+		c.Assert(s.g.UpsertEdge(context.TODO(), edge), gc.IsNil)
 		edgeUUIDs[i] = edge.ID
 		edgeInsertTimes[i] = time.Now()
 	}
@@ -376,11 +739,11 @@ func (s *SuiteBase) TestPartitionedEdgeIterators(c *gc.C) {
 	linkUUIDs := make([]uuid.UUID, numEdges*2)
 	for i := 0; i < numEdges*2; i++ {
 		link := &graph.Link{URL: fmt.Sprint(i)}
-		c.Assert(s.g.UpsertLink(link), gc.IsNil)
+		c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
 		linkUUIDs[i] = link.ID
 	}
 	for i := 0; i < numEdges; i++ {
-		c.Assert(s.g.UpsertEdge(&graph.Edge{
+		c.Assert(s.g.UpsertEdge(context.TODO(), &graph.Edge{
 			Source:      linkUUIDs[0],
 			Destination: linkUUIDs[i],
 		}), gc.IsNil)
@@ -427,6 +790,53 @@ func (s *SuiteBase) iteratePartitionedEdges(c *gc.C, numPartitions int) int {
 	return len(seen)
 }
 
+// TestInEdges verifies that the edges pointing to a link can be retrieved.
+func (s *SuiteBase) TestInEdges(c *gc.C) {
+	links := make([]*graph.Link, 4)
+	for i := 0; i < len(links); i++ {
+		links[i] = &graph.Link{URL: fmt.Sprint(i)}
+		c.Assert(s.g.UpsertLink(context.TODO(), links[i]), gc.IsNil)
+	}
+
+	dstID := links[0].ID
+	var inEdgeTimes []time.Time
+	for i := 1; i < len(links); i++ {
+		c.Assert(s.g.UpsertEdge(context.TODO(), &graph.Edge{Source: links[i].ID, Destination: dstID}), gc.IsNil)
+		inEdgeTimes = append(inEdgeTimes, time.Now())
+	}
+	c.Assert(s.g.UpsertEdge(context.TODO(), &graph.Edge{Source: dstID, Destination: links[1].ID}), gc.IsNil)
+
+	s.assertInEdgeSourcesMatch(c, dstID, time.Now(), []uuid.UUID{links[1].ID, links[2].ID, links[3].ID})
+	s.assertInEdgeSourcesMatch(c, dstID, inEdgeTimes[0], []uuid.UUID{links[1].ID})
+	s.assertInEdgeSourcesMatch(c, links[1].ID, time.Now(), []uuid.UUID{dstID})
+
+	// Removing edges or links should also update the inbound edge lists.
+	c.Assert(s.g.RemoveStaleEdges(context.TODO(), links[2].ID, time.Now()), gc.IsNil)
+	s.assertInEdgeSourcesMatch(c, dstID, time.Now(), []uuid.UUID{links[1].ID, links[3].ID})
+	c.Assert(s.g.RemoveLink(context.TODO(), links[3].ID), gc.IsNil)
+	s.assertInEdgeSourcesMatch(c, dstID, time.Now(), []uuid.UUID{links[1].ID})
+	c.Assert(s.g.RemoveLink(context.TODO(), dstID), gc.IsNil)
+	s.assertInEdgeSourcesMatch(c, links[1].ID, time.Now(), nil)
+}
+
+func (s *SuiteBase) assertInEdgeSourcesMatch(c *gc.C, dstID uuid.UUID, updatedBefore time.Time, exp []uuid.UUID) {
+	it, err := s.g.InEdges(context.TODO(), dstID, updatedBefore)
+	c.Assert(err, gc.IsNil)
+
+	var got []uuid.UUID
+	for it.Next() {
+		edge := it.Edge()
+		c.Assert(edge.Destination, gc.Equals, dstID)
+		got = append(got, edge.Source)
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+
+	sort.Slice(got, func(l, r int) bool { return got[l].String() < got[r].String() })
+	sort.Slice(exp, func(l, r int) bool { return exp[l].String() < exp[r].String() })
+	c.Assert(got, gc.DeepEquals, exp)
+}
+
 // TestRemoveStaleEdges verifies that the edge deletion logic works as expected.
 func (s *SuiteBase) TestRemoveStaleEdges(c *gc.C) {
 	numEdges := 100
@@ -434,7 +844,7 @@ func (s *SuiteBase) TestRemoveStaleEdges(c *gc.C) {
 	goneUUIDs := make(map[uuid.UUID]struct{})
 	for i := 0; i < numEdges*4; i++ {
 		link := &graph.Link{URL: fmt.Sprint(i)}
-		c.Assert(s.g.UpsertLink(link), gc.IsNil)
+		c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
 		linkUUIDs[i] = link.ID
 	}
 
@@ -444,7 +854,7 @@ func (s *SuiteBase) TestRemoveStaleEdges(c *gc.C) {
 			Source:      linkUUIDs[0],
 			Destination: linkUUIDs[i],
 		}
-		c.Assert(s.g.UpsertEdge(e1), gc.IsNil)
+		c.Assert(s.g.UpsertEdge(context.TODO(), e1), gc.IsNil)
 		goneUUIDs[e1.ID] = struct{}{}
 		lastTs = e1.UpdatedAt
 	}
@@ -458,9 +868,9 @@ func (s *SuiteBase) TestRemoveStaleEdges(c *gc.C) {
 			Source:      linkUUIDs[0],
 			Destination: linkUUIDs[numEdges+i+1],
 		}
-		c.Assert(s.g.UpsertEdge(e2), gc.IsNil)
+		c.Assert(s.g.UpsertEdge(context.TODO(), e2), gc.IsNil)
 	}
-	c.Assert(s.g.RemoveStaleEdges(linkUUIDs[0], deleteBefore), gc.IsNil)
+	c.Assert(s.g.RemoveStaleEdges(context.TODO(), linkUUIDs[0], deleteBefore), gc.IsNil)
 
 	it, err := s.partitionedEdgeIterator(c, 0, 1, time.Now())
 	c.Assert(err, gc.IsNil)
@@ -477,51 +887,407 @@ func (s *SuiteBase) TestRemoveStaleEdges(c *gc.C) {
 	c.Assert(seen, gc.Equals, numEdges)
 }
 
-func (s *SuiteBase) partitionedLinkIterator(c *gc.C, partition, numPartitions int, accessedBefore time.Time) (graph.LinkIterator, error) {
-	from, to := s.partitionRange(c, partition, numPartitions)
-	return s.g.Links(from, to, accessedBefore)
+// TestRemoveLink verifies that removing a link also removes all edges that
+// originate from or point to it.
+func (s *SuiteBase) TestRemoveLink(c *gc.C) {
+	links := make([]*graph.Link, 3)
+	for i := 0; i < len(links); i++ {
+		links[i] = &graph.Link{URL: fmt.Sprint(i)}
+		c.Assert(s.g.UpsertLink(context.TODO(), links[i]), gc.IsNil)
+	}
+
+	edgeEndpoints := [][2]int{{0, 1}, {1, 2}, {2, 0}, {1, 0}, {0, 2}}
+	for _, endpoints := range edgeEndpoints {
+		c.Assert(s.g.UpsertEdge(context.TODO(), &graph.Edge{
+			Source:      links[endpoints[0]].ID,
+			Destination: links[endpoints[1]].ID,
+		}), gc.IsNil)
+	}
+
+	removedID := links[0].ID
+	c.Assert(s.g.RemoveLink(context.TODO(), removedID), gc.IsNil)
+
+	_, err := s.g.FindLink(context.TODO(), removedID)
+	c.Assert(xerrors.Is(err, graph.ErrNotFound), gc.Equals, true)
+
+	// Removing the same link twice should fail.
+	err = s.g.RemoveLink(context.TODO(), removedID)
+	c.Assert(xerrors.Is(err, graph.ErrNotFound), gc.Equals, true)
+
+	// Removing stale edges from the remaining links must not trip over
+	// dangling edges that referenced the removed link.
+	c.Assert(s.g.RemoveStaleEdges(context.TODO(), links[2].ID, time.Now().Add(-time.Hour)), gc.IsNil)
+
+	// Only the 1->2 edge should survive.
+	it, err := s.partitionedEdgeIterator(c, 0, 1, time.Now().Add(time.Hour))
+	c.Assert(err, gc.IsNil)
+	var remaining []*graph.Edge
+	for it.Next() {
+		remaining = append(remaining, it.Edge())
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+	c.Assert(remaining, gc.HasLen, 1)
+	c.Assert(remaining[0].Source, gc.Equals, links[1].ID)
+	c.Assert(remaining[0].Destination, gc.Equals, links[2].ID)
+
+	// The URL of the removed link should be available for re-use.
+	readded := &graph.Link{URL: links[0].URL}
+	c.Assert(s.g.UpsertLink(context.TODO(), readded), gc.IsNil)
+	c.Assert(readded.ID, gc.Not(gc.Equals), removedID)
 }
 
-func (s *SuiteBase) partitionedEdgeIterator(c *gc.C, partition, numPartitions int, updatedBefore time.Time) (graph.EdgeIterator, error) {
-	from, to := s.partitionRange(c, partition, numPartitions)
-	return s.g.Edges(from, to, updatedBefore)
+// TestAliases verifies that aliases are resolved by link lookups and that
+// edges can be rewritten to point to canonical links.
+func (s *SuiteBase) TestAliases(c *gc.C) {
+	links := make([]*graph.Link, 4)
+	for i := 0; i < len(links); i++ {
+		links[i] = &graph.Link{URL: fmt.Sprintf("https://example.com/%d", i)}
+		c.Assert(s.g.UpsertLink(context.TODO(), links[i]), gc.IsNil)
+	}
+	src, alias, canonical, other := links[0], links[1], links[2], links[3]
+
+	edge := &graph.Edge{Source: src.ID, Destination: alias.ID}
+	c.Assert(s.g.UpsertEdge(context.TODO(), edge), gc.IsNil)
+
+	c.Assert(s.g.AddAlias(context.TODO(), alias.ID, canonical.ID), gc.IsNil)
+
+	found, err := s.g.FindLink(context.TODO(), alias.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.ID, gc.Equals, canonical.ID)
+	found, err = s.g.FindLinkByURL(context.TODO(), alias.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.ID, gc.Equals, canonical.ID)
+
+	// Edges should only be rewritten when explicitly requested.
+	from, to := partition.MinUUID, partition.MaxUUID
+	it, err := s.g.Edges(context.TODO(), from, to, time.Now().Add(time.Hour))
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.Next(), gc.Equals, true)
+	c.Assert(it.Edge().Destination, gc.Equals, alias.ID)
+	c.Assert(it.Close(), gc.IsNil)
+
+	it, err = s.g.CanonicalEdges(context.TODO(), from, to, time.Now().Add(time.Hour))
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.Next(), gc.Equals, true)
+	c.Assert(it.Edge().ID, gc.Equals, edge.ID)
+	c.Assert(it.Edge().Destination, gc.Equals, canonical.ID)
+	c.Assert(it.Next(), gc.Equals, false)
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+
+	// Aliasing the canonical link should update the existing alias too.
+	c.Assert(s.g.AddAlias(context.TODO(), canonical.ID, other.ID), gc.IsNil)
+	found, err = s.g.FindLink(context.TODO(), alias.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.ID, gc.Equals, other.ID)
+
+	// Cycles and unknown links should be rejected.
+	err = s.g.AddAlias(context.TODO(), other.ID, alias.ID)
+	c.Assert(xerrors.Is(err, graph.ErrAliasCycle), gc.Equals, true, gc.Commentf("got error: %v", err))
+	err = s.g.AddAlias(context.TODO(), other.ID, other.ID)
+	c.Assert(xerrors.Is(err, graph.ErrAliasCycle), gc.Equals, true, gc.Commentf("got error: %v", err))
+	err = s.g.AddAlias(context.TODO(), uuid.New(), other.ID)
+	c.Assert(xerrors.Is(err, graph.ErrNotFound), gc.Equals, true, gc.Commentf("got error: %v", err))
+
+	// Removing the canonical link drops its aliases.
+	c.Assert(s.g.RemoveLink(context.TODO(), other.ID), gc.IsNil)
+	found, err = s.g.FindLink(context.TODO(), alias.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.ID, gc.Equals, alias.ID)
 }
 
-func (s *SuiteBase) partitionRange(c *gc.C, partition, numPartitions int) (from, to uuid.UUID) {
-	if partition < 0 || partition >= numPartitions {
-		c.Fatal("invalid partition")
+// TestResumableLinkIteration verifies that links are iterated in ID order
+// and that an interrupted iteration can be resumed via a cursor.
+func (s *SuiteBase) TestResumableLinkIteration(c *gc.C) {
+	numLinks := 50
+	for i := 0; i < numLinks; i++ {
+		c.Assert(s.g.UpsertLink(context.TODO(), &graph.Link{URL: fmt.Sprint(i)}), gc.IsNil)
 	}
 
-	var minUUID = uuid.Nil
-	var maxUUID = uuid.MustParse("ffffffff-ffff-ffff-ffff-ffffffffffff")
-	var err error
+	from, to := partition.MinUUID, partition.MaxUUID
+	it, err := s.g.Links(context.TODO(), from, to, time.Now())
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.Cursor(), gc.Equals, graph.Cursor(""))
 
-	// Calculate the size of each partition as: (2^128 / numPartitions)
-	tokenRange := big.NewInt(0)
-	partSize := big.NewInt(0)
-	partSize.SetBytes(maxUUID[:])
-	partSize = partSize.Div(partSize, big.NewInt(int64(numPartitions)))
+	var (
+		seen   []uuid.UUID
+		cursor graph.Cursor
+	)
+	for i := 0; i < numLinks/2 && it.Next(); i++ {
+		seen = append(seen, it.Link().ID)
+		cursor = it.Cursor()
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
 
-	// We model the partitions as a segment that begins at minUUID (all
-	// bits set to zero) and ends at maxUUID (all bits set to 1). By
-	// setting the end range for the *last* partition to maxUUID we ensure
-	// that we always cover the full range of UUIDs even if the range
-	// itself is not evenly divisible by numPartitions.
-	if partition == 0 {
-		from = minUUID
-	} else {
-		tokenRange.Mul(partSize, big.NewInt(int64(partition)))
-		from, err = uuid.FromBytes(tokenRange.Bytes())
-		c.Assert(err, gc.IsNil)
+	// Resume the iteration from the last obtained cursor.
+	it, err = s.g.LinksAfter(context.TODO(), from, to, time.Now(), cursor)
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.Cursor(), gc.Equals, cursor)
+	for it.Next() {
+		seen = append(seen, it.Link().ID)
 	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
 
-	if partition == numPartitions-1 {
-		to = maxUUID
-	} else {
-		tokenRange.Mul(partSize, big.NewInt(int64(partition+1)))
-		to, err = uuid.FromBytes(tokenRange.Bytes())
-		c.Assert(err, gc.IsNil)
+	c.Assert(seen, gc.HasLen, numLinks)
+	assertIDsOrdered(c, seen)
+
+	// Resuming with a malformed cursor should fail.
+	_, err = s.g.LinksAfter(context.TODO(), from, to, time.Now(), "bogus!")
+	c.Assert(xerrors.Is(err, graph.ErrInvalidCursor), gc.Equals, true)
+}
+
+// TestResumableEdgeIteration verifies that edges are iterated in ID order
+// and that an interrupted iteration can be resumed via a cursor.
+func (s *SuiteBase) TestResumableEdgeIteration(c *gc.C) {
+	numEdges := 50
+	linkUUIDs := make([]uuid.UUID, numEdges)
+	for i := 0; i < numEdges; i++ {
+		link := &graph.Link{URL: fmt.Sprint(i)}
+		c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+		linkUUIDs[i] = link.ID
+	}
+	for i := 0; i < numEdges; i++ {
+		c.Assert(s.g.UpsertEdge(context.TODO(), &graph.Edge{
+			Source:      linkUUIDs[i%5],
+			Destination: linkUUIDs[i],
+		}), gc.IsNil)
 	}
 
+	from, to := partition.MinUUID, partition.MaxUUID
+	it, err := s.g.Edges(context.TODO(), from, to, time.Now())
+	c.Assert(err, gc.IsNil)
+
+	var (
+		seen   []uuid.UUID
+		cursor graph.Cursor
+	)
+	for i := 0; i < numEdges/2 && it.Next(); i++ {
+		seen = append(seen, it.Edge().ID)
+		cursor = it.Cursor()
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+
+	// Resume the iteration from the last obtained cursor.
+	it, err = s.g.EdgesAfter(context.TODO(), from, to, time.Now(), cursor)
+	c.Assert(err, gc.IsNil)
+	for it.Next() {
+		seen = append(seen, it.Edge().ID)
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+
+	c.Assert(seen, gc.HasLen, numEdges)
+	assertIDsOrdered(c, seen)
+
+	// Resuming with a malformed cursor should fail.
+	_, err = s.g.EdgesAfter(context.TODO(), from, to, time.Now(), "bogus!")
+	c.Assert(xerrors.Is(err, graph.ErrInvalidCursor), gc.Equals, true)
+}
+
+func assertIDsOrdered(c *gc.C, ids []uuid.UUID) {
+	for i := 1; i < len(ids); i++ {
+		c.Assert(bytes.Compare(ids[i-1][:], ids[i][:]) < 0, gc.Equals, true, gc.Commentf("IDs at index %d and %d are not in ascending order", i-1, i))
+	}
+}
+
+// TestSnapshot verifies that a graph snapshot is not affected by mutations
+// applied to the graph after the snapshot was taken.
+func (s *SuiteBase) TestSnapshot(c *gc.C) {
+	links := make([]*graph.Link, 4)
+	for i := 0; i < len(links); i++ {
+		links[i] = &graph.Link{URL: fmt.Sprint(i), RetrievedAt: time.Now().Add(-time.Hour).Truncate(time.Second).UTC()}
+		c.Assert(s.g.UpsertLink(context.TODO(), links[i]), gc.IsNil)
+	}
+	edges := make([]*graph.Edge, 3)
+	for i := 0; i < len(edges); i++ {
+		edges[i] = &graph.Edge{Source: links[0].ID, Destination: links[i+1].ID}
+		c.Assert(s.g.UpsertEdge(context.TODO(), edges[i]), gc.IsNil)
+	}
+
+	snap, err := s.g.Snapshot(context.TODO())
+	c.Assert(err, gc.IsNil)
+	defer func() { c.Assert(snap.Close(), gc.IsNil) }()
+
+	// Mutate the graph after taking the snapshot.
+	c.Assert(s.g.AddAlias(context.TODO(), links[2].ID, links[1].ID), gc.IsNil)
+	updated := &graph.Link{URL: links[0].URL, RetrievedAt: time.Now().Truncate(time.Second).UTC(), HTTPStatus: 200}
+	c.Assert(s.g.UpsertLink(context.TODO(), updated), gc.IsNil)
+	added := &graph.Link{URL: "added"}
+	c.Assert(s.g.UpsertLink(context.TODO(), added), gc.IsNil)
+	c.Assert(s.g.UpsertEdge(context.TODO(), &graph.Edge{Source: links[0].ID, Destination: added.ID}), gc.IsNil)
+	c.Assert(s.g.RemoveLink(context.TODO(), links[3].ID), gc.IsNil)
+
+	// The snapshot should still reflect the original graph.
+	stored, err := snap.FindLink(context.TODO(), links[0].ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(stored, gc.DeepEquals, links[0])
+	stored, err = snap.FindLink(context.TODO(), links[2].ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(stored, gc.DeepEquals, links[2])
+	_, err = snap.FindLink(context.TODO(), links[3].ID)
+	c.Assert(err, gc.IsNil)
+	_, err = snap.FindLink(context.TODO(), added.ID)
+	c.Assert(xerrors.Is(err, graph.ErrNotFound), gc.Equals, true)
+
+	from, to := partition.MinUUID, partition.MaxUUID
+	linkIt, err := snap.Links(context.TODO(), from, to, time.Now().Add(time.Hour))
+	c.Assert(err, gc.IsNil)
+	var gotLinks []*graph.Link
+	for linkIt.Next() {
+		gotLinks = append(gotLinks, linkIt.Link())
+	}
+	c.Assert(linkIt.Error(), gc.IsNil)
+	c.Assert(linkIt.Close(), gc.IsNil)
+	sort.Slice(gotLinks, func(l, r int) bool { return gotLinks[l].URL < gotLinks[r].URL })
+	c.Assert(gotLinks, gc.DeepEquals, links)
+
+	edgeIt, err := snap.Edges(context.TODO(), from, to, time.Now().Add(time.Hour))
+	c.Assert(err, gc.IsNil)
+	var gotEdgeIDs []uuid.UUID
+	for edgeIt.Next() {
+		gotEdgeIDs = append(gotEdgeIDs, edgeIt.Edge().ID)
+	}
+	c.Assert(edgeIt.Error(), gc.IsNil)
+	c.Assert(edgeIt.Close(), gc.IsNil)
+
+	expEdgeIDs := []uuid.UUID{edges[0].ID, edges[1].ID, edges[2].ID}
+	sort.Slice(gotEdgeIDs, func(l, r int) bool { return gotEdgeIDs[l].String() < gotEdgeIDs[r].String() })
+	sort.Slice(expEdgeIDs, func(l, r int) bool { return expEdgeIDs[l].String() < expEdgeIDs[r].String() })
+	c.Assert(gotEdgeIDs, gc.DeepEquals, expEdgeIDs)
+
+	// The live graph should reflect the mutations.
+	stored, err = s.g.FindLink(context.TODO(), links[0].ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(stored.HTTPStatus, gc.Equals, 200)
+}
+
+// TestIteratorCancellation verifies that link and edge iterators stop
+// returning results once their context is cancelled.
+func (s *SuiteBase) TestIteratorCancellation(c *gc.C) {
+	linkUUIDs := make([]uuid.UUID, 10)
+	for i := 0; i < len(linkUUIDs); i++ {
+		link := &graph.Link{URL: fmt.Sprint(i)}
+		c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+		linkUUIDs[i] = link.ID
+	}
+	for i := 0; i < len(linkUUIDs); i++ {
+		c.Assert(s.g.UpsertEdge(context.TODO(), &graph.Edge{
+			Source:      linkUUIDs[0],
+			Destination: linkUUIDs[i],
+		}), gc.IsNil)
+	}
+
+	from, to := partition.MinUUID, partition.MaxUUID
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	linkIt, err := s.g.Links(ctx, from, to, time.Now())
+	c.Assert(err, gc.IsNil)
+	c.Assert(linkIt.Next(), gc.Equals, true)
+	cancelFn()
+	c.Assert(linkIt.Next(), gc.Equals, false)
+	c.Assert(xerrors.Is(linkIt.Error(), context.Canceled), gc.Equals, true, gc.Commentf("got error: %v", linkIt.Error()))
+	_ = linkIt.Close()
+
+	ctx, cancelFn = context.WithCancel(context.TODO())
+	edgeIt, err := s.g.Edges(ctx, from, to, time.Now())
+	c.Assert(err, gc.IsNil)
+	c.Assert(edgeIt.Next(), gc.Equals, true)
+	cancelFn()
+	c.Assert(edgeIt.Next(), gc.Equals, false)
+	c.Assert(xerrors.Is(edgeIt.Error(), context.Canceled), gc.Equals, true, gc.Commentf("got error: %v", edgeIt.Error()))
+	_ = edgeIt.Close()
+
+	// Queries with an already cancelled context should fail.
+	_, err = s.g.Links(ctx, from, to, time.Now())
+	c.Assert(xerrors.Is(err, context.Canceled), gc.Equals, true, gc.Commentf("got error: %v", err))
+	_, err = s.g.Edges(ctx, from, to, time.Now())
+	c.Assert(xerrors.Is(err, context.Canceled), gc.Equals, true, gc.Commentf("got error: %v", err))
+}
+
+// TestWatch verifies that graph mutations are delivered to watchers both for
+// events that occurred before and after the watcher was created.
+func (s *SuiteBase) TestWatch(c *gc.C) {
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancelFn()
+
+	src := &graph.Link{URL: "src"}
+	c.Assert(s.g.UpsertLink(ctx, src), gc.IsNil)
+	dst := &graph.Link{URL: "dst"}
+	c.Assert(s.g.UpsertLink(ctx, dst), gc.IsNil)
+	edge := &graph.Edge{Source: src.ID, Destination: dst.ID}
+	c.Assert(s.g.UpsertEdge(ctx, edge), gc.IsNil)
+	c.Assert(s.g.RemoveStaleEdges(ctx, src.ID, time.Now().Add(time.Hour)), gc.IsNil)
+	c.Assert(s.g.RemoveLink(ctx, dst.ID), gc.IsNil)
+
+	// Past events should be replayed in order.
+	it, err := s.g.Watch(ctx, time.Time{})
+	c.Assert(err, gc.IsNil)
+	var events []*graph.Event
+	for i := 0; i < 5; i++ {
+		c.Assert(it.Next(), gc.Equals, true, gc.Commentf("expected event %d; got error: %v", i, it.Error()))
+		events = append(events, it.Event())
+	}
+
+	expTypes := []graph.EventType{graph.LinkUpserted, graph.LinkUpserted, graph.EdgeUpserted, graph.EdgeRemoved, graph.LinkRemoved}
+	for i, ev := range events {
+		c.Assert(ev.Type, gc.Equals, expTypes[i], gc.Commentf("event %d", i))
+	}
+	c.Assert(events[0].Link.ID, gc.Equals, src.ID)
+	c.Assert(events[1].Link.ID, gc.Equals, dst.ID)
+	c.Assert(events[2].Edge.ID, gc.Equals, edge.ID)
+	c.Assert(events[3].Edge.ID, gc.Equals, edge.ID)
+	c.Assert(events[4].Link.ID, gc.Equals, dst.ID)
+
+	// Mutations applied while the watcher is blocked should be delivered.
+	live := &graph.Link{URL: "live"}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = s.g.UpsertLink(ctx, live)
+	}()
+	c.Assert(it.Next(), gc.Equals, true, gc.Commentf("got error: %v", it.Error()))
+	c.Assert(it.Event().Type, gc.Equals, graph.LinkUpserted)
+	c.Assert(it.Event().Link.URL, gc.Equals, live.URL)
+	c.Assert(it.Close(), gc.IsNil)
+	c.Assert(it.Next(), gc.Equals, false)
+	c.Assert(it.Error(), gc.IsNil)
+
+	// Only events after the provided timestamp should be returned.
+	it, err = s.g.Watch(ctx, events[2].Timestamp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.Next(), gc.Equals, true, gc.Commentf("got error: %v", it.Error()))
+	c.Assert(it.Event().Type, gc.Equals, graph.EdgeRemoved)
+	c.Assert(it.Close(), gc.IsNil)
+
+	// Blocked watchers should be released when their context is cancelled.
+	watchCtx, watchCancelFn := context.WithCancel(ctx)
+	it, err = s.g.Watch(watchCtx, time.Now().Add(time.Hour))
+	c.Assert(err, gc.IsNil)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		watchCancelFn()
+	}()
+	c.Assert(it.Next(), gc.Equals, false)
+	c.Assert(xerrors.Is(it.Error(), context.Canceled), gc.Equals, true, gc.Commentf("got error: %v", it.Error()))
+	c.Assert(it.Close(), gc.IsNil)
+}
+
+func (s *SuiteBase) partitionedLinkIterator(c *gc.C, index, numPartitions int, accessedBefore time.Time) (graph.LinkIterator, error) {
+	from, to := s.partitionExtents(c, index, numPartitions)
+	return s.g.Links(context.TODO(), from, to, accessedBefore)
+}
+
+func (s *SuiteBase) partitionedEdgeIterator(c *gc.C, index, numPartitions int, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	from, to := s.partitionExtents(c, index, numPartitions)
+	return s.g.Edges(context.TODO(), from, to, updatedBefore)
+}
+
+func (s *SuiteBase) partitionExtents(c *gc.C, index, numPartitions int) (from, to uuid.UUID) {
+	r, err := partition.NewFullRange(numPartitions)
+	c.Assert(err, gc.IsNil)
+	from, to, err = r.PartitionExtents(index)
+	c.Assert(err, gc.IsNil)
 	return from, to
 }
@@ -0,0 +1,249 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"log"
+	"time"
+)
+
+// Compile-time check for ensuring Graph implements graph.Graph.
+var _ graph.Graph = (*Graph)(nil)
+
+// Divergence describes a difference between the contents of the primary and
+// the secondary graph.
+type Divergence struct {
+	// Op is the operation that detected the divergence.
+	Op string
+
+	// ID is the ID of the affected link. For edges, it is the ID of the
+	// edge source.
+	ID uuid.UUID
+
+	// Primary and Secondary contain the *graph.Link or *graph.Edge found
+	// in each graph. They are nil if the item is missing from a graph or
+	// if the divergence was caused by a failed write.
+	Primary   interface{}
+	Secondary interface{}
+
+	// Err is the error returned by the secondary graph, if any.
+	Err error
+}
+
+// String implements fmt.Stringer.
+func (d Divergence) String() string {
+	if d.Err != nil {
+		return fmt.Sprintf("%s %s: secondary error: %v", d.Op, d.ID, d.Err)
+	}
+	return fmt.Sprintf("%s %s: primary=%+v secondary=%+v", d.Op, d.ID, d.Primary, d.Secondary)
+}
+
+// ReportFn is invoked for every divergence detected between the primary and
+// the secondary graph.
+type ReportFn func(Divergence)
+
+// LogReporter returns a ReportFn that writes divergences to logger.
+func LogReporter(logger *log.Logger) ReportFn {
+	return func(d Divergence) {
+		logger.Printf("linkgraph mirror divergence: %s", d)
+	}
+}
+
+// Graph is a graph.Graph that applies all mutations to a primary and a
+// secondary graph and serves all reads from the primary. It allows data to
+// be migrated to a new backend while the primary remains the source of
+// truth.
+//
+// Mutations are applied to the secondary only after they succeed on the
+// primary. Links and edges are copied together with the IDs assigned by the
+// primary. Failures and ID mismatches on the secondary do not fail the
+// operation but are passed to the ReportFn instead.
+type Graph struct {
+	graph.Graph
+	secondary graph.Graph
+	report    ReportFn
+}
+
+// NewGraph returns a new Graph that mirrors the mutations applied to primary
+// to secondary.
+func NewGraph(primary, secondary graph.Graph, report ReportFn) *Graph {
+	return &Graph{
+		Graph:     primary,
+		secondary: secondary,
+		report:    report,
+	}
+}
+
+// UpsertLink implements graph.Graph.
+func (g *Graph) UpsertLink(ctx context.Context, link *graph.Link) error {
+	if err := g.Graph.UpsertLink(ctx, link); err != nil {
+		return err
+	}
+
+	lCopy := copyLink(link)
+	if err := g.secondary.UpsertLink(ctx, lCopy); err != nil {
+		g.report(Divergence{Op: "upsert link", ID: link.ID, Err: err})
+	} else if lCopy.ID != link.ID {
+		g.report(Divergence{Op: "upsert link", ID: link.ID, Primary: copyLink(link), Secondary: lCopy})
+	}
+	return nil
+}
+
+// UpsertLinks implements graph.Graph. Only the links that were successfully
+// upserted to the primary are mirrored to the secondary.
+func (g *Graph) UpsertLinks(ctx context.Context, links []*graph.Link) error {
+	primaryErr := g.Graph.UpsertLinks(ctx, links)
+	okIndices, err := succeededItems(len(links), primaryErr)
+	if err != nil {
+		return err
+	}
+
+	copies := make([]*graph.Link, len(okIndices))
+	for i, index := range okIndices {
+		copies[i] = copyLink(links[index])
+	}
+
+	secondaryErr := g.secondary.UpsertLinks(ctx, copies)
+	mirrored, err := succeededItems(len(copies), secondaryErr)
+	if err != nil {
+		for _, index := range okIndices {
+			g.report(Divergence{Op: "upsert links", ID: links[index].ID, Err: err})
+		}
+		return primaryErr
+	}
+
+	if batchErr, ok := secondaryErr.(*graph.BatchError); ok {
+		for i, err := range batchErr.Errors {
+			if err != nil {
+				g.report(Divergence{Op: "upsert links", ID: links[okIndices[i]].ID, Err: err})
+			}
+		}
+	}
+	for _, i := range mirrored {
+		if primary := links[okIndices[i]]; copies[i].ID != primary.ID {
+			g.report(Divergence{Op: "upsert links", ID: primary.ID, Primary: copyLink(primary), Secondary: copies[i]})
+		}
+	}
+	return primaryErr
+}
+
+// RemoveLink implements graph.Graph.
+func (g *Graph) RemoveLink(ctx context.Context, id uuid.UUID) error {
+	if err := g.Graph.RemoveLink(ctx, id); err != nil {
+		return err
+	}
+	if err := g.secondary.RemoveLink(ctx, id); err != nil {
+		g.report(Divergence{Op: "remove link", ID: id, Err: err})
+	}
+	return nil
+}
+
+// AddAlias implements graph.Graph.
+func (g *Graph) AddAlias(ctx context.Context, aliasID, canonicalID uuid.UUID) error {
+	if err := g.Graph.AddAlias(ctx, aliasID, canonicalID); err != nil {
+		return err
+	}
+	if err := g.secondary.AddAlias(ctx, aliasID, canonicalID); err != nil {
+		g.report(Divergence{Op: "add alias", ID: aliasID, Err: err})
+	}
+	return nil
+}
+
+// UpsertEdge implements graph.Graph.
+func (g *Graph) UpsertEdge(ctx context.Context, edge *graph.Edge) error {
+	if err := g.Graph.UpsertEdge(ctx, edge); err != nil {
+		return err
+	}
+	eCopy := copyEdge(edge)
+	if err := g.secondary.UpsertEdge(ctx, eCopy); err != nil {
+		g.report(Divergence{Op: "upsert edge", ID: edge.Source, Err: err})
+	} else if eCopy.ID != edge.ID {
+		g.report(Divergence{Op: "upsert edge", ID: edge.Source, Primary: copyEdge(edge), Secondary: eCopy})
+	}
+	return nil
+}
+
+// UpsertEdges implements graph.Graph. Only the edges that were successfully
+// upserted to the primary are mirrored to the secondary.
+func (g *Graph) UpsertEdges(ctx context.Context, edges []*graph.Edge) error {
+	primaryErr := g.Graph.UpsertEdges(ctx, edges)
+	okIndices, err := succeededItems(len(edges), primaryErr)
+	if err != nil {
+		return err
+	}
+
+	copies := make([]*graph.Edge, len(okIndices))
+	for i, index := range okIndices {
+		copies[i] = copyEdge(edges[index])
+	}
+
+	secondaryErr := g.secondary.UpsertEdges(ctx, copies)
+	mirrored, err := succeededItems(len(copies), secondaryErr)
+	if err != nil {
+		for _, edge := range copies {
+			g.report(Divergence{Op: "upsert edges", ID: edge.Source, Err: err})
+		}
+		return primaryErr
+	}
+
+	if batchErr, ok := secondaryErr.(*graph.BatchError); ok {
+		for i, err := range batchErr.Errors {
+			if err != nil {
+				g.report(Divergence{Op: "upsert edges", ID: copies[i].Source, Err: err})
+			}
+		}
+	}
+	for _, i := range mirrored {
+		if primary := edges[okIndices[i]]; copies[i].ID != primary.ID {
+			g.report(Divergence{Op: "upsert edges", ID: primary.Source, Primary: copyEdge(primary), Secondary: copies[i]})
+		}
+	}
+	return primaryErr
+}
+
+// RemoveStaleEdges implements graph.Graph.
+func (g *Graph) RemoveStaleEdges(ctx context.Context, fromID uuid.UUID, updatedBefore time.Time) error {
+	if err := g.Graph.RemoveStaleEdges(ctx, fromID, updatedBefore); err != nil {
+		return err
+	}
+	if err := g.secondary.RemoveStaleEdges(ctx, fromID, updatedBefore); err != nil {
+		g.report(Divergence{Op: "remove stale edges", ID: fromID, Err: err})
+	}
+	return nil
+}
+
+// succeededItems returns the indices of the batch items that were processed
+// successfully given the error returned by a batch operation. If err is not
+// a *graph.BatchError, it is returned back to the caller.
+func succeededItems(batchLen int, err error) ([]int, error) {
+	var itemErrs []error
+	if err != nil {
+		batchErr, ok := err.(*graph.BatchError)
+		if !ok {
+			return nil, err
+		}
+		itemErrs = batchErr.Errors
+	}
+
+	indices := make([]int, 0, batchLen)
+	for i := 0; i < batchLen; i++ {
+		if itemErrs == nil || itemErrs[i] == nil {
+			indices = append(indices, i)
+		}
+	}
+	return indices, nil
+}
+
+func copyLink(link *graph.Link) *graph.Link {
+	lCopy := new(graph.Link)
+	*lCopy = *link
+	return lCopy
+}
+
+func copyEdge(edge *graph.Edge) *graph.Edge {
+	eCopy := new(graph.Edge)
+	*eCopy = *edge
+	return eCopy
+}
@@ -0,0 +1,139 @@
+package mirror
+
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/graph/graphtest"
+	"github.com/kyteproject/search-engine/linkgraph/store/memory"
+	"sync"
+	"testing"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(MirrorGraphTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type MirrorGraphTestSuite struct {
+	graphtest.SuiteBase
+	primary   *memory.InMemoryGraph
+	secondary *memory.InMemoryGraph
+	g         *Graph
+	reports   *divergenceRecorder
+}
+
+func (s *MirrorGraphTestSuite) SetUpTest(c *gc.C) {
+	s.primary = memory.NewInMemoryGraph()
+	s.secondary = memory.NewInMemoryGraph()
+	s.reports = new(divergenceRecorder)
+	s.g = NewGraph(s.primary, s.secondary, s.reports.report)
+	s.SetGraph(s.g)
+}
+
+func (s *MirrorGraphTestSuite) TearDownTest(c *gc.C) {
+	// Every test in the shared suite must leave both graphs in sync.
+	c.Assert(s.reports.get(), gc.HasLen, 0)
+
+	verifier, err := NewVerifier(s.primary, s.secondary, 4, s.reports.report)
+	c.Assert(err, gc.IsNil)
+	stats, err := verifier.Verify(context.TODO())
+	c.Assert(err, gc.IsNil)
+	c.Assert(stats.Divergences, gc.Equals, 0, gc.Commentf("divergences: %v", s.reports.get()))
+}
+
+func (s *MirrorGraphTestSuite) TestWritesAreMirrored(c *gc.C) {
+	links := []*graph.Link{
+		{URL: "https://example.com/a"},
+		{URL: "https://example.com/b"},
+	}
+	c.Assert(s.g.UpsertLinks(context.TODO(), links), gc.IsNil)
+	edge := &graph.Edge{Source: links[0].ID, Destination: links[1].ID, AnchorText: "b"}
+	c.Assert(s.g.UpsertEdge(context.TODO(), edge), gc.IsNil)
+	c.Assert(s.g.AddAlias(context.TODO(), links[1].ID, links[0].ID), gc.IsNil)
+
+	for _, link := range links {
+		primary, err := s.primary.FindLink(context.TODO(), link.ID)
+		c.Assert(err, gc.IsNil)
+		secondary, err := s.secondary.FindLink(context.TODO(), link.ID)
+		c.Assert(err, gc.IsNil)
+		c.Assert(secondary, gc.DeepEquals, primary)
+	}
+
+	it, err := s.secondary.InEdges(context.TODO(), links[1].ID, time.Now())
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.Next(), gc.Equals, true)
+	c.Assert(it.Edge().ID, gc.Equals, edge.ID)
+	c.Assert(it.Close(), gc.IsNil)
+}
+
+func (s *MirrorGraphTestSuite) TestSecondaryFailuresAreReported(c *gc.C) {
+	// Store a link with the same URL but a different ID in the secondary.
+	c.Assert(s.secondary.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com/a"}), gc.IsNil)
+
+	link := &graph.Link{URL: "https://example.com/a"}
+	c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+
+	reports := s.reports.reset()
+	c.Assert(reports, gc.HasLen, 1)
+	c.Assert(reports[0].Op, gc.Equals, "upsert link")
+	c.Assert(reports[0].ID, gc.Equals, link.ID)
+	c.Assert(reports[0].Secondary.(*graph.Link).ID, gc.Not(gc.Equals), link.ID)
+
+	// Edges referencing the link cannot be mirrored either.
+	other := &graph.Link{URL: "https://example.com/b"}
+	c.Assert(s.g.UpsertLink(context.TODO(), other), gc.IsNil)
+	c.Assert(s.g.UpsertEdges(context.TODO(), []*graph.Edge{{Source: link.ID, Destination: other.ID}}), gc.IsNil)
+
+	reports = s.reports.reset()
+	c.Assert(reports, gc.HasLen, 1)
+	c.Assert(reports[0].Op, gc.Equals, "upsert edges")
+	c.Assert(reports[0].Err, gc.ErrorMatches, ".*unknown source and/or destination.*")
+
+	// Edges that already exist in the secondary keep their ID there.
+	third := &graph.Link{URL: "https://example.com/c"}
+	c.Assert(s.g.UpsertLink(context.TODO(), third), gc.IsNil)
+	c.Assert(s.secondary.UpsertEdge(context.TODO(), &graph.Edge{Source: other.ID, Destination: third.ID}), gc.IsNil)
+	edge := &graph.Edge{Source: other.ID, Destination: third.ID}
+	c.Assert(s.g.UpsertEdge(context.TODO(), edge), gc.IsNil)
+
+	reports = s.reports.reset()
+	c.Assert(reports, gc.HasLen, 1)
+	c.Assert(reports[0].Op, gc.Equals, "upsert edge")
+	c.Assert(reports[0].ID, gc.Equals, other.ID)
+	c.Assert(reports[0].Secondary.(*graph.Edge).ID, gc.Not(gc.Equals), edge.ID)
+	c.Assert(s.g.RemoveLink(context.TODO(), third.ID), gc.IsNil)
+
+	// Bring the graphs back in sync so that the teardown check passes.
+	secondary, err := s.secondary.FindLinkByURL(context.TODO(), link.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.secondary.RemoveLink(context.TODO(), secondary.ID), gc.IsNil)
+	c.Assert(s.primary.RemoveLink(context.TODO(), link.ID), gc.IsNil)
+}
+
+// divergenceRecorder collects the divergences passed to its report method.
+type divergenceRecorder struct {
+	mu          sync.Mutex
+	divergences []Divergence
+}
+
+func (r *divergenceRecorder) report(d Divergence) {
+	r.mu.Lock()
+	r.divergences = append(r.divergences, d)
+	r.mu.Unlock()
+}
+
+func (r *divergenceRecorder) get() []Divergence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Divergence(nil), r.divergences...)
+}
+
+func (r *divergenceRecorder) reset() []Divergence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	divergences := r.divergences
+	r.divergences = nil
+	return divergences
+}
@@ -0,0 +1,364 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/partition"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+// VerifyStats contains the results of a verification pass.
+type VerifyStats struct {
+	LinksChecked int
+	EdgesChecked int
+	Divergences  int
+}
+
+// edgeKey identifies an edge across graphs. Edges are matched by their
+// links rather than by ID so that an edge whose ID differs between the
+// graphs is reported as a single divergence.
+type edgeKey struct {
+	src, dst uuid.UUID
+}
+
+// maxTime is used for looking up edges regardless of their UpdatedAt value.
+var maxTime = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// Verifier compares the links and edges of a primary and a secondary graph
+// and reports any differences. Each partition of the UUID space is compared
+// using a snapshot of each graph.
+//
+// The snapshots of the two graphs are not taken at the same point in time,
+// so they may disagree about items that are modified while a pass is
+// running. Before reporting a difference, the affected item is therefore
+// read again from both graphs and only reported if it still differs.
+//
+// Links are matched by ID and edges by their source and destination. Edge
+// update timestamps are not compared as each graph assigns them
+// independently. Other timestamps are compared with microsecond precision,
+// which is the precision supported by CockroachDB.
+type Verifier struct {
+	primary   graph.Graph
+	secondary graph.Graph
+	report    ReportFn
+	partRange partition.Range
+}
+
+// NewVerifier returns a Verifier that compares primary and secondary by
+// splitting the UUID space into numPartitions partitions. The edges of
+// each partition are buffered in memory while it is being verified.
+func NewVerifier(primary, secondary graph.Graph, numPartitions int, report ReportFn) (*Verifier, error) {
+	partRange, err := partition.NewFullRange(numPartitions)
+	if err != nil {
+		return nil, xerrors.Errorf("new verifier: %w", err)
+	}
+	return &Verifier{
+		primary:   primary,
+		secondary: secondary,
+		report:    report,
+		partRange: partRange,
+	}, nil
+}
+
+// Run performs a verification pass every interval until ctx expires.
+func (v *Verifier) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := v.Verify(ctx); err != nil {
+			// Passes interrupted by ctx expiring are not errors.
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Verify performs a single verification pass over all partitions. Only
+// links retrieved and edges updated before the pass started are compared.
+func (v *Verifier) Verify(ctx context.Context) (VerifyStats, error) {
+	var (
+		stats  VerifyStats
+		before = time.Now()
+	)
+
+	primarySnap, err := v.primary.Snapshot(ctx)
+	if err != nil {
+		return stats, xerrors.Errorf("verify: primary snapshot: %w", err)
+	}
+	defer func() { _ = primarySnap.Close() }()
+
+	secondarySnap, err := v.secondary.Snapshot(ctx)
+	if err != nil {
+		return stats, xerrors.Errorf("verify: secondary snapshot: %w", err)
+	}
+	defer func() { _ = secondarySnap.Close() }()
+
+	for p := 0; p < v.partRange.NumPartitions(); p++ {
+		from, to, err := v.partRange.PartitionExtents(p)
+		if err != nil {
+			return stats, xerrors.Errorf("verify: %w", err)
+		}
+		if err = v.verifyLinks(ctx, primarySnap, secondarySnap, from, to, before, &stats); err != nil {
+			return stats, xerrors.Errorf("verify: %w", err)
+		}
+		if err = v.verifyEdges(ctx, primarySnap, secondarySnap, from, to, before, &stats); err != nil {
+			return stats, xerrors.Errorf("verify: %w", err)
+		}
+	}
+	return stats, nil
+}
+
+// verifyLinks compares the links of a partition by merging the ID-ordered
+// link sequences of both snapshots.
+func (v *Verifier) verifyLinks(ctx context.Context, primarySnap, secondarySnap graph.Snapshot, from, to uuid.UUID, before time.Time, stats *VerifyStats) error {
+	primaryIt, err := primarySnap.Links(ctx, from, to, before)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = primaryIt.Close() }()
+
+	secondaryIt, err := secondarySnap.Links(ctx, from, to, before)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = secondaryIt.Close() }()
+
+	next := func(it graph.LinkIterator) *graph.Link {
+		if it.Next() {
+			return it.Link()
+		}
+		return nil
+	}
+
+	primary, secondary := next(primaryIt), next(secondaryIt)
+	for primary != nil || secondary != nil {
+		stats.LinksChecked++
+		switch cmp := compareIDs(primary, secondary); {
+		case cmp < 0:
+			err = v.recheckLink(ctx, stats, primary.ID)
+			primary = next(primaryIt)
+		case cmp > 0:
+			err = v.recheckLink(ctx, stats, secondary.ID)
+			secondary = next(secondaryIt)
+		default:
+			if !linksEqual(primary, secondary) {
+				err = v.recheckLink(ctx, stats, primary.ID)
+			}
+			primary, secondary = next(primaryIt), next(secondaryIt)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = primaryIt.Error(); err != nil {
+		return err
+	}
+	return secondaryIt.Error()
+}
+
+// verifyEdges compares the edges originating from the links of a partition.
+func (v *Verifier) verifyEdges(ctx context.Context, primarySnap, secondarySnap graph.Snapshot, from, to uuid.UUID, before time.Time, stats *VerifyStats) error {
+	primaryIt, err := primarySnap.Edges(ctx, from, to, before)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = primaryIt.Close() }()
+
+	primaryEdges := make(map[edgeKey]*graph.Edge)
+	for primaryIt.Next() {
+		edge := primaryIt.Edge()
+		primaryEdges[edgeKey{src: edge.Source, dst: edge.Destination}] = edge
+	}
+	if err = primaryIt.Error(); err != nil {
+		return err
+	}
+
+	secondaryIt, err := secondarySnap.Edges(ctx, from, to, before)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = secondaryIt.Close() }()
+
+	for secondaryIt.Next() {
+		stats.EdgesChecked++
+		secondary := secondaryIt.Edge()
+		key := edgeKey{src: secondary.Source, dst: secondary.Destination}
+		primary := primaryEdges[key]
+		delete(primaryEdges, key)
+
+		if primary == nil || !edgesEqual(primary, secondary) {
+			if err = v.recheckEdge(ctx, stats, key); err != nil {
+				return err
+			}
+		}
+	}
+	if err = secondaryIt.Error(); err != nil {
+		return err
+	}
+
+	for key := range primaryEdges {
+		stats.EdgesChecked++
+		if err = v.recheckEdge(ctx, stats, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recheckLink reads the link with the specified ID from both graphs and
+// reports a divergence if the two versions differ.
+func (v *Verifier) recheckLink(ctx context.Context, stats *VerifyStats, id uuid.UUID) error {
+	primary, err := findLink(ctx, v.primary, id)
+	if err != nil {
+		return err
+	}
+	secondary, err := findLink(ctx, v.secondary, id)
+	if err != nil {
+		return err
+	}
+
+	if !linksInSync(primary, secondary) {
+		v.reportLink(stats, id, primary, secondary)
+	}
+	return nil
+}
+
+// recheckEdge reads the edge identified by key from both graphs and
+// reports a divergence if the two versions differ.
+func (v *Verifier) recheckEdge(ctx context.Context, stats *VerifyStats, key edgeKey) error {
+	primary, err := findEdge(ctx, v.primary, key)
+	if err != nil {
+		return err
+	}
+	secondary, err := findEdge(ctx, v.secondary, key)
+	if err != nil {
+		return err
+	}
+
+	if !edgesInSync(primary, secondary) {
+		v.reportEdge(stats, key.src, primary, secondary)
+	}
+	return nil
+}
+
+// findLink looks up a link by its ID and returns nil if it does not exist.
+func findLink(ctx context.Context, g graph.Graph, id uuid.UUID) (*graph.Link, error) {
+	link, err := g.FindLink(ctx, id)
+	if xerrors.Is(err, graph.ErrNotFound) {
+		return nil, nil
+	}
+	return link, err
+}
+
+// findEdge looks up the edge identified by key and returns nil if it does
+// not exist.
+func findEdge(ctx context.Context, g graph.Graph, key edgeKey) (*graph.Edge, error) {
+	it, err := g.InEdges(ctx, key.dst, maxTime)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = it.Close() }()
+
+	for it.Next() {
+		if edge := it.Edge(); edge.Source == key.src {
+			return edge, nil
+		}
+	}
+	return nil, it.Error()
+}
+
+// reportLink reports a link divergence. Missing links are left as untyped
+// nil values in the Divergence.
+func (v *Verifier) reportLink(stats *VerifyStats, id uuid.UUID, primary, secondary *graph.Link) {
+	stats.Divergences++
+	d := Divergence{Op: "verify links", ID: id}
+	if primary != nil {
+		d.Primary = primary
+	}
+	if secondary != nil {
+		d.Secondary = secondary
+	}
+	v.report(d)
+}
+
+// reportEdge reports an edge divergence. Missing edges are left as untyped
+// nil values in the Divergence.
+func (v *Verifier) reportEdge(stats *VerifyStats, id uuid.UUID, primary, secondary *graph.Edge) {
+	stats.Divergences++
+	d := Divergence{Op: "verify edges", ID: id}
+	if primary != nil {
+		d.Primary = primary
+	}
+	if secondary != nil {
+		d.Secondary = secondary
+	}
+	v.report(d)
+}
+
+// compareIDs orders two links by ID, treating a nil link as larger than
+// any other link.
+func compareIDs(a, b *graph.Link) int {
+	switch {
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+// linksInSync returns true if a and b are both missing or hold the same
+// link.
+func linksInSync(a, b *graph.Link) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ID == b.ID && linksEqual(a, b)
+}
+
+// edgesInSync returns true if a and b are both missing or hold the same
+// edge.
+func edgesInSync(a, b *graph.Edge) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return edgesEqual(a, b)
+}
+
+func linksEqual(a, b *graph.Link) bool {
+	return a.URL == b.URL &&
+		timesEqual(a.RetrievedAt, b.RetrievedAt) &&
+		a.ETag == b.ETag &&
+		timesEqual(a.LastModified, b.LastModified) &&
+		a.ContentHash == b.ContentHash &&
+		a.HTTPStatus == b.HTTPStatus &&
+		a.FailureCount == b.FailureCount &&
+		a.Status == b.Status &&
+		timesEqual(a.NextCrawlAt, b.NextCrawlAt)
+}
+
+func edgesEqual(a, b *graph.Edge) bool {
+	return a.ID == b.ID &&
+		a.AnchorText == b.AnchorText &&
+		a.Nofollow == b.Nofollow &&
+		a.Sponsored == b.Sponsored &&
+		a.UGC == b.UGC &&
+		a.Weight == b.Weight
+}
+
+func timesEqual(a, b time.Time) bool {
+	return a.Truncate(time.Microsecond).Equal(b.Truncate(time.Microsecond))
+}
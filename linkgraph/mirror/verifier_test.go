@@ -0,0 +1,170 @@
+package mirror
+
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/store/memory"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(VerifierTestSuite))
+
+type VerifierTestSuite struct {
+	primary   *memory.InMemoryGraph
+	secondary *memory.InMemoryGraph
+	reports   *divergenceRecorder
+	verifier  *Verifier
+}
+
+func (s *VerifierTestSuite) SetUpTest(c *gc.C) {
+	s.primary = memory.NewInMemoryGraph()
+	s.secondary = memory.NewInMemoryGraph()
+	s.reports = new(divergenceRecorder)
+
+	var err error
+	s.verifier, err = NewVerifier(s.primary, s.secondary, 3, s.reports.report)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *VerifierTestSuite) TestNoDivergences(c *gc.C) {
+	links, _ := s.populate(c)
+
+	stats, err := s.verifier.Verify(context.TODO())
+	c.Assert(err, gc.IsNil)
+	c.Assert(stats, gc.Equals, VerifyStats{LinksChecked: len(links), EdgesChecked: 2})
+	c.Assert(s.reports.get(), gc.HasLen, 0)
+}
+
+func (s *VerifierTestSuite) TestDivergences(c *gc.C) {
+	links, edges := s.populate(c)
+
+	// Link only present in the primary.
+	primaryOnly := &graph.Link{URL: "https://example.com/primary-only"}
+	c.Assert(s.primary.UpsertLink(context.TODO(), primaryOnly), gc.IsNil)
+
+	// Link only present in the secondary.
+	secondaryOnly := &graph.Link{URL: "https://example.com/secondary-only"}
+	c.Assert(s.secondary.UpsertLink(context.TODO(), secondaryOnly), gc.IsNil)
+
+	// Link with different crawl metadata.
+	changed := *links[0]
+	changed.RetrievedAt = changed.RetrievedAt.Add(time.Minute)
+	changed.ETag = "v2"
+	c.Assert(s.secondary.UpsertLink(context.TODO(), &changed), gc.IsNil)
+
+	// Edge with different attributes and an edge missing from the
+	// secondary.
+	changedEdge := *edges[0]
+	changedEdge.Nofollow = true
+	c.Assert(s.secondary.UpsertEdge(context.TODO(), &changedEdge), gc.IsNil)
+	c.Assert(s.primary.UpsertEdge(context.TODO(), &graph.Edge{Source: links[2].ID, Destination: links[0].ID}), gc.IsNil)
+
+	// Edge that was recreated with a different ID in the secondary.
+	c.Assert(s.secondary.RemoveStaleEdges(context.TODO(), edges[1].Source, time.Now().Add(time.Hour)), gc.IsNil)
+	recreated := &graph.Edge{Source: edges[1].Source, Destination: edges[1].Destination, AnchorText: "c", Weight: 0.5}
+	c.Assert(s.secondary.UpsertEdge(context.TODO(), recreated), gc.IsNil)
+
+	stats, err := s.verifier.Verify(context.TODO())
+	c.Assert(err, gc.IsNil)
+	c.Assert(stats.Divergences, gc.Equals, 6)
+
+	got := make(map[string]Divergence)
+	for _, d := range s.reports.get() {
+		got[d.Op+" "+d.ID.String()] = d
+	}
+	c.Assert(got, gc.HasLen, 6)
+
+	d := got["verify links "+primaryOnly.ID.String()]
+	c.Assert(d.Primary, gc.NotNil)
+	c.Assert(d.Secondary, gc.IsNil)
+
+	d = got["verify links "+secondaryOnly.ID.String()]
+	c.Assert(d.Primary, gc.IsNil)
+	c.Assert(d.Secondary, gc.NotNil)
+
+	d = got["verify links "+links[0].ID.String()]
+	c.Assert(d.Secondary.(*graph.Link).ETag, gc.Equals, "v2")
+
+	d = got["verify edges "+edges[0].Source.String()]
+	c.Assert(d.Secondary.(*graph.Edge).Nofollow, gc.Equals, true)
+
+	d = got["verify edges "+links[2].ID.String()]
+	c.Assert(d.Primary, gc.NotNil)
+	c.Assert(d.Secondary, gc.IsNil)
+
+	d = got["verify edges "+edges[1].Source.String()]
+	c.Assert(d.Primary.(*graph.Edge).ID, gc.Equals, edges[1].ID)
+	c.Assert(d.Secondary.(*graph.Edge).ID, gc.Equals, recreated.ID)
+}
+
+func (s *VerifierTestSuite) TestConcurrentWritesAreNotReported(c *gc.C) {
+	links, edges := s.populate(c)
+
+	// Apply a few mirrored writes after the primary snapshot is taken but
+	// before the secondary one is, so that the snapshots disagree.
+	mirrored := NewGraph(s.primary, s.secondary, s.reports.report)
+	primary := &snapshotHookGraph{Graph: s.primary, hook: func() {
+		updated := *links[0]
+		updated.RetrievedAt = updated.RetrievedAt.Add(time.Minute)
+		updated.ETag = "v2"
+		c.Assert(mirrored.UpsertLink(context.TODO(), &updated), gc.IsNil)
+		c.Assert(mirrored.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com/d", RetrievedAt: time.Now().Add(-time.Minute)}), gc.IsNil)
+		c.Assert(mirrored.UpsertEdge(context.TODO(), &graph.Edge{Source: edges[0].Source, Destination: edges[0].Destination, Nofollow: true}), gc.IsNil)
+	}}
+	verifier, err := NewVerifier(primary, s.secondary, 3, s.reports.report)
+	c.Assert(err, gc.IsNil)
+
+	stats, err := verifier.Verify(context.TODO())
+	c.Assert(err, gc.IsNil)
+	c.Assert(stats.Divergences, gc.Equals, 0, gc.Commentf("divergences: %v", s.reports.get()))
+}
+
+func (s *VerifierTestSuite) TestRun(c *gc.C) {
+	c.Assert(s.primary.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com"}), gc.IsNil)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancelFn()
+	c.Assert(s.verifier.Run(ctx, 10*time.Millisecond), gc.IsNil)
+
+	// The same divergence is reported by every pass.
+	c.Assert(len(s.reports.get()) > 1, gc.Equals, true)
+}
+
+// populate adds the same links and edges to both graphs via a mirrored
+// graph.
+func (s *VerifierTestSuite) populate(c *gc.C) ([]*graph.Link, []*graph.Edge) {
+	g := NewGraph(s.primary, s.secondary, s.reports.report)
+
+	links := []*graph.Link{
+		{URL: "https://example.com/a", RetrievedAt: time.Now().Add(-time.Hour)},
+		{URL: "https://example.com/b", RetrievedAt: time.Now().Add(-time.Hour)},
+		{URL: "https://example.com/c", RetrievedAt: time.Now().Add(-time.Hour)},
+	}
+	c.Assert(g.UpsertLinks(context.TODO(), links), gc.IsNil)
+
+	edges := []*graph.Edge{
+		{Source: links[0].ID, Destination: links[1].ID, AnchorText: "b"},
+		{Source: links[1].ID, Destination: links[2].ID, AnchorText: "c", Weight: 0.5},
+	}
+	c.Assert(g.UpsertEdges(context.TODO(), edges), gc.IsNil)
+	c.Assert(s.reports.get(), gc.HasLen, 0)
+	return links, edges
+}
+
+// snapshotHookGraph invokes hook once, right after the first snapshot of
+// the wrapped graph has been taken.
+type snapshotHookGraph struct {
+	graph.Graph
+	hook func()
+}
+
+func (g *snapshotHookGraph) Snapshot(ctx context.Context) (graph.Snapshot, error) {
+	snap, err := g.Graph.Snapshot(ctx)
+	if err == nil && g.hook != nil {
+		g.hook()
+		g.hook = nil
+	}
+	return snap, err
+}
@@ -0,0 +1,134 @@
+package partition
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+	"math/big"
+	"sort"
+)
+
+var (
+	// MinUUID is the lowest UUID value.
+	MinUUID = uuid.Nil
+
+	// MaxUUID is the highest UUID value.
+	MaxUUID = uuid.MustParse("ffffffff-ffff-ffff-ffff-ffffffffffff")
+
+	// ErrInvalidPartitionCount is returned when attempting to split a range
+	// into a non-positive number of partitions or into more partitions than
+	// the range can hold.
+	ErrInvalidPartitionCount = xerrors.New("invalid number of partitions")
+
+	// ErrInvalidRange is returned when the start of a range is not less
+	// than its end.
+	ErrInvalidRange = xerrors.New("range start must be less than range end")
+
+	// ErrInvalidPartition is returned when requesting the extents of a
+	// partition that does not exist.
+	ErrInvalidPartition = xerrors.New("invalid partition")
+
+	// ErrIDOutOfRange is returned when looking up the partition of an ID
+	// that does not belong to the range.
+	ErrIDOutOfRange = xerrors.New("ID is outside of the range")
+)
+
+// Range represents a contiguous UUID region that is split into a number of
+// balanced partitions. Each partition covers a [from, to) sub-range which
+// can be passed as-is to the Links and Edges methods of graph.Graph.
+type Range struct {
+	start uuid.UUID
+	end   uuid.UUID
+
+	// extents holds the numPartitions+1 boundaries of the partitions.
+	extents []uuid.UUID
+}
+
+// NewFullRange creates a new range that covers the entire UUID space and
+// splits it into numPartitions partitions. As ranges are half-open, MaxUUID
+// itself does not belong to the range.
+func NewFullRange(numPartitions int) (Range, error) {
+	return NewRange(MinUUID, MaxUUID, numPartitions)
+}
+
+// NewRange creates a new range [start, end) and splits it into
+// numPartitions partitions.
+func NewRange(start, end uuid.UUID, numPartitions int) (Range, error) {
+	if bytes.Compare(start[:], end[:]) >= 0 {
+		return Range{}, ErrInvalidRange
+	}
+	if numPartitions <= 0 {
+		return Range{}, ErrInvalidPartitionCount
+	}
+
+	var (
+		tokenRange big.Int
+		partSize   big.Int
+		startInt   = new(big.Int).SetBytes(start[:])
+		endInt     = new(big.Int).SetBytes(end[:])
+	)
+
+	// Calculate the size of each partition as: ((end - start) / numPartitions)
+	tokenRange.Sub(endInt, startInt)
+	partSize.Div(&tokenRange, big.NewInt(int64(numPartitions)))
+	if partSize.Sign() == 0 {
+		return Range{}, ErrInvalidPartitionCount
+	}
+
+	// By setting the end of the *last* partition to end we ensure that we
+	// always cover the full range even if it is not evenly divisible by
+	// numPartitions.
+	extents := make([]uuid.UUID, numPartitions+1)
+	extents[0] = start
+	for partition := 1; partition < numPartitions; partition++ {
+		var boundary big.Int
+		boundary.Mul(&partSize, big.NewInt(int64(partition)))
+		boundary.Add(&boundary, startInt)
+		boundary.FillBytes(extents[partition][:])
+	}
+	extents[numPartitions] = end
+
+	return Range{start: start, end: end, extents: extents}, nil
+}
+
+// Split returns a new range that covers the same UUID region as r but is
+// split into numPartitions partitions. It allows work to be re-distributed
+// when the number of workers changes.
+func (r Range) Split(numPartitions int) (Range, error) {
+	return NewRange(r.start, r.end, numPartitions)
+}
+
+// NumPartitions returns the number of partitions in the range.
+func (r Range) NumPartitions() int {
+	return len(r.extents) - 1
+}
+
+// Extents returns the boundaries of the partitions in the range. The slice
+// contains NumPartitions()+1 entries where partition i covers the
+// [extents[i], extents[i+1]) region.
+func (r Range) Extents() []uuid.UUID {
+	return append([]uuid.UUID(nil), r.extents...)
+}
+
+// PartitionExtents returns the [from, to) extents of the requested
+// partition.
+func (r Range) PartitionExtents(partition int) (from, to uuid.UUID, err error) {
+	if partition < 0 || partition >= r.NumPartitions() {
+		return uuid.Nil, uuid.Nil, xerrors.Errorf("partition extents: %w", ErrInvalidPartition)
+	}
+	return r.extents[partition], r.extents[partition+1], nil
+}
+
+// PartitionForID returns the index of the partition that contains id.
+func (r Range) PartitionForID(id uuid.UUID) (int, error) {
+	if bytes.Compare(id[:], r.start[:]) < 0 || bytes.Compare(id[:], r.end[:]) >= 0 {
+		return -1, xerrors.Errorf("partition for ID: %w", ErrIDOutOfRange)
+	}
+
+	// The partition that contains id ends at the first extent that is
+	// greater than id.
+	index := sort.Search(len(r.extents), func(i int) bool {
+		return bytes.Compare(id[:], r.extents[i][:]) < 0
+	})
+	return index - 1, nil
+}
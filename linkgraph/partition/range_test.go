@@ -0,0 +1,111 @@
+package partition
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+	"testing"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(RangeTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type RangeTestSuite struct{}
+
+func (s *RangeTestSuite) TestNewRangeErrors(c *gc.C) {
+	_, err := NewRange(MaxUUID, MinUUID, 1)
+	c.Assert(err, gc.Equals, ErrInvalidRange)
+
+	_, err = NewRange(MinUUID, MaxUUID, 0)
+	c.Assert(err, gc.Equals, ErrInvalidPartitionCount)
+
+	// A range with two UUIDs can only be split into at most two partitions.
+	end := uuid.MustParse("00000000-0000-0000-0000-000000000002")
+	_, err = NewRange(MinUUID, end, 3)
+	c.Assert(err, gc.Equals, ErrInvalidPartitionCount)
+}
+
+func (s *RangeTestSuite) TestEvenSplit(c *gc.C) {
+	r, err := NewFullRange(4)
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.NumPartitions(), gc.Equals, 4)
+
+	expExtents := []uuid.UUID{
+		uuid.MustParse("00000000-0000-0000-0000-000000000000"),
+		uuid.MustParse("3fffffff-ffff-ffff-ffff-ffffffffffff"),
+		uuid.MustParse("7fffffff-ffff-ffff-ffff-fffffffffffe"),
+		uuid.MustParse("bfffffff-ffff-ffff-ffff-fffffffffffd"),
+		uuid.MustParse("ffffffff-ffff-ffff-ffff-ffffffffffff"),
+	}
+	c.Assert(r.Extents(), gc.DeepEquals, expExtents)
+
+	for partition := 0; partition < r.NumPartitions(); partition++ {
+		from, to, err := r.PartitionExtents(partition)
+		c.Assert(err, gc.IsNil)
+		c.Assert(from, gc.Equals, expExtents[partition])
+		c.Assert(to, gc.Equals, expExtents[partition+1])
+	}
+
+	_, _, err = r.PartitionExtents(-1)
+	c.Assert(xerrors.Is(err, ErrInvalidPartition), gc.Equals, true)
+	_, _, err = r.PartitionExtents(4)
+	c.Assert(xerrors.Is(err, ErrInvalidPartition), gc.Equals, true)
+}
+
+func (s *RangeTestSuite) TestSmallPartitions(c *gc.C) {
+	// Splitting the space into many partitions yields boundaries with
+	// leading zero bytes which must still be encoded as valid UUIDs.
+	r, err := NewFullRange(1 << 10)
+	c.Assert(err, gc.IsNil)
+
+	extents := r.Extents()
+	c.Assert(extents, gc.HasLen, 1<<10+1)
+	for i := 1; i < len(extents); i++ {
+		c.Assert(bytes.Compare(extents[i-1][:], extents[i][:]) < 0, gc.Equals, true)
+	}
+}
+
+func (s *RangeTestSuite) TestPartitionForID(c *gc.C) {
+	r, err := NewFullRange(10)
+	c.Assert(err, gc.IsNil)
+
+	extents := r.Extents()
+	for partition := 0; partition < r.NumPartitions(); partition++ {
+		got, err := r.PartitionForID(extents[partition])
+		c.Assert(err, gc.IsNil)
+		c.Assert(got, gc.Equals, partition)
+	}
+
+	for i := 0; i < 100; i++ {
+		id := uuid.New()
+		partition, err := r.PartitionForID(id)
+		c.Assert(err, gc.IsNil)
+
+		from, to, err := r.PartitionExtents(partition)
+		c.Assert(err, gc.IsNil)
+		c.Assert(bytes.Compare(from[:], id[:]) <= 0, gc.Equals, true)
+		c.Assert(bytes.Compare(id[:], to[:]) < 0, gc.Equals, true)
+	}
+
+	_, err = r.PartitionForID(MaxUUID)
+	c.Assert(xerrors.Is(err, ErrIDOutOfRange), gc.Equals, true)
+}
+
+func (s *RangeTestSuite) TestSplit(c *gc.C) {
+	start := uuid.MustParse("40000000-0000-0000-0000-000000000000")
+	end := uuid.MustParse("80000000-0000-0000-0000-000000000000")
+	r, err := NewRange(start, end, 2)
+	c.Assert(err, gc.IsNil)
+
+	resplit, err := r.Split(4)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resplit.NumPartitions(), gc.Equals, 4)
+
+	extents := resplit.Extents()
+	c.Assert(extents[0], gc.Equals, start)
+	c.Assert(extents[4], gc.Equals, end)
+	c.Assert(extents[2], gc.Equals, r.Extents()[1])
+}
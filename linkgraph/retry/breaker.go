@@ -0,0 +1,106 @@
+package retry
+
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the wrapped graph while the
+// circuit breaker is open. It matches graph.ErrUnavailable so callers can
+// treat it as any other transient failure.
+var ErrCircuitOpen error = circuitOpenError{}
+
+type circuitOpenError struct{}
+
+func (circuitOpenError) Error() string        { return "circuit breaker is open" }
+func (circuitOpenError) Is(target error) bool { return target == graph.ErrUnavailable }
+
+type breakerState uint8
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is a circuit breaker that opens after a number of consecutive
+// retryable failures and rejects all requests until a cooldown period
+// elapses. Once the cooldown elapses, a single probe request is let through;
+// the breaker closes if the probe succeeds and re-opens otherwise.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	nowFn     func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+// newBreaker returns a closed breaker. A threshold of zero disables the
+// breaker.
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		nowFn:     time.Now,
+	}
+}
+
+// allow returns ErrCircuitOpen if a request must not be sent to the wrapped
+// graph.
+func (b *breaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.nowFn().Sub(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		// A probe request is already in flight.
+		return ErrCircuitOpen
+	default:
+		return nil
+	}
+}
+
+// record updates the breaker state with the outcome of a request that was
+// allowed through. Only retryable errors count as failures. Context errors
+// say nothing about the health of the wrapped graph: they leave the failure
+// count unchanged and re-open a half-open breaker so that the next request
+// probes the wrapped graph again. Any other outcome proves that the wrapped
+// graph is reachable.
+func (b *breaker) record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if xerrors.Is(err, context.Canceled) || xerrors.Is(err, context.DeadlineExceeded) {
+		if b.state == breakerHalfOpen {
+			b.state = breakerOpen
+		}
+		return
+	}
+	if err == nil || !graph.IsRetryable(err) {
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt = breakerOpen, b.nowFn()
+	}
+}
@@ -0,0 +1,76 @@
+package retry
+
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(BreakerTestSuite))
+
+type BreakerTestSuite struct{}
+
+func (s *BreakerTestSuite) TestStateTransitions(c *gc.C) {
+	now := time.Now()
+	b := newBreaker(2, time.Minute)
+	b.nowFn = func() time.Time { return now }
+
+	// Non-retryable errors reset the consecutive failure count.
+	c.Assert(b.allow(), gc.IsNil)
+	b.record(graph.ErrUnavailable)
+	b.record(graph.ErrNotFound)
+	b.record(graph.ErrConflict)
+	c.Assert(b.allow(), gc.IsNil)
+
+	b.record(graph.ErrUnavailable)
+	c.Assert(b.allow(), gc.Equals, ErrCircuitOpen)
+
+	// After the cooldown a single probe is allowed; a failed probe
+	// re-opens the breaker straight away.
+	now = now.Add(time.Minute)
+	c.Assert(b.allow(), gc.IsNil)
+	c.Assert(b.allow(), gc.Equals, ErrCircuitOpen)
+	b.record(graph.ErrUnavailable)
+	c.Assert(b.allow(), gc.Equals, ErrCircuitOpen)
+
+	now = now.Add(time.Minute)
+	c.Assert(b.allow(), gc.IsNil)
+	b.record(nil)
+	c.Assert(b.allow(), gc.IsNil)
+	c.Assert(b.allow(), gc.IsNil)
+}
+
+func (s *BreakerTestSuite) TestContextErrors(c *gc.C) {
+	now := time.Now()
+	b := newBreaker(2, time.Minute)
+	b.nowFn = func() time.Time { return now }
+
+	// Context errors neither reset nor increase the failure count.
+	b.record(graph.ErrUnavailable)
+	b.record(context.DeadlineExceeded)
+	b.record(xerrors.Errorf("find link: %w", context.Canceled))
+	c.Assert(b.allow(), gc.IsNil)
+	b.record(graph.ErrUnavailable)
+	c.Assert(b.allow(), gc.Equals, ErrCircuitOpen)
+
+	// A probe that is abandoned by its caller re-opens the breaker but
+	// lets the next request probe the wrapped graph again.
+	now = now.Add(time.Minute)
+	c.Assert(b.allow(), gc.IsNil)
+	b.record(context.DeadlineExceeded)
+	c.Assert(b.allow(), gc.IsNil)
+	c.Assert(b.allow(), gc.Equals, ErrCircuitOpen)
+	b.record(nil)
+	c.Assert(b.allow(), gc.IsNil)
+}
+
+func (s *BreakerTestSuite) TestDisabled(c *gc.C) {
+	b := newBreaker(0, time.Minute)
+	for i := 0; i < 10; i++ {
+		b.record(graph.ErrUnavailable)
+	}
+	c.Assert(b.allow(), gc.IsNil)
+}
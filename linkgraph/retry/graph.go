@@ -0,0 +1,267 @@
+package retry
+
+import (
+	"context"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+// Compile-time check for ensuring Graph implements graph.Graph.
+var _ graph.Graph = (*Graph)(nil)
+
+// Config encapsulates the settings for a retrying Graph. Zero values are
+// replaced by the defaults listed next to each field.
+type Config struct {
+	// The delay before the first retry. Subsequent delays are multiplied
+	// by Multiplier up to MaxInterval. Defaults: 50ms, 5s and 2.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+
+	// Jitter randomizes each delay by up to the specified fraction in
+	// either direction; 0.5 yields delays between 50% and 150% of the
+	// computed value. A zero value disables jitter.
+	Jitter float64
+
+	// The maximum number of times an operation is attempted, including
+	// the initial attempt. Defaults to 5.
+	MaxAttempts int
+
+	// The number of consecutive retryable failures that open the circuit
+	// breaker and the time it stays open before probing the wrapped graph
+	// again. A zero BreakerThreshold disables the circuit breaker.
+	// BreakerCooldown defaults to 30s.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.InitialInterval <= 0 {
+		cfg.InitialInterval = 50 * time.Millisecond
+	}
+	if cfg.MaxInterval <= 0 {
+		cfg.MaxInterval = 5 * time.Second
+	}
+	if cfg.Multiplier < 1 {
+		cfg.Multiplier = 2
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.BreakerCooldown <= 0 {
+		cfg.BreakerCooldown = 30 * time.Second
+	}
+}
+
+// Graph is a graph.Graph decorator that retries operations failing with an
+// error for which graph.IsRetryable returns true, using exponential backoff.
+// A circuit breaker shared by all operations fast-fails requests with
+// ErrCircuitOpen while the wrapped graph appears to be down.
+//
+// Only the calls that create iterators, snapshots and event streams are
+// retried; errors reported while consuming them are returned unchanged.
+type Graph struct {
+	backend graph.Graph
+	cfg     Config
+	breaker *breaker
+}
+
+// NewGraph returns a new Graph that wraps g using the provided config.
+func NewGraph(g graph.Graph, cfg Config) *Graph {
+	cfg.setDefaults()
+	return &Graph{
+		backend: g,
+		cfg:     cfg,
+		breaker: newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+	}
+}
+
+// do invokes op until it succeeds, fails with a non-retryable error, the
+// attempts are exhausted or ctx expires.
+func (g *Graph) do(ctx context.Context, op func() error) error {
+	policy := backoff.NewExponentialBackOff()
+	policy.InitialInterval = g.cfg.InitialInterval
+	policy.MaxInterval = g.cfg.MaxInterval
+	policy.Multiplier = g.cfg.Multiplier
+	policy.RandomizationFactor = g.cfg.Jitter
+	policy.MaxElapsedTime = 0
+
+	var (
+		attempts int
+
+		// lastErr holds the error of the last attempt if it is to be
+		// retried.
+		lastErr error
+	)
+	err := backoff.Retry(func() error {
+		lastErr = nil
+		if err := g.breaker.allow(); err != nil {
+			return backoff.Permanent(err)
+		}
+
+		attempts++
+		err := op()
+		g.breaker.record(err)
+		if err != nil && !graph.IsRetryable(err) {
+			return backoff.Permanent(err)
+		}
+		lastErr = err
+		return err
+	}, backoff.WithContext(backoff.WithMaxRetries(policy, uint64(g.cfg.MaxAttempts-1)), ctx))
+
+	// Retries also stop early if ctx expires or its deadline would pass
+	// before the next attempt. In the latter case, backoff.Retry returns
+	// the error of the last attempt instead of a context error.
+	if lastErr != nil && attempts < g.cfg.MaxAttempts {
+		ctxErr := ctx.Err()
+		if ctxErr == nil {
+			ctxErr = context.DeadlineExceeded
+		}
+		return xerrors.Errorf("last error: %v: %w", lastErr, ctxErr)
+	}
+	if err != nil && attempts > 1 && graph.IsRetryable(err) {
+		return xerrors.Errorf("giving up after %d attempts: %w", attempts, err)
+	}
+	return err
+}
+
+// UpsertLink implements graph.Graph.
+func (g *Graph) UpsertLink(ctx context.Context, link *graph.Link) error {
+	return g.do(ctx, func() error { return g.backend.UpsertLink(ctx, link) })
+}
+
+// UpsertLinks implements graph.Graph. Batches that fail with a
+// graph.BatchError are not retried.
+func (g *Graph) UpsertLinks(ctx context.Context, links []*graph.Link) error {
+	return g.do(ctx, func() error { return g.backend.UpsertLinks(ctx, links) })
+}
+
+// FindLink implements graph.Graph.
+func (g *Graph) FindLink(ctx context.Context, id uuid.UUID) (link *graph.Link, err error) {
+	err = g.do(ctx, func() error {
+		link, err = g.backend.FindLink(ctx, id)
+		return err
+	})
+	return link, err
+}
+
+// FindLinkByURL implements graph.Graph.
+func (g *Graph) FindLinkByURL(ctx context.Context, url string) (link *graph.Link, err error) {
+	err = g.do(ctx, func() error {
+		link, err = g.backend.FindLinkByURL(ctx, url)
+		return err
+	})
+	return link, err
+}
+
+// RemoveLink implements graph.Graph. Note that if an attempt removes the
+// link but its outcome is lost, the next attempt fails with
+// graph.ErrNotFound.
+func (g *Graph) RemoveLink(ctx context.Context, id uuid.UUID) error {
+	return g.do(ctx, func() error { return g.backend.RemoveLink(ctx, id) })
+}
+
+// AddAlias implements graph.Graph.
+func (g *Graph) AddAlias(ctx context.Context, aliasID, canonicalID uuid.UUID) error {
+	return g.do(ctx, func() error { return g.backend.AddAlias(ctx, aliasID, canonicalID) })
+}
+
+// Links implements graph.Graph.
+func (g *Graph) Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (it graph.LinkIterator, err error) {
+	err = g.do(ctx, func() error {
+		it, err = g.backend.Links(ctx, fromID, toID, retrievedBefore)
+		return err
+	})
+	return it, err
+}
+
+// LinksAfter implements graph.Graph.
+func (g *Graph) LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (it graph.LinkIterator, err error) {
+	err = g.do(ctx, func() error {
+		it, err = g.backend.LinksAfter(ctx, fromID, toID, retrievedBefore, after)
+		return err
+	})
+	return it, err
+}
+
+// LinksDueForCrawl implements graph.Graph.
+func (g *Graph) LinksDueForCrawl(ctx context.Context, fromID, toID uuid.UUID, dueBefore time.Time) (it graph.LinkIterator, err error) {
+	err = g.do(ctx, func() error {
+		it, err = g.backend.LinksDueForCrawl(ctx, fromID, toID, dueBefore)
+		return err
+	})
+	return it, err
+}
+
+// UpsertEdge implements graph.Graph.
+func (g *Graph) UpsertEdge(ctx context.Context, edge *graph.Edge) error {
+	return g.do(ctx, func() error { return g.backend.UpsertEdge(ctx, edge) })
+}
+
+// UpsertEdges implements graph.Graph. Batches that fail with a
+// graph.BatchError are not retried.
+func (g *Graph) UpsertEdges(ctx context.Context, edges []*graph.Edge) error {
+	return g.do(ctx, func() error { return g.backend.UpsertEdges(ctx, edges) })
+}
+
+// Edges implements graph.Graph.
+func (g *Graph) Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (it graph.EdgeIterator, err error) {
+	err = g.do(ctx, func() error {
+		it, err = g.backend.Edges(ctx, fromID, toID, updatedBefore)
+		return err
+	})
+	return it, err
+}
+
+// EdgesAfter implements graph.Graph.
+func (g *Graph) EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (it graph.EdgeIterator, err error) {
+	err = g.do(ctx, func() error {
+		it, err = g.backend.EdgesAfter(ctx, fromID, toID, updatedBefore, after)
+		return err
+	})
+	return it, err
+}
+
+// InEdges implements graph.Graph.
+func (g *Graph) InEdges(ctx context.Context, dstID uuid.UUID, updatedBefore time.Time) (it graph.EdgeIterator, err error) {
+	err = g.do(ctx, func() error {
+		it, err = g.backend.InEdges(ctx, dstID, updatedBefore)
+		return err
+	})
+	return it, err
+}
+
+// CanonicalEdges implements graph.Graph.
+func (g *Graph) CanonicalEdges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (it graph.EdgeIterator, err error) {
+	err = g.do(ctx, func() error {
+		it, err = g.backend.CanonicalEdges(ctx, fromID, toID, updatedBefore)
+		return err
+	})
+	return it, err
+}
+
+// RemoveStaleEdges implements graph.Graph.
+func (g *Graph) RemoveStaleEdges(ctx context.Context, fromID uuid.UUID, updatedBefore time.Time) error {
+	return g.do(ctx, func() error { return g.backend.RemoveStaleEdges(ctx, fromID, updatedBefore) })
+}
+
+// Snapshot implements graph.Graph.
+func (g *Graph) Snapshot(ctx context.Context) (snap graph.Snapshot, err error) {
+	err = g.do(ctx, func() error {
+		snap, err = g.backend.Snapshot(ctx)
+		return err
+	})
+	return snap, err
+}
+
+// Watch implements graph.Graph.
+func (g *Graph) Watch(ctx context.Context, since time.Time) (it graph.EventIterator, err error) {
+	err = g.do(ctx, func() error {
+		it, err = g.backend.Watch(ctx, since)
+		return err
+	})
+	return it, err
+}
@@ -0,0 +1,211 @@
+package retry
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/graph/graphtest"
+	"github.com/kyteproject/search-engine/linkgraph/store/memory"
+	"golang.org/x/xerrors"
+	"testing"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(RetryGraphTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type RetryGraphTestSuite struct {
+	graphtest.SuiteBase
+	backend *flakyGraph
+	g       *Graph
+}
+
+func (s *RetryGraphTestSuite) SetUpTest(c *gc.C) {
+	s.backend = &flakyGraph{Graph: memory.NewInMemoryGraph()}
+	s.g = NewGraph(s.backend, Config{
+		InitialInterval:  time.Millisecond,
+		MaxInterval:      time.Millisecond,
+		Jitter:           0.5,
+		MaxAttempts:      3,
+		BreakerThreshold: 5,
+		BreakerCooldown:  time.Hour,
+	})
+	s.SetGraph(s.g)
+}
+
+func (s *RetryGraphTestSuite) TestRetryableErrors(c *gc.C) {
+	specs := []struct {
+		descr     string
+		errs      []error
+		expCalls  int
+		expErr    error
+		expErrMsg string
+	}{
+		{
+			descr:    "no errors",
+			expCalls: 1,
+		},
+		{
+			descr:    "recovers after transient errors",
+			errs:     []error{graph.ErrConflict, xerrors.Errorf("find link: %w", graph.ErrUnavailable)},
+			expCalls: 3,
+		},
+		{
+			descr:     "gives up after max attempts",
+			errs:      []error{graph.ErrUnavailable, graph.ErrUnavailable, graph.ErrUnavailable, graph.ErrUnavailable},
+			expCalls:  3,
+			expErr:    graph.ErrUnavailable,
+			expErrMsg: "giving up after 3 attempts: .*",
+		},
+		{
+			descr:    "non-retryable errors are not retried",
+			errs:     []error{graph.ErrNotFound, graph.ErrUnavailable},
+			expCalls: 1,
+			expErr:   graph.ErrNotFound,
+		},
+	}
+
+	link := &graph.Link{URL: "https://example.com"}
+	c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.descr)
+		s.backend.reset(spec.errs...)
+
+		got, err := s.g.FindLink(context.TODO(), link.ID)
+		c.Assert(s.backend.calls, gc.Equals, spec.expCalls)
+		if spec.expErr == nil {
+			c.Assert(err, gc.IsNil)
+			c.Assert(got, gc.DeepEquals, link)
+			continue
+		}
+
+		c.Assert(xerrors.Is(err, spec.expErr), gc.Equals, true, gc.Commentf("got error: %v", err))
+		if spec.expErrMsg != "" {
+			c.Assert(err, gc.ErrorMatches, spec.expErrMsg)
+		}
+	}
+}
+
+func (s *RetryGraphTestSuite) TestContextCancellation(c *gc.C) {
+	g := NewGraph(s.backend, Config{InitialInterval: time.Hour, MaxAttempts: 3})
+	s.backend.reset(graph.ErrUnavailable)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancelFn()
+
+	_, err := g.FindLink(ctx, uuid.New())
+	c.Assert(xerrors.Is(err, context.DeadlineExceeded), gc.Equals, true, gc.Commentf("got error: %v", err))
+	c.Assert(err, gc.ErrorMatches, "last error: graph backend unavailable: .*")
+	c.Assert(s.backend.calls, gc.Equals, 1)
+
+	// Contexts without a deadline abort the wait for the next attempt
+	// once they are cancelled.
+	s.backend.reset(graph.ErrUnavailable)
+	ctx, cancelFn = context.WithCancel(context.TODO())
+	time.AfterFunc(10*time.Millisecond, cancelFn)
+
+	_, err = g.FindLink(ctx, uuid.New())
+	c.Assert(xerrors.Is(err, context.Canceled), gc.Equals, true, gc.Commentf("got error: %v", err))
+	c.Assert(err, gc.ErrorMatches, "last error: graph backend unavailable: .*")
+	c.Assert(s.backend.calls, gc.Equals, 1)
+}
+
+func (s *RetryGraphTestSuite) TestCircuitBreaker(c *gc.C) {
+	now := time.Now()
+	s.g.breaker.nowFn = func() time.Time { return now }
+
+	link := &graph.Link{URL: "https://example.com"}
+	c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+
+	// Two failed operations with 3 attempts each trip the breaker after
+	// the 5th consecutive failure.
+	s.backend.reset(graph.ErrUnavailable, graph.ErrUnavailable, graph.ErrUnavailable, graph.ErrUnavailable, graph.ErrUnavailable, graph.ErrUnavailable)
+	_, err := s.g.FindLink(context.TODO(), link.ID)
+	c.Assert(xerrors.Is(err, graph.ErrUnavailable), gc.Equals, true)
+	_, err = s.g.FindLink(context.TODO(), link.ID)
+	c.Assert(xerrors.Is(err, ErrCircuitOpen), gc.Equals, true, gc.Commentf("got error: %v", err))
+	c.Assert(s.backend.calls, gc.Equals, 5)
+
+	// While the breaker is open, requests fail fast without reaching the
+	// backend and are still reported as retryable.
+	_, err = s.g.FindLink(context.TODO(), link.ID)
+	c.Assert(err, gc.Equals, ErrCircuitOpen)
+	c.Assert(graph.IsRetryable(err), gc.Equals, true)
+	c.Assert(s.backend.calls, gc.Equals, 5)
+
+	// Once the cooldown elapses, a successful probe closes the breaker.
+	now = now.Add(time.Hour)
+	s.backend.reset()
+	got, err := s.g.FindLink(context.TODO(), link.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.ID, gc.Equals, link.ID)
+	_, err = s.g.FindLink(context.TODO(), link.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.backend.calls, gc.Equals, 2)
+}
+
+func (s *RetryGraphTestSuite) TestHungBackend(c *gc.C) {
+	now := time.Now()
+	s.g.breaker.nowFn = func() time.Time { return now }
+
+	findLink := func(errs ...error) error {
+		s.backend.reset(errs...)
+		ctx, cancelFn := context.WithTimeout(context.TODO(), 5*time.Millisecond)
+		defer cancelFn()
+		_, err := s.g.FindLink(ctx, uuid.New())
+		return err
+	}
+
+	// Requests that time out while the backend hangs neither reset nor
+	// increase the consecutive failure count.
+	err := findLink(graph.ErrUnavailable, graph.ErrUnavailable, graph.ErrUnavailable, errBlock)
+	c.Assert(xerrors.Is(err, graph.ErrUnavailable), gc.Equals, true, gc.Commentf("got error: %v", err))
+	for i := 0; i < 3; i++ {
+		err = findLink(errBlock)
+		c.Assert(xerrors.Is(err, context.DeadlineExceeded), gc.Equals, true, gc.Commentf("got error: %v", err))
+	}
+	err = findLink(graph.ErrUnavailable, graph.ErrUnavailable, errBlock)
+	c.Assert(xerrors.Is(err, ErrCircuitOpen), gc.Equals, true, gc.Commentf("got error: %v", err))
+	c.Assert(s.backend.calls, gc.Equals, 2)
+
+	// A probe that times out must not close the breaker.
+	now = now.Add(time.Hour)
+	err = findLink(errBlock)
+	c.Assert(xerrors.Is(err, context.DeadlineExceeded), gc.Equals, true, gc.Commentf("got error: %v", err))
+	c.Assert(s.g.breaker.state, gc.Equals, breakerOpen)
+	c.Assert(s.g.breaker.failures, gc.Equals, 5)
+}
+
+// errBlock instructs flakyGraph to block until the context expires.
+var errBlock = xerrors.New("block")
+
+// flakyGraph is a graph.Graph whose FindLink method fails with a list of
+// predefined errors before delegating to the wrapped graph. The errBlock
+// entry makes it block until the context expires instead.
+type flakyGraph struct {
+	graph.Graph
+	errs  []error
+	calls int
+}
+
+func (g *flakyGraph) reset(errs ...error) {
+	g.errs, g.calls = errs, 0
+}
+
+func (g *flakyGraph) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	g.calls++
+	if len(g.errs) != 0 {
+		err := g.errs[0]
+		g.errs = g.errs[1:]
+		if err == errBlock {
+			<-ctx.Done()
+			return nil, xerrors.Errorf("find link: %w", ctx.Err())
+		}
+		return nil, err
+	}
+	return g.Graph.FindLink(ctx, id)
+}
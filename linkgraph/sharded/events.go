@@ -0,0 +1,216 @@
+package sharded
+
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"sync"
+	"time"
+)
+
+// Watch implements graph.Graph. The event streams of all shards are merged
+// in timestamp order. As shards produce events independently, an event is
+// only emitted once every shard has produced a later event or once it has
+// been held back for a short period of time. Events about link copies are
+// filtered out.
+func (g *Graph) Watch(ctx context.Context, since time.Time) (graph.EventIterator, error) {
+	watchCtx, cancelFn := context.WithCancel(ctx)
+	its := make([]graph.EventIterator, 0, len(g.shards))
+	for _, shard := range g.shards {
+		it, err := shard.Watch(watchCtx, since)
+		if err != nil {
+			cancelFn()
+			for _, it := range its {
+				_ = it.Close()
+			}
+			return nil, xerrors.Errorf("watch: %w", err)
+		}
+		its = append(its, it)
+	}
+
+	i := &eventIterator{
+		ctx:          ctx,
+		watchCtx:     watchCtx,
+		cancelFn:     cancelFn,
+		g:            g,
+		its:          its,
+		evCh:         make(chan shardEvent),
+		pendingCount: make([]int, len(its)),
+		done:         make([]bool, len(its)),
+		active:       len(its),
+		closeCh:      make(chan struct{}),
+	}
+	i.wg.Add(len(its))
+	for shard, it := range its {
+		go i.pump(shard, it)
+	}
+	return i, nil
+}
+
+// shardEvent is emitted by the goroutine that consumes the event stream of
+// a shard. The final shardEvent of each shard has done set to true.
+type shardEvent struct {
+	shard      int
+	ev         *graph.Event
+	receivedAt time.Time
+
+	done bool
+	err  error
+}
+
+// eventIterator is a graph.EventIterator that merges the event streams of
+// multiple shards.
+type eventIterator struct {
+	ctx      context.Context
+	watchCtx context.Context
+	cancelFn context.CancelFunc
+	g        *Graph
+	its      []graph.EventIterator
+	evCh     chan shardEvent
+
+	// The following fields are only accessed by the goroutine that calls
+	// Next.
+	pending      []shardEvent
+	pendingCount []int
+	done         []bool
+	active       int
+	curEv        *graph.Event
+	lastErr      error
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// pump forwards the events of a shard to the merging iterator.
+func (i *eventIterator) pump(shard int, it graph.EventIterator) {
+	defer i.wg.Done()
+
+	for it.Next() {
+		ev := it.Event()
+		if ev.Link != nil && i.g.shardFor(ev.Link.ID) != shard {
+			continue // skip events about link copies
+		}
+
+		select {
+		case i.evCh <- shardEvent{shard: shard, ev: ev}:
+		case <-i.watchCtx.Done():
+			return
+		}
+	}
+
+	select {
+	case i.evCh <- shardEvent{shard: shard, done: true, err: it.Error()}:
+	case <-i.watchCtx.Done():
+	}
+}
+
+// Next implements graph.EventIterator.
+func (i *eventIterator) Next() bool {
+	for {
+		if i.lastErr != nil {
+			return false
+		}
+
+		select {
+		case <-i.closeCh:
+			return false
+		default:
+		}
+
+		var timer *time.Timer
+		if index := i.oldestPending(); index >= 0 {
+			held := time.Since(i.pending[index].receivedAt)
+			if held >= i.g.holdWindow || i.allShardsPending() {
+				sev := i.pending[index]
+				i.pending = append(i.pending[:index], i.pending[index+1:]...)
+				i.pendingCount[sev.shard]--
+				i.curEv = sev.ev
+				return true
+			}
+			timer = time.NewTimer(i.g.holdWindow - held)
+		} else if i.active == 0 {
+			return false
+		}
+
+		var timeoutCh <-chan time.Time
+		if timer != nil {
+			timeoutCh = timer.C
+		}
+
+		select {
+		case sev := <-i.evCh:
+			i.receive(sev)
+		case <-timeoutCh:
+		case <-i.closeCh:
+		case <-i.ctx.Done():
+			i.lastErr = i.ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// receive records an event emitted by a shard.
+func (i *eventIterator) receive(sev shardEvent) {
+	if sev.done {
+		i.done[sev.shard] = true
+		i.active--
+		if sev.err != nil {
+			i.lastErr = sev.err
+		}
+		return
+	}
+
+	sev.receivedAt = time.Now()
+	i.pending = append(i.pending, sev)
+	i.pendingCount[sev.shard]++
+}
+
+// oldestPending returns the index of the pending event with the lowest
+// timestamp or -1 if there are no pending events. Ties are broken by the
+// order in which the events were received.
+func (i *eventIterator) oldestPending() int {
+	oldest := -1
+	for index, sev := range i.pending {
+		if oldest == -1 || sev.ev.Timestamp.Before(i.pending[oldest].ev.Timestamp) {
+			oldest = index
+		}
+	}
+	return oldest
+}
+
+// allShardsPending returns true if each shard that can still produce events
+// has at least one pending event.
+func (i *eventIterator) allShardsPending() bool {
+	for shard, count := range i.pendingCount {
+		if count == 0 && !i.done[shard] {
+			return false
+		}
+	}
+	return true
+}
+
+// Event implements graph.EventIterator.
+func (i *eventIterator) Event() *graph.Event {
+	return i.curEv
+}
+
+// Error implements graph.EventIterator.
+func (i *eventIterator) Error() error {
+	return i.lastErr
+}
+
+// Close implements graph.EventIterator.
+func (i *eventIterator) Close() error {
+	i.closeOnce.Do(func() {
+		close(i.closeCh)
+		i.cancelFn()
+		for _, it := range i.its {
+			_ = it.Close()
+		}
+		i.wg.Wait()
+	})
+	return nil
+}
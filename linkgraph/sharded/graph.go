@@ -0,0 +1,576 @@
+package sharded
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/partition"
+	"golang.org/x/xerrors"
+	"math/big"
+	"time"
+)
+
+// Compile-time check for ensuring Graph implements graph.Graph.
+var _ graph.Graph = (*Graph)(nil)
+
+// ErrCopyConflict is returned when a shard cannot hold a copy of a link
+// owned by another shard because the link URL is already assigned to a
+// different ID within that shard.
+var ErrCopyConflict = xerrors.New("shard already stores the link URL under a different ID")
+
+// maxTime is used for looking up links regardless of their RetrievedAt
+// value.
+var maxTime = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// Graph is a graph.Graph that splits the UUID space into equally sized
+// ranges and stores the links of each range in a separate shard.
+//
+// Each URL is mapped to a "URL shard" by hashing it. Upserts are always
+// sent to the URL shard first which ensures that each URL maps to a single
+// link across all shards. Links that are upserted without an ID are
+// assigned a random ID from the range owned by their URL shard. If a link
+// is created with a caller-provided ID that belongs to another shard, the
+// link is stored by the shard owning the ID and the URL shard keeps a copy
+// of it so that subsequent upserts of the same URL can be routed to the
+// right shard.
+//
+// Edges are stored by the shard that owns their source link. When the
+// destination link belongs to another shard, a copy of the destination
+// link is added to the source shard. Similarly, aliases are replicated to
+// all shards. Link copies are never returned by lookups, iterators or
+// event streams; the range-based queries are clamped to the range owned by
+// each shard. As each shard checks the IDs of its own edges only, edges
+// with different source shards may be created with the same caller-provided
+// ID without graph.ErrEdgeIDInUse being returned.
+type Graph struct {
+	shards    []graph.Graph
+	partRange partition.Range
+
+	// holdWindow is the time that merged event streams wait for events
+	// from idle shards before emitting an event.
+	holdWindow time.Duration
+}
+
+// NewGraph returns a new Graph that distributes links over the provided
+// shards. The number and order of shards must remain the same for the
+// lifetime of the stored data.
+func NewGraph(shards []graph.Graph) (*Graph, error) {
+	partRange, err := partition.NewFullRange(len(shards))
+	if err != nil {
+		return nil, xerrors.Errorf("new sharded graph: %w", err)
+	}
+	return &Graph{
+		shards:     shards,
+		partRange:  partRange,
+		holdWindow: 50 * time.Millisecond,
+	}, nil
+}
+
+// shardFor returns the index of the shard that owns id.
+func (g *Graph) shardFor(id uuid.UUID) int {
+	index, err := g.partRange.PartitionForID(id)
+	if err != nil {
+		// MaxUUID is the only ID that lies outside the full range.
+		return len(g.shards) - 1
+	}
+	return index
+}
+
+// urlShard returns the index of the shard that is responsible for locating
+// links with the specified URL.
+func (g *Graph) urlShard(url string) int {
+	return g.shardFor(uuid.NewSHA1(uuid.NameSpaceURL, []byte(url)))
+}
+
+// newLinkID returns a random ID from the range owned by shard.
+func (g *Graph) newLinkID(shard int) uuid.UUID {
+	extents := g.partRange.Extents()
+	var (
+		id      = uuid.New()
+		from    = new(big.Int).SetBytes(extents[shard][:])
+		size    = new(big.Int).SetBytes(extents[shard+1][:])
+		idValue = new(big.Int).SetBytes(id[:])
+	)
+	size.Sub(size, from)
+	idValue.Mod(idValue, size)
+	idValue.Add(idValue, from)
+	idValue.FillBytes(id[:])
+	return id
+}
+
+// UpsertLink implements graph.Graph.
+func (g *Graph) UpsertLink(ctx context.Context, link *graph.Link) error {
+	var (
+		urlShard = g.urlShard(link.URL)
+		assigned = link.ID == uuid.Nil
+	)
+	if assigned {
+		link.ID = g.newLinkID(urlShard)
+	}
+
+	orig := *link
+	err := g.shards[urlShard].UpsertLink(ctx, link)
+	if err == nil {
+		err = g.upsertAtOwner(ctx, urlShard, &orig, link)
+	}
+	if err != nil {
+		if assigned {
+			link.ID = uuid.Nil
+		}
+		return err
+	}
+	return nil
+}
+
+// UpsertLinks implements graph.Graph.
+func (g *Graph) UpsertLinks(ctx context.Context, links []*graph.Link) error {
+	var (
+		errs     []error
+		assigned = make([]bool, len(links))
+		origs    = make([]graph.Link, len(links))
+		groups   = make([][]int, len(g.shards))
+	)
+	setErr := func(i int, err error) {
+		if errs == nil {
+			errs = make([]error, len(links))
+		}
+		errs[i] = err
+		if assigned[i] {
+			links[i].ID = uuid.Nil
+		}
+	}
+
+	for i, link := range links {
+		urlShard := g.urlShard(link.URL)
+		if link.ID == uuid.Nil {
+			link.ID, assigned[i] = g.newLinkID(urlShard), true
+		}
+		origs[i] = *link
+		groups[urlShard] = append(groups[urlShard], i)
+	}
+
+	for urlShard, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+
+		batch := make([]*graph.Link, len(group))
+		for j, i := range group {
+			batch[j] = links[i]
+		}
+
+		err := g.shards[urlShard].UpsertLinks(ctx, batch)
+		batchErr, isBatchErr := err.(*graph.BatchError)
+		for j, i := range group {
+			itemErr := err
+			if isBatchErr {
+				itemErr = batchErr.Errors[j]
+			}
+			if itemErr == nil {
+				itemErr = g.upsertAtOwner(ctx, urlShard, &origs[i], links[i])
+			}
+			if itemErr != nil {
+				setErr(i, itemErr)
+			}
+		}
+	}
+
+	if errs != nil {
+		return &graph.BatchError{Errors: errs}
+	}
+	return nil
+}
+
+// upsertAtOwner completes a link upsert that was applied to the URL shard
+// of the link. If the link ID reported by that shard belongs to another
+// shard, the upsert is repeated against the shard owning the ID
+// and link is updated with the result.
+func (g *Graph) upsertAtOwner(ctx context.Context, urlShard int, orig, link *graph.Link) error {
+	owner := g.shardFor(link.ID)
+	if owner == urlShard {
+		return nil
+	}
+
+	lCopy := *orig
+	lCopy.ID = link.ID
+	if err := g.shards[owner].UpsertLink(ctx, &lCopy); err != nil {
+		// Drop the copy that was just created by the URL shard so that
+		// the URL does not remain associated with the rejected ID.
+		if xerrors.Is(err, graph.ErrLinkIDInUse) {
+			_ = g.shards[urlShard].RemoveLink(ctx, link.ID)
+		}
+		return err
+	}
+	*link = lCopy
+	return nil
+}
+
+// FindLink implements graph.Graph.
+func (g *Graph) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	link, err := g.shards[g.shardFor(id)].FindLink(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return g.resolveCopy(ctx, g.shardFor(id), link)
+}
+
+// FindLinkByURL implements graph.Graph.
+func (g *Graph) FindLinkByURL(ctx context.Context, url string) (*graph.Link, error) {
+	urlShard := g.urlShard(url)
+	link, err := g.shards[urlShard].FindLinkByURL(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return g.resolveCopy(ctx, urlShard, link)
+}
+
+// resolveCopy returns the link stored by the owning shard if link is a copy
+// that was obtained from another shard.
+func (g *Graph) resolveCopy(ctx context.Context, shard int, link *graph.Link) (*graph.Link, error) {
+	if owner := g.shardFor(link.ID); owner != shard {
+		return g.shards[owner].FindLink(ctx, link.ID)
+	}
+	return link, nil
+}
+
+// RemoveLink implements graph.Graph. The link is removed from its owning
+// shard first and then any copies of it are removed from the remaining
+// shards together with any edges pointing to them.
+func (g *Graph) RemoveLink(ctx context.Context, id uuid.UUID) error {
+	owner := g.shardFor(id)
+	if err := g.shards[owner].RemoveLink(ctx, id); err != nil {
+		return err
+	}
+	for shard := range g.shards {
+		if shard == owner {
+			continue
+		}
+		if err := g.shards[shard].RemoveLink(ctx, id); err != nil && !xerrors.Is(err, graph.ErrNotFound) {
+			return xerrors.Errorf("remove link: %w", err)
+		}
+	}
+	return nil
+}
+
+// AddAlias implements graph.Graph. Aliases are replicated to every shard so
+// that alias resolution and CanonicalEdges work for links of any shard.
+func (g *Graph) AddAlias(ctx context.Context, aliasID, canonicalID uuid.UUID) error {
+	alias, err := g.rawLink(ctx, aliasID)
+	if err != nil {
+		return xerrors.Errorf("add alias: %w", err)
+	}
+	canonical, err := g.rawLink(ctx, canonicalID)
+	if err != nil {
+		return xerrors.Errorf("add alias: %w", err)
+	}
+
+	// Apply the alias to the owner of the alias link first so that
+	// invalid aliases are rejected before any other shard is modified.
+	owner := g.shardFor(aliasID)
+	order := append([]int{owner}, otherShards(len(g.shards), owner)...)
+	for _, shard := range order {
+		if err = g.ensureCopy(ctx, shard, alias); err != nil {
+			return xerrors.Errorf("add alias: %w", err)
+		}
+		if err = g.ensureCopy(ctx, shard, canonical); err != nil {
+			return xerrors.Errorf("add alias: %w", err)
+		}
+		if err = g.shards[shard].AddAlias(ctx, aliasID, canonicalID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawLink looks up a link by its ID without resolving aliases.
+func (g *Graph) rawLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	next, overflow := nextID(id)
+	if overflow {
+		return nil, graph.ErrNotFound
+	}
+
+	it, err := g.shards[g.shardFor(id)].Links(ctx, id, next, maxTime)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = it.Close() }()
+
+	if it.Next() {
+		return it.Link(), nil
+	}
+	if err = it.Error(); err != nil {
+		return nil, err
+	}
+	return nil, graph.ErrNotFound
+}
+
+// ensureCopy adds a copy of link to the specified shard unless the shard
+// owns the link.
+func (g *Graph) ensureCopy(ctx context.Context, shard int, link *graph.Link) error {
+	if g.shardFor(link.ID) == shard {
+		return nil
+	}
+
+	// The copy has a zero RetrievedAt value so it never overwrites the
+	// crawl metadata of an existing copy.
+	lCopy := &graph.Link{ID: link.ID, URL: link.URL}
+	if err := g.shards[shard].UpsertLink(ctx, lCopy); err != nil {
+		return err
+	}
+	if lCopy.ID != link.ID {
+		return ErrCopyConflict
+	}
+	return nil
+}
+
+// Links implements graph.Graph.
+func (g *Graph) Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (graph.LinkIterator, error) {
+	return g.LinksAfter(ctx, fromID, toID, retrievedBefore, "")
+}
+
+// LinksAfter implements graph.Graph.
+func (g *Graph) LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	return g.mergeLinks(fromID, toID, after, linkIDLess, func(shard int, from, to uuid.UUID) (graph.LinkIterator, error) {
+		return g.shards[shard].LinksAfter(ctx, from, to, retrievedBefore, after)
+	})
+}
+
+// LinksDueForCrawl implements graph.Graph.
+func (g *Graph) LinksDueForCrawl(ctx context.Context, fromID, toID uuid.UUID, dueBefore time.Time) (graph.LinkIterator, error) {
+	return g.mergeLinks(fromID, toID, "", linkDueLess, func(shard int, from, to uuid.UUID) (graph.LinkIterator, error) {
+		return g.shards[shard].LinksDueForCrawl(ctx, from, to, dueBefore)
+	})
+}
+
+// UpsertEdge implements graph.Graph.
+func (g *Graph) UpsertEdge(ctx context.Context, edge *graph.Edge) error {
+	srcShard := g.shardFor(edge.Source)
+	err := g.shards[srcShard].UpsertEdge(ctx, edge)
+	if !xerrors.Is(err, graph.ErrUnknownEdgeLinks) || g.shardFor(edge.Destination) == srcShard {
+		return err
+	}
+
+	// The destination belongs to another shard; copy it to the source
+	// shard and try again.
+	if copyErr := g.copyDestination(ctx, srcShard, edge.Destination); copyErr != nil {
+		if xerrors.Is(copyErr, graph.ErrNotFound) {
+			return err
+		}
+		return xerrors.Errorf("upsert edge: %w", copyErr)
+	}
+	return g.shards[srcShard].UpsertEdge(ctx, edge)
+}
+
+// UpsertEdges implements graph.Graph.
+func (g *Graph) UpsertEdges(ctx context.Context, edges []*graph.Edge) error {
+	var (
+		errs   []error
+		groups = make([][]int, len(g.shards))
+	)
+	for i, edge := range edges {
+		srcShard := g.shardFor(edge.Source)
+		groups[srcShard] = append(groups[srcShard], i)
+	}
+
+	for srcShard, group := range groups {
+		var retry []int
+		itemErrs := g.upsertEdgeGroup(ctx, srcShard, edges, group)
+		for j, i := range group {
+			if xerrors.Is(itemErrs[j], graph.ErrUnknownEdgeLinks) && g.shardFor(edges[i].Destination) != srcShard {
+				if copyErr := g.copyDestination(ctx, srcShard, edges[i].Destination); copyErr == nil {
+					retry = append(retry, i)
+					continue
+				} else if !xerrors.Is(copyErr, graph.ErrNotFound) {
+					itemErrs[j] = xerrors.Errorf("upsert edges: %w", copyErr)
+				}
+			}
+			if itemErrs[j] != nil {
+				if errs == nil {
+					errs = make([]error, len(edges))
+				}
+				errs[i] = itemErrs[j]
+			}
+		}
+
+		if len(retry) == 0 {
+			continue
+		}
+		for j, err := range g.upsertEdgeGroup(ctx, srcShard, edges, retry) {
+			if err != nil {
+				if errs == nil {
+					errs = make([]error, len(edges))
+				}
+				errs[retry[j]] = err
+			}
+		}
+	}
+
+	if errs != nil {
+		return &graph.BatchError{Errors: errs}
+	}
+	return nil
+}
+
+// upsertEdgeGroup upserts the edges at the specified indices to a shard and
+// returns the per-item errors.
+func (g *Graph) upsertEdgeGroup(ctx context.Context, shard int, edges []*graph.Edge, group []int) []error {
+	itemErrs := make([]error, len(group))
+	if len(group) == 0 {
+		return itemErrs
+	}
+
+	batch := make([]*graph.Edge, len(group))
+	for j, i := range group {
+		batch[j] = edges[i]
+	}
+
+	err := g.shards[shard].UpsertEdges(ctx, batch)
+	if batchErr, ok := err.(*graph.BatchError); ok {
+		copy(itemErrs, batchErr.Errors)
+	} else if err != nil {
+		for j := range itemErrs {
+			itemErrs[j] = err
+		}
+	}
+	return itemErrs
+}
+
+// copyDestination adds a copy of the link with dstID to the specified shard.
+func (g *Graph) copyDestination(ctx context.Context, shard int, dstID uuid.UUID) error {
+	dst, err := g.rawLink(ctx, dstID)
+	if err != nil {
+		return err
+	}
+	return g.ensureCopy(ctx, shard, dst)
+}
+
+// Edges implements graph.Graph.
+func (g *Graph) Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return g.EdgesAfter(ctx, fromID, toID, updatedBefore, "")
+}
+
+// EdgesAfter implements graph.Graph.
+func (g *Graph) EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (graph.EdgeIterator, error) {
+	return g.mergeEdges(g.shardRanges(fromID, toID), after, func(shard int, from, to uuid.UUID) (graph.EdgeIterator, error) {
+		return g.shards[shard].EdgesAfter(ctx, from, to, updatedBefore, after)
+	})
+}
+
+// InEdges implements graph.Graph. As edges are stored by the shard that
+// owns their source, all shards are queried.
+func (g *Graph) InEdges(ctx context.Context, dstID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return g.mergeEdges(g.allShards(), "", func(shard int, _, _ uuid.UUID) (graph.EdgeIterator, error) {
+		return g.shards[shard].InEdges(ctx, dstID, updatedBefore)
+	})
+}
+
+// CanonicalEdges implements graph.Graph.
+func (g *Graph) CanonicalEdges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return g.mergeEdges(g.shardRanges(fromID, toID), "", func(shard int, from, to uuid.UUID) (graph.EdgeIterator, error) {
+		return g.shards[shard].CanonicalEdges(ctx, from, to, updatedBefore)
+	})
+}
+
+// RemoveStaleEdges implements graph.Graph.
+func (g *Graph) RemoveStaleEdges(ctx context.Context, fromID uuid.UUID, updatedBefore time.Time) error {
+	return g.shards[g.shardFor(fromID)].RemoveStaleEdges(ctx, fromID, updatedBefore)
+}
+
+// shardRange is the part of a queried ID range that is owned by a shard.
+type shardRange struct {
+	shard    int
+	from, to uuid.UUID
+}
+
+// shardRanges splits the [fromID, toID) range into the sub-ranges owned by
+// each shard.
+func (g *Graph) shardRanges(fromID, toID uuid.UUID) []shardRange {
+	var (
+		extents = g.partRange.Extents()
+		ranges  []shardRange
+	)
+	for shard := range g.shards {
+		from, to := extents[shard], extents[shard+1]
+		if bytes.Compare(fromID[:], from[:]) > 0 {
+			from = fromID
+		}
+		if bytes.Compare(toID[:], to[:]) < 0 {
+			to = toID
+		}
+		if bytes.Compare(from[:], to[:]) < 0 {
+			ranges = append(ranges, shardRange{shard: shard, from: from, to: to})
+		}
+	}
+	return ranges
+}
+
+// allShards returns a shardRange with empty extents for every shard.
+func (g *Graph) allShards() []shardRange {
+	ranges := make([]shardRange, len(g.shards))
+	for shard := range ranges {
+		ranges[shard].shard = shard
+	}
+	return ranges
+}
+
+// mergeLinks opens a link iterator for each shard that overlaps the
+// [fromID, toID) range and merges them into a single iterator.
+func (g *Graph) mergeLinks(fromID, toID uuid.UUID, after graph.Cursor, less func(a, b *graph.Link) bool, openFn func(shard int, from, to uuid.UUID) (graph.LinkIterator, error)) (graph.LinkIterator, error) {
+	if _, err := after.ID(); err != nil {
+		return nil, xerrors.Errorf("links: %w", err)
+	}
+
+	var its []graph.LinkIterator
+	for _, r := range g.shardRanges(fromID, toID) {
+		it, err := openFn(r.shard, r.from, r.to)
+		if err != nil {
+			_ = closeLinkIterators(its)
+			return nil, err
+		}
+		its = append(its, it)
+	}
+	return newLinkIterator(its, less, after), nil
+}
+
+// mergeEdges opens an edge iterator for each of the provided shard ranges
+// and merges them into a single iterator.
+func (g *Graph) mergeEdges(ranges []shardRange, after graph.Cursor, openFn func(shard int, from, to uuid.UUID) (graph.EdgeIterator, error)) (graph.EdgeIterator, error) {
+	if _, err := after.ID(); err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
+
+	var its []graph.EdgeIterator
+	for _, r := range ranges {
+		it, err := openFn(r.shard, r.from, r.to)
+		if err != nil {
+			_ = closeEdgeIterators(its)
+			return nil, err
+		}
+		its = append(its, it)
+	}
+	return newEdgeIterator(its, after), nil
+}
+
+// nextID returns the ID that immediately follows id. The second return
+// value is true if id is MaxUUID.
+func nextID(id uuid.UUID) (uuid.UUID, bool) {
+	for i := len(id) - 1; i >= 0; i-- {
+		id[i]++
+		if id[i] != 0 {
+			return id, false
+		}
+	}
+	return id, true
+}
+
+// otherShards returns the indices of all shards except the specified one.
+func otherShards(numShards, except int) []int {
+	others := make([]int, 0, numShards-1)
+	for shard := 0; shard < numShards; shard++ {
+		if shard != except {
+			others = append(others, shard)
+		}
+	}
+	return others
+}
@@ -0,0 +1,192 @@
+package sharded
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/graph/graphtest"
+	"github.com/kyteproject/search-engine/linkgraph/partition"
+	"github.com/kyteproject/search-engine/linkgraph/store/memory"
+	"golang.org/x/xerrors"
+	"testing"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(ShardedGraphTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type ShardedGraphTestSuite struct {
+	graphtest.SuiteBase
+	shards []graph.Graph
+	g      *Graph
+}
+
+func (s *ShardedGraphTestSuite) SetUpTest(c *gc.C) {
+	s.shards = []graph.Graph{
+		memory.NewInMemoryGraph(),
+		memory.NewInMemoryGraph(),
+		memory.NewInMemoryGraph(),
+	}
+
+	var err error
+	s.g, err = NewGraph(s.shards)
+	c.Assert(err, gc.IsNil)
+	s.g.holdWindow = 10 * time.Millisecond
+	s.SetGraph(s.g)
+}
+
+func (s *ShardedGraphTestSuite) TestNoShards(c *gc.C) {
+	_, err := NewGraph(nil)
+	c.Assert(xerrors.Is(err, partition.ErrInvalidPartitionCount), gc.Equals, true)
+}
+
+func (s *ShardedGraphTestSuite) TestLinksAreDistributed(c *gc.C) {
+	for i := 0; i < 60; i++ {
+		c.Assert(s.g.UpsertLink(context.TODO(), &graph.Link{URL: fmt.Sprintf("https://example.com/%d", i)}), gc.IsNil)
+	}
+
+	// Each shard should hold a share of the links and only links whose
+	// IDs belong to its range.
+	for shard := range s.shards {
+		ids := s.shardLinkIDs(c, shard)
+		c.Assert(len(ids) > 0, gc.Equals, true, gc.Commentf("shard %d is empty", shard))
+		for _, id := range ids {
+			c.Assert(s.g.shardFor(id), gc.Equals, shard)
+		}
+	}
+
+	// Upserting the same URL again must not create a new link.
+	first, err := s.g.FindLinkByURL(context.TODO(), "https://example.com/0")
+	c.Assert(err, gc.IsNil)
+	link := &graph.Link{URL: first.URL}
+	c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+	c.Assert(link.ID, gc.Equals, first.ID)
+	c.Assert(s.allLinkIDs(c), gc.HasLen, 60)
+}
+
+func (s *ShardedGraphTestSuite) TestCrossShardEdges(c *gc.C) {
+	src, dst := s.linksOnDifferentShards(c)
+	srcShard := s.g.shardFor(src.ID)
+
+	edge := &graph.Edge{Source: src.ID, Destination: dst.ID}
+	c.Assert(s.g.UpsertEdge(context.TODO(), edge), gc.IsNil)
+
+	// The source shard holds a copy of the destination which must not
+	// leak through the sharded graph.
+	_, err := s.shards[srcShard].FindLink(context.TODO(), dst.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.allLinkIDs(c), gc.HasLen, 2)
+
+	it, err := s.g.InEdges(context.TODO(), dst.ID, time.Now().Add(time.Hour))
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.Next(), gc.Equals, true)
+	c.Assert(it.Edge().ID, gc.Equals, edge.ID)
+	c.Assert(it.Next(), gc.Equals, false)
+	c.Assert(it.Close(), gc.IsNil)
+
+	// Edges to unknown links are still rejected.
+	err = s.g.UpsertEdge(context.TODO(), &graph.Edge{Source: src.ID, Destination: uuid.New()})
+	c.Assert(xerrors.Is(err, graph.ErrUnknownEdgeLinks), gc.Equals, true, gc.Commentf("got error: %v", err))
+
+	// Removing the destination removes the edge and the copy.
+	c.Assert(s.g.RemoveLink(context.TODO(), dst.ID), gc.IsNil)
+	_, err = s.shards[srcShard].FindLink(context.TODO(), dst.ID)
+	c.Assert(xerrors.Is(err, graph.ErrNotFound), gc.Equals, true)
+
+	eIt, err := s.g.Edges(context.TODO(), partition.MinUUID, partition.MaxUUID, time.Now().Add(time.Hour))
+	c.Assert(err, gc.IsNil)
+	c.Assert(eIt.Next(), gc.Equals, false)
+	c.Assert(eIt.Close(), gc.IsNil)
+}
+
+func (s *ShardedGraphTestSuite) TestCallerProvidedIDs(c *gc.C) {
+	url := "https://example.com"
+	urlShard := s.g.urlShard(url)
+
+	// Pick an ID that belongs to a different shard than the URL.
+	var id uuid.UUID
+	for id = uuid.New(); s.g.shardFor(id) == urlShard; id = uuid.New() {
+	}
+
+	link := &graph.Link{ID: id, URL: url, ETag: "v1"}
+	c.Assert(s.g.UpsertLink(context.TODO(), link), gc.IsNil)
+	c.Assert(link.ID, gc.Equals, id)
+
+	// Upserting the URL without an ID should resolve to the same link.
+	other := &graph.Link{URL: url, ETag: "v2"}
+	c.Assert(s.g.UpsertLink(context.TODO(), other), gc.IsNil)
+	c.Assert(other.ID, gc.Equals, id)
+
+	found, err := s.g.FindLinkByURL(context.TODO(), url)
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.ID, gc.Equals, id)
+	c.Assert(found.ETag, gc.Equals, "v2")
+	c.Assert(s.allLinkIDs(c), gc.HasLen, 1)
+}
+
+func (s *ShardedGraphTestSuite) TestWatchSkipsCopies(c *gc.C) {
+	src, dst := s.linksOnDifferentShards(c)
+	c.Assert(s.g.UpsertEdge(context.TODO(), &graph.Edge{Source: src.ID, Destination: dst.ID}), gc.IsNil)
+
+	ctx, cancelFn := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancelFn()
+	it, err := s.g.Watch(ctx, time.Time{})
+	c.Assert(err, gc.IsNil)
+	defer func() { _ = it.Close() }()
+
+	var linkEvents int
+	for i := 0; i < 3; i++ {
+		c.Assert(it.Next(), gc.Equals, true, gc.Commentf("got error: %v", it.Error()))
+		if it.Event().Type == graph.LinkUpserted {
+			linkEvents++
+		}
+	}
+	c.Assert(linkEvents, gc.Equals, 2)
+	c.Assert(it.Event().Type, gc.Equals, graph.EdgeUpserted)
+}
+
+// linksOnDifferentShards creates two links that belong to different shards.
+func (s *ShardedGraphTestSuite) linksOnDifferentShards(c *gc.C) (*graph.Link, *graph.Link) {
+	src := &graph.Link{URL: "https://example.com/src"}
+	c.Assert(s.g.UpsertLink(context.TODO(), src), gc.IsNil)
+	for i := 0; ; i++ {
+		dst := &graph.Link{URL: fmt.Sprintf("https://example.com/dst/%d", i)}
+		if s.g.urlShard(dst.URL) != s.g.shardFor(src.ID) {
+			c.Assert(s.g.UpsertLink(context.TODO(), dst), gc.IsNil)
+			return src, dst
+		}
+	}
+}
+
+// shardLinkIDs returns the IDs of all links stored by a shard, including
+// copies of links owned by other shards.
+func (s *ShardedGraphTestSuite) shardLinkIDs(c *gc.C, shard int) []uuid.UUID {
+	it, err := s.shards[shard].Links(context.TODO(), partition.MinUUID, partition.MaxUUID, time.Now().Add(time.Hour))
+	c.Assert(err, gc.IsNil)
+	defer func() { c.Assert(it.Close(), gc.IsNil) }()
+
+	var ids []uuid.UUID
+	for it.Next() {
+		ids = append(ids, it.Link().ID)
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	return ids
+}
+
+// allLinkIDs returns the IDs of all links visible through the sharded graph.
+func (s *ShardedGraphTestSuite) allLinkIDs(c *gc.C) []uuid.UUID {
+	it, err := s.g.Links(context.TODO(), partition.MinUUID, partition.MaxUUID, time.Now().Add(time.Hour))
+	c.Assert(err, gc.IsNil)
+	defer func() { c.Assert(it.Close(), gc.IsNil) }()
+
+	var ids []uuid.UUID
+	for it.Next() {
+		ids = append(ids, it.Link().ID)
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	return ids
+}
@@ -0,0 +1,246 @@
+package sharded
+
+import (
+	"bytes"
+	"container/heap"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+)
+
+// linkIDLess orders links by ID.
+func linkIDLess(a, b *graph.Link) bool {
+	return bytes.Compare(a.ID[:], b.ID[:]) < 0
+}
+
+// linkDueLess orders links by NextCrawlAt and then by ID.
+func linkDueLess(a, b *graph.Link) bool {
+	if !a.NextCrawlAt.Equal(b.NextCrawlAt) {
+		return a.NextCrawlAt.Before(b.NextCrawlAt)
+	}
+	return linkIDLess(a, b)
+}
+
+// linkHeapEntry holds the next link of a merged iterator.
+type linkHeapEntry struct {
+	link *graph.Link
+	src  int
+}
+
+// linkHeap is a min-heap of the next link of each merged iterator.
+type linkHeap struct {
+	entries []linkHeapEntry
+	less    func(a, b *graph.Link) bool
+}
+
+func (h *linkHeap) Len() int           { return len(h.entries) }
+func (h *linkHeap) Less(i, j int) bool { return h.less(h.entries[i].link, h.entries[j].link) }
+func (h *linkHeap) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *linkHeap) Push(x interface{}) { h.entries = append(h.entries, x.(linkHeapEntry)) }
+func (h *linkHeap) Pop() interface{} {
+	last := len(h.entries) - 1
+	entry := h.entries[last]
+	h.entries = h.entries[:last]
+	return entry
+}
+
+// linkIterator merges the ordered output of a set of link iterators.
+type linkIterator struct {
+	its     []graph.LinkIterator
+	heap    linkHeap
+	started bool
+
+	curLink   *graph.Link
+	curSrc    int
+	curCursor graph.Cursor
+	lastErr   error
+}
+
+func newLinkIterator(its []graph.LinkIterator, less func(a, b *graph.Link) bool, startCursor graph.Cursor) *linkIterator {
+	return &linkIterator{
+		its:       its,
+		heap:      linkHeap{less: less},
+		curCursor: startCursor,
+	}
+}
+
+// Next implements graph.LinkIterator.
+func (i *linkIterator) Next() bool {
+	if i.lastErr != nil {
+		return false
+	}
+
+	if !i.started {
+		i.started = true
+		for src := range i.its {
+			if !i.fill(src) {
+				return false
+			}
+		}
+	} else if i.curLink != nil && !i.fill(i.curSrc) {
+		return false
+	}
+
+	if i.heap.Len() == 0 {
+		i.curLink = nil
+		return false
+	}
+	entry := heap.Pop(&i.heap).(linkHeapEntry)
+	i.curLink, i.curSrc = entry.link, entry.src
+	i.curCursor = graph.NewCursor(i.curLink.ID)
+	return true
+}
+
+// fill pushes the next link of the specified iterator to the heap.
+func (i *linkIterator) fill(src int) bool {
+	it := i.its[src]
+	if it.Next() {
+		heap.Push(&i.heap, linkHeapEntry{link: it.Link(), src: src})
+		return true
+	}
+	if err := it.Error(); err != nil {
+		i.lastErr = err
+		return false
+	}
+	return true
+}
+
+// Error implements graph.LinkIterator.
+func (i *linkIterator) Error() error {
+	return i.lastErr
+}
+
+// Close implements graph.LinkIterator.
+func (i *linkIterator) Close() error {
+	return closeLinkIterators(i.its)
+}
+
+// Link implements graph.LinkIterator.
+func (i *linkIterator) Link() *graph.Link {
+	return i.curLink
+}
+
+// Cursor implements graph.LinkIterator.
+func (i *linkIterator) Cursor() graph.Cursor {
+	return i.curCursor
+}
+
+// closeLinkIterators closes its and returns the first error encountered.
+func closeLinkIterators(its []graph.LinkIterator) error {
+	var firstErr error
+	for _, it := range its {
+		if err := it.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// edgeHeapEntry holds the next edge of a merged iterator.
+type edgeHeapEntry struct {
+	edge *graph.Edge
+	src  int
+}
+
+// edgeHeap is a min-heap of the next edge of each merged iterator ordered
+// by edge ID.
+type edgeHeap []edgeHeapEntry
+
+func (h edgeHeap) Len() int { return len(h) }
+func (h edgeHeap) Less(i, j int) bool {
+	return bytes.Compare(h[i].edge.ID[:], h[j].edge.ID[:]) < 0
+}
+func (h edgeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *edgeHeap) Push(x interface{}) { *h = append(*h, x.(edgeHeapEntry)) }
+func (h *edgeHeap) Pop() interface{} {
+	last := len(*h) - 1
+	entry := (*h)[last]
+	*h = (*h)[:last]
+	return entry
+}
+
+// edgeIterator merges the ID-ordered output of a set of edge iterators.
+type edgeIterator struct {
+	its     []graph.EdgeIterator
+	heap    edgeHeap
+	started bool
+
+	curEdge   *graph.Edge
+	curSrc    int
+	curCursor graph.Cursor
+	lastErr   error
+}
+
+func newEdgeIterator(its []graph.EdgeIterator, startCursor graph.Cursor) *edgeIterator {
+	return &edgeIterator{its: its, curCursor: startCursor}
+}
+
+// Next implements graph.EdgeIterator.
+func (i *edgeIterator) Next() bool {
+	if i.lastErr != nil {
+		return false
+	}
+
+	if !i.started {
+		i.started = true
+		for src := range i.its {
+			if !i.fill(src) {
+				return false
+			}
+		}
+	} else if i.curEdge != nil && !i.fill(i.curSrc) {
+		return false
+	}
+
+	if i.heap.Len() == 0 {
+		i.curEdge = nil
+		return false
+	}
+	entry := heap.Pop(&i.heap).(edgeHeapEntry)
+	i.curEdge, i.curSrc = entry.edge, entry.src
+	i.curCursor = graph.NewCursor(i.curEdge.ID)
+	return true
+}
+
+// fill pushes the next edge of the specified iterator to the heap.
+func (i *edgeIterator) fill(src int) bool {
+	it := i.its[src]
+	if it.Next() {
+		heap.Push(&i.heap, edgeHeapEntry{edge: it.Edge(), src: src})
+		return true
+	}
+	if err := it.Error(); err != nil {
+		i.lastErr = err
+		return false
+	}
+	return true
+}
+
+// Error implements graph.EdgeIterator.
+func (i *edgeIterator) Error() error {
+	return i.lastErr
+}
+
+// Close implements graph.EdgeIterator.
+func (i *edgeIterator) Close() error {
+	return closeEdgeIterators(i.its)
+}
+
+// Edge implements graph.EdgeIterator.
+func (i *edgeIterator) Edge() *graph.Edge {
+	return i.curEdge
+}
+
+// Cursor implements graph.EdgeIterator.
+func (i *edgeIterator) Cursor() graph.Cursor {
+	return i.curCursor
+}
+
+// closeEdgeIterators closes its and returns the first error encountered.
+func closeEdgeIterators(its []graph.EdgeIterator) error {
+	var firstErr error
+	for _, it := range its {
+		if err := it.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,81 @@
+package sharded
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+// snapshot is a graph.Snapshot implementation that combines a snapshot of
+// each shard. The shard snapshots are taken one after the other so they are
+// not guaranteed to reflect the exact same point in time.
+type snapshot struct {
+	g     *Graph
+	snaps []graph.Snapshot
+}
+
+// Snapshot implements graph.Graph.
+func (g *Graph) Snapshot(ctx context.Context) (graph.Snapshot, error) {
+	snaps := make([]graph.Snapshot, 0, len(g.shards))
+	for _, shard := range g.shards {
+		snap, err := shard.Snapshot(ctx)
+		if err != nil {
+			for _, snap := range snaps {
+				_ = snap.Close()
+			}
+			return nil, xerrors.Errorf("snapshot: %w", err)
+		}
+		snaps = append(snaps, snap)
+	}
+	return &snapshot{g: g, snaps: snaps}, nil
+}
+
+// FindLink implements graph.Snapshot.
+func (s *snapshot) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	shard := s.g.shardFor(id)
+	link, err := s.snaps[shard].FindLink(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if owner := s.g.shardFor(link.ID); owner != shard {
+		return s.snaps[owner].FindLink(ctx, link.ID)
+	}
+	return link, nil
+}
+
+// Links implements graph.Snapshot.
+func (s *snapshot) Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (graph.LinkIterator, error) {
+	return s.LinksAfter(ctx, fromID, toID, retrievedBefore, "")
+}
+
+// LinksAfter implements graph.Snapshot.
+func (s *snapshot) LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	return s.g.mergeLinks(fromID, toID, after, linkIDLess, func(shard int, from, to uuid.UUID) (graph.LinkIterator, error) {
+		return s.snaps[shard].LinksAfter(ctx, from, to, retrievedBefore, after)
+	})
+}
+
+// Edges implements graph.Snapshot.
+func (s *snapshot) Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return s.EdgesAfter(ctx, fromID, toID, updatedBefore, "")
+}
+
+// EdgesAfter implements graph.Snapshot.
+func (s *snapshot) EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (graph.EdgeIterator, error) {
+	return s.g.mergeEdges(s.g.shardRanges(fromID, toID), after, func(shard int, from, to uuid.UUID) (graph.EdgeIterator, error) {
+		return s.snaps[shard].EdgesAfter(ctx, from, to, updatedBefore, after)
+	})
+}
+
+// Close implements graph.Snapshot.
+func (s *snapshot) Close() error {
+	var firstErr error
+	for _, snap := range s.snaps {
+		if err := snap.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
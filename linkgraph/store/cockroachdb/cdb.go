@@ -1,135 +1,808 @@
 package cdb
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/kyteproject/search-engine/linkgraph/graph"
-	"github.com/lib/pq"
+	"github.com/kyteproject/search-engine/linkgraph/store/internal/pruner"
 	"golang.org/x/xerrors"
+	"strings"
 	"time"
 )
 
 var (
+	// linkColumns and edgeColumns list the columns that are read back by
+	// the scanLink and scanEdge helpers.
+	linkColumns = "id, url, retrieved_at, etag, last_modified, content_hash, http_status, failure_count, status, next_crawl_at"
+	edgeColumns = "id, src, dst, updated_at, anchor_text, nofollow, sponsored, ugc, weight"
+
 	// If insert url is duplicate -> update retrieved_at to max of the original and submitted
-	upsertLinkQuery = `
-		INSERT INTO links (url, retrieved_at) VALUES ($1, $2)
-		ON CONFLICT (url) DO UPDATE SET retrieved_at=GREATEST(links.retrieved_at, $2)
-		RETURNING id, retrieved_at`
-	findLinkQuery = `
-		SELECT url, retrieved_at FROM links WHERE id=$1`
-	linksInPartitionQuery = `
-		SELECT id, url, retrieved_at FROM links WHERE id >= $1 AND id < $2 AND retrieved_at < $3`
-
-	// If insert duplicate change updated_at to current timestamp
-	upsertEdgeQuery = `
-		INSERT INTO edges (src, dst, updated_at) VALUES ($1, $2, NOW())
-		ON CONFLICT (src, dst) DO UPDATE SET updated_at=NOW()
-		RETURNING id, updated_at`
-	edgesInPartitionQuery = `
-		SELECT id, src, dst, updated_at FROM edges WHERE src >= $1 AND src < $2 AND updated_at < $3`
+	// and only overwrite the crawl metadata if the submitted values are not older than the
+	// stored ones.
+	upsertLinkInsertClause = `
+		INSERT INTO links (id, url, retrieved_at, etag, last_modified, content_hash, http_status, failure_count, status, next_crawl_at)
+		VALUES `
+	upsertLinkConflictClause = `
+		ON CONFLICT (url) DO UPDATE SET
+			etag=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.etag ELSE links.etag END,
+			last_modified=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.last_modified ELSE links.last_modified END,
+			content_hash=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.content_hash ELSE links.content_hash END,
+			http_status=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.http_status ELSE links.http_status END,
+			failure_count=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.failure_count ELSE links.failure_count END,
+			status=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.status ELSE links.status END,
+			next_crawl_at=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.next_crawl_at ELSE links.next_crawl_at END,
+			retrieved_at=GREATEST(links.retrieved_at, excluded.retrieved_at)
+		RETURNING ` + linkColumns
+	upsertLinkQuery = upsertLinkInsertClause + "($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)" + upsertLinkConflictClause
+	findLinkQuery   = "SELECT " + linkColumns + ` FROM links
+		WHERE id=COALESCE((SELECT canonical FROM link_aliases WHERE alias=$1), $1)`
+	findLinkByURLQuery = "SELECT " + linkColumns + ` FROM links
+		WHERE id=(
+			SELECT COALESCE(a.canonical, l.id) FROM links AS l
+			LEFT JOIN link_aliases AS a ON a.alias=l.id
+			WHERE l.url=$1
+		)`
+	resolveAliasQuery     = "SELECT canonical FROM link_aliases WHERE alias=$1"
+	upsertAliasQuery      = "INSERT INTO link_aliases (alias, canonical) VALUES ($1, $2) ON CONFLICT (alias) DO UPDATE SET canonical=excluded.canonical"
+	repointAliasesQuery   = "UPDATE link_aliases SET canonical=$2 WHERE canonical=$1"
+	removeLinkQuery       = "DELETE FROM links WHERE id=$1 RETURNING " + linkColumns
+	removeLinkEdgesQuery  = "DELETE FROM edges WHERE src=$1 OR dst=$1 RETURNING " + edgeColumns
+	linksInPartitionQuery = "SELECT " + linkColumns + ` FROM links
+		WHERE id >= $1 AND id < $2 AND retrieved_at < $3
+		ORDER BY id`
+	linksInPartitionAfterQuery = "SELECT " + linkColumns + ` FROM links
+		WHERE id >= $1 AND id < $2 AND retrieved_at < $3 AND id > $4
+		ORDER BY id`
+	linksDueForCrawlQuery = "SELECT " + linkColumns + ` FROM links
+		WHERE id >= $1 AND id < $2 AND next_crawl_at < $3 AND status NOT IN ($4, $5)
+		ORDER BY next_crawl_at, id`
+
+	// If insert duplicate change updated_at to current timestamp and replace
+	// the edge attributes with the submitted ones. Edge IDs are generated by
+	// the client as older PostgreSQL versions lack gen_random_uuid.
+	upsertEdgeInsertClause = `
+		INSERT INTO edges (id, src, dst, anchor_text, nofollow, sponsored, ugc, weight, updated_at) VALUES `
+	upsertEdgeConflictClause = `
+		ON CONFLICT (src, dst) DO UPDATE SET
+			anchor_text=excluded.anchor_text,
+			nofollow=excluded.nofollow,
+			sponsored=excluded.sponsored,
+			ugc=excluded.ugc,
+			weight=excluded.weight,
+			updated_at=NOW()
+		RETURNING ` + edgeColumns
+	upsertEdgeQuery       = upsertEdgeInsertClause + "($1, $2, $3, $4, $5, $6, $7, $8, NOW())" + upsertEdgeConflictClause
+	edgesInPartitionQuery = "SELECT " + edgeColumns + ` FROM edges
+		WHERE src >= $1 AND src < $2 AND updated_at < $3
+		ORDER BY id`
+	edgesInPartitionAfterQuery = "SELECT " + edgeColumns + ` FROM edges
+		WHERE src >= $1 AND src < $2 AND updated_at < $3 AND id > $4
+		ORDER BY id`
+	// The columns of canonicalEdgesQuery must match edgeColumns.
+	canonicalEdgesQuery = `
+		SELECT e.id, e.src, COALESCE(a.canonical, e.dst), e.updated_at, e.anchor_text, e.nofollow, e.sponsored, e.ugc, e.weight
+		FROM edges AS e LEFT JOIN link_aliases AS a ON a.alias=e.dst
+		WHERE e.src >= $1 AND e.src < $2 AND e.updated_at < $3
+		ORDER BY e.id`
+	inEdgesQuery = "SELECT " + edgeColumns + ` FROM edges
+		WHERE dst = $1 AND updated_at < $2
+		ORDER BY id`
 	removeStaleEdgesQuery = `
-		DELETE FROM edges WHERE src=$1 AND updated_at < $2`
+		DELETE FROM edges WHERE src=$1 AND updated_at < $2
+		RETURNING ` + edgeColumns
 
 	// Compile-time check for ensuring CockroachDbGraph implements Graph.
 	_ graph.Graph = (*CockroachDBGraph)(nil)
 )
 
+// maxBatchSize is the maximum number of rows that are inserted by a single
+// multi-row INSERT statement when upserting links or edges in bulk.
+const maxBatchSize = 256
+
 // CockroachDBGraph implements a graph that persists links & edges to a cockroachDB
+// or, when created via NewPostgresGraph, to a PostgreSQL database.
 type CockroachDBGraph struct {
-	db *sql.DB
+	db       *sql.DB
+	dialect  dialect
+	watchLag time.Duration
+	pruner   *pruner.Pruner
+}
+
+// Config encapsulates the settings for a CockroachDBGraph. Zero values are
+// replaced by the defaults listed next to each field.
+type Config struct {
+	// The time for which the events returned by Watch are retained and
+	// the interval at which older events are pruned. Defaults: 24h and
+	// 10m. A negative EventRetention disables pruning, which is useful
+	// when several graph instances share a database.
+	EventRetention     time.Duration
+	EventPruneInterval time.Duration
+
+	// How far behind the present watchers read on CockroachDB. Events
+	// are only delivered once they are older than this; transactions
+	// that take longer to commit may be aborted by watchers. Ignored on
+	// PostgreSQL. Defaults to 2s.
+	WatchLag time.Duration
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.EventRetention == 0 {
+		cfg.EventRetention = 24 * time.Hour
+	}
+	if cfg.EventPruneInterval <= 0 {
+		cfg.EventPruneInterval = 10 * time.Minute
+	}
+	if cfg.WatchLag <= 0 {
+		cfg.WatchLag = 2 * time.Second
+	}
 }
 
 // NewCockroachDBGraph returns a new CockroachDBGraph instance that connects via provided dsn
+// and uses the default Config settings.
 func NewCockroachDBGraph(dsn string) (*CockroachDBGraph, error) {
+	return NewCockroachDBGraphWithConfig(dsn, Config{})
+}
+
+// NewCockroachDBGraphWithConfig behaves like NewCockroachDBGraph but applies
+// the provided settings.
+func NewCockroachDBGraphWithConfig(dsn string, cfg Config) (*CockroachDBGraph, error) {
+	return newGraph(dsn, dialectCockroachDB, cfg)
+}
+
+// newGraph opens a database connection via the provided dsn and starts
+// pruning old events as specified by cfg.
+func newGraph(dsn string, d dialect, cfg Config) (*CockroachDBGraph, error) {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	return &CockroachDBGraph{db: db}, nil
+	cfg.setDefaults()
+	g := &CockroachDBGraph{db: db, dialect: d, watchLag: cfg.WatchLag}
+	if cfg.EventRetention > 0 {
+		g.pruner = pruner.Start(g.PruneEvents, cfg.EventRetention, cfg.EventPruneInterval)
+	}
+	return g, nil
 }
 
 // Close closes the CockroachDB connection or returns an error
 func (c *CockroachDBGraph) Close() error {
+	if c.pruner != nil {
+		c.pruner.Stop()
+	}
 	return c.db.Close()
 }
 
 // UpsertLink creates a new link or updates an existing one and persists
-func (c *CockroachDBGraph) UpsertLink(link *graph.Link) error {
-	row := c.db.QueryRow(upsertLinkQuery, link.URL, link.RetrievedAt.UTC())
-	if err := row.Scan(&link.ID, &link.RetrievedAt); err != nil {
+func (c *CockroachDBGraph) UpsertLink(ctx context.Context, link *graph.Link) error {
+	if err := graph.ValidateLink(link); err != nil {
 		return xerrors.Errorf("upsert link: %w", err)
 	}
 
-	link.RetrievedAt = link.RetrievedAt.UTC()
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(
+			ctx,
+			upsertLinkQuery,
+			linkIDOrNew(link),
+			link.URL,
+			link.RetrievedAt.UTC(),
+			link.ETag,
+			link.LastModified.UTC(),
+			link.ContentHash,
+			link.HTTPStatus,
+			link.FailureCount,
+			link.Status,
+			link.NextCrawlAt.UTC(),
+		)
+		stored, err := scanLink(row)
+		if err != nil {
+			if isPrimaryKeyViolationError(err) {
+				return graph.ErrLinkIDInUse
+			}
+			return err
+		}
+
+		*link = *stored
+		return c.appendEvents(ctx, tx, linkEvent(graph.LinkUpserted, stored))
+	})
+	if err != nil {
+		return xerrors.Errorf("upsert link: %w", mapError(err))
+	}
 	return nil
 }
 
+// UpsertLinks creates or updates a batch of links using multi-row INSERT
+// statements.
+func (c *CockroachDBGraph) UpsertLinks(ctx context.Context, links []*graph.Link) error {
+	var (
+		errs  []error
+		valid = make([]int, 0, len(links))
+	)
+	for i, link := range links {
+		if err := graph.ValidateLink(link); err != nil {
+			if errs == nil {
+				errs = make([]error, len(links))
+			}
+			errs[i] = xerrors.Errorf("upsert links: %w", err)
+			continue
+		}
+		valid = append(valid, i)
+	}
+
+	for _, chunk := range batchChunks(len(valid), func(i int) string { return links[valid[i]].URL }) {
+		// Map the chunk indices back to indices of the links slice.
+		for j := range chunk {
+			chunk[j] = valid[chunk[j]]
+		}
+
+		err := c.upsertLinkChunk(ctx, links, chunk)
+		if isPrimaryKeyViolationError(err) {
+			// Upsert the links of the chunk one by one to find out
+			// which of them reuse the ID of another link.
+			for _, i := range chunk {
+				if err := c.UpsertLink(ctx, links[i]); err != nil {
+					if errs == nil {
+						errs = make([]error, len(links))
+					}
+					errs[i] = xerrors.Errorf("upsert links: %w", err)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			if errs == nil {
+				errs = make([]error, len(links))
+			}
+			for _, i := range chunk {
+				errs[i] = xerrors.Errorf("upsert links: %w", mapError(err))
+			}
+		}
+	}
+
+	if errs != nil {
+		return &graph.BatchError{Errors: errs}
+	}
+	return nil
+}
+
+// upsertLinkChunk upserts the links at the specified indices with a single
+// statement. All links in the chunk must have a distinct URL.
+func (c *CockroachDBGraph) upsertLinkChunk(ctx context.Context, links []*graph.Link, chunk []int) error {
+	const numCols = 10
+	args := make([]interface{}, 0, len(chunk)*numCols)
+	for _, i := range chunk {
+		link := links[i]
+		args = append(args,
+			linkIDOrNew(link),
+			link.URL,
+			link.RetrievedAt.UTC(),
+			link.ETag,
+			link.LastModified.UTC(),
+			link.ContentHash,
+			link.HTTPStatus,
+			link.FailureCount,
+			link.Status,
+			link.NextCrawlAt.UTC(),
+		)
+	}
+
+	query := upsertLinkInsertClause + valuesList(len(chunk), numCols, "") + upsertLinkConflictClause
+	return c.withTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = rows.Close() }()
+
+		// The order of the returned rows is not guaranteed to match the
+		// order of the VALUES list so we need to map them back via the URL.
+		byURL := make(map[string]*graph.Link, len(chunk))
+		events := make([]*graph.Event, 0, len(chunk))
+		for rows.Next() {
+			stored, err := scanLink(rows)
+			if err != nil {
+				return err
+			}
+			byURL[stored.URL] = stored
+			events = append(events, linkEvent(graph.LinkUpserted, stored))
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if err = c.appendEvents(ctx, tx, events...); err != nil {
+			return err
+		}
+		for _, i := range chunk {
+			if stored := byURL[links[i].URL]; stored != nil {
+				*links[i] = *stored
+			}
+		}
+		return nil
+	})
+}
+
 // FindLink looks up a link by its ID and returns
-func (c *CockroachDBGraph) FindLink(id uuid.UUID) (*graph.Link, error) {
-	row := c.db.QueryRow(findLinkQuery, id)
-	link := &graph.Link{ID: id}
-	if err := row.Scan(&link.URL, &link.RetrievedAt); err != nil {
+func (c *CockroachDBGraph) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	link, err := scanLink(c.db.QueryRowContext(ctx, findLinkQuery, id))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, xerrors.Errorf("find link: %w", graph.ErrNotFound)
 		}
-		return nil, xerrors.Errorf("find link: %w", err)
+		return nil, xerrors.Errorf("find link: %w", mapError(err))
 	}
+	return link, nil
+}
 
-	link.RetrievedAt = link.RetrievedAt.UTC()
+// FindLinkByURL looks up a link by its URL and returns it
+func (c *CockroachDBGraph) FindLinkByURL(ctx context.Context, url string) (*graph.Link, error) {
+	link, err := scanLink(c.db.QueryRowContext(ctx, findLinkByURLQuery, url))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, xerrors.Errorf("find link by URL: %w", graph.ErrNotFound)
+		}
+		return nil, xerrors.Errorf("find link by URL: %w", mapError(err))
+	}
 	return link, nil
 }
 
+// RemoveLink removes the link with the specified ID together with any edges
+// that originate from or point to it.
+func (c *CockroachDBGraph) RemoveLink(ctx context.Context, id uuid.UUID) error {
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		// Remove the edges explicitly instead of relying on the ON DELETE
+		// CASCADE constraints so that we can emit an event for each one.
+		rows, err := tx.QueryContext(ctx, removeLinkEdgesQuery, id)
+		if err != nil {
+			return err
+		}
+		events, err := edgeEvents(graph.EdgeRemoved, rows)
+		if err != nil {
+			return err
+		}
+
+		link, err := scanLink(tx.QueryRowContext(ctx, removeLinkQuery, id))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return graph.ErrNotFound
+			}
+			return err
+		}
+
+		events = append(events, linkEvent(graph.LinkRemoved, link))
+		return c.appendEvents(ctx, tx, events...)
+	})
+	if err != nil {
+		return xerrors.Errorf("remove link: %w", mapError(err))
+	}
+	return nil
+}
+
+// AddAlias records that the link with aliasID refers to the same document as
+// the link with canonicalID.
+func (c *CockroachDBGraph) AddAlias(ctx context.Context, aliasID, canonicalID uuid.UUID) error {
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		// Flatten alias chains by pointing the alias to the link at the
+		// end of the chain.
+		err := tx.QueryRowContext(ctx, resolveAliasQuery, canonicalID).Scan(&canonicalID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if canonicalID == aliasID {
+			return graph.ErrAliasCycle
+		}
+
+		if _, err = tx.ExecContext(ctx, upsertAliasQuery, aliasID, canonicalID); err != nil {
+			if isForeignKeyViolationError(err) {
+				err = graph.ErrNotFound
+			}
+			return err
+		}
+
+		// Any links that were aliases of aliasID now point to canonicalID.
+		_, err = tx.ExecContext(ctx, repointAliasesQuery, aliasID, canonicalID)
+		return err
+	})
+	if err != nil {
+		return xerrors.Errorf("add alias: %w", mapError(err))
+	}
+	return nil
+}
+
 // Links returns an iterator for the set of links whose IDs belong to the
 // [fromId, toID] range and were last accessed before the provided value
-func (c *CockroachDBGraph) Links(fromID, toID uuid.UUID, accessedBefore time.Time) (graph.LinkIterator, error) {
-	rows, err := c.db.Query(linksInPartitionQuery, fromID, toID, accessedBefore.UTC())
+func (c *CockroachDBGraph) Links(ctx context.Context, fromID, toID uuid.UUID, accessedBefore time.Time) (graph.LinkIterator, error) {
+	return c.LinksAfter(ctx, fromID, toID, accessedBefore, "")
+}
+
+// LinksAfter returns an iterator for the set of links whose IDs belong to the
+// [fromId, toID) range, were last accessed before the provided value and are
+// positioned after the provided cursor.
+func (c *CockroachDBGraph) LinksAfter(ctx context.Context, fromID, toID uuid.UUID, accessedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, xerrors.Errorf("links: %w", mapError(err))
+	}
+
+	var rows *sql.Rows
+	if after == "" {
+		rows, err = c.db.QueryContext(ctx, linksInPartitionQuery, fromID, toID, accessedBefore.UTC())
+	} else {
+		rows, err = c.db.QueryContext(ctx, linksInPartitionAfterQuery, fromID, toID, accessedBefore.UTC(), afterID)
+	}
 	if err != nil {
-		return nil, xerrors.Errorf("links: %w", err)
+		return nil, xerrors.Errorf("links: %w", mapError(err))
 	}
-	return &linkIterator{rows: rows}, nil
+	return &linkIterator{ctx: ctx, rows: rows, startCursor: after}, nil
+}
+
+// LinksDueForCrawl returns an iterator for the set of links whose IDs belong
+// to the [fromID, toID) range, can be crawled and are scheduled to be crawled
+// before the provided timestamp.
+func (c *CockroachDBGraph) LinksDueForCrawl(ctx context.Context, fromID, toID uuid.UUID, dueBefore time.Time) (graph.LinkIterator, error) {
+	rows, err := c.db.QueryContext(
+		ctx,
+		linksDueForCrawlQuery,
+		fromID,
+		toID,
+		dueBefore.UTC(),
+		graph.LinkStatusBlocked,
+		graph.LinkStatusGone,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("links due for crawl: %w", mapError(err))
+	}
+	return &linkIterator{ctx: ctx, rows: rows}, nil
 }
 
 // UpsertEdge creates a new edge or updates an existing edge.
-func (c *CockroachDBGraph) UpsertEdge(edge *graph.Edge) error {
-	row := c.db.QueryRow(upsertEdgeQuery, edge.Source, edge.Destination)
-	if err := row.Scan(&edge.ID, &edge.UpdatedAt); err != nil {
+func (c *CockroachDBGraph) UpsertEdge(ctx context.Context, edge *graph.Edge) error {
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(
+			ctx,
+			upsertEdgeQuery,
+			edgeIDOrNew(edge),
+			edge.Source,
+			edge.Destination,
+			edge.AnchorText,
+			edge.Nofollow,
+			edge.Sponsored,
+			edge.UGC,
+			edge.Weight,
+		)
+		stored, err := scanEdge(row)
+		if err != nil {
+			if isPrimaryKeyViolationError(err) {
+				return graph.ErrEdgeIDInUse
+			}
+			return err
+		}
+
+		*edge = *stored
+		return c.appendEvents(ctx, tx, edgeEvent(graph.EdgeUpserted, stored))
+	})
+	if err != nil {
 		if isForeignKeyViolationError(err) {
 			err = graph.ErrUnknownEdgeLinks
 		}
-		return xerrors.Errorf("upsert edge: %w", err)
+		return xerrors.Errorf("upsert edge: %w", mapError(err))
 	}
+	return nil
+}
 
-	edge.UpdatedAt = edge.UpdatedAt.UTC()
+// UpsertEdges creates or updates a batch of edges using multi-row INSERT
+// statements.
+func (c *CockroachDBGraph) UpsertEdges(ctx context.Context, edges []*graph.Edge) error {
+	var errs []error
+	chunks := batchChunks(len(edges), func(i int) string {
+		return edges[i].Source.String() + edges[i].Destination.String()
+	})
+	for _, chunk := range chunks {
+		err := c.upsertEdgeChunk(ctx, edges, chunk)
+		if err == nil {
+			continue
+		}
+
+		if errs == nil {
+			errs = make([]error, len(edges))
+		}
+
+		// A multi-row insert fails as a whole if any of the edges
+		// references an unknown link or reuses the ID of another edge.
+		// Retry each edge individually so that the error can be
+		// attributed to the offending edges.
+		if isForeignKeyViolationError(err) || isPrimaryKeyViolationError(err) {
+			for _, i := range chunk {
+				if err := c.UpsertEdge(ctx, edges[i]); err != nil {
+					errs[i] = xerrors.Errorf("upsert edges: %w", mapError(err))
+				}
+			}
+			continue
+		}
+
+		for _, i := range chunk {
+			errs[i] = xerrors.Errorf("upsert edges: %w", mapError(err))
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return &graph.BatchError{Errors: errs}
+		}
+	}
 	return nil
 }
 
+// upsertEdgeChunk upserts the edges at the specified indices with a single
+// statement. All edges in the chunk must have a distinct (src, dst) pair.
+func (c *CockroachDBGraph) upsertEdgeChunk(ctx context.Context, edges []*graph.Edge, chunk []int) error {
+	const numCols = 8
+	type edgeKey struct{ src, dst uuid.UUID }
+	args := make([]interface{}, 0, len(chunk)*numCols)
+	for _, i := range chunk {
+		edge := edges[i]
+		args = append(args,
+			edgeIDOrNew(edge),
+			edge.Source,
+			edge.Destination,
+			edge.AnchorText,
+			edge.Nofollow,
+			edge.Sponsored,
+			edge.UGC,
+			edge.Weight,
+		)
+	}
+
+	query := upsertEdgeInsertClause + valuesList(len(chunk), numCols, "NOW()") + upsertEdgeConflictClause
+	return c.withTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = rows.Close() }()
+
+		byKey := make(map[edgeKey]*graph.Edge, len(chunk))
+		events := make([]*graph.Event, 0, len(chunk))
+		for rows.Next() {
+			stored, err := scanEdge(rows)
+			if err != nil {
+				return err
+			}
+			byKey[edgeKey{stored.Source, stored.Destination}] = stored
+			events = append(events, edgeEvent(graph.EdgeUpserted, stored))
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if err = c.appendEvents(ctx, tx, events...); err != nil {
+			return err
+		}
+		for _, i := range chunk {
+			if stored := byKey[edgeKey{edges[i].Source, edges[i].Destination}]; stored != nil {
+				*edges[i] = *stored
+			}
+		}
+		return nil
+	})
+}
+
 // Edges returns an iterator for the set of edges whose source vertex IDs
 // belong to the [fromID, toID) range and were last updated before the provided value.
-func (c *CockroachDBGraph) Edges(fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
-	rows, err := c.db.Query(edgesInPartitionQuery, fromID, toID, updatedBefore.UTC())
+func (c *CockroachDBGraph) Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return c.EdgesAfter(ctx, fromID, toID, updatedBefore, "")
+}
+
+// EdgesAfter returns an iterator for the set of edges whose source vertex IDs
+// belong to the [fromID, toID) range, were last updated before the provided
+// value and are positioned after the provided cursor.
+func (c *CockroachDBGraph) EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (graph.EdgeIterator, error) {
+	afterID, err := after.ID()
 	if err != nil {
-		return nil, xerrors.Errorf("edges: %w", err)
+		return nil, xerrors.Errorf("edges: %w", mapError(err))
 	}
-	return &edgeIterator{rows: rows}, nil
+
+	var rows *sql.Rows
+	if after == "" {
+		rows, err = c.db.QueryContext(ctx, edgesInPartitionQuery, fromID, toID, updatedBefore.UTC())
+	} else {
+		rows, err = c.db.QueryContext(ctx, edgesInPartitionAfterQuery, fromID, toID, updatedBefore.UTC(), afterID)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", mapError(err))
+	}
+	return &edgeIterator{ctx: ctx, rows: rows, startCursor: after}, nil
+}
+
+// CanonicalEdges returns an iterator for the set of edges whose source vertex
+// IDs belong to the [fromID, toID) range and were updated before the provided
+// timestamp. Edges pointing to an alias are rewritten to point to the
+// canonical link instead.
+func (c *CockroachDBGraph) CanonicalEdges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	rows, err := c.db.QueryContext(ctx, canonicalEdgesQuery, fromID, toID, updatedBefore.UTC())
+	if err != nil {
+		return nil, xerrors.Errorf("canonical edges: %w", mapError(err))
+	}
+	return &edgeIterator{ctx: ctx, rows: rows}, nil
+}
+
+// InEdges returns an iterator for the set of edges that point to the
+// specified destination link and were last updated before the provided value.
+func (c *CockroachDBGraph) InEdges(ctx context.Context, dstID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	rows, err := c.db.QueryContext(ctx, inEdgesQuery, dstID, updatedBefore.UTC())
+	if err != nil {
+		return nil, xerrors.Errorf("in edges: %w", mapError(err))
+	}
+	return &edgeIterator{ctx: ctx, rows: rows}, nil
 }
 
 // RemoveStaleEdges removes any edge that originates from the specified link ID
 // and was updated before the specified timestamp.
-func (c *CockroachDBGraph) RemoveStaleEdges(fromID uuid.UUID, updatedBefore time.Time) error {
-	_, err := c.db.Exec(removeStaleEdgesQuery, fromID, updatedBefore.UTC())
+func (c *CockroachDBGraph) RemoveStaleEdges(ctx context.Context, fromID uuid.UUID, updatedBefore time.Time) error {
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, removeStaleEdgesQuery, fromID, updatedBefore.UTC())
+		if err != nil {
+			return err
+		}
+		events, err := edgeEvents(graph.EdgeRemoved, rows)
+		if err != nil {
+			return err
+		}
+		return c.appendEvents(ctx, tx, events...)
+	})
 	if err != nil {
-		return xerrors.Errorf("remove stale edges: %w", err)
+		return xerrors.Errorf("remove stale edges: %w", mapError(err))
 	}
 	return nil
 }
 
-// isForeignKeyViolationError returns true if err indicates a foreign key
-// constraint violation.
-func isForeignKeyViolationError(err error) bool {
-	pqErr, valid := err.(*pq.Error)
-	if !valid {
-		return false
+// withTx runs fn inside a transaction which is committed if fn returns
+// without an error and rolled back otherwise.
+func (c *CockroachDBGraph) withTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// linkIDOrNew returns the ID of link or a new random ID if the link has no
+// ID yet. If the link URL already exists, the generated ID is discarded by
+// the ON CONFLICT clause of the upsert statement.
+func linkIDOrNew(link *graph.Link) uuid.UUID {
+	if link.ID != uuid.Nil {
+		return link.ID
+	}
+	return uuid.New()
+}
+
+// edgeIDOrNew returns the ID of edge or a new random ID if the edge has no
+// ID yet. If the edge links are already connected, the generated ID is
+// discarded by the ON CONFLICT clause of the upsert statement.
+func edgeIDOrNew(edge *graph.Edge) uuid.UUID {
+	if edge.ID != uuid.Nil {
+		return edge.ID
+	}
+	return uuid.New()
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanLink reads a link from a row whose columns match linkColumns.
+func scanLink(row rowScanner) (*graph.Link, error) {
+	link := new(graph.Link)
+	err := row.Scan(
+		&link.ID,
+		&link.URL,
+		&link.RetrievedAt,
+		&link.ETag,
+		&link.LastModified,
+		&link.ContentHash,
+		&link.HTTPStatus,
+		&link.FailureCount,
+		&link.Status,
+		&link.NextCrawlAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	link.RetrievedAt = link.RetrievedAt.UTC()
+	link.LastModified = link.LastModified.UTC()
+	link.NextCrawlAt = link.NextCrawlAt.UTC()
+	return link, nil
+}
+
+// scanEdge reads an edge from a row whose columns match edgeColumns.
+func scanEdge(row rowScanner) (*graph.Edge, error) {
+	edge := new(graph.Edge)
+	err := row.Scan(
+		&edge.ID,
+		&edge.Source,
+		&edge.Destination,
+		&edge.UpdatedAt,
+		&edge.AnchorText,
+		&edge.Nofollow,
+		&edge.Sponsored,
+		&edge.UGC,
+		&edge.Weight,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	edge.UpdatedAt = edge.UpdatedAt.UTC()
+	return edge, nil
+}
+
+// batchChunks splits the indices of a batch with numItems items into chunks
+// of at most maxBatchSize items. As a single INSERT ... ON CONFLICT statement
+// cannot update the same row twice, items that share the same key are
+// always placed in different chunks, preserving their relative order.
+func batchChunks(numItems int, keyFn func(int) string) [][]int {
+	var (
+		chunks   [][]int
+		keyCount = make(map[string]int, numItems)
+	)
+
+	// Group items in rounds so that round N contains the Nth occurrence
+	// of each key.
+	var rounds [][]int
+	for i := 0; i < numItems; i++ {
+		key := keyFn(i)
+		round := keyCount[key]
+		keyCount[key]++
+		if round == len(rounds) {
+			rounds = append(rounds, nil)
+		}
+		rounds[round] = append(rounds[round], i)
 	}
-	return pqErr.Code.Name() == "foreign_key_violation"
-}
\ No newline at end of file
+
+	for _, round := range rounds {
+		for len(round) > maxBatchSize {
+			chunks = append(chunks, round[:maxBatchSize])
+			round = round[maxBatchSize:]
+		}
+		chunks = append(chunks, round)
+	}
+	return chunks
+}
+
+// valuesList returns the placeholder list for a multi-row VALUES clause with
+// numRows rows of numCols bound arguments each. If extra is not empty, it is
+// appended as an additional, unbound column to each row.
+func valuesList(numRows, numCols int, extra string) string {
+	var (
+		sb  strings.Builder
+		arg = 1
+	)
+	for row := 0; row < numRows; row++ {
+		if row > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteByte('(')
+		for col := 0; col < numCols; col++ {
+			if col > 0 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(&sb, "$%d", arg)
+			arg++
+		}
+		if extra != "" {
+			sb.WriteString(", ")
+			sb.WriteString(extra)
+		}
+		sb.WriteByte(')')
+	}
+	return sb.String()
+}
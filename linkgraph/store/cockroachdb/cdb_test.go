@@ -1,10 +1,15 @@
 package cdb
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
 	"github.com/kyteproject/search-engine/linkgraph/graph/graphtest"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	gc "gopkg.in/check.v1"
 )
@@ -15,6 +20,7 @@ func Test(t *testing.T) { gc.TestingT(t) }
 
 type CockroachDbGraphTestSuite struct {
 	graphtest.SuiteBase
+	g  *CockroachDBGraph
 	db *sql.DB
 }
 
@@ -28,23 +34,69 @@ func (s *CockroachDbGraphTestSuite) SetUpSuite(c *gc.C) {
 	g, err := NewCockroachDBGraph(dsn)
 	c.Assert(err, gc.IsNil)
 	s.SetGraph(g)
-	s.db = g.db
+	s.g, s.db = g, g.db
 }
 
 func (s *CockroachDbGraphTestSuite) SetUpTest(c *gc.C) {
-	s.flushDB(c)
+	flushDB(c, s.db)
 }
 
 func (s *CockroachDbGraphTestSuite) TearDownSuite(c *gc.C) {
 	if s.db != nil {
-		s.flushDB(c)
-		c.Assert(s.db.Close(), gc.IsNil)
+		flushDB(c, s.db)
+		c.Assert(s.g.Close(), gc.IsNil)
 	}
 }
 
-func (s *CockroachDbGraphTestSuite) flushDB(c *gc.C) {
-	_, err := s.db.Exec("DELETE FROM links")
+func flushDB(c *gc.C, db *sql.DB) {
+	_, err := db.Exec("DELETE FROM links")
 	c.Assert(err, gc.IsNil)
-	_, err = s.db.Exec("DELETE FROM edges")
+	_, err = db.Exec("DELETE FROM edges")
 	c.Assert(err, gc.IsNil)
-}
\ No newline at end of file
+	_, err = db.Exec("DELETE FROM link_aliases")
+	c.Assert(err, gc.IsNil)
+	_, err = db.Exec("DELETE FROM graph_events")
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *CockroachDbGraphTestSuite) TestWatchDoesNotAbortWriters(c *gc.C) {
+	const (
+		numWriters = 8
+		numLinks   = 25
+	)
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	defer cancelFn()
+
+	// Keep a watcher polling for recent events while links are upserted.
+	it, err := s.g.Watch(ctx, time.Now())
+	c.Assert(err, gc.IsNil)
+	var watcherWg sync.WaitGroup
+	watcherWg.Add(1)
+	go func() {
+		defer watcherWg.Done()
+		for it.Next() {
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, numWriters*numLinks)
+	for w := 0; w < numWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < numLinks; i++ {
+				errCh <- s.g.UpsertLink(ctx, &graph.Link{URL: fmt.Sprintf("https://example.com/%d/%d", w, i)})
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		c.Assert(err, gc.IsNil, gc.Commentf("writer was aborted by the watcher"))
+	}
+	c.Assert(it.Close(), gc.IsNil)
+	watcherWg.Wait()
+	c.Assert(it.Error(), gc.IsNil)
+}
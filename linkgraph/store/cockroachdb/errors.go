@@ -0,0 +1,100 @@
+package cdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/lib/pq"
+	"golang.org/x/xerrors"
+	"io"
+	"net"
+	"strings"
+)
+
+// classifiedError associates an error returned by the database driver with
+// one of the graph package errors while still allowing callers to access
+// the original error.
+type classifiedError struct {
+	err  error
+	kind error
+}
+
+// Error implements the error interface.
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the original error.
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether target matches the graph error assigned to e.
+func (e *classifiedError) Is(target error) bool {
+	return target == e.kind
+}
+
+// mapError classifies err as graph.ErrConflict or graph.ErrUnavailable based
+// on the error code reported by the database or the type of the error. Any
+// other error is returned unchanged.
+func mapError(err error) error {
+	if kind := errorKind(err); kind != nil {
+		return &classifiedError{err: err, kind: kind}
+	}
+	return err
+}
+
+// errorKind returns the graph error that corresponds to err or nil if err
+// cannot be classified.
+func errorKind(err error) error {
+	// Context errors are reported as-is. This check must come first as
+	// context.DeadlineExceeded also implements net.Error.
+	if err == nil || xerrors.Is(err, context.Canceled) || xerrors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+
+	var pqErr *pq.Error
+	if xerrors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == "40001", pqErr.Code == "40P01":
+			// serialization_failure is returned by CockroachDB when a
+			// transaction needs to be retried and deadlock_detected by
+			// PostgreSQL when concurrent batches lock rows in a different
+			// order.
+			return graph.ErrConflict
+		case pqErr.Code == "40003", pqErr.Code.Class() == "08", pqErr.Code.Class() == "53",
+			pqErr.Code == "57P01", pqErr.Code == "57P02", pqErr.Code == "57P03":
+			// statement_completion_unknown, connection exceptions,
+			// insufficient resources and node shutdowns.
+			return graph.ErrUnavailable
+		}
+		return nil
+	}
+
+	var netErr net.Error
+	if xerrors.Is(err, driver.ErrBadConn) || xerrors.Is(err, io.EOF) ||
+		xerrors.Is(err, io.ErrUnexpectedEOF) || xerrors.As(err, &netErr) {
+		return graph.ErrUnavailable
+	}
+	return nil
+}
+
+// isForeignKeyViolationError returns true if err indicates a foreign key
+// constraint violation.
+func isForeignKeyViolationError(err error) bool {
+	pqErr, valid := err.(*pq.Error)
+	if !valid {
+		return false
+	}
+	return pqErr.Code.Name() == "foreign_key_violation"
+}
+
+// isPrimaryKeyViolationError returns true if err indicates that a row with
+// the same primary key already exists.
+func isPrimaryKeyViolationError(err error) bool {
+	pqErr, valid := err.(*pq.Error)
+	if !valid || pqErr.Code.Name() != "unique_violation" {
+		return false
+	}
+	return pqErr.Constraint == "primary" || strings.HasSuffix(pqErr.Constraint, "_pkey")
+}
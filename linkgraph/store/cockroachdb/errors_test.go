@@ -0,0 +1,67 @@
+package cdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/lib/pq"
+	"golang.org/x/xerrors"
+	"io"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(ErrorMappingTestSuite))
+
+type ErrorMappingTestSuite struct{}
+
+func (s *ErrorMappingTestSuite) TestMapError(c *gc.C) {
+	specs := []struct {
+		descr     string
+		err       error
+		expKind   error
+		retryable bool
+	}{
+		{descr: "serialization failure", err: &pq.Error{Code: "40001"}, expKind: graph.ErrConflict, retryable: true},
+		{descr: "deadlock", err: &pq.Error{Code: "40P01"}, expKind: graph.ErrConflict, retryable: true},
+		{descr: "connection failure", err: &pq.Error{Code: "08006"}, expKind: graph.ErrUnavailable, retryable: true},
+		{descr: "admin shutdown", err: &pq.Error{Code: "57P01"}, expKind: graph.ErrUnavailable, retryable: true},
+		{descr: "bad connection", err: driver.ErrBadConn, expKind: graph.ErrUnavailable, retryable: true},
+		{descr: "unexpected EOF", err: xerrors.Errorf("read: %w", io.ErrUnexpectedEOF), expKind: graph.ErrUnavailable, retryable: true},
+		{descr: "unique violation", err: &pq.Error{Code: "23505"}},
+		{descr: "syntax error", err: &pq.Error{Code: "42601"}},
+		{descr: "deadline exceeded", err: context.DeadlineExceeded},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.descr)
+
+		err := xerrors.Errorf("op: %w", mapError(spec.err))
+		c.Assert(xerrors.Is(err, spec.err), gc.Equals, true, gc.Commentf("original error is not reachable"))
+		if spec.expKind != nil {
+			c.Assert(xerrors.Is(err, spec.expKind), gc.Equals, true)
+		}
+		c.Assert(graph.IsRetryable(err), gc.Equals, spec.retryable)
+	}
+
+	c.Assert(mapError(nil), gc.IsNil)
+}
+
+func (s *ErrorMappingTestSuite) TestIsPrimaryKeyViolationError(c *gc.C) {
+	specs := []struct {
+		descr string
+		err   error
+		exp   bool
+	}{
+		{descr: "primary key", err: &pq.Error{Code: "23505", Constraint: "primary"}, exp: true},
+		{descr: "named primary key", err: &pq.Error{Code: "23505", Constraint: "links_pkey"}, exp: true},
+		{descr: "unique index", err: &pq.Error{Code: "23505", Constraint: "links_url_key"}},
+		{descr: "foreign key", err: &pq.Error{Code: "23503", Constraint: "primary"}},
+		{descr: "other error", err: io.EOF},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.descr)
+		c.Assert(isPrimaryKeyViolationError(spec.err), gc.Equals, spec.exp)
+	}
+}
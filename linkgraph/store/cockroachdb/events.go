@@ -0,0 +1,365 @@
+package cdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// On CockroachDB, events are stamped with the commit timestamp of
+	// their transaction. Reading the events after a given commit timestamp
+	// forces any transaction that has not committed yet to commit at a
+	// later timestamp, so watchers can never miss an event. As such
+	// transactions cannot be pushed, they restart instead. Watchers read
+	// Config.WatchLag in the past so that only transactions that run for
+	// longer than that are affected. The AS OF SYSTEM TIME clause is
+	// formatted with the lag in milliseconds.
+	appendEventClause = `
+		INSERT INTO graph_events (event_type, payload, commit_ts) VALUES `
+	eventsAfterQuery = `
+		SELECT seq, event_type, payload, commit_ts::STRING FROM graph_events
+		AS OF SYSTEM TIME '-%dms'
+		WHERE (commit_ts, seq) > ($1::DECIMAL, $2)
+		ORDER BY commit_ts, seq
+		LIMIT $3`
+
+	// On PostgreSQL, transactions hold a shared advisory lock from the
+	// moment they allocate sequence numbers for their events until they
+	// commit. Watchers briefly take the lock in exclusive mode while they
+	// fetch a page of events so that they never observe a sequence number
+	// while a lower one is still uncommitted. Writers do not block each
+	// other.
+	pgLockEventsQuery   = "SELECT pg_advisory_xact_lock_shared($1)"
+	pgWatchEventsQuery  = "SELECT pg_advisory_xact_lock($1)"
+	pgAppendEventClause = `
+		INSERT INTO graph_events (event_type, payload, created_at) VALUES `
+	pgEventsAfterQuery = `
+		SELECT seq, event_type, payload, created_at FROM graph_events
+		WHERE seq > $1 AND created_at > $2
+		ORDER BY seq
+		LIMIT $3`
+
+	pruneEventsQuery = `
+		DELETE FROM graph_events WHERE created_at < $1`
+)
+
+const (
+	// eventPollInterval controls how often watchers poll the graph_events
+	// table for new events once they have caught up.
+	eventPollInterval = 250 * time.Millisecond
+
+	// eventPageSize is the maximum number of events fetched by each poll.
+	eventPageSize = 512
+
+	// pgEventsLockID identifies the advisory lock that keeps PostgreSQL
+	// watchers from fetching events while transactions that record events
+	// are committing.
+	pgEventsLockID = 0x6c696e6b67726170
+)
+
+// Watch returns an iterator for the stream of mutation events that occur
+// after the provided timestamp. Events are read from the graph_events table
+// which is populated by the same transactions that mutate the graph.
+//
+// Events are delivered in commit order. On CockroachDB, events are delivered
+// once they are older than Config.WatchLag. Transactions that take longer
+// than that to record events cannot have their commit timestamp pushed by
+// watchers and instead fail with a retryable graph.ErrConflict error. On
+// PostgreSQL, each
+// poll waits for the transactions that are recording events to commit and
+// holds off new ones until the page of events has been fetched.
+func (c *CockroachDBGraph) Watch(ctx context.Context, since time.Time) (graph.EventIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("watch: %w", err)
+	}
+
+	return &eventIterator{
+		ctx:      ctx,
+		db:       c.db,
+		dialect:  c.dialect,
+		query:    fmt.Sprintf(eventsAfterQuery, c.watchLag.Milliseconds()),
+		since:    since.UTC(),
+		commitTS: commitTSAfter(since),
+		seq:      -1,
+		closeCh:  make(chan struct{}),
+	}, nil
+}
+
+// PruneEvents removes any events that were recorded before the provided
+// timestamp from the graph_events table.
+func (c *CockroachDBGraph) PruneEvents(ctx context.Context, olderThan time.Time) error {
+	if _, err := c.db.ExecContext(ctx, pruneEventsQuery, olderThan.UTC()); err != nil {
+		return xerrors.Errorf("prune events: %w", mapError(err))
+	}
+	return nil
+}
+
+// appendEvents records a set of events in the graph_events table as part of
+// the provided transaction. It must be the last statement executed by the
+// transaction.
+func (c *CockroachDBGraph) appendEvents(ctx context.Context, tx *sql.Tx, events ...*graph.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	const numCols = 2
+	args := make([]interface{}, 0, len(events)*numCols)
+	for _, ev := range events {
+		var (
+			payload []byte
+			err     error
+		)
+		if ev.Link != nil {
+			payload, err = json.Marshal(ev.Link)
+		} else {
+			payload, err = json.Marshal(ev.Edge)
+		}
+		if err != nil {
+			return err
+		}
+		args = append(args, int(ev.Type), string(payload))
+	}
+
+	query := appendEventClause + valuesList(len(events), numCols, "cluster_logical_timestamp()")
+	if c.dialect == dialectPostgres {
+		if _, err := tx.ExecContext(ctx, pgLockEventsQuery, pgEventsLockID); err != nil {
+			return err
+		}
+		query = pgAppendEventClause + valuesList(len(events), numCols, "clock_timestamp()")
+	}
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// linkEvent returns a new event of the specified type for a link.
+func linkEvent(evType graph.EventType, link *graph.Link) *graph.Event {
+	return &graph.Event{Type: evType, Link: link}
+}
+
+// edgeEvent returns a new event of the specified type for an edge.
+func edgeEvent(evType graph.EventType, edge *graph.Edge) *graph.Event {
+	return &graph.Event{Type: evType, Edge: edge}
+}
+
+// edgeEvents consumes and closes a set of rows whose columns match
+// edgeColumns and returns an event of the specified type for each edge.
+func edgeEvents(evType graph.EventType, rows *sql.Rows) ([]*graph.Event, error) {
+	defer func() { _ = rows.Close() }()
+
+	var events []*graph.Event
+	for rows.Next() {
+		edge, err := scanEdge(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, edgeEvent(evType, edge))
+	}
+	return events, rows.Err()
+}
+
+// eventIterator is a graph.EventIterator implementation for the cdb graph.
+type eventIterator struct {
+	ctx     context.Context
+	db      *sql.DB
+	dialect dialect
+	since   time.Time
+
+	// query fetches the next page of events on CockroachDB.
+	query string
+
+	// commitTS and seq hold the position of the last fetched event. The
+	// commit timestamp is only tracked on CockroachDB.
+	commitTS string
+	seq      int64
+
+	// caughtUp is set when the last poll returned a partial page.
+	caughtUp bool
+
+	pending []*graph.Event
+	curEv   *graph.Event
+	lastErr error
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// Next implements graph.EventIterator.
+func (i *eventIterator) Next() bool {
+	for {
+		if i.lastErr != nil {
+			return false
+		}
+
+		select {
+		case <-i.closeCh:
+			return false
+		default:
+		}
+
+		if err := i.ctx.Err(); err != nil {
+			i.lastErr = err
+			return false
+		}
+
+		if len(i.pending) != 0 {
+			i.curEv, i.pending = i.pending[0], i.pending[1:]
+			return true
+		}
+
+		// Wait before polling again once all events have been fetched.
+		if i.caughtUp {
+			select {
+			case <-time.After(eventPollInterval):
+			case <-i.closeCh:
+				return false
+			case <-i.ctx.Done():
+				i.lastErr = i.ctx.Err()
+				return false
+			}
+		}
+
+		numRows, err := i.fetchPage()
+		if err != nil {
+			i.lastErr = mapError(err)
+			return false
+		}
+		i.caughtUp = numRows < eventPageSize
+	}
+}
+
+// fetchPage fetches the next page of events and appends them to the pending
+// list.
+func (i *eventIterator) fetchPage() (int, error) {
+	if i.dialect != dialectPostgres {
+		rows, err := i.db.QueryContext(i.ctx, i.query, i.commitTS, i.seq, eventPageSize)
+		if err != nil {
+			return 0, err
+		}
+		return i.scanPage(rows)
+	}
+
+	tx, err := i.db.BeginTx(i.ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// The page is read by a statement that starts once the lock is held,
+	// so it observes the events of every writer that held the lock before.
+	if _, err = tx.ExecContext(i.ctx, pgWatchEventsQuery, pgEventsLockID); err != nil {
+		return 0, err
+	}
+	rows, err := tx.QueryContext(i.ctx, pgEventsAfterQuery, i.seq, i.since, eventPageSize)
+	if err != nil {
+		return 0, err
+	}
+	numRows, err := i.scanPage(rows)
+	if err != nil {
+		return numRows, err
+	}
+	return numRows, tx.Commit()
+}
+
+// scanPage consumes and closes a page of events and appends them to the
+// pending list.
+func (i *eventIterator) scanPage(rows *sql.Rows) (int, error) {
+	defer func() { _ = rows.Close() }()
+
+	var numRows int
+	for rows.Next() {
+		var (
+			seq      int64
+			evType   int
+			payload  []byte
+			ts       time.Time
+			commitTS string
+			err      error
+		)
+		if i.dialect == dialectPostgres {
+			err = rows.Scan(&seq, &evType, &payload, &ts)
+		} else if err = rows.Scan(&seq, &evType, &payload, &commitTS); err == nil {
+			ts, err = parseCommitTS(commitTS)
+		}
+		if err != nil {
+			return numRows, err
+		}
+		numRows++
+
+		ev, err := decodeEvent(graph.EventType(evType), payload)
+		if err != nil {
+			return numRows, err
+		}
+		ev.Timestamp = ts.UTC()
+
+		i.commitTS, i.seq = commitTS, seq
+		i.pending = append(i.pending, ev)
+	}
+	return numRows, rows.Err()
+}
+
+// commitTSAfter returns the lowest CockroachDB commit timestamp whose wall
+// time is later than t. Commit timestamps are decimals whose integer part is
+// the wall time in nanoseconds.
+func commitTSAfter(t time.Time) string {
+	if t.Before(time.Unix(0, 0)) {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano()+1, 10)
+}
+
+// parseCommitTS returns the wall time of a CockroachDB commit timestamp.
+func parseCommitTS(commitTS string) (time.Time, error) {
+	wallTime := commitTS
+	if dot := strings.IndexByte(commitTS, '.'); dot != -1 {
+		wallTime = commitTS[:dot]
+	}
+	nanos, err := strconv.ParseInt(wallTime, 10, 64)
+	if err != nil {
+		return time.Time{}, xerrors.Errorf("invalid commit timestamp %q", commitTS)
+	}
+	return time.Unix(0, nanos), nil
+}
+
+// decodeEvent unmarshals the payload of an event with the specified type.
+func decodeEvent(evType graph.EventType, payload []byte) (*graph.Event, error) {
+	ev := &graph.Event{Type: evType}
+	switch evType {
+	case graph.LinkUpserted, graph.LinkRemoved:
+		ev.Link = new(graph.Link)
+		if err := json.Unmarshal(payload, ev.Link); err != nil {
+			return nil, err
+		}
+	case graph.EdgeUpserted, graph.EdgeRemoved:
+		ev.Edge = new(graph.Edge)
+		if err := json.Unmarshal(payload, ev.Edge); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, xerrors.Errorf("unknown event type %d", evType)
+	}
+	return ev, nil
+}
+
+// Event implements graph.EventIterator.
+func (i *eventIterator) Event() *graph.Event {
+	return i.curEv
+}
+
+// Error implements graph.EventIterator.
+func (i *eventIterator) Error() error {
+	return i.lastErr
+}
+
+// Close implements graph.EventIterator.
+func (i *eventIterator) Close() error {
+	i.closeOnce.Do(func() { close(i.closeCh) })
+	return nil
+}
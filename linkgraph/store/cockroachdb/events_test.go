@@ -0,0 +1,49 @@
+package cdb
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(CommitTSTestSuite))
+
+type CommitTSTestSuite struct{}
+
+func (s *CommitTSTestSuite) TestParseCommitTS(c *gc.C) {
+	specs := []struct {
+		descr    string
+		commitTS string
+		exp      time.Time
+		expErr   string
+	}{
+		{descr: "with logical component", commitTS: "1616161616161616161.0000000001", exp: time.Unix(0, 1616161616161616161)},
+		{descr: "without logical component", commitTS: "1616161616161616161", exp: time.Unix(0, 1616161616161616161)},
+		{descr: "zero", commitTS: "0", exp: time.Unix(0, 0)},
+		{descr: "malformed", commitTS: "bogus", expErr: `invalid commit timestamp "bogus"`},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.descr)
+		got, err := parseCommitTS(spec.commitTS)
+		if spec.expErr != "" {
+			c.Assert(err, gc.ErrorMatches, spec.expErr)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(got.Equal(spec.exp), gc.Equals, true, gc.Commentf("got %v", got))
+	}
+}
+
+func (s *CommitTSTestSuite) TestCommitTSAfter(c *gc.C) {
+	// Events whose commit timestamp shares the wall time of the provided
+	// timestamp must be excluded, regardless of their logical component.
+	ts := time.Unix(0, 1616161616161616161)
+	c.Assert(commitTSAfter(ts), gc.Equals, "1616161616161616162")
+	got, err := parseCommitTS(commitTSAfter(ts))
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.After(ts), gc.Equals, true)
+
+	// Timestamps before the epoch include all events.
+	c.Assert(commitTSAfter(time.Time{}), gc.Equals, "0")
+}
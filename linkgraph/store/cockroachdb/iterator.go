@@ -1,6 +1,7 @@
 package cdb
 
 import (
+	"context"
 	"database/sql"
 	"github.com/kyteproject/search-engine/linkgraph/graph"
 	"golang.org/x/xerrors"
@@ -8,23 +9,40 @@ import (
 
 // linkIterator is a graph.LinkIterator implementation for the cdb graph.
 type linkIterator struct {
+	ctx         context.Context
 	rows        *sql.Rows
 	lastErr     error
 	latchedLink *graph.Link
+	startCursor graph.Cursor
+
+	// tx, if set, is the transaction the rows belong to; it is rolled
+	// back when the iterator is closed.
+	tx *sql.Tx
 }
 
 // Next implements graph.LinkIterator.
 func (i *linkIterator) Next() bool {
-	if i.lastErr != nil || !i.rows.Next() {
+	if i.lastErr != nil {
 		return false
 	}
 
-	l := new(graph.Link)
-	i.lastErr = i.rows.Scan(&l.ID, &l.URL, &l.RetrievedAt)
-	if i.lastErr != nil {
+	// The database driver closes the rows asynchronously when the
+	// context is cancelled so check it explicitly.
+	if err := i.ctx.Err(); err != nil {
+		i.lastErr = err
+		return false
+	}
+
+	if !i.rows.Next() {
+		i.lastErr = mapError(i.rows.Err())
+		return false
+	}
+
+	l, err := scanLink(i.rows)
+	if err != nil {
+		i.lastErr = mapError(err)
 		return false
 	}
-	l.RetrievedAt = l.RetrievedAt.UTC()
 
 	i.latchedLink = l
 	return true
@@ -38,6 +56,11 @@ func (i *linkIterator) Error() error {
 // Close implements graph.LinkIterator.
 func (i *linkIterator) Close() error {
 	err := i.rows.Close()
+	if i.tx != nil {
+		// The transaction is read-only and may have already been rolled
+		// back if the iterator context was cancelled.
+		_ = i.tx.Rollback()
+	}
 	if err != nil {
 		return xerrors.Errorf("link iterator: %w", err)
 	}
@@ -49,25 +72,50 @@ func (i *linkIterator) Link() *graph.Link {
 	return i.latchedLink
 }
 
+// Cursor implements graph.LinkIterator.
+func (i *linkIterator) Cursor() graph.Cursor {
+	if i.latchedLink == nil {
+		return i.startCursor
+	}
+	return graph.NewCursor(i.latchedLink.ID)
+}
+
 // edgeIterator is a graph.EdgeIterator implementation for the cdb graph.
 type edgeIterator struct {
+	ctx         context.Context
 	rows        *sql.Rows
 	lastErr     error
 	latchedEdge *graph.Edge
+	startCursor graph.Cursor
+
+	// tx, if set, is the transaction the rows belong to; it is rolled
+	// back when the iterator is closed.
+	tx *sql.Tx
 }
 
 // Next implements graph.EdgeIterator.
 func (i *edgeIterator) Next() bool {
-	if i.lastErr != nil || !i.rows.Next() {
+	if i.lastErr != nil {
 		return false
 	}
 
-	e := new(graph.Edge)
-	i.lastErr = i.rows.Scan(&e.ID, &e.Source, &e.Destination, &e.UpdatedAt)
-	if i.lastErr != nil {
+	// The database driver closes the rows asynchronously when the
+	// context is cancelled so check it explicitly.
+	if err := i.ctx.Err(); err != nil {
+		i.lastErr = err
+		return false
+	}
+
+	if !i.rows.Next() {
+		i.lastErr = mapError(i.rows.Err())
+		return false
+	}
+
+	e, err := scanEdge(i.rows)
+	if err != nil {
+		i.lastErr = mapError(err)
 		return false
 	}
-	e.UpdatedAt = e.UpdatedAt.UTC()
 
 	i.latchedEdge = e
 	return true
@@ -81,6 +129,11 @@ func (i *edgeIterator) Error() error {
 // Close implements graph.EdgeIterator.
 func (i *edgeIterator) Close() error {
 	err := i.rows.Close()
+	if i.tx != nil {
+		// The transaction is read-only and may have already been rolled
+		// back if the iterator context was cancelled.
+		_ = i.tx.Rollback()
+	}
 	if err != nil {
 		return xerrors.Errorf("edge iterator: %w", err)
 	}
@@ -90,4 +143,12 @@ func (i *edgeIterator) Close() error {
 // Edge implements graph.EdgeIterator.
 func (i *edgeIterator) Edge() *graph.Edge {
 	return i.latchedEdge
-}
\ No newline at end of file
+}
+
+// Cursor implements graph.EdgeIterator.
+func (i *edgeIterator) Cursor() graph.Cursor {
+	if i.latchedEdge == nil {
+		return i.startCursor
+	}
+	return graph.NewCursor(i.latchedEdge.ID)
+}
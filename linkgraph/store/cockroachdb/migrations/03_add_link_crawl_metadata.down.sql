@@ -0,0 +1,6 @@
+ALTER TABLE links
+    DROP COLUMN IF EXISTS etag,
+    DROP COLUMN IF EXISTS last_modified,
+    DROP COLUMN IF EXISTS content_hash,
+    DROP COLUMN IF EXISTS http_status,
+    DROP COLUMN IF EXISTS failure_count;
\ No newline at end of file
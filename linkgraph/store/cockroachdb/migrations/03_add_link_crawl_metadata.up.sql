@@ -0,0 +1,6 @@
+ALTER TABLE links
+    ADD COLUMN IF NOT EXISTS etag STRING NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS last_modified TIMESTAMP NOT NULL DEFAULT '0001-01-01 00:00:00',
+    ADD COLUMN IF NOT EXISTS content_hash STRING NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS http_status INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS failure_count INT NOT NULL DEFAULT 0;
\ No newline at end of file
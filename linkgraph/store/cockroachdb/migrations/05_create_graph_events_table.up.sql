@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS graph_events (
+    seq INT PRIMARY KEY DEFAULT unique_rowid(),
+    event_type INT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    INDEX graph_events_created_at_idx (created_at, seq)
+);
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE edges
+    ADD COLUMN IF NOT EXISTS anchor_text STRING NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS nofollow BOOL NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS sponsored BOOL NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS ugc BOOL NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS weight FLOAT NOT NULL DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE links
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS next_crawl_at;
\ No newline at end of file
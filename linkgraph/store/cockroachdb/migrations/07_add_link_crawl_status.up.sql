@@ -0,0 +1,3 @@
+ALTER TABLE links
+    ADD COLUMN IF NOT EXISTS status INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS next_crawl_at TIMESTAMP NOT NULL DEFAULT '0001-01-01 00:00:00';
\ No newline at end of file
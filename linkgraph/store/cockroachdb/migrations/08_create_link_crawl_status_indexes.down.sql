@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS links@links_next_crawl_at_idx;
+DROP INDEX IF EXISTS links@links_status_idx;
\ No newline at end of file
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS links_next_crawl_at_idx ON links (next_crawl_at, id) STORING (status);
+CREATE INDEX IF NOT EXISTS links_status_idx ON links (status);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS link_aliases;
\ No newline at end of file
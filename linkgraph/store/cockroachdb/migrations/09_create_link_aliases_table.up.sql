@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS link_aliases (
+    alias UUID PRIMARY KEY REFERENCES links(id) ON DELETE CASCADE,
+    canonical UUID NOT NULL REFERENCES links(id) ON DELETE CASCADE,
+    INDEX link_aliases_canonical_idx (canonical)
+);
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS graph_events@graph_events_commit_ts_idx;
+ALTER TABLE graph_events DROP COLUMN IF EXISTS commit_ts;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE graph_events ADD COLUMN IF NOT EXISTS commit_ts DECIMAL NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS graph_events_commit_ts_idx ON graph_events (commit_ts, seq);
\ No newline at end of file
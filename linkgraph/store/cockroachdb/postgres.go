@@ -0,0 +1,173 @@
+package cdb
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/lib/pq"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+// dialect identifies the flavour of SQL database that a CockroachDBGraph
+// talks to. Apart from snapshots, all queries are shared by both dialects.
+type dialect uint8
+
+const (
+	dialectCockroachDB dialect = iota
+	dialectPostgres
+)
+
+var (
+	exportSnapshotQuery = "SELECT pg_export_snapshot()"
+	setSnapshotQuery    = "SET TRANSACTION SNAPSHOT "
+
+	// snapshotTxOptions are used both for the transaction that exports a
+	// snapshot and the transactions that import it.
+	snapshotTxOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+)
+
+// NewPostgresGraph returns a new CockroachDBGraph instance that connects to a
+// stock PostgreSQL (11 or newer) server via the provided dsn. The database
+// schema must be created with the migrations in the postgres_migrations
+// folder. The graph uses the default Config settings.
+func NewPostgresGraph(dsn string) (*CockroachDBGraph, error) {
+	return NewPostgresGraphWithConfig(dsn, Config{})
+}
+
+// NewPostgresGraphWithConfig behaves like NewPostgresGraph but applies the
+// provided settings.
+func NewPostgresGraphWithConfig(dsn string, cfg Config) (*CockroachDBGraph, error) {
+	return newGraph(dsn, dialectPostgres, cfg)
+}
+
+// pgSnapshot is a graph.Snapshot implementation for PostgreSQL. It keeps open
+// a transaction that exports its snapshot; each query runs in a separate
+// transaction that imports the exported snapshot so that concurrent
+// iterators do not have to share a connection.
+type pgSnapshot struct {
+	db *sql.DB
+
+	// tx exports the snapshot identified by id and must remain open for
+	// as long as the snapshot is in use.
+	tx *sql.Tx
+	id string
+}
+
+// pgSnapshot returns a point-in-time view of a PostgreSQL-backed graph.
+func (c *CockroachDBGraph) pgSnapshot(ctx context.Context) (graph.Snapshot, error) {
+	// The exporting transaction outlives ctx so it must not be bound to it.
+	tx, err := c.db.BeginTx(context.Background(), snapshotTxOptions)
+	if err != nil {
+		return nil, xerrors.Errorf("snapshot: %w", mapError(err))
+	}
+
+	var id string
+	if err = tx.QueryRowContext(ctx, exportSnapshotQuery).Scan(&id); err != nil {
+		_ = tx.Rollback()
+		return nil, xerrors.Errorf("snapshot: %w", mapError(err))
+	}
+	return &pgSnapshot{db: c.db, tx: tx, id: id}, nil
+}
+
+// begin starts a read-only transaction that observes the snapshot.
+func (s *pgSnapshot) begin(ctx context.Context) (*sql.Tx, error) {
+	tx, err := s.db.BeginTx(ctx, snapshotTxOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// SET TRANSACTION does not accept placeholders.
+	if _, err = tx.ExecContext(ctx, setSnapshotQuery+pq.QuoteLiteral(s.id)); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// FindLink implements graph.Snapshot.
+func (s *pgSnapshot) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("find link: %w", mapError(err))
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	link, err := scanLink(tx.QueryRowContext(ctx, findLinkQuery, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, xerrors.Errorf("find link: %w", graph.ErrNotFound)
+		}
+		return nil, xerrors.Errorf("find link: %w", mapError(err))
+	}
+	return link, nil
+}
+
+// Links implements graph.Snapshot.
+func (s *pgSnapshot) Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (graph.LinkIterator, error) {
+	return s.LinksAfter(ctx, fromID, toID, retrievedBefore, "")
+}
+
+// LinksAfter implements graph.Snapshot.
+func (s *pgSnapshot) LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, xerrors.Errorf("links: %w", mapError(err))
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("links: %w", mapError(err))
+	}
+
+	var rows *sql.Rows
+	if after == "" {
+		rows, err = tx.QueryContext(ctx, linksInPartitionQuery, fromID, toID, retrievedBefore.UTC())
+	} else {
+		rows, err = tx.QueryContext(ctx, linksInPartitionAfterQuery, fromID, toID, retrievedBefore.UTC(), afterID)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, xerrors.Errorf("links: %w", mapError(err))
+	}
+	return &linkIterator{ctx: ctx, rows: rows, startCursor: after, tx: tx}, nil
+}
+
+// Edges implements graph.Snapshot.
+func (s *pgSnapshot) Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return s.EdgesAfter(ctx, fromID, toID, updatedBefore, "")
+}
+
+// EdgesAfter implements graph.Snapshot.
+func (s *pgSnapshot) EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (graph.EdgeIterator, error) {
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", mapError(err))
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", mapError(err))
+	}
+
+	var rows *sql.Rows
+	if after == "" {
+		rows, err = tx.QueryContext(ctx, edgesInPartitionQuery, fromID, toID, updatedBefore.UTC())
+	} else {
+		rows, err = tx.QueryContext(ctx, edgesInPartitionAfterQuery, fromID, toID, updatedBefore.UTC(), afterID)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, xerrors.Errorf("edges: %w", mapError(err))
+	}
+	return &edgeIterator{ctx: ctx, rows: rows, startCursor: after, tx: tx}, nil
+}
+
+// Close implements graph.Snapshot.
+func (s *pgSnapshot) Close() error {
+	if err := s.tx.Rollback(); err != nil {
+		return xerrors.Errorf("snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS links (
+    id UUID PRIMARY KEY,
+    url TEXT UNIQUE,
+    retrieved_at TIMESTAMPTZ,
+    etag TEXT NOT NULL DEFAULT '',
+    last_modified TIMESTAMPTZ NOT NULL DEFAULT '0001-01-01 00:00:00+00',
+    content_hash TEXT NOT NULL DEFAULT '',
+    http_status INT NOT NULL DEFAULT 0,
+    failure_count INT NOT NULL DEFAULT 0,
+    status INT NOT NULL DEFAULT 0,
+    next_crawl_at TIMESTAMPTZ NOT NULL DEFAULT '0001-01-01 00:00:00+00'
+);
+CREATE INDEX IF NOT EXISTS links_next_crawl_at_idx ON links (next_crawl_at, id) INCLUDE (status);
+CREATE INDEX IF NOT EXISTS links_status_idx ON links (status);
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS edges (
+    id UUID PRIMARY KEY,
+    src UUID NOT NULL REFERENCES links(id) ON DELETE CASCADE,
+    dst UUID NOT NULL REFERENCES links(id) ON DELETE CASCADE,
+    updated_at TIMESTAMPTZ,
+    anchor_text TEXT NOT NULL DEFAULT '',
+    nofollow BOOL NOT NULL DEFAULT false,
+    sponsored BOOL NOT NULL DEFAULT false,
+    ugc BOOL NOT NULL DEFAULT false,
+    weight DOUBLE PRECISION NOT NULL DEFAULT 0,
+    CONSTRAINT edge_links UNIQUE(src,dst)
+);
+CREATE INDEX IF NOT EXISTS edges_dst_idx ON edges (dst) INCLUDE (updated_at);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS graph_events;
\ No newline at end of file
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS graph_events (
+    seq BIGSERIAL PRIMARY KEY,
+    event_type INT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS graph_events_created_at_idx ON graph_events (created_at, seq);
\ No newline at end of file
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS link_aliases (
+    alias UUID PRIMARY KEY REFERENCES links(id) ON DELETE CASCADE,
+    canonical UUID NOT NULL REFERENCES links(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS link_aliases_canonical_idx ON link_aliases (canonical);
\ No newline at end of file
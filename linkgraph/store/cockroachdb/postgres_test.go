@@ -0,0 +1,79 @@
+package cdb
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/graph/graphtest"
+	"os"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(PostgresGraphTestSuite))
+
+type PostgresGraphTestSuite struct {
+	graphtest.SuiteBase
+	g  *CockroachDBGraph
+	db *sql.DB
+}
+
+func (s *PostgresGraphTestSuite) SetUpSuite(c *gc.C) {
+	dsn := os.Getenv("PG_DSN")
+	// dsn := "postgres://postgres@localhost:5432/linkgraph?sslmode=disable"
+	if dsn == "" {
+		c.Skip("Missing PG_DSN envvar; skipping postgres-backed graph test suite")
+	}
+
+	g, err := NewPostgresGraph(dsn)
+	c.Assert(err, gc.IsNil)
+	s.SetGraph(g)
+	s.g, s.db = g, g.db
+}
+
+func (s *PostgresGraphTestSuite) SetUpTest(c *gc.C) {
+	flushDB(c, s.db)
+}
+
+func (s *PostgresGraphTestSuite) TearDownSuite(c *gc.C) {
+	if s.db != nil {
+		flushDB(c, s.db)
+		c.Assert(s.g.Close(), gc.IsNil)
+	}
+}
+
+func (s *PostgresGraphTestSuite) TestConcurrentEventWriters(c *gc.C) {
+	ctx := context.TODO()
+	events := []*graph.Event{
+		linkEvent(graph.LinkUpserted, &graph.Link{ID: uuid.New(), URL: "https://example.com/1"}),
+		linkEvent(graph.LinkUpserted, &graph.Link{ID: uuid.New(), URL: "https://example.com/2"}),
+	}
+
+	tx1, err := s.db.BeginTx(ctx, nil)
+	c.Assert(err, gc.IsNil)
+	defer func() { _ = tx1.Rollback() }()
+	c.Assert(s.g.appendEvents(ctx, tx1, events[0]), gc.IsNil)
+
+	// A second writer must not wait for the first one to commit.
+	tx2, err := s.db.BeginTx(ctx, nil)
+	c.Assert(err, gc.IsNil)
+	defer func() { _ = tx2.Rollback() }()
+	tctx, cancelFn := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelFn()
+	c.Assert(s.g.appendEvents(tctx, tx2, events[1]), gc.IsNil)
+
+	// Commit out of order; watchers still observe the events in the order
+	// of their sequence numbers and only once both have committed.
+	c.Assert(tx2.Commit(), gc.IsNil)
+	c.Assert(tx1.Commit(), gc.IsNil)
+
+	it, err := s.g.Watch(ctx, time.Time{})
+	c.Assert(err, gc.IsNil)
+	for _, exp := range events {
+		c.Assert(it.Next(), gc.Equals, true)
+		c.Assert(it.Event().Link.URL, gc.Equals, exp.Link.URL)
+	}
+	c.Assert(it.Close(), gc.IsNil)
+}
@@ -0,0 +1,123 @@
+package cdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+var (
+	snapshotTimestampQuery = `SELECT cluster_logical_timestamp()::STRING`
+
+	// The following queries are templates that receive the snapshot
+	// timestamp as their first argument.
+	snapshotFindLinkQuery = "SELECT " + linkColumns + ` FROM links AS OF SYSTEM TIME '%s'
+		WHERE id=COALESCE((SELECT canonical FROM link_aliases WHERE alias=$1), $1)`
+	snapshotLinksInPartitionQuery = "SELECT " + linkColumns + ` FROM links AS OF SYSTEM TIME '%s'
+		WHERE id >= $1 AND id < $2 AND retrieved_at < $3
+		ORDER BY id`
+	snapshotLinksInPartitionAfterQuery = "SELECT " + linkColumns + ` FROM links AS OF SYSTEM TIME '%s'
+		WHERE id >= $1 AND id < $2 AND retrieved_at < $3 AND id > $4
+		ORDER BY id`
+	snapshotEdgesInPartitionQuery = "SELECT " + edgeColumns + ` FROM edges AS OF SYSTEM TIME '%s'
+		WHERE src >= $1 AND src < $2 AND updated_at < $3
+		ORDER BY id`
+	snapshotEdgesInPartitionAfterQuery = "SELECT " + edgeColumns + ` FROM edges AS OF SYSTEM TIME '%s'
+		WHERE src >= $1 AND src < $2 AND updated_at < $3 AND id > $4
+		ORDER BY id`
+)
+
+// snapshot is a graph.Snapshot implementation for the cdb graph. It relies
+// on CockroachDB's AS OF SYSTEM TIME clause to read the state of the graph
+// at the time the snapshot was taken.
+type snapshot struct {
+	db *sql.DB
+
+	// ts is the cluster logical timestamp of the snapshot.
+	ts string
+}
+
+// Snapshot returns a read-only, point-in-time view of the graph. The snapshot
+// remains valid for as long as the snapshot timestamp is within the garbage
+// collection window of the database. PostgreSQL lacks AS OF SYSTEM TIME so
+// PostgreSQL snapshots are backed by an exported transaction snapshot instead.
+func (c *CockroachDBGraph) Snapshot(ctx context.Context) (graph.Snapshot, error) {
+	if c.dialect == dialectPostgres {
+		return c.pgSnapshot(ctx)
+	}
+
+	var ts string
+	if err := c.db.QueryRowContext(ctx, snapshotTimestampQuery).Scan(&ts); err != nil {
+		return nil, xerrors.Errorf("snapshot: %w", mapError(err))
+	}
+	return &snapshot{db: c.db, ts: ts}, nil
+}
+
+// FindLink implements graph.Snapshot.
+func (s *snapshot) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	link, err := scanLink(s.db.QueryRowContext(ctx, fmt.Sprintf(snapshotFindLinkQuery, s.ts), id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, xerrors.Errorf("find link: %w", graph.ErrNotFound)
+		}
+		return nil, xerrors.Errorf("find link: %w", mapError(err))
+	}
+	return link, nil
+}
+
+// Links implements graph.Snapshot.
+func (s *snapshot) Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (graph.LinkIterator, error) {
+	return s.LinksAfter(ctx, fromID, toID, retrievedBefore, "")
+}
+
+// LinksAfter implements graph.Snapshot.
+func (s *snapshot) LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, xerrors.Errorf("links: %w", mapError(err))
+	}
+
+	var rows *sql.Rows
+	if after == "" {
+		rows, err = s.db.QueryContext(ctx, fmt.Sprintf(snapshotLinksInPartitionQuery, s.ts), fromID, toID, retrievedBefore.UTC())
+	} else {
+		rows, err = s.db.QueryContext(ctx, fmt.Sprintf(snapshotLinksInPartitionAfterQuery, s.ts), fromID, toID, retrievedBefore.UTC(), afterID)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("links: %w", mapError(err))
+	}
+	return &linkIterator{ctx: ctx, rows: rows, startCursor: after}, nil
+}
+
+// Edges implements graph.Snapshot.
+func (s *snapshot) Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return s.EdgesAfter(ctx, fromID, toID, updatedBefore, "")
+}
+
+// EdgesAfter implements graph.Snapshot.
+func (s *snapshot) EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (graph.EdgeIterator, error) {
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", mapError(err))
+	}
+
+	var rows *sql.Rows
+	if after == "" {
+		rows, err = s.db.QueryContext(ctx, fmt.Sprintf(snapshotEdgesInPartitionQuery, s.ts), fromID, toID, updatedBefore.UTC())
+	} else {
+		rows, err = s.db.QueryContext(ctx, fmt.Sprintf(snapshotEdgesInPartitionAfterQuery, s.ts), fromID, toID, updatedBefore.UTC(), afterID)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", mapError(err))
+	}
+	return &edgeIterator{ctx: ctx, rows: rows, startCursor: after}, nil
+}
+
+// Close implements graph.Snapshot.
+func (s *snapshot) Close() error {
+	return nil
+}
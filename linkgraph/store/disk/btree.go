@@ -0,0 +1,354 @@
+package disk
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"sort"
+)
+
+const (
+	// btreeDegree controls the fan-out of keyTree nodes. Each node other
+	// than the root holds between btreeDegree-1 and 2*btreeDegree-1 keys.
+	btreeDegree = 32
+
+	maxNodeKeys = 2*btreeDegree - 1
+	minNodeKeys = btreeDegree - 1
+)
+
+// treeKey orders the entries of the index. Links are keyed by their ID
+// alone while edges are keyed by the ID of their source link followed by
+// their own ID, so that the edges of a range of source links are adjacent.
+type treeKey struct {
+	major uuid.UUID
+	minor uuid.UUID
+}
+
+// less returns true if k sorts before other.
+func (k treeKey) less(other treeKey) bool {
+	if cmp := bytes.Compare(k.major[:], other.major[:]); cmp != 0 {
+		return cmp < 0
+	}
+	return bytes.Compare(k.minor[:], other.minor[:]) < 0
+}
+
+// btreeOwner identifies the tree that is allowed to modify a node in place.
+// It must not be a zero-sized type so that each allocation yields a
+// distinct pointer.
+type btreeOwner struct{ _ byte }
+
+// btreeNode is a keyTree node. The keys of a node are sorted; when the node
+// has children, children[i] holds the keys that sort between keys[i-1] and
+// keys[i].
+type btreeNode struct {
+	owner    *btreeOwner
+	keys     []treeKey
+	children []*btreeNode
+}
+
+// keyTree is an ordered set of index keys implemented as a B-tree so that
+// range scans cost O(log n + k).
+//
+// Cloning a tree is an O(1) operation as the clone shares all nodes with
+// the original tree. Nodes are copied the first time the clone modifies
+// them (copy-on-write); the original tree must not be modified once it has
+// been cloned.
+type keyTree struct {
+	root  *btreeNode
+	size  int
+	owner *btreeOwner
+}
+
+// newKeyTree returns an empty keyTree.
+func newKeyTree() *keyTree {
+	return &keyTree{owner: new(btreeOwner)}
+}
+
+// clone returns a copy of the tree that shares its nodes with t.
+func (t *keyTree) clone() *keyTree {
+	return &keyTree{root: t.root, size: t.size, owner: new(btreeOwner)}
+}
+
+// len returns the number of keys in the tree.
+func (t *keyTree) len() int {
+	return t.size
+}
+
+// has returns true if the tree contains k.
+func (t *keyTree) has(k treeKey) bool {
+	for n := t.root; n != nil; {
+		i, found := n.find(k)
+		if found {
+			return true
+		}
+		if len(n.children) == 0 {
+			return false
+		}
+		n = n.children[i]
+	}
+	return false
+}
+
+// insert adds k to the tree.
+func (t *keyTree) insert(k treeKey) {
+	if t.root == nil {
+		t.root = &btreeNode{owner: t.owner, keys: []treeKey{k}}
+		t.size = 1
+		return
+	}
+
+	// Split a full root so that insertInto never has to propagate a split
+	// back up the tree.
+	t.root = t.mutableNode(t.root)
+	if len(t.root.keys) >= maxNodeKeys {
+		key, right := t.split(t.root, maxNodeKeys/2)
+		t.root = &btreeNode{
+			owner:    t.owner,
+			keys:     []treeKey{key},
+			children: []*btreeNode{t.root, right},
+		}
+	}
+	if t.insertInto(t.root, k) {
+		t.size++
+	}
+}
+
+// remove removes k from the tree and returns true if it was present.
+func (t *keyTree) remove(k treeKey) bool {
+	if t.root == nil {
+		return false
+	}
+
+	t.root = t.mutableNode(t.root)
+	removed := t.removeFrom(t.root, k)
+	if len(t.root.keys) == 0 {
+		if len(t.root.children) != 0 {
+			t.root = t.root.children[0]
+		} else {
+			t.root = nil
+		}
+	}
+	if removed {
+		t.size--
+	}
+	return removed
+}
+
+// ascendRange invokes fn for each key that belongs to the [from, to) range
+// in ascending order until fn returns false.
+func (t *keyTree) ascendRange(from, to treeKey, fn func(treeKey) bool) {
+	if t.root != nil {
+		t.root.ascendRange(from, to, fn)
+	}
+}
+
+func (n *btreeNode) ascendRange(from, to treeKey, fn func(treeKey) bool) bool {
+	i, _ := n.find(from)
+	for ; i < len(n.keys); i++ {
+		if len(n.children) != 0 && !n.children[i].ascendRange(from, to, fn) {
+			return false
+		}
+		if !n.keys[i].less(to) || !fn(n.keys[i]) {
+			return false
+		}
+	}
+	if len(n.children) != 0 {
+		return n.children[len(n.children)-1].ascendRange(from, to, fn)
+	}
+	return true
+}
+
+// find returns the index of the first key that is not less than k and
+// whether that key equals k.
+func (n *btreeNode) find(k treeKey) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return !n.keys[i].less(k)
+	})
+	return i, i < len(n.keys) && n.keys[i] == k
+}
+
+// mutableNode returns a node that t may modify in place: either n itself,
+// if it is owned by t, or a copy of n.
+func (t *keyTree) mutableNode(n *btreeNode) *btreeNode {
+	if n.owner == t.owner {
+		return n
+	}
+
+	c := &btreeNode{
+		owner: t.owner,
+		keys:  append(make([]treeKey, 0, len(n.keys)+1), n.keys...),
+	}
+	if len(n.children) != 0 {
+		c.children = append(make([]*btreeNode, 0, len(n.children)+1), n.children...)
+	}
+	return c
+}
+
+// mutableChild replaces the i-th child of the mutable node n with a node
+// that t may modify in place and returns it.
+func (t *keyTree) mutableChild(n *btreeNode, i int) *btreeNode {
+	c := t.mutableNode(n.children[i])
+	n.children[i] = c
+	return c
+}
+
+// split moves the keys following index i of the mutable node n to a new
+// node and returns the key at index i along with the new node.
+func (t *keyTree) split(n *btreeNode, i int) (treeKey, *btreeNode) {
+	key := n.keys[i]
+	right := &btreeNode{owner: t.owner}
+	right.keys = append(make([]treeKey, 0, maxNodeKeys), n.keys[i+1:]...)
+	n.keys = n.keys[:i]
+	if len(n.children) != 0 {
+		right.children = append(make([]*btreeNode, 0, maxNodeKeys+1), n.children[i+1:]...)
+		n.children = truncateChildren(n.children, i+1)
+	}
+	return key, right
+}
+
+// insertInto inserts k into the subtree rooted at the mutable, non-full
+// node n and returns true if the tree did not already contain it.
+func (t *keyTree) insertInto(n *btreeNode, k treeKey) bool {
+	i, found := n.find(k)
+	if found {
+		return false
+	}
+	if len(n.children) == 0 {
+		n.keys = insertKey(n.keys, i, k)
+		return true
+	}
+
+	// Make sure that the child we descend into has room for one more
+	// key. Splitting it moves its median key into n, which may be the
+	// key we are looking for or require descending to the right.
+	if len(n.children[i].keys) >= maxNodeKeys {
+		key, right := t.split(t.mutableChild(n, i), maxNodeKeys/2)
+		n.keys = insertKey(n.keys, i, key)
+		n.children = insertChild(n.children, i+1, right)
+
+		switch {
+		case key == k:
+			return false
+		case key.less(k):
+			i++
+		}
+	}
+	return t.insertInto(t.mutableChild(n, i), k)
+}
+
+// removeFrom removes k from the subtree rooted at the mutable node n.
+// Children are grown before descending into them so that they never
+// underflow.
+func (t *keyTree) removeFrom(n *btreeNode, k treeKey) bool {
+	i, found := n.find(k)
+	if len(n.children) == 0 {
+		if found {
+			n.keys = removeKey(n.keys, i)
+		}
+		return found
+	}
+
+	if len(n.children[i].keys) <= minNodeKeys {
+		t.growChild(n, i)
+		return t.removeFrom(n, k)
+	}
+
+	child := t.mutableChild(n, i)
+	if found {
+		// Replace the key with its predecessor, i.e. the largest key of
+		// the left subtree.
+		n.keys[i] = t.removeMax(child)
+		return true
+	}
+	return t.removeFrom(child, k)
+}
+
+// removeMax removes and returns the largest key from the subtree rooted at
+// the mutable node n.
+func (t *keyTree) removeMax(n *btreeNode) treeKey {
+	if len(n.children) == 0 {
+		key := n.keys[len(n.keys)-1]
+		n.keys = n.keys[:len(n.keys)-1]
+		return key
+	}
+
+	i := len(n.children) - 1
+	if len(n.children[i].keys) <= minNodeKeys {
+		t.growChild(n, i)
+		return t.removeMax(n)
+	}
+	return t.removeMax(t.mutableChild(n, i))
+}
+
+// growChild ensures that the i-th child of the mutable node n holds more
+// than minNodeKeys keys by either borrowing a key from one of its siblings
+// or by merging it with a sibling.
+func (t *keyTree) growChild(n *btreeNode, i int) {
+	switch {
+	case i > 0 && len(n.children[i-1].keys) > minNodeKeys:
+		// Borrow the largest key of the left sibling.
+		child, left := t.mutableChild(n, i), t.mutableChild(n, i-1)
+		child.keys = insertKey(child.keys, 0, n.keys[i-1])
+		n.keys[i-1] = left.keys[len(left.keys)-1]
+		left.keys = left.keys[:len(left.keys)-1]
+		if len(left.children) != 0 {
+			child.children = insertChild(child.children, 0, left.children[len(left.children)-1])
+			left.children = truncateChildren(left.children, len(left.children)-1)
+		}
+	case i < len(n.keys) && len(n.children[i+1].keys) > minNodeKeys:
+		// Borrow the smallest key of the right sibling.
+		child, right := t.mutableChild(n, i), t.mutableChild(n, i+1)
+		child.keys = append(child.keys, n.keys[i])
+		n.keys[i] = right.keys[0]
+		right.keys = removeKey(right.keys, 0)
+		if len(right.children) != 0 {
+			child.children = append(child.children, right.children[0])
+			right.children = removeChild(right.children, 0)
+		}
+	default:
+		// Merge the child with its right sibling (or the left sibling
+		// into the child if it is the last one) along with the key that
+		// separates them.
+		if i >= len(n.keys) {
+			i--
+		}
+		child, right := t.mutableChild(n, i), n.children[i+1]
+		child.keys = append(child.keys, n.keys[i])
+		child.keys = append(child.keys, right.keys...)
+		child.children = append(child.children, right.children...)
+		n.keys = removeKey(n.keys, i)
+		n.children = removeChild(n.children, i+1)
+	}
+}
+
+func insertKey(keys []treeKey, i int, k treeKey) []treeKey {
+	keys = append(keys, treeKey{})
+	copy(keys[i+1:], keys[i:])
+	keys[i] = k
+	return keys
+}
+
+func removeKey(keys []treeKey, i int) []treeKey {
+	copy(keys[i:], keys[i+1:])
+	return keys[:len(keys)-1]
+}
+
+func insertChild(children []*btreeNode, i int, child *btreeNode) []*btreeNode {
+	children = append(children, nil)
+	copy(children[i+1:], children[i:])
+	children[i] = child
+	return children
+}
+
+func removeChild(children []*btreeNode, i int) []*btreeNode {
+	copy(children[i:], children[i+1:])
+	return truncateChildren(children, len(children)-1)
+}
+
+// truncateChildren shortens children to length n and clears the dropped
+// entries so that they can be garbage collected.
+func truncateChildren(children []*btreeNode, n int) []*btreeNode {
+	for i := n; i < len(children); i++ {
+		children[i] = nil
+	}
+	return children[:n]
+}
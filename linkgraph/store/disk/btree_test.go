@@ -0,0 +1,180 @@
+package disk
+
+import (
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/partition"
+	"math/rand"
+	"sort"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(KeyTreeTestSuite))
+
+type KeyTreeTestSuite struct{}
+
+func (s *KeyTreeTestSuite) TestRandomOperations(c *gc.C) {
+	var (
+		rnd  = rand.New(rand.NewSource(42))
+		tree = newKeyTree()
+		exp  = make(map[treeKey]bool)
+		keys []treeKey
+	)
+
+	// Mix inserts, duplicate inserts and removals so that nodes get split,
+	// borrow keys from their siblings and get merged.
+	for i := 0; i < 20000; i++ {
+		switch op := rnd.Intn(10); {
+		case op < 6 || len(keys) == 0:
+			k := randomKey(rnd)
+			tree.insert(k)
+			exp[k] = true
+			keys = append(keys, k)
+		case op < 7:
+			tree.insert(keys[rnd.Intn(len(keys))])
+		default:
+			j := rnd.Intn(len(keys))
+			c.Assert(tree.remove(keys[j]), gc.Equals, exp[keys[j]])
+			delete(exp, keys[j])
+			keys[j] = keys[len(keys)-1]
+			keys = keys[:len(keys)-1]
+		}
+	}
+
+	assertKeyTreeContents(c, tree, exp)
+	c.Assert(tree.remove(randomKey(rnd)), gc.Equals, false)
+
+	// Remove everything to exercise shrinking the tree down to nothing.
+	for k := range exp {
+		c.Assert(tree.remove(k), gc.Equals, true)
+		delete(exp, k)
+	}
+	assertKeyTreeContents(c, tree, exp)
+}
+
+func (s *KeyTreeTestSuite) TestAscendRange(c *gc.C) {
+	var (
+		rnd  = rand.New(rand.NewSource(42))
+		tree = newKeyTree()
+		srcs = make([]uuid.UUID, 50)
+		keys []treeKey
+	)
+	for i := range srcs {
+		srcs[i] = randomKey(rnd).major
+	}
+	for i := 0; i < 5000; i++ {
+		k := treeKey{major: srcs[rnd.Intn(len(srcs))], minor: randomKey(rnd).minor}
+		tree.insert(k)
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	sort.Slice(srcs, func(i, j int) bool { return uuidLess(srcs[i], srcs[j]) })
+
+	// Ranges of major IDs yield all the keys that share them.
+	var got []treeKey
+	tree.ascendRange(treeKey{major: srcs[10]}, treeKey{major: srcs[20]}, func(k treeKey) bool {
+		got = append(got, k)
+		return true
+	})
+	var exp []treeKey
+	for _, k := range keys {
+		if inRange(k.major, srcs[10], srcs[20]) {
+			exp = append(exp, k)
+		}
+	}
+	c.Assert(got, gc.DeepEquals, exp)
+
+	// Ranges that start at an existing key include it; fn can stop the
+	// iteration early.
+	got = got[:0]
+	tree.ascendRange(keys[10], treeKey{major: partition.MaxUUID}, func(k treeKey) bool {
+		got = append(got, k)
+		return len(got) < 3
+	})
+	c.Assert(got, gc.DeepEquals, keys[10:13])
+}
+
+func (s *KeyTreeTestSuite) TestCloneIsolation(c *gc.C) {
+	var (
+		rnd  = rand.New(rand.NewSource(42))
+		tree = newKeyTree()
+		exp  = make(map[treeKey]bool)
+	)
+	for i := 0; i < 5000; i++ {
+		k := randomKey(rnd)
+		tree.insert(k)
+		exp[k] = true
+	}
+
+	// Modifying a clone must not affect the original tree.
+	clone := tree.clone()
+	cloneExp := make(map[treeKey]bool, len(exp))
+	var n int
+	for k := range exp {
+		if n++; n%2 == 0 {
+			c.Assert(clone.remove(k), gc.Equals, true)
+		} else {
+			cloneExp[k] = true
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		k := randomKey(rnd)
+		clone.insert(k)
+		cloneExp[k] = true
+	}
+
+	assertKeyTreeContents(c, tree, exp)
+	assertKeyTreeContents(c, clone, cloneExp)
+}
+
+func assertKeyTreeContents(c *gc.C, tree *keyTree, exp map[treeKey]bool) {
+	c.Assert(tree.len(), gc.Equals, len(exp))
+	for k := range exp {
+		c.Assert(tree.has(k), gc.Equals, true)
+	}
+
+	var (
+		prev  *treeKey
+		count int
+	)
+	tree.ascendRange(treeKey{}, treeKey{major: partition.MaxUUID}, func(k treeKey) bool {
+		if prev != nil {
+			c.Assert(prev.less(k), gc.Equals, true)
+		}
+		c.Assert(exp[k], gc.Equals, true)
+		prev = &k
+		count++
+		return true
+	})
+	c.Assert(count, gc.Equals, len(exp))
+
+	if tree.root != nil {
+		assertNodeInvariants(c, tree.root, true)
+	}
+}
+
+// assertNodeInvariants checks the key counts of the subtree rooted at n and
+// returns its height. All leaves of a B-tree must be at the same depth.
+func assertNodeInvariants(c *gc.C, n *btreeNode, isRoot bool) int {
+	c.Assert(len(n.keys) <= maxNodeKeys, gc.Equals, true)
+	if !isRoot {
+		c.Assert(len(n.keys) >= minNodeKeys, gc.Equals, true)
+	}
+	if len(n.children) == 0 {
+		return 1
+	}
+
+	c.Assert(n.children, gc.HasLen, len(n.keys)+1)
+	height := assertNodeInvariants(c, n.children[0], false)
+	for _, child := range n.children[1:] {
+		c.Assert(assertNodeInvariants(c, child, false), gc.Equals, height)
+	}
+	return height + 1
+}
+
+func randomKey(rnd *rand.Rand) treeKey {
+	var k treeKey
+	_, _ = rnd.Read(k.major[:])
+	_, _ = rnd.Read(k.minor[:])
+	return k
+}
@@ -0,0 +1,1011 @@
+package disk
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/store/internal/graphlog"
+	"golang.org/x/xerrors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Compile-time check for ensuring DiskGraph implements graph.Graph.
+var _ graph.Graph = (*DiskGraph)(nil)
+
+var (
+	// ErrCorruptLog is returned when the log contains intact records that
+	// cannot be interpreted or replayed.
+	ErrCorruptLog = graphlog.ErrCorrupt
+
+	// ErrClosed is returned when attempting to mutate a closed graph.
+	ErrClosed = xerrors.New("graph is closed")
+)
+
+const (
+	logFileName     = "graph.log"
+	compactFileName = "graph.log.compact"
+	indexFileName   = "graph.idx"
+)
+
+// SyncPolicy controls when the log is flushed to stable storage.
+type SyncPolicy uint8
+
+const (
+	// SyncAlways flushes the log before each mutation returns.
+	SyncAlways SyncPolicy = iota
+
+	// SyncPeriodically flushes the log every Config.SyncInterval. If the
+	// host crashes, the mutations of the last interval may be lost.
+	SyncPeriodically
+
+	// SyncNever leaves flushing the log to the operating system.
+	SyncNever
+)
+
+// Config encapsulates the settings for a DiskGraph.
+type Config struct {
+	// SyncPolicy controls when the log is flushed to stable storage.
+	// Defaults to SyncAlways.
+	SyncPolicy SyncPolicy
+
+	// SyncInterval is the flush interval used by SyncPeriodically.
+	// Defaults to 1s.
+	SyncInterval time.Duration
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = time.Second
+	}
+}
+
+// RecoveryStats describes the work performed while opening a DiskGraph.
+type RecoveryStats struct {
+	// IndexLoaded is set if the index was loaded from the index file that
+	// was written when the graph was last closed. Otherwise, the index
+	// was rebuilt by replaying the log.
+	IndexLoaded bool
+
+	// The number of records that were replayed from the log.
+	Records int
+
+	// The number of bytes that were truncated from the end of the log
+	// because they belonged to records that were not completely written.
+	TruncatedBytes int64
+}
+
+// DiskGraph implements a graph that persists links and edges to a local
+// directory.
+//
+// Each mutation is appended to a log as a checksummed record that captures
+// the mutation outcome. An index maps the ID of each link and edge to the
+// offset of the record holding its current state; apart from the offsets,
+// it only keeps the attributes that queries filter by so that link and
+// edge data is read from the log on demand. Compact rewrites the log so
+// that it only contains the records needed to rebuild the current state.
+//
+// Close persists the index to a separate file which is loaded the next time
+// the graph is opened. If the graph was not closed cleanly, e.g. due to a
+// crash, the index is rebuilt by replaying the log; a partially written
+// record at the end of the log is discarded.
+//
+// Mutation events are not persisted: Watch only reports mutations that
+// occur after the graph was opened. A directory must not be opened by
+// more than one DiskGraph at a time.
+type DiskGraph struct {
+	dir      string
+	cfg      Config
+	recovery RecoveryStats
+
+	// mu serializes mutations so that records are appended to the log in
+	// the order they were applied to the index.
+	mu     sync.RWMutex
+	idx    *index
+	log    *logFile
+	size   int64
+	buf    []byte
+	dirty  bool
+	closed bool
+
+	// shared is set when idx is referenced by a snapshot.
+	shared bool
+
+	// failErr is set when the log could not be written or flushed. Once
+	// set, the graph rejects any further mutations as the log can no
+	// longer be trusted to reflect the index.
+	failErr error
+
+	// events holds the most recent mutation events and eventBase the
+	// sequence number of the first retained event. eventsCh is closed and
+	// replaced each time a new event gets published to wake up watchers.
+	events    []*graph.Event
+	eventBase uint64
+	eventsCh  chan struct{}
+
+	// compactMu prevents concurrent compactions.
+	compactMu sync.Mutex
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewDiskGraph opens the graph stored in dir, creating the directory if it
+// does not exist, and loads or rebuilds its index.
+func NewDiskGraph(dir string, cfg Config) (*DiskGraph, error) {
+	cfg.setDefaults()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, xerrors.Errorf("open disk graph: %w", err)
+	}
+
+	// A leftover compaction output means that the process crashed before
+	// the compaction completed; the original log is still intact. The
+	// same holds for partially written index files.
+	for _, name := range []string{compactFileName, indexFileName + ".tmp"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return nil, xerrors.Errorf("open disk graph: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, xerrors.Errorf("open disk graph: %w", err)
+	}
+
+	g := &DiskGraph{
+		dir:      dir,
+		cfg:      cfg,
+		log:      newLogFile(f),
+		eventsCh: make(chan struct{}),
+		stopCh:   make(chan struct{}),
+	}
+	if err := g.recover(); err != nil {
+		_ = f.Close()
+		return nil, xerrors.Errorf("open disk graph: %w", err)
+	}
+
+	if cfg.SyncPolicy == SyncPeriodically {
+		g.wg.Add(1)
+		go g.syncPeriodically()
+	}
+	return g, nil
+}
+
+// recover initializes an empty log or loads the index of an existing one.
+// If the index file is missing or does not match the log, the index is
+// rebuilt by replaying the log and any torn records at its end are
+// truncated.
+func (g *DiskGraph) recover() error {
+	info, err := g.log.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		if _, err = g.log.WriteAt(graphlog.Header(), 0); err != nil {
+			return err
+		}
+		if err = g.log.Sync(); err != nil {
+			return err
+		}
+		g.idx, g.size = newIndex(), graphlog.HeaderSize
+		return syncDir(g.dir)
+	}
+
+	// The header is validated even if the index file can be used.
+	if _, err = graphlog.NewReader(io.NewSectionReader(g.log, 0, graphlog.HeaderSize)); err != nil {
+		return err
+	}
+
+	indexPath := filepath.Join(g.dir, indexFileName)
+	idx, err := readIndexFile(indexPath, info.Size())
+	switch {
+	case err == nil:
+		g.idx, g.size = idx, info.Size()
+		g.recovery.IndexLoaded = true
+	case os.IsNotExist(err) || xerrors.Is(err, errStaleIndex):
+		if err = g.replayLog(info.Size()); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	// The index file only reflects the log as it was when the graph was
+	// closed. Remove it before the log gets modified.
+	if err = os.Remove(indexPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return syncDir(g.dir)
+}
+
+// replayLog rebuilds the index by replaying the log, truncating any torn
+// records at its end.
+func (g *DiskGraph) replayLog(logSize int64) error {
+	lr, err := graphlog.NewReader(io.NewSectionReader(g.log, 0, logSize))
+	if err != nil {
+		return err
+	}
+
+	g.idx = newIndex()
+	g.recovery.Records, err = replayFrames(lr, g.idx)
+	if err == graphlog.ErrTornFrame {
+		g.recovery.TruncatedBytes = logSize - lr.Offset()
+		if err = g.log.Truncate(lr.Offset()); err != nil {
+			return err
+		}
+		if err = g.log.Sync(); err != nil {
+			return err
+		}
+	} else if err != io.EOF {
+		return err
+	}
+	g.size = lr.Offset()
+	return nil
+}
+
+// replayFrames applies the records returned by lr to idx until lr reports
+// an error. It returns the number of applied records together with the
+// error reported by lr, which is io.EOF once the end of the log is reached.
+func replayFrames(lr *graphlog.Reader, idx *index) (int, error) {
+	for n := 0; ; n++ {
+		offset := lr.Offset()
+		r, err := lr.Next()
+		if err == io.EOF || err == graphlog.ErrTornFrame {
+			return n, err
+		} else if err != nil {
+			return n, xerrors.Errorf("replay record at offset %d: %w", offset, err)
+		}
+
+		if err = idx.apply(r, offset); err != nil {
+			return n, xerrors.Errorf("replay record at offset %d: %v: %w", offset, err, ErrCorruptLog)
+		}
+	}
+}
+
+// Recovery returns statistics about the work performed when the graph was
+// opened.
+func (g *DiskGraph) Recovery() RecoveryStats {
+	return g.recovery
+}
+
+// checkWritable returns an error if the graph cannot accept mutations. The
+// caller must hold mu.
+func (g *DiskGraph) checkWritable() error {
+	if g.closed {
+		return ErrClosed
+	}
+	return g.failErr
+}
+
+// checkReadable returns an error if the graph can no longer be queried. The
+// caller must hold mu.
+func (g *DiskGraph) checkReadable() error {
+	if g.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// appendRecord appends r to the log and returns the offset of its frame.
+// The caller must hold the write lock and call commit once all records of
+// a mutation have been appended.
+func (g *DiskGraph) appendRecord(r *graphlog.Record) (int64, error) {
+	g.buf = graphlog.AppendFrame(g.buf[:0], r)
+	if _, err := g.log.WriteAt(g.buf, g.size); err != nil {
+		g.failErr = xerrors.Errorf("write log: %w", err)
+		return 0, g.failErr
+	}
+
+	offset := g.size
+	g.size += int64(len(g.buf))
+	g.dirty = true
+	return offset, nil
+}
+
+// commit flushes the records appended by a mutation according to the
+// configured sync policy. The caller must hold the write lock.
+func (g *DiskGraph) commit() error {
+	if g.cfg.SyncPolicy != SyncAlways {
+		return nil
+	}
+	return g.syncLocked()
+}
+
+// cloneIfShared replaces the index with a private copy if it is currently
+// referenced by a snapshot. The caller must hold the write lock.
+func (g *DiskGraph) cloneIfShared() {
+	if g.shared {
+		g.idx = g.idx.clone()
+		g.shared = false
+	}
+}
+
+// view returns a view of the live index. The caller must hold the read
+// lock for as long as the view is in use.
+func (g *DiskGraph) view() view {
+	return view{idx: g.idx, log: g.log}
+}
+
+// Sync flushes the log to stable storage.
+func (g *DiskGraph) Sync() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.checkWritable(); err != nil {
+		return xerrors.Errorf("sync: %w", err)
+	}
+	return g.syncLocked()
+}
+
+// syncLocked flushes the log if it contains unflushed records. The caller
+// must hold mu.
+func (g *DiskGraph) syncLocked() error {
+	if !g.dirty {
+		return nil
+	}
+	if err := g.log.Sync(); err != nil {
+		g.failErr = xerrors.Errorf("sync log: %w", err)
+		return g.failErr
+	}
+	g.dirty = false
+	return nil
+}
+
+// syncPeriodically flushes the log every SyncInterval until the graph is
+// closed.
+func (g *DiskGraph) syncPeriodically() {
+	defer g.wg.Done()
+
+	ticker := time.NewTicker(g.cfg.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.mu.Lock()
+			if g.checkWritable() == nil {
+				_ = g.syncLocked()
+			}
+			g.mu.Unlock()
+		case <-g.stopCh:
+			return
+		}
+	}
+}
+
+// Close flushes the log, persists the index and releases the underlying
+// files. Any further calls fail with ErrClosed; snapshots that are still
+// open remain readable until they are closed.
+func (g *DiskGraph) Close() error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil
+	}
+	g.closed = true
+	close(g.stopCh)
+
+	var err error
+	if g.failErr == nil {
+		if err = g.syncLocked(); err == nil {
+			// The index file only speeds up the next open; the log
+			// alone is sufficient to restore the graph.
+			_ = writeIndexFile(g.dir, g.idx, g.size)
+		}
+	}
+	if closeErr := g.log.release(); err == nil {
+		err = closeErr
+	}
+	g.mu.Unlock()
+
+	g.wg.Wait()
+	return err
+}
+
+// Compact rewrites the log so that it only contains the records required
+// to restore the current state of the graph. Mutations can proceed while
+// the bulk of the new log is written and indexed; they are only blocked
+// while the records appended in the meantime are carried over and the new
+// log is swapped in.
+func (g *DiskGraph) Compact(ctx context.Context) error {
+	g.compactMu.Lock()
+	defer g.compactMu.Unlock()
+
+	g.mu.Lock()
+	if err := g.checkWritable(); err != nil {
+		g.mu.Unlock()
+		return xerrors.Errorf("compact: %w", err)
+	}
+	snap := g.snapshotLocked()
+	snapSize := g.size
+	g.mu.Unlock()
+
+	compactPath := filepath.Join(g.dir, compactFileName)
+	f, err := os.OpenFile(compactPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		_ = snap.Close()
+		return xerrors.Errorf("compact: %w", err)
+	}
+
+	err = graphlog.WriteSnapshot(ctx, f, snap)
+	_ = snap.Close()
+	if err == nil {
+		err = g.swapLog(f, snapSize)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(compactPath)
+		return xerrors.Errorf("compact: %w", err)
+	}
+	return nil
+}
+
+// swapLog indexes the compacted log f, carries over the records that were
+// appended to the current log after offset snapSize and atomically replaces
+// the current log with f.
+func (g *DiskGraph) swapLog(f *os.File, snapSize int64) error {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	lr, err := graphlog.NewReader(io.NewSectionReader(f, 0, size))
+	if err != nil {
+		return err
+	}
+	idx := newIndex()
+	if _, err = replayFrames(lr, idx); err != io.EOF {
+		return xerrors.Errorf("index compacted log: %w", err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err = g.checkWritable(); err != nil {
+		return err
+	}
+
+	tail := io.NewSectionReader(g.log, snapSize, g.size-snapSize)
+	n, err := io.Copy(f, tail)
+	if err != nil {
+		return err
+	}
+	if _, err = replayFrames(graphlog.NewFrameReader(io.NewSectionReader(f, size, n), size), idx); err != io.EOF {
+		return xerrors.Errorf("index carried over records: %w", err)
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(f.Name(), filepath.Join(g.dir, logFileName)); err != nil {
+		return err
+	}
+	_ = g.log.release()
+	g.log, g.size, g.dirty = newLogFile(f), size+n, false
+	g.idx, g.shared = idx, false
+
+	// The new log is in place; failing to persist the rename only means
+	// that the old log may resurface after a crash, which is harmless.
+	_ = syncDir(g.dir)
+	return nil
+}
+
+// syncDir flushes the directory entries of dir to stable storage.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// UpsertLink creates a new link or updates an existing link.
+func (g *DiskGraph) UpsertLink(ctx context.Context, link *graph.Link) error {
+	if err := graph.ValidateLink(link); err != nil {
+		return xerrors.Errorf("upsert link: %w", err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.checkWritable(); err != nil {
+		return xerrors.Errorf("upsert link: %w", err)
+	}
+	if err := g.upsertLink(link); err != nil {
+		return xerrors.Errorf("upsert link: %w", err)
+	}
+	if err := g.commit(); err != nil {
+		return xerrors.Errorf("upsert link: %w", err)
+	}
+	return nil
+}
+
+// UpsertLinks creates or updates a batch of links while holding the write
+// lock and flushing the log only once.
+func (g *DiskGraph) UpsertLinks(ctx context.Context, links []*graph.Link) error {
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("upsert links: %w", err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.checkWritable(); err != nil {
+		return xerrors.Errorf("upsert links: %w", err)
+	}
+
+	var errs []error
+	for i, link := range links {
+		err := graph.ValidateLink(link)
+		if err == nil {
+			err = g.upsertLink(link)
+		}
+		if g.failErr != nil {
+			return xerrors.Errorf("upsert links: %w", g.failErr)
+		} else if err != nil {
+			if errs == nil {
+				errs = make([]error, len(links))
+			}
+			errs[i] = xerrors.Errorf("upsert links: %w", err)
+		}
+	}
+
+	if err := g.commit(); err != nil {
+		return xerrors.Errorf("upsert links: %w", err)
+	}
+	if errs != nil {
+		return &graph.BatchError{Errors: errs}
+	}
+	return nil
+}
+
+// upsertLink implements the link upsert logic. The caller must hold the
+// write lock.
+func (g *DiskGraph) upsertLink(link *graph.Link) error {
+	// Check if a link with the same URL already exists. If so, convert
+	// this into an update and point the link ID to the existing link.
+	// The crawl metadata is only replaced if the submitted link is not
+	// older than the stored one so that the most recent RetrievedAt
+	// timestamp is always retained.
+	existing, err := g.view().findLinkByURL(link.URL)
+	switch {
+	case err == nil:
+		link.ID = existing.ID
+		if link.RetrievedAt.Before(existing.RetrievedAt) {
+			*link = *existing
+			return nil
+		}
+	case !xerrors.Is(err, graph.ErrNotFound):
+		return err
+	case link.ID != uuid.Nil:
+		// Keep the ID provided by the caller.
+		if _, exists := g.idx.links[link.ID]; exists {
+			return graph.ErrLinkIDInUse
+		}
+	default:
+		for {
+			link.ID = uuid.New()
+			if _, exists := g.idx.links[link.ID]; !exists {
+				break
+			}
+		}
+	}
+
+	lCopy := new(graph.Link)
+	*lCopy = *link
+	offset, err := g.appendRecord(&graphlog.Record{Type: graphlog.LinkPut, Link: lCopy})
+	if err != nil {
+		return err
+	}
+	g.cloneIfShared()
+	g.idx.putLink(lCopy, offset)
+	g.publish(graph.LinkUpserted, lCopy, nil)
+	return nil
+}
+
+// FindLink looks up a link by its ID.
+func (g *DiskGraph) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if err := g.checkReadable(); err != nil {
+		return nil, xerrors.Errorf("find link: %w", err)
+	}
+	link, err := g.view().findLink(id)
+	if err != nil {
+		return nil, xerrors.Errorf("find link: %w", err)
+	}
+	return link, nil
+}
+
+// FindLinkByURL looks up a link by its URL.
+func (g *DiskGraph) FindLinkByURL(ctx context.Context, url string) (*graph.Link, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if err := g.checkReadable(); err != nil {
+		return nil, xerrors.Errorf("find link by URL: %w", err)
+	}
+	link, err := g.view().findLinkByURL(url)
+	if err == nil && g.idx.resolveAlias(link.ID) != link.ID {
+		link, err = g.view().findLink(link.ID)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("find link by URL: %w", err)
+	}
+	return link, nil
+}
+
+// RemoveLink removes the link with the specified ID as well as all edges
+// that originate from or point to it.
+func (g *DiskGraph) RemoveLink(ctx context.Context, id uuid.UUID) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.checkWritable(); err != nil {
+		return xerrors.Errorf("remove link: %w", err)
+	}
+	entry, exists := g.idx.links[id]
+	if !exists {
+		return xerrors.Errorf("remove link: %w", graph.ErrNotFound)
+	}
+
+	// Read the removed link and edges up front so that they can be
+	// published once the removal is logged.
+	link, err := g.log.readLink(entry.offset)
+	if err != nil {
+		return xerrors.Errorf("remove link: %w", err)
+	}
+	var edges []*graph.Edge
+	seen := make(map[uuid.UUID]bool)
+	for _, list := range [][]uuid.UUID{g.idx.outEdges[id], g.idx.inEdges[id]} {
+		for _, edgeID := range list {
+			// Self-referencing edges appear in both lists.
+			if seen[edgeID] {
+				continue
+			}
+			seen[edgeID] = true
+
+			edge, err := g.log.readEdge(g.idx.edges[edgeID].offset)
+			if err != nil {
+				return xerrors.Errorf("remove link: %w", err)
+			}
+			edges = append(edges, edge)
+		}
+	}
+
+	if _, err = g.appendRecord(&graphlog.Record{Type: graphlog.LinkRemove, ID: id}); err != nil {
+		return xerrors.Errorf("remove link: %w", err)
+	}
+	g.cloneIfShared()
+	g.idx.removeLink(id)
+	for _, edge := range edges {
+		g.publish(graph.EdgeRemoved, nil, edge)
+	}
+	g.publish(graph.LinkRemoved, link, nil)
+
+	if err = g.commit(); err != nil {
+		return xerrors.Errorf("remove link: %w", err)
+	}
+	return nil
+}
+
+// AddAlias records that the link with aliasID refers to the same document as
+// the link with canonicalID.
+func (g *DiskGraph) AddAlias(ctx context.Context, aliasID, canonicalID uuid.UUID) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.checkWritable(); err != nil {
+		return xerrors.Errorf("add alias: %w", err)
+	}
+	_, aliasExists := g.idx.links[aliasID]
+	_, canonicalExists := g.idx.links[canonicalID]
+	if !aliasExists || !canonicalExists {
+		return xerrors.Errorf("add alias: %w", graph.ErrNotFound)
+	}
+
+	// Flatten alias chains by pointing the alias to the link at the end
+	// of the chain.
+	canonicalID = g.idx.resolveAlias(canonicalID)
+	if canonicalID == aliasID {
+		return xerrors.Errorf("add alias: %w", graph.ErrAliasCycle)
+	}
+
+	if _, err := g.appendRecord(&graphlog.Record{Type: graphlog.AliasPut, ID: aliasID, CanonicalID: canonicalID}); err != nil {
+		return xerrors.Errorf("add alias: %w", err)
+	}
+	g.cloneIfShared()
+	g.idx.putAlias(aliasID, canonicalID)
+
+	if err := g.commit(); err != nil {
+		return xerrors.Errorf("add alias: %w", err)
+	}
+	return nil
+}
+
+// Links returns an iterator for the set of links whose IDs belong to the
+// [fromID, toID) range and were retrieved before the provided timestamp.
+func (g *DiskGraph) Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (graph.LinkIterator, error) {
+	return g.LinksAfter(ctx, fromID, toID, retrievedBefore, "")
+}
+
+// LinksAfter returns an iterator for the set of links whose IDs belong to
+// the [fromID, toID) range, were retrieved before the provided timestamp and
+// are positioned after the provided cursor.
+func (g *DiskGraph) LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("links: %w", err)
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if err := g.checkReadable(); err != nil {
+		return nil, xerrors.Errorf("links: %w", err)
+	}
+	it, err := g.view().linksAfter(ctx, fromID, toID, retrievedBefore, after)
+	if err != nil {
+		return nil, xerrors.Errorf("links: %w", err)
+	}
+	return it, nil
+}
+
+// LinksDueForCrawl returns an iterator for the set of links whose IDs belong
+// to the [fromID, toID) range, can be crawled and are scheduled to be crawled
+// before the provided timestamp.
+func (g *DiskGraph) LinksDueForCrawl(ctx context.Context, fromID, toID uuid.UUID, dueBefore time.Time) (graph.LinkIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("links due for crawl: %w", err)
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if err := g.checkReadable(); err != nil {
+		return nil, xerrors.Errorf("links due for crawl: %w", err)
+	}
+	it, err := g.view().linksDueForCrawl(ctx, fromID, toID, dueBefore)
+	if err != nil {
+		return nil, xerrors.Errorf("links due for crawl: %w", err)
+	}
+	return it, nil
+}
+
+// UpsertEdge creates a new edge or updates an existing edge.
+func (g *DiskGraph) UpsertEdge(ctx context.Context, edge *graph.Edge) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.checkWritable(); err != nil {
+		return xerrors.Errorf("upsert edge: %w", err)
+	}
+	if err := g.upsertEdge(edge); err != nil {
+		return xerrors.Errorf("upsert edge: %w", err)
+	}
+	if err := g.commit(); err != nil {
+		return xerrors.Errorf("upsert edge: %w", err)
+	}
+	return nil
+}
+
+// UpsertEdges creates or updates a batch of edges while holding the write
+// lock and flushing the log only once.
+func (g *DiskGraph) UpsertEdges(ctx context.Context, edges []*graph.Edge) error {
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("upsert edges: %w", err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.checkWritable(); err != nil {
+		return xerrors.Errorf("upsert edges: %w", err)
+	}
+
+	var errs []error
+	for i, edge := range edges {
+		err := g.upsertEdge(edge)
+		if g.failErr != nil {
+			return xerrors.Errorf("upsert edges: %w", g.failErr)
+		} else if err != nil {
+			if errs == nil {
+				errs = make([]error, len(edges))
+			}
+			errs[i] = xerrors.Errorf("upsert edges: %w", err)
+		}
+	}
+
+	if err := g.commit(); err != nil {
+		return xerrors.Errorf("upsert edges: %w", err)
+	}
+	if errs != nil {
+		return &graph.BatchError{Errors: errs}
+	}
+	return nil
+}
+
+// upsertEdge implements the edge upsert logic. The caller must hold the
+// write lock.
+func (g *DiskGraph) upsertEdge(edge *graph.Edge) error {
+	// Verify source and destination links exist
+	_, sourceExists := g.idx.links[edge.Source]
+	_, destinationExists := g.idx.links[edge.Destination]
+	if !sourceExists || !destinationExists {
+		return graph.ErrUnknownEdgeLinks
+	}
+
+	// Update the existing edge between the two links or insert a new one.
+	eCopy := new(graph.Edge)
+	*eCopy = *edge
+	if existingID, exists := g.idx.findEdge(edge.Source, edge.Destination); exists {
+		eCopy.ID = existingID
+	} else if eCopy.ID != uuid.Nil {
+		if _, exists := g.idx.edges[eCopy.ID]; exists {
+			return graph.ErrEdgeIDInUse
+		}
+	} else {
+		for {
+			eCopy.ID = uuid.New()
+			if _, exists := g.idx.edges[eCopy.ID]; !exists {
+				break
+			}
+		}
+	}
+	// Use UTC as that is the location of the timestamps read from the log.
+	eCopy.UpdatedAt = time.Now().UTC()
+
+	offset, err := g.appendRecord(&graphlog.Record{Type: graphlog.EdgePut, Edge: eCopy})
+	if err != nil {
+		return err
+	}
+	g.cloneIfShared()
+	g.idx.putEdge(eCopy, offset)
+	*edge = *eCopy
+	g.publish(graph.EdgeUpserted, nil, eCopy)
+	return nil
+}
+
+// Edges returns an iterator for the set of edges whose source vertex IDs
+// belong to the [fromID, toID) range and were updated before the provided
+// timestamp.
+func (g *DiskGraph) Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return g.EdgesAfter(ctx, fromID, toID, updatedBefore, "")
+}
+
+// EdgesAfter returns an iterator for the set of edges whose source vertex
+// IDs belong to the [fromID, toID) range, were updated before the provided
+// timestamp and are positioned after the provided cursor.
+func (g *DiskGraph) EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (graph.EdgeIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if err := g.checkReadable(); err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
+	it, err := g.view().edgesAfter(ctx, fromID, toID, updatedBefore, after)
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
+	return it, nil
+}
+
+// InEdges returns an iterator for the set of edges that point to the
+// specified destination link and were updated before the provided timestamp.
+func (g *DiskGraph) InEdges(ctx context.Context, dstID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("in edges: %w", err)
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if err := g.checkReadable(); err != nil {
+		return nil, xerrors.Errorf("in edges: %w", err)
+	}
+	it, err := g.view().inEdges(ctx, dstID, updatedBefore)
+	if err != nil {
+		return nil, xerrors.Errorf("in edges: %w", err)
+	}
+	return it, nil
+}
+
+// CanonicalEdges returns an iterator for the set of edges whose source vertex
+// IDs belong to the [fromID, toID) range and were updated before the provided
+// timestamp. Edges pointing to an alias are rewritten to point to the
+// canonical link instead.
+func (g *DiskGraph) CanonicalEdges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("canonical edges: %w", err)
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if err := g.checkReadable(); err != nil {
+		return nil, xerrors.Errorf("canonical edges: %w", err)
+	}
+	it, err := g.view().edgesAfter(ctx, fromID, toID, updatedBefore, "")
+	if err != nil {
+		return nil, xerrors.Errorf("canonical edges: %w", err)
+	}
+
+	// Copy the aliases so that the iterator observes them at the same
+	// point in time as the edges.
+	it.aliases = make(map[uuid.UUID]uuid.UUID, len(g.idx.aliases))
+	for aliasID, canonicalID := range g.idx.aliases {
+		it.aliases[aliasID] = canonicalID
+	}
+	return it, nil
+}
+
+// RemoveStaleEdges removes any edge that originates from the specified link ID
+// and was updated before the specified timestamp.
+func (g *DiskGraph) RemoveStaleEdges(ctx context.Context, fromID uuid.UUID, updatedBefore time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.checkWritable(); err != nil {
+		return xerrors.Errorf("remove stale edges: %w", err)
+	}
+
+	stale := g.idx.staleEdges(fromID, updatedBefore)
+	if len(stale) == 0 {
+		return nil
+	}
+	edges := make([]*graph.Edge, len(stale))
+	for i, edgeID := range stale {
+		edge, err := g.log.readEdge(g.idx.edges[edgeID].offset)
+		if err != nil {
+			return xerrors.Errorf("remove stale edges: %w", err)
+		}
+		edges[i] = edge
+	}
+
+	if _, err := g.appendRecord(&graphlog.Record{Type: graphlog.StaleEdgesRemove, ID: fromID, Before: updatedBefore}); err != nil {
+		return xerrors.Errorf("remove stale edges: %w", err)
+	}
+	g.cloneIfShared()
+	for _, edge := range edges {
+		g.idx.dropEdge(edge.ID)
+		g.publish(graph.EdgeRemoved, nil, edge)
+	}
+
+	if err := g.commit(); err != nil {
+		return xerrors.Errorf("remove stale edges: %w", err)
+	}
+	return nil
+}
+
+// Snapshot returns a read-only, point-in-time view of the graph. Taking a
+// snapshot is cheap as the snapshot shares the index with the graph; the
+// cost of copying it is deferred until the graph is next mutated.
+func (g *DiskGraph) Snapshot(ctx context.Context) (graph.Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("snapshot: %w", err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.checkReadable(); err != nil {
+		return nil, xerrors.Errorf("snapshot: %w", err)
+	}
+	return g.snapshotLocked(), nil
+}
+
+// snapshotLocked returns a snapshot that shares the index with the graph.
+// The caller must hold the write lock.
+func (g *DiskGraph) snapshotLocked() *snapshot {
+	g.shared = true
+	return &snapshot{view: view{idx: g.idx, log: g.log.acquire()}}
+}
@@ -0,0 +1,450 @@
+package disk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/graph/graphtest"
+	"github.com/kyteproject/search-engine/linkgraph/store/internal/graphlog"
+	"golang.org/x/xerrors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(DiskGraphTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type DiskGraphTestSuite struct {
+	graphtest.SuiteBase
+	dir string
+	g   *DiskGraph
+}
+
+func (s *DiskGraphTestSuite) SetUpTest(c *gc.C) {
+	s.dir = c.MkDir()
+	s.g = s.open(c, Config{})
+	s.SetGraph(s.g)
+}
+
+func (s *DiskGraphTestSuite) TearDownTest(c *gc.C) {
+	c.Assert(s.g.Close(), gc.IsNil)
+}
+
+func (s *DiskGraphTestSuite) TestReopenRestoresGraph(c *gc.C) {
+	ctx := context.TODO()
+	links := s.populate(c)
+
+	// Exercise every record type.
+	c.Assert(s.g.AddAlias(ctx, links[1].ID, links[2].ID), gc.IsNil)
+	c.Assert(s.g.RemoveStaleEdges(ctx, links[3].ID, time.Now().Add(time.Hour)), gc.IsNil)
+	c.Assert(s.g.RemoveLink(ctx, links[4].ID), gc.IsNil)
+	links[0].ETag = "v2"
+	c.Assert(s.g.UpsertLink(ctx, links[0]), gc.IsNil)
+
+	before := graphtest.DumpGraph(c, s.g)
+	s.reopen(c, Config{})
+	c.Assert(s.g.Recovery().IndexLoaded, gc.Equals, true)
+	c.Assert(s.g.Recovery().TruncatedBytes, gc.Equals, int64(0))
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, s.g), before)
+
+	resolved, err := s.g.FindLink(ctx, links[1].ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resolved.ID, gc.Equals, links[2].ID)
+
+	// Edge upserts after a restart must update the restored edges.
+	edge := &graph.Edge{Source: links[0].ID, Destination: links[1].ID}
+	c.Assert(s.g.UpsertEdge(ctx, edge), gc.IsNil)
+	after := graphtest.DumpGraph(c, s.g)
+	c.Assert(after.Edges, gc.HasLen, len(before.Edges))
+	after.Edges, before.Edges = nil, nil
+	graphtest.AssertSameGraph(c, after, before)
+}
+
+func (s *DiskGraphTestSuite) TestRebuildIndexFromLog(c *gc.C) {
+	specs := []struct {
+		descr  string
+		mutate func(c *gc.C, indexPath string)
+	}{
+		{
+			descr: "missing index file",
+			mutate: func(c *gc.C, indexPath string) {
+				c.Assert(os.Remove(indexPath), gc.IsNil)
+			},
+		},
+		{
+			descr: "corrupt index file",
+			mutate: func(c *gc.C, indexPath string) {
+				data, err := os.ReadFile(indexPath)
+				c.Assert(err, gc.IsNil)
+				data[len(data)/2] ^= 0xff
+				c.Assert(os.WriteFile(indexPath, data, 0644), gc.IsNil)
+			},
+		},
+		{
+			descr: "index file of an older log",
+			mutate: func(c *gc.C, indexPath string) {
+				// Append a record and restore the previous index file.
+				stale, err := os.ReadFile(indexPath)
+				c.Assert(err, gc.IsNil)
+				g, err := NewDiskGraph(filepath.Dir(indexPath), Config{})
+				c.Assert(err, gc.IsNil)
+				c.Assert(g.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com/unindexed"}), gc.IsNil)
+				c.Assert(g.Close(), gc.IsNil)
+				c.Assert(os.WriteFile(indexPath, stale, 0644), gc.IsNil)
+			},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.descr)
+
+		s.resetGraph(c)
+		links := s.populate(c)
+		c.Assert(s.g.AddAlias(context.TODO(), links[1].ID, links[2].ID), gc.IsNil)
+		c.Assert(s.g.Close(), gc.IsNil)
+
+		s.g = s.open(c, Config{})
+		c.Assert(s.g.Recovery().IndexLoaded, gc.Equals, true)
+		c.Assert(s.g.Close(), gc.IsNil)
+
+		indexPath := filepath.Join(s.dir, indexFileName)
+		_, err := os.Stat(indexPath)
+		c.Assert(err, gc.IsNil)
+		spec.mutate(c, indexPath)
+
+		s.g = s.open(c, Config{})
+		c.Assert(s.g.Recovery().IndexLoaded, gc.Equals, false)
+		c.Assert(s.g.Recovery().Records > 0, gc.Equals, true)
+
+		resolved, err := s.g.FindLink(context.TODO(), links[1].ID)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resolved.ID, gc.Equals, links[2].ID)
+
+		before := graphtest.DumpGraph(c, s.g)
+		s.reopen(c, Config{})
+		c.Assert(s.g.Recovery().IndexLoaded, gc.Equals, true)
+		graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, s.g), before)
+	}
+}
+
+func (s *DiskGraphTestSuite) TestWatchSkipsReplayedMutations(c *gc.C) {
+	s.populate(c)
+	s.reopen(c, Config{})
+
+	ctx, cancelFn := context.WithCancel(context.TODO())
+	it, err := s.g.Watch(ctx, time.Time{})
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(s.g.UpsertLink(ctx, &graph.Link{URL: "https://example.com/new"}), gc.IsNil)
+	c.Assert(it.Next(), gc.Equals, true)
+	c.Assert(it.Event().Link.URL, gc.Equals, "https://example.com/new")
+
+	cancelFn()
+	c.Assert(it.Close(), gc.IsNil)
+}
+
+func (s *DiskGraphTestSuite) TestTruncatedLogTail(c *gc.C) {
+	specs := []struct {
+		descr   string
+		corrupt func(data []byte) []byte
+	}{
+		{
+			descr:   "partially written frame header",
+			corrupt: func(data []byte) []byte { return append(data, 0x2a, 0x00) },
+		},
+		{
+			descr: "partially written payload",
+			corrupt: func(data []byte) []byte {
+				frame := graphlog.AppendFrame(nil, &graphlog.Record{Type: graphlog.LinkRemove, ID: uuid.New()})
+				return append(data, frame[:len(frame)-3]...)
+			},
+		},
+		{
+			descr: "checksum mismatch",
+			corrupt: func(data []byte) []byte {
+				data[len(data)-1] ^= 0xff
+				return data
+			},
+		},
+		{
+			descr: "zeroed tail",
+			corrupt: func(data []byte) []byte {
+				return append(data, make([]byte, 64)...)
+			},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.descr)
+
+		s.resetGraph(c)
+		s.populate(c)
+		before := graphtest.DumpGraph(c, s.g)
+
+		// Add a link whose record is at the end of the log so that the
+		// checksum spec can corrupt it.
+		c.Assert(s.g.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com/last"}), gc.IsNil)
+		c.Assert(s.g.Close(), gc.IsNil)
+
+		// A crash leaves no index file behind.
+		c.Assert(os.Remove(filepath.Join(s.dir, indexFileName)), gc.IsNil)
+
+		logPath := filepath.Join(s.dir, logFileName)
+		data, err := os.ReadFile(logPath)
+		c.Assert(err, gc.IsNil)
+		intact := len(data)
+		data = spec.corrupt(data)
+		c.Assert(os.WriteFile(logPath, data, 0644), gc.IsNil)
+
+		s.g = s.open(c, Config{})
+		c.Assert(s.g.Recovery().TruncatedBytes > 0, gc.Equals, true)
+
+		got := graphtest.DumpGraph(c, s.g)
+		if len(data) == intact {
+			// The corrupt record is dropped.
+			graphtest.AssertSameGraph(c, got, before)
+		} else {
+			c.Assert(got.Links, gc.HasLen, len(before.Links)+1)
+		}
+
+		// The graph must remain writable and the new records must not be
+		// shadowed by the discarded bytes.
+		c.Assert(s.g.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com/after"}), gc.IsNil)
+		s.reopen(c, Config{})
+		c.Assert(s.g.Recovery().TruncatedBytes, gc.Equals, int64(0))
+		_, err = s.g.FindLinkByURL(context.TODO(), "https://example.com/after")
+		c.Assert(err, gc.IsNil)
+	}
+}
+
+func (s *DiskGraphTestSuite) TestCorruptRecordInsideLog(c *gc.C) {
+	specs := []struct {
+		descr   string
+		corrupt func(frame []byte)
+	}{
+		{
+			descr:   "checksum mismatch",
+			corrupt: func(frame []byte) { frame[len(frame)-1] ^= 0xff },
+		},
+		{
+			descr:   "zero frame size",
+			corrupt: func(frame []byte) { copy(frame, make([]byte, 4)) },
+		},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.descr)
+
+		s.resetGraph(c)
+		s.populate(c)
+		c.Assert(s.g.Close(), gc.IsNil)
+		c.Assert(os.Remove(filepath.Join(s.dir, indexFileName)), gc.IsNil)
+
+		// Corrupt the first record; the records that follow it must not
+		// be discarded as if they were a torn tail.
+		logPath := filepath.Join(s.dir, logFileName)
+		data, err := os.ReadFile(logPath)
+		c.Assert(err, gc.IsNil)
+		first, err := graphlog.NewReader(bytes.NewReader(data))
+		c.Assert(err, gc.IsNil)
+		_, err = first.Next()
+		c.Assert(err, gc.IsNil)
+		spec.corrupt(data[graphlog.HeaderSize:first.Offset()])
+		c.Assert(os.WriteFile(logPath, data, 0644), gc.IsNil)
+
+		_, err = NewDiskGraph(s.dir, Config{})
+		c.Assert(xerrors.Is(err, ErrCorruptLog), gc.Equals, true, gc.Commentf("got error: %v", err))
+
+		after, err := os.ReadFile(logPath)
+		c.Assert(err, gc.IsNil)
+		c.Assert(after, gc.DeepEquals, data, gc.Commentf("log was modified"))
+
+		s.dir = c.MkDir()
+		s.g = s.open(c, Config{})
+	}
+}
+
+func (s *DiskGraphTestSuite) TestUnsupportedLogHeader(c *gc.C) {
+	c.Assert(s.g.Close(), gc.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(s.dir, logFileName), []byte("not a graph log"), 0644), gc.IsNil)
+
+	_, err := NewDiskGraph(s.dir, Config{})
+	c.Assert(xerrors.Is(err, ErrCorruptLog), gc.Equals, true, gc.Commentf("got error: %v", err))
+
+	s.dir = c.MkDir()
+	s.g = s.open(c, Config{})
+}
+
+func (s *DiskGraphTestSuite) TestCompact(c *gc.C) {
+	ctx := context.TODO()
+	links := s.populate(c)
+	c.Assert(s.g.AddAlias(ctx, links[1].ID, links[2].ID), gc.IsNil)
+
+	// Generate a long history of updates for the same link.
+	for i := 0; i < 200; i++ {
+		links[0].ETag = fmt.Sprint(i)
+		c.Assert(s.g.UpsertLink(ctx, links[0]), gc.IsNil)
+	}
+	before := graphtest.DumpGraph(c, s.g)
+	sizeBefore := s.logSize(c)
+
+	c.Assert(s.g.Compact(ctx), gc.IsNil)
+	c.Assert(s.logSize(c) < sizeBefore, gc.Equals, true)
+
+	// Mutations after the compaction must be appended to the new log.
+	c.Assert(s.g.UpsertLink(ctx, &graph.Link{URL: "https://example.com/after"}), gc.IsNil)
+	before.Links = append(before.Links, mustFindLinkByURL(c, s.g, "https://example.com/after"))
+
+	s.reopen(c, Config{})
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, s.g), before)
+
+	resolved, err := s.g.FindLink(ctx, links[1].ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resolved.ID, gc.Equals, links[2].ID)
+}
+
+func (s *DiskGraphTestSuite) TestCompactCarriesOverConcurrentMutations(c *gc.C) {
+	ctx := context.TODO()
+	s.populate(c)
+
+	// Simulate mutations that occur while the compacted log is written by
+	// appending them after the snapshot offset.
+	snap, err := s.g.Snapshot(ctx)
+	c.Assert(err, gc.IsNil)
+	snapSize := s.g.size
+	c.Assert(s.g.UpsertLink(ctx, &graph.Link{URL: "https://example.com/concurrent"}), gc.IsNil)
+	before := graphtest.DumpGraph(c, s.g)
+
+	f, err := os.Create(filepath.Join(s.dir, compactFileName))
+	c.Assert(err, gc.IsNil)
+	c.Assert(graphlog.WriteSnapshot(ctx, f, snap), gc.IsNil)
+	c.Assert(snap.Close(), gc.IsNil)
+	c.Assert(s.g.swapLog(f, snapSize), gc.IsNil)
+
+	s.reopen(c, Config{})
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, s.g), before)
+}
+
+func (s *DiskGraphTestSuite) TestStaleCompactionOutputIsDiscarded(c *gc.C) {
+	s.populate(c)
+	before := graphtest.DumpGraph(c, s.g)
+	c.Assert(s.g.Close(), gc.IsNil)
+
+	compactPath := filepath.Join(s.dir, compactFileName)
+	c.Assert(os.WriteFile(compactPath, graphlog.Header(), 0644), gc.IsNil)
+
+	s.g = s.open(c, Config{})
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, s.g), before)
+	_, err := os.Stat(compactPath)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
+
+func (s *DiskGraphTestSuite) TestSyncPolicies(c *gc.C) {
+	specs := []struct {
+		descr string
+		cfg   Config
+	}{
+		{descr: "always", cfg: Config{SyncPolicy: SyncAlways}},
+		{descr: "periodically", cfg: Config{SyncPolicy: SyncPeriodically, SyncInterval: time.Millisecond}},
+		{descr: "never", cfg: Config{SyncPolicy: SyncNever}},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.descr)
+
+		c.Assert(s.g.Close(), gc.IsNil)
+		s.dir = c.MkDir()
+		s.g = s.open(c, spec.cfg)
+		s.populate(c)
+		c.Assert(s.g.Sync(), gc.IsNil)
+
+		before := graphtest.DumpGraph(c, s.g)
+		s.reopen(c, spec.cfg)
+		graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, s.g), before)
+	}
+}
+
+func (s *DiskGraphTestSuite) TestMutationsAfterClose(c *gc.C) {
+	c.Assert(s.g.Close(), gc.IsNil)
+	c.Assert(s.g.Close(), gc.IsNil)
+
+	err := s.g.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com"})
+	c.Assert(xerrors.Is(err, ErrClosed), gc.Equals, true)
+	err = s.g.Compact(context.TODO())
+	c.Assert(xerrors.Is(err, ErrClosed), gc.Equals, true)
+
+	s.g = s.open(c, Config{})
+}
+
+// populate creates a set of links and edges between them.
+func (s *DiskGraphTestSuite) populate(c *gc.C) []*graph.Link {
+	links := make([]*graph.Link, 6)
+	for i := range links {
+		links[i] = &graph.Link{
+			URL:          fmt.Sprintf("https://example.com/%d", i),
+			RetrievedAt:  time.Now().Add(-time.Duration(i) * time.Minute),
+			ETag:         fmt.Sprintf("etag-%d", i),
+			LastModified: time.Now().Add(-time.Hour),
+			ContentHash:  fmt.Sprintf("hash-%d", i),
+			HTTPStatus:   200,
+			FailureCount: i,
+			Status:       graph.LinkStatusCrawled,
+			NextCrawlAt:  time.Now().Add(time.Duration(i) * time.Hour),
+		}
+	}
+	c.Assert(s.g.UpsertLinks(context.TODO(), links), gc.IsNil)
+
+	edges := make([]*graph.Edge, 0, len(links))
+	for i := range links {
+		edges = append(edges, &graph.Edge{
+			Source:      links[i].ID,
+			Destination: links[(i+1)%len(links)].ID,
+			AnchorText:  fmt.Sprintf("anchor-%d", i),
+			Nofollow:    i%2 == 0,
+			Sponsored:   i%3 == 0,
+			UGC:         i%4 == 0,
+			Weight:      float64(i) / 2,
+		})
+	}
+	c.Assert(s.g.UpsertEdges(context.TODO(), edges), gc.IsNil)
+	return links
+}
+
+// open opens the graph stored in the test directory.
+func (s *DiskGraphTestSuite) open(c *gc.C, cfg Config) *DiskGraph {
+	g, err := NewDiskGraph(s.dir, cfg)
+	c.Assert(err, gc.IsNil)
+	return g
+}
+
+// reopen closes and re-opens the graph under test.
+func (s *DiskGraphTestSuite) reopen(c *gc.C, cfg Config) {
+	c.Assert(s.g.Close(), gc.IsNil)
+	s.g = s.open(c, cfg)
+}
+
+// resetGraph replaces the graph under test with an empty one.
+func (s *DiskGraphTestSuite) resetGraph(c *gc.C) {
+	c.Assert(s.g.Close(), gc.IsNil)
+	s.dir = c.MkDir()
+	s.g = s.open(c, Config{})
+}
+
+func (s *DiskGraphTestSuite) logSize(c *gc.C) int64 {
+	info, err := os.Stat(filepath.Join(s.dir, logFileName))
+	c.Assert(err, gc.IsNil)
+	return info.Size()
+}
+
+func mustFindLinkByURL(c *gc.C, g graph.Graph, url string) *graph.Link {
+	link, err := g.FindLinkByURL(context.TODO(), url)
+	c.Assert(err, gc.IsNil)
+	return link
+}
@@ -0,0 +1,143 @@
+package disk
+
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxRetainedEvents controls the number of past mutation events that are
+// retained so they can be replayed to new watchers.
+const maxRetainedEvents = 10000
+
+// Watch returns an iterator for the stream of mutation events that occur
+// after the provided timestamp. Events that took place before the graph was
+// opened are not available and only the most recent maxRetainedEvents events
+// can be replayed.
+func (g *DiskGraph) Watch(ctx context.Context, since time.Time) (graph.EventIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("watch: %w", err)
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	first := sort.Search(len(g.events), func(i int) bool {
+		return g.events[i].Timestamp.After(since)
+	})
+	return &eventIterator{
+		ctx:     ctx,
+		g:       g,
+		nextSeq: g.eventBase + uint64(first),
+		closeCh: make(chan struct{}),
+	}, nil
+}
+
+// publish appends a mutation event to the event log and wakes up any
+// blocked watchers. The link and edge arguments must point to values that
+// are no longer modified. The caller must hold the write lock.
+func (g *DiskGraph) publish(evType graph.EventType, link *graph.Link, edge *graph.Edge) {
+	g.events = append(g.events, &graph.Event{
+		Type:      evType,
+		Timestamp: time.Now(),
+		Link:      link,
+		Edge:      edge,
+	})
+
+	// Trim the event log once it grows to twice its maximum size so that
+	// the cost of trimming is amortized across publish calls.
+	if len(g.events) >= 2*maxRetainedEvents {
+		numDropped := len(g.events) - maxRetainedEvents
+		g.events = append([]*graph.Event(nil), g.events[numDropped:]...)
+		g.eventBase += uint64(numDropped)
+	}
+
+	close(g.eventsCh)
+	g.eventsCh = make(chan struct{})
+}
+
+// eventIterator is a graph.EventIterator implementation for the disk graph.
+type eventIterator struct {
+	ctx context.Context
+	g   *DiskGraph
+
+	// nextSeq is the sequence number of the next event to be returned.
+	nextSeq uint64
+	curEv   *graph.Event
+	lastErr error
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// Next implements graph.EventIterator.
+func (i *eventIterator) Next() bool {
+	for {
+		if i.lastErr != nil {
+			return false
+		}
+
+		select {
+		case <-i.closeCh:
+			return false
+		default:
+		}
+
+		i.g.mu.RLock()
+		// If the watcher fell behind, skip over any events that have
+		// been dropped from the event log.
+		if i.nextSeq < i.g.eventBase {
+			i.nextSeq = i.g.eventBase
+		}
+		if index := i.nextSeq - i.g.eventBase; index < uint64(len(i.g.events)) {
+			i.curEv = i.g.events[index]
+			i.nextSeq++
+			i.g.mu.RUnlock()
+			return true
+		}
+		waitCh := i.g.eventsCh
+		i.g.mu.RUnlock()
+
+		// Block until a new event is published.
+		select {
+		case <-waitCh:
+		case <-i.closeCh:
+			return false
+		case <-i.ctx.Done():
+			i.lastErr = i.ctx.Err()
+			return false
+		}
+	}
+}
+
+// Event implements graph.EventIterator.
+func (i *eventIterator) Event() *graph.Event {
+	// Events are shared between watchers so hand out a copy.
+	ev := new(graph.Event)
+	*ev = *i.curEv
+	if ev.Link != nil {
+		link := new(graph.Link)
+		*link = *ev.Link
+		ev.Link = link
+	}
+	if ev.Edge != nil {
+		edge := new(graph.Edge)
+		*edge = *ev.Edge
+		ev.Edge = edge
+	}
+	return ev
+}
+
+// Error implements graph.EventIterator.
+func (i *eventIterator) Error() error {
+	return i.lastErr
+}
+
+// Close implements graph.EventIterator.
+func (i *eventIterator) Close() error {
+	i.closeOnce.Do(func() { close(i.closeCh) })
+	return nil
+}
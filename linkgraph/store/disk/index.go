@@ -0,0 +1,313 @@
+package disk
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/store/internal/graphlog"
+	"golang.org/x/xerrors"
+	"hash/fnv"
+	"time"
+)
+
+// linkEntry locates the record that holds the current state of a link in
+// the log. It also keeps the link attributes that queries filter by so that
+// records only need to be read for the links that are returned.
+type linkEntry struct {
+	offset      int64
+	urlHash     uint64
+	retrievedAt time.Time
+	nextCrawlAt time.Time
+	status      graph.LinkStatus
+}
+
+// edgeEntry locates the record that holds the current state of an edge in
+// the log.
+type edgeEntry struct {
+	offset    int64
+	src       uuid.UUID
+	dst       uuid.UUID
+	updatedAt time.Time
+}
+
+// index maps link and edge IDs to the offsets of their records in the log.
+//
+// The edge lists and URL buckets are never modified in place; updates
+// always replace them with a fresh copy and the key trees are copied on
+// write. Cloning the index thus only needs to copy the maps themselves.
+type index struct {
+	links map[uuid.UUID]linkEntry
+	edges map[uuid.UUID]edgeEntry
+
+	// linkKeys and edgeKeys keep the link and edge IDs in the order in
+	// which range queries return them so that the queries do not need to
+	// scan the whole index.
+	linkKeys *keyTree
+	edgeKeys *keyTree
+
+	// urls maps the hash of each link URL to the IDs of the links whose
+	// URLs share that hash.
+	urls map[uint64][]uuid.UUID
+
+	// outEdges and inEdges map link IDs to the IDs of the edges that
+	// originate from or point to them.
+	outEdges map[uuid.UUID][]uuid.UUID
+	inEdges  map[uuid.UUID][]uuid.UUID
+
+	// aliases maps the ID of each alias link to the ID of its canonical
+	// link. Alias chains are always flattened.
+	aliases map[uuid.UUID]uuid.UUID
+}
+
+// newIndex returns an empty index.
+func newIndex() *index {
+	return &index{
+		links:    make(map[uuid.UUID]linkEntry),
+		edges:    make(map[uuid.UUID]edgeEntry),
+		linkKeys: newKeyTree(),
+		edgeKeys: newKeyTree(),
+		urls:     make(map[uint64][]uuid.UUID),
+		outEdges: make(map[uuid.UUID][]uuid.UUID),
+		inEdges:  make(map[uuid.UUID][]uuid.UUID),
+		aliases:  make(map[uuid.UUID]uuid.UUID),
+	}
+}
+
+// clone returns a copy of the index that can be modified independently.
+func (idx *index) clone() *index {
+	c := &index{
+		links:    make(map[uuid.UUID]linkEntry, len(idx.links)),
+		edges:    make(map[uuid.UUID]edgeEntry, len(idx.edges)),
+		linkKeys: idx.linkKeys.clone(),
+		edgeKeys: idx.edgeKeys.clone(),
+		urls:     make(map[uint64][]uuid.UUID, len(idx.urls)),
+		outEdges: make(map[uuid.UUID][]uuid.UUID, len(idx.outEdges)),
+		inEdges:  make(map[uuid.UUID][]uuid.UUID, len(idx.inEdges)),
+		aliases:  make(map[uuid.UUID]uuid.UUID, len(idx.aliases)),
+	}
+	for id, entry := range idx.links {
+		c.links[id] = entry
+	}
+	for id, entry := range idx.edges {
+		c.edges[id] = entry
+	}
+	for hash, ids := range idx.urls {
+		c.urls[hash] = ids
+	}
+	for id, list := range idx.outEdges {
+		c.outEdges[id] = list
+	}
+	for id, list := range idx.inEdges {
+		c.inEdges[id] = list
+	}
+	for aliasID, canonicalID := range idx.aliases {
+		c.aliases[aliasID] = canonicalID
+	}
+	return c
+}
+
+// apply updates the index with the mutation described by the record stored
+// at the specified log offset.
+func (idx *index) apply(r *graphlog.Record, offset int64) error {
+	switch r.Type {
+	case graphlog.LinkPut:
+		idx.putLink(r.Link, offset)
+	case graphlog.LinkRemove:
+		if _, exists := idx.links[r.ID]; !exists {
+			return graph.ErrNotFound
+		}
+		idx.removeLink(r.ID)
+	case graphlog.AliasPut:
+		if _, exists := idx.links[r.ID]; !exists {
+			return graph.ErrNotFound
+		}
+		if _, exists := idx.links[r.CanonicalID]; !exists {
+			return graph.ErrNotFound
+		}
+		idx.putAlias(r.ID, r.CanonicalID)
+	case graphlog.EdgePut:
+		_, srcExists := idx.links[r.Edge.Source]
+		_, dstExists := idx.links[r.Edge.Destination]
+		if !srcExists || !dstExists {
+			return graph.ErrUnknownEdgeLinks
+		}
+		idx.putEdge(r.Edge, offset)
+	case graphlog.StaleEdgesRemove:
+		idx.removeStaleEdges(r.ID, r.Before)
+	default:
+		return xerrors.Errorf("unexpected record type %d", r.Type)
+	}
+	return nil
+}
+
+// putLink points the entry of link to the record at the specified offset.
+func (idx *index) putLink(link *graph.Link, offset int64) {
+	entry, exists := idx.links[link.ID]
+	if !exists {
+		entry.urlHash = hashURL(link.URL)
+		idx.urls[entry.urlHash] = appendID(idx.urls[entry.urlHash], link.ID)
+		idx.linkKeys.insert(linkKey(link.ID))
+	}
+	entry.offset = offset
+	entry.retrievedAt = link.RetrievedAt
+	entry.nextCrawlAt = link.NextCrawlAt
+	entry.status = link.Status
+	idx.links[link.ID] = entry
+}
+
+// removeLink drops a link together with its edges and any aliases that
+// involve it.
+func (idx *index) removeLink(id uuid.UUID) {
+	for _, edgeID := range idx.outEdges[id] {
+		idx.dropEdge(edgeID)
+	}
+	for _, edgeID := range idx.inEdges[id] {
+		idx.dropEdge(edgeID)
+	}
+	delete(idx.outEdges, id)
+	delete(idx.inEdges, id)
+
+	delete(idx.aliases, id)
+	for aliasID, canonicalID := range idx.aliases {
+		if canonicalID == id {
+			delete(idx.aliases, aliasID)
+		}
+	}
+
+	hash := idx.links[id].urlHash
+	if ids := withoutID(idx.urls[hash], id); len(ids) != 0 {
+		idx.urls[hash] = ids
+	} else {
+		delete(idx.urls, hash)
+	}
+	idx.linkKeys.remove(linkKey(id))
+	delete(idx.links, id)
+}
+
+// putAlias records that aliasID is an alias of canonicalID, which must not
+// be an alias itself.
+func (idx *index) putAlias(aliasID, canonicalID uuid.UUID) {
+	idx.aliases[aliasID] = canonicalID
+
+	// Any links that were aliases of aliasID now point to canonicalID.
+	for otherID, otherCanonicalID := range idx.aliases {
+		if otherCanonicalID == aliasID {
+			idx.aliases[otherID] = canonicalID
+		}
+	}
+}
+
+// resolveAlias returns the ID of the canonical link for id or id itself if
+// it is not an alias.
+func (idx *index) resolveAlias(id uuid.UUID) uuid.UUID {
+	if canonicalID, aliased := idx.aliases[id]; aliased {
+		return canonicalID
+	}
+	return id
+}
+
+// putEdge points the entry of edge to the record at the specified offset.
+// Any other edge between the same pair of links is dropped.
+func (idx *index) putEdge(edge *graph.Edge, offset int64) {
+	if existingID, exists := idx.findEdge(edge.Source, edge.Destination); exists && existingID != edge.ID {
+		idx.dropEdge(existingID)
+	}
+	if _, exists := idx.edges[edge.ID]; !exists {
+		idx.outEdges[edge.Source] = appendID(idx.outEdges[edge.Source], edge.ID)
+		idx.inEdges[edge.Destination] = appendID(idx.inEdges[edge.Destination], edge.ID)
+		idx.edgeKeys.insert(edgeKey(edge.Source, edge.ID))
+	}
+	idx.edges[edge.ID] = edgeEntry{
+		offset:    offset,
+		src:       edge.Source,
+		dst:       edge.Destination,
+		updatedAt: edge.UpdatedAt,
+	}
+}
+
+// findEdge returns the ID of the edge from src to dst.
+func (idx *index) findEdge(src, dst uuid.UUID) (uuid.UUID, bool) {
+	for _, edgeID := range idx.outEdges[src] {
+		if idx.edges[edgeID].dst == dst {
+			return edgeID, true
+		}
+	}
+	return uuid.Nil, false
+}
+
+// staleEdges returns the IDs of the edges that originate from src and were
+// updated before the specified timestamp.
+func (idx *index) staleEdges(src uuid.UUID, updatedBefore time.Time) []uuid.UUID {
+	var stale []uuid.UUID
+	for _, edgeID := range idx.outEdges[src] {
+		if idx.edges[edgeID].updatedAt.Before(updatedBefore) {
+			stale = append(stale, edgeID)
+		}
+	}
+	return stale
+}
+
+// removeStaleEdges drops the edges that originate from src and were updated
+// before the specified timestamp.
+func (idx *index) removeStaleEdges(src uuid.UUID, updatedBefore time.Time) {
+	for _, edgeID := range idx.staleEdges(src, updatedBefore) {
+		idx.dropEdge(edgeID)
+	}
+}
+
+// dropEdge removes an edge from the index.
+func (idx *index) dropEdge(edgeID uuid.UUID) {
+	entry, exists := idx.edges[edgeID]
+	if !exists {
+		return
+	}
+	idx.outEdges[entry.src] = withoutID(idx.outEdges[entry.src], edgeID)
+	idx.inEdges[entry.dst] = withoutID(idx.inEdges[entry.dst], edgeID)
+	idx.edgeKeys.remove(edgeKey(entry.src, edgeID))
+	delete(idx.edges, edgeID)
+}
+
+// linkKey returns the key of the link with the specified ID in the ordered
+// link index.
+func linkKey(id uuid.UUID) treeKey {
+	return treeKey{major: id}
+}
+
+// edgeKey returns the key of the edge with the specified source and ID in
+// the ordered edge index.
+func edgeKey(src, id uuid.UUID) treeKey {
+	return treeKey{major: src, minor: id}
+}
+
+// hashURL returns the key of url in the URL index.
+func hashURL(url string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(url))
+	return h.Sum64()
+}
+
+// appendID returns a copy of list with id appended to it.
+func appendID(list []uuid.UUID, id uuid.UUID) []uuid.UUID {
+	return append(list[:len(list):len(list)], id)
+}
+
+// withoutID returns a copy of list with id removed.
+func withoutID(list []uuid.UUID, id uuid.UUID) []uuid.UUID {
+	var newList []uuid.UUID
+	for _, other := range list {
+		if other != id {
+			newList = append(newList, other)
+		}
+	}
+	return newList
+}
+
+// uuidLess returns true if UUID a sorts before UUID b.
+func uuidLess(a, b uuid.UUID) bool {
+	return bytes.Compare(a[:], b[:]) < 0
+}
+
+// inRange returns true if id belongs to the [fromID, toID) range.
+func inRange(id, fromID, toID uuid.UUID) bool {
+	return !uuidLess(id, fromID) && uuidLess(id, toID)
+}
@@ -0,0 +1,227 @@
+package disk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// indexVersion is bumped whenever the index file format changes.
+	// Index files with a different version are ignored and the index is
+	// rebuilt from the log instead.
+	indexVersion = 1
+
+	// indexMagic identifies linkgraph index files.
+	indexMagic = "LGRAPHIX"
+)
+
+var (
+	// errStaleIndex is returned by readIndexFile if the index file does
+	// not describe the log it was found next to.
+	errStaleIndex = xerrors.New("stale index file")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// writeIndexFile persists idx together with the size of the log that it
+// describes. The file is replaced atomically so that a crash leaves either
+// the old or the new index file behind.
+func writeIndexFile(dir string, idx *index, logSize int64) error {
+	tmpPath := filepath.Join(dir, indexFileName+".tmp")
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	iw := &indexWriter{w: bw, crc: crc32.New(crcTable)}
+	iw.putBytes([]byte(indexMagic))
+	iw.putUint(indexVersion)
+	iw.putUint(uint64(logSize))
+
+	iw.putUint(uint64(len(idx.links)))
+	for id, entry := range idx.links {
+		iw.putUUID(id)
+		iw.putUint(uint64(entry.offset))
+		iw.putUint(entry.urlHash)
+		iw.putTime(entry.retrievedAt)
+		iw.putTime(entry.nextCrawlAt)
+		iw.putUint(uint64(entry.status))
+	}
+	iw.putUint(uint64(len(idx.edges)))
+	for id, entry := range idx.edges {
+		iw.putUUID(id)
+		iw.putUint(uint64(entry.offset))
+		iw.putUUID(entry.src)
+		iw.putUUID(entry.dst)
+		iw.putTime(entry.updatedAt)
+	}
+	iw.putUint(uint64(len(idx.aliases)))
+	for aliasID, canonicalID := range idx.aliases {
+		iw.putUUID(aliasID)
+		iw.putUUID(canonicalID)
+	}
+
+	err = iw.err
+	if err == nil {
+		var trailer [crc32.Size]byte
+		binary.LittleEndian.PutUint32(trailer[:], iw.crc.Sum32())
+		_, err = bw.Write(trailer[:])
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, filepath.Join(dir, indexFileName))
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// readIndexFile loads the index persisted by writeIndexFile. It returns
+// errStaleIndex if the index file is damaged or was written for a log whose
+// size differs from logSize.
+func readIndexFile(path string, logSize int64) (*index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(indexMagic)+crc32.Size || string(data[:len(indexMagic)]) != indexMagic {
+		return nil, xerrors.Errorf("unsupported index header: %w", errStaleIndex)
+	}
+	body, trailer := data[:len(data)-crc32.Size], data[len(data)-crc32.Size:]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(trailer) {
+		return nil, xerrors.Errorf("index checksum mismatch: %w", errStaleIndex)
+	}
+
+	ir := &indexReader{buf: body[len(indexMagic):]}
+	if version := ir.uint(); version != indexVersion {
+		return nil, xerrors.Errorf("unsupported index version %d: %w", version, errStaleIndex)
+	}
+	if size := int64(ir.uint()); size != logSize {
+		return nil, xerrors.Errorf("index describes %d log bytes instead of %d: %w", size, logSize, errStaleIndex)
+	}
+
+	idx := newIndex()
+	for n := ir.uint(); n > 0 && !ir.short; n-- {
+		id := ir.uuid()
+		entry := linkEntry{
+			offset:      int64(ir.uint()),
+			urlHash:     ir.uint(),
+			retrievedAt: ir.time(),
+			nextCrawlAt: ir.time(),
+			status:      graph.LinkStatus(ir.uint()),
+		}
+		idx.links[id] = entry
+		idx.urls[entry.urlHash] = appendID(idx.urls[entry.urlHash], id)
+		idx.linkKeys.insert(linkKey(id))
+	}
+	for n := ir.uint(); n > 0 && !ir.short; n-- {
+		id := ir.uuid()
+		entry := edgeEntry{
+			offset:    int64(ir.uint()),
+			src:       ir.uuid(),
+			dst:       ir.uuid(),
+			updatedAt: ir.time(),
+		}
+		idx.edges[id] = entry
+		idx.outEdges[entry.src] = appendID(idx.outEdges[entry.src], id)
+		idx.inEdges[entry.dst] = appendID(idx.inEdges[entry.dst], id)
+		idx.edgeKeys.insert(edgeKey(entry.src, id))
+	}
+	for n := ir.uint(); n > 0 && !ir.short; n-- {
+		aliasID := ir.uuid()
+		idx.aliases[aliasID] = ir.uuid()
+	}
+	if ir.short || len(ir.buf) != 0 {
+		return nil, xerrors.Errorf("malformed index entries: %w", errStaleIndex)
+	}
+	return idx, nil
+}
+
+// indexWriter writes the fields of an index file while computing their
+// checksum. The first write error is retained in err.
+type indexWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	err error
+}
+
+func (w *indexWriter) putBytes(b []byte) {
+	if w.err != nil {
+		return
+	}
+	_, _ = w.crc.Write(b)
+	_, w.err = w.w.Write(b)
+}
+
+func (w *indexWriter) putUint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	w.putBytes(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+func (w *indexWriter) putUUID(id uuid.UUID) {
+	w.putBytes(id[:])
+}
+
+func (w *indexWriter) putTime(t time.Time) {
+	var tmp [binary.MaxVarintLen64]byte
+	w.putBytes(tmp[:binary.PutVarint(tmp[:], t.Unix())])
+	w.putUint(uint64(t.Nanosecond()))
+}
+
+// indexReader decodes the fields of an index file. Reading past the end of
+// the buffer sets short and yields zero values.
+type indexReader struct {
+	buf   []byte
+	short bool
+}
+
+func (r *indexReader) uint() uint64 {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.short = true
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *indexReader) uuid() uuid.UUID {
+	var id uuid.UUID
+	if len(r.buf) < len(id) {
+		r.short = true
+		return id
+	}
+	copy(id[:], r.buf)
+	r.buf = r.buf[len(id):]
+	return id
+}
+
+func (r *indexReader) time() time.Time {
+	sec, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.short = true
+		return time.Time{}
+	}
+	r.buf = r.buf[n:]
+	return time.Unix(sec, int64(r.uint()))
+}
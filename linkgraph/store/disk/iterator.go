@@ -0,0 +1,172 @@
+package disk
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"sync"
+)
+
+// linkIterator is a graph.LinkIterator implementation for the disk graph.
+// The offsets of the matching records are collected when the iterator is
+// created; the records themselves are read lazily from the log.
+type linkIterator struct {
+	ctx context.Context
+	log *logFile
+
+	// offsets holds the log offsets of the links that remain to be
+	// returned.
+	offsets []int64
+
+	curLink *graph.Link
+	lastErr error
+
+	// startCursor is the cursor that the iteration was resumed from.
+	startCursor graph.Cursor
+
+	releaseOnce sync.Once
+}
+
+// Next implements graph.LinkIterator.
+func (i *linkIterator) Next() bool {
+	if i.lastErr != nil {
+		return false
+	}
+	if err := i.ctx.Err(); err != nil {
+		i.lastErr = err
+		i.release()
+		return false
+	}
+	if len(i.offsets) == 0 {
+		i.release()
+		return false
+	}
+
+	link, err := i.log.readLink(i.offsets[0])
+	if err != nil {
+		i.lastErr = err
+		i.release()
+		return false
+	}
+	i.offsets = i.offsets[1:]
+	i.curLink = link
+	return true
+}
+
+// Link implements graph.LinkIterator.
+func (i *linkIterator) Link() *graph.Link {
+	link := new(graph.Link)
+	*link = *i.curLink
+	return link
+}
+
+// Cursor implements graph.LinkIterator.
+func (i *linkIterator) Cursor() graph.Cursor {
+	if i.curLink == nil {
+		return i.startCursor
+	}
+	return graph.NewCursor(i.curLink.ID)
+}
+
+// Error implements graph.LinkIterator.
+func (i *linkIterator) Error() error {
+	return i.lastErr
+}
+
+// Close implements graph.LinkIterator.
+func (i *linkIterator) Close() error {
+	i.release()
+	return nil
+}
+
+// release drops the reference to the log once no more records need to be
+// read from it.
+func (i *linkIterator) release() {
+	i.releaseOnce.Do(func() { _ = i.log.release() })
+}
+
+// edgeIterator is a graph.EdgeIterator implementation for the disk graph.
+// The offsets of the matching records are collected when the iterator is
+// created; the records themselves are read lazily from the log.
+type edgeIterator struct {
+	ctx context.Context
+	log *logFile
+
+	// offsets holds the log offsets of the edges that remain to be
+	// returned.
+	offsets []int64
+
+	// aliases is only set for iterators returned by CanonicalEdges. The
+	// destination of edges pointing to an alias is rewritten to the ID of
+	// the canonical link.
+	aliases map[uuid.UUID]uuid.UUID
+
+	curEdge *graph.Edge
+	lastErr error
+
+	// startCursor is the cursor that the iteration was resumed from.
+	startCursor graph.Cursor
+
+	releaseOnce sync.Once
+}
+
+// Next implements graph.EdgeIterator.
+func (i *edgeIterator) Next() bool {
+	if i.lastErr != nil {
+		return false
+	}
+	if err := i.ctx.Err(); err != nil {
+		i.lastErr = err
+		i.release()
+		return false
+	}
+	if len(i.offsets) == 0 {
+		i.release()
+		return false
+	}
+
+	edge, err := i.log.readEdge(i.offsets[0])
+	if err != nil {
+		i.lastErr = err
+		i.release()
+		return false
+	}
+	if canonicalID, aliased := i.aliases[edge.Destination]; aliased {
+		edge.Destination = canonicalID
+	}
+	i.offsets = i.offsets[1:]
+	i.curEdge = edge
+	return true
+}
+
+// Edge implements graph.EdgeIterator.
+func (i *edgeIterator) Edge() *graph.Edge {
+	edge := new(graph.Edge)
+	*edge = *i.curEdge
+	return edge
+}
+
+// Cursor implements graph.EdgeIterator.
+func (i *edgeIterator) Cursor() graph.Cursor {
+	if i.curEdge == nil {
+		return i.startCursor
+	}
+	return graph.NewCursor(i.curEdge.ID)
+}
+
+// Error implements graph.EdgeIterator.
+func (i *edgeIterator) Error() error {
+	return i.lastErr
+}
+
+// Close implements graph.EdgeIterator.
+func (i *edgeIterator) Close() error {
+	i.release()
+	return nil
+}
+
+// release drops the reference to the log once no more records need to be
+// read from it.
+func (i *edgeIterator) release() {
+	i.releaseOnce.Do(func() { _ = i.log.release() })
+}
@@ -0,0 +1,295 @@
+package disk
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/store/internal/graphlog"
+	"golang.org/x/xerrors"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ctxCheckInterval controls how often index scans check whether their
+// context has been cancelled.
+const ctxCheckInterval = 1024
+
+// logFile is a reference-counted handle to a log. Snapshots and iterators
+// hold a reference so that they can keep reading records from a log that
+// was replaced by a compaction.
+type logFile struct {
+	*os.File
+	refs int32
+}
+
+// newLogFile returns a handle to f with a single reference.
+func newLogFile(f *os.File) *logFile {
+	return &logFile{File: f, refs: 1}
+}
+
+// acquire adds a reference to the log.
+func (lf *logFile) acquire() *logFile {
+	atomic.AddInt32(&lf.refs, 1)
+	return lf
+}
+
+// release drops a reference to the log and closes it once the last
+// reference is gone.
+func (lf *logFile) release() error {
+	if atomic.AddInt32(&lf.refs, -1) == 0 {
+		return lf.Close()
+	}
+	return nil
+}
+
+// readLink reads the link record at the specified offset.
+func (lf *logFile) readLink(offset int64) (*graph.Link, error) {
+	r, err := graphlog.ReadFrameAt(lf, offset)
+	if err != nil {
+		return nil, err
+	}
+	if r.Type != graphlog.LinkPut {
+		return nil, xerrors.Errorf("unexpected record type %d at offset %d: %w", r.Type, offset, ErrCorruptLog)
+	}
+	return r.Link, nil
+}
+
+// readEdge reads the edge record at the specified offset.
+func (lf *logFile) readEdge(offset int64) (*graph.Edge, error) {
+	r, err := graphlog.ReadFrameAt(lf, offset)
+	if err != nil {
+		return nil, err
+	}
+	if r.Type != graphlog.EdgePut {
+		return nil, xerrors.Errorf("unexpected record type %d at offset %d: %w", r.Type, offset, ErrCorruptLog)
+	}
+	return r.Edge, nil
+}
+
+// view combines an index with the log that its offsets point into. The
+// graph queries its live index through a view while holding the read lock;
+// snapshots wrap a view whose index is no longer modified.
+type view struct {
+	idx *index
+	log *logFile
+}
+
+// findLink looks up a link by its ID, resolving aliases.
+func (v view) findLink(id uuid.UUID) (*graph.Link, error) {
+	entry, exists := v.idx.links[v.idx.resolveAlias(id)]
+	if !exists {
+		return nil, graph.ErrNotFound
+	}
+	return v.log.readLink(entry.offset)
+}
+
+// findLinkByURL looks up a link by its URL without resolving aliases.
+func (v view) findLinkByURL(url string) (*graph.Link, error) {
+	for _, id := range v.idx.urls[hashURL(url)] {
+		link, err := v.log.readLink(v.idx.links[id].offset)
+		if err != nil {
+			return nil, err
+		} else if link.URL == url {
+			return link, nil
+		}
+	}
+	return nil, graph.ErrNotFound
+}
+
+// linksAfter returns an iterator for the links whose IDs belong to the
+// [fromID, toID) range, were retrieved before the provided timestamp and are
+// positioned after the provided cursor.
+func (v view) linksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, err
+	}
+
+	// Resume the scan at the cursor position if it lies within the range.
+	if after != "" && uuidLess(fromID, afterID) {
+		fromID = afterID
+	}
+
+	var (
+		offsets []int64
+		scanned int
+	)
+	v.idx.linkKeys.ascendRange(linkKey(fromID), linkKey(toID), func(k treeKey) bool {
+		if scanned++; scanned%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return false
+		}
+		if entry := v.idx.links[k.major]; (after == "" || k.major != afterID) && entry.retrievedAt.Before(retrievedBefore) {
+			offsets = append(offsets, entry.offset)
+		}
+		return true
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("scan link index: %w", err)
+	}
+	return &linkIterator{ctx: ctx, log: v.log.acquire(), offsets: offsets, startCursor: after}, nil
+}
+
+// linksDueForCrawl returns an iterator for the crawlable links whose IDs
+// belong to the [fromID, toID) range and are due before the provided
+// timestamp.
+func (v view) linksDueForCrawl(ctx context.Context, fromID, toID uuid.UUID, dueBefore time.Time) (graph.LinkIterator, error) {
+	type dueRef struct {
+		offsetRef
+		nextCrawlAt time.Time
+	}
+
+	// As links are returned in crawl order, the matching links need to be
+	// collected and sorted up front.
+	var (
+		list    []dueRef
+		scanned int
+	)
+	v.idx.linkKeys.ascendRange(linkKey(fromID), linkKey(toID), func(k treeKey) bool {
+		if scanned++; scanned%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return false
+		}
+		if entry := v.idx.links[k.major]; entry.status.IsCrawlable() && entry.nextCrawlAt.Before(dueBefore) {
+			list = append(list, dueRef{offsetRef: offsetRef{id: k.major, offset: entry.offset}, nextCrawlAt: entry.nextCrawlAt})
+		}
+		return true
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("scan link index: %w", err)
+	}
+
+	sort.Slice(list, func(l, r int) bool {
+		if !list[l].nextCrawlAt.Equal(list[r].nextCrawlAt) {
+			return list[l].nextCrawlAt.Before(list[r].nextCrawlAt)
+		}
+		return uuidLess(list[l].id, list[r].id)
+	})
+	offsets := make([]int64, len(list))
+	for i, ref := range list {
+		offsets[i] = ref.offset
+	}
+	return &linkIterator{ctx: ctx, log: v.log.acquire(), offsets: offsets}, nil
+}
+
+// edgesAfter returns an iterator for the edges whose source vertex IDs
+// belong to the [fromID, toID) range, were updated before the provided
+// timestamp and are positioned after the provided cursor.
+func (v view) edgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (*edgeIterator, error) {
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, err
+	}
+
+	// The edge index is ordered by source, so only the edges of the links
+	// in the range are visited. They still need to be sorted by ID as the
+	// cursor refers to edge IDs.
+	var (
+		list    []offsetRef
+		scanned int
+	)
+	v.idx.edgeKeys.ascendRange(treeKey{major: fromID}, treeKey{major: toID}, func(k treeKey) bool {
+		if scanned++; scanned%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return false
+		}
+		if entry := v.idx.edges[k.minor]; entry.updatedAt.Before(updatedBefore) && (after == "" || uuidLess(afterID, k.minor)) {
+			list = append(list, offsetRef{id: k.minor, offset: entry.offset})
+		}
+		return true
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("scan edge index: %w", err)
+	}
+
+	sort.Slice(list, func(l, r int) bool { return uuidLess(list[l].id, list[r].id) })
+	return &edgeIterator{ctx: ctx, log: v.log.acquire(), offsets: refOffsets(list), startCursor: after}, nil
+}
+
+// inEdges returns an iterator for the edges that point to dstID and were
+// updated before the provided timestamp.
+func (v view) inEdges(ctx context.Context, dstID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	var list []offsetRef
+	for _, edgeID := range v.idx.inEdges[dstID] {
+		if entry := v.idx.edges[edgeID]; entry.updatedAt.Before(updatedBefore) {
+			list = append(list, offsetRef{id: edgeID, offset: entry.offset})
+		}
+	}
+
+	sort.Slice(list, func(l, r int) bool { return uuidLess(list[l].id, list[r].id) })
+	return &edgeIterator{ctx: ctx, log: v.log.acquire(), offsets: refOffsets(list)}, nil
+}
+
+// offsetRef associates the ID of a link or edge with the offset of its
+// record.
+type offsetRef struct {
+	id     uuid.UUID
+	offset int64
+}
+
+// refOffsets returns the offsets of the records referenced by list.
+func refOffsets(list []offsetRef) []int64 {
+	out := make([]int64, len(list))
+	for i, ref := range list {
+		out[i] = ref.offset
+	}
+	return out
+}
+
+// snapshot is a graph.Snapshot implementation for the disk graph. Its index
+// is shared with the graph until the next mutation, at which point the
+// graph switches to a private copy.
+type snapshot struct {
+	view
+	closeOnce sync.Once
+}
+
+// FindLink implements graph.Snapshot.
+func (s *snapshot) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	link, err := s.findLink(id)
+	if err != nil {
+		return nil, xerrors.Errorf("find link: %w", err)
+	}
+	return link, nil
+}
+
+// Links implements graph.Snapshot.
+func (s *snapshot) Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (graph.LinkIterator, error) {
+	return s.LinksAfter(ctx, fromID, toID, retrievedBefore, "")
+}
+
+// LinksAfter implements graph.Snapshot.
+func (s *snapshot) LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("links: %w", err)
+	}
+	it, err := s.linksAfter(ctx, fromID, toID, retrievedBefore, after)
+	if err != nil {
+		return nil, xerrors.Errorf("links: %w", err)
+	}
+	return it, nil
+}
+
+// Edges implements graph.Snapshot.
+func (s *snapshot) Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return s.EdgesAfter(ctx, fromID, toID, updatedBefore, "")
+}
+
+// EdgesAfter implements graph.Snapshot.
+func (s *snapshot) EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (graph.EdgeIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
+	it, err := s.edgesAfter(ctx, fromID, toID, updatedBefore, after)
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
+	return it, nil
+}
+
+// Close implements graph.Snapshot.
+func (s *snapshot) Close() error {
+	var err error
+	s.closeOnce.Do(func() { err = s.log.release() })
+	return err
+}
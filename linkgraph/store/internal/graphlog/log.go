@@ -0,0 +1,192 @@
+// Package graphlog implements the binary log format that is used for
+// persisting link graphs. A log consists of a header followed by a sequence
+// of checksummed frames, each holding a record that describes a mutation of
+// the graph.
+package graphlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"golang.org/x/xerrors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// Version is bumped whenever the log format changes in a way that
+	// older versions of this package cannot read.
+	Version = 1
+
+	// frameHeaderSize is the size of the length and checksum fields that
+	// precede the payload of each record.
+	frameHeaderSize = 8
+
+	// maxRecordSize bounds the payload size of a record so that a corrupt
+	// length field cannot trigger a huge allocation.
+	maxRecordSize = 1 << 20
+)
+
+var (
+	// ErrCorrupt is returned when a log contains intact records that
+	// cannot be interpreted or replayed.
+	ErrCorrupt = xerrors.New("corrupt log")
+
+	// ErrTornFrame is returned by Reader for frames that were not
+	// completely written.
+	ErrTornFrame = xerrors.New("torn frame")
+
+	// logMagic identifies linkgraph log files.
+	logMagic = [8]byte{'L', 'G', 'R', 'A', 'P', 'H', 'L', 'G'}
+
+	// HeaderSize is the size of the header at the start of each log.
+	HeaderSize = int64(len(logMagic) + 4)
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Header returns the header that is written at the start of each log.
+func Header() []byte {
+	hdr := make([]byte, HeaderSize)
+	copy(hdr, logMagic[:])
+	binary.LittleEndian.PutUint32(hdr[len(logMagic):], Version)
+	return hdr
+}
+
+// AppendFrame appends the framed encoding of r to buf. Each frame consists
+// of the payload length, the CRC-32C checksum of the payload and the payload
+// itself.
+func AppendFrame(buf []byte, r *Record) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, frameHeaderSize)...)
+	buf = r.Marshal(buf)
+
+	payload := buf[start+frameHeaderSize:]
+	binary.LittleEndian.PutUint32(buf[start:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[start+4:], crc32.Checksum(payload, crcTable))
+	return buf
+}
+
+// Reader reads the records of a log in order.
+type Reader struct {
+	r      *bufio.Reader
+	offset int64
+	frame  []byte
+}
+
+// NewReader validates the log header and returns a reader positioned at the
+// first record.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	hdr := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, xerrors.Errorf("read log header: %w", ErrCorrupt)
+	}
+	if !bytes.Equal(hdr, Header()) {
+		return nil, xerrors.Errorf("unsupported log header %x: %w", hdr, ErrCorrupt)
+	}
+	return &Reader{r: br, offset: HeaderSize}, nil
+}
+
+// NewFrameReader returns a reader for a sequence of frames that starts at
+// the specified log offset. Unlike NewReader, it does not expect r to start
+// with a log header.
+func NewFrameReader(r io.Reader, offset int64) *Reader {
+	return &Reader{r: bufio.NewReader(r), offset: offset}
+}
+
+// Offset returns the offset just past the last record returned by Next.
+func (lr *Reader) Offset() int64 {
+	return lr.offset
+}
+
+// Next returns the next record of the log or io.EOF once all records have
+// been read. A frame that was not completely written before the end of the
+// log is reported as ErrTornFrame; so is an invalid frame that is followed
+// by nothing but zero bytes, as left behind by a crash while the log was
+// being extended. Any other invalid frame is reported as ErrCorrupt.
+func (lr *Reader) Next() (*Record, error) {
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(lr.r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		} else if err == io.ErrUnexpectedEOF {
+			return nil, ErrTornFrame
+		}
+		return nil, err
+	}
+
+	size := binary.LittleEndian.Uint32(hdr[:])
+	if size == 0 || size > maxRecordSize {
+		// Only skip past the payload to find out whether the frame runs
+		// past the end of the log.
+		if n, err := io.CopyN(ioutil.Discard, lr.r, int64(size)); n < int64(size) {
+			if err == io.EOF {
+				return nil, ErrTornFrame
+			}
+			return nil, err
+		}
+		return nil, lr.invalidFrame("invalid size %d", size)
+	}
+	if cap(lr.frame) < int(size) {
+		lr.frame = make([]byte, size)
+	}
+	payload := lr.frame[:size]
+	if _, err := io.ReadFull(lr.r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrTornFrame
+		}
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(hdr[4:]) {
+		return nil, lr.invalidFrame("checksum mismatch")
+	}
+
+	r, err := Unmarshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	lr.offset += frameHeaderSize + int64(size)
+	return r, nil
+}
+
+// invalidFrame returns ErrTornFrame if the remainder of the log only holds
+// zero bytes and an ErrCorrupt error built from format and args otherwise.
+func (lr *Reader) invalidFrame(format string, args ...interface{}) error {
+	for {
+		b, err := lr.r.ReadByte()
+		if err == io.EOF {
+			return ErrTornFrame
+		} else if err != nil {
+			return err
+		} else if b != 0 {
+			break
+		}
+	}
+	args = append(args, lr.offset, ErrCorrupt)
+	return xerrors.Errorf(format+" in frame at offset %d: %w", args...)
+}
+
+// ReadFrameAt decodes the record of the frame that starts at the specified
+// offset of r. Frames that are torn or fail their checksum are reported as
+// ErrCorrupt.
+func ReadFrameAt(r io.ReaderAt, offset int64) (*Record, error) {
+	var hdr [frameHeaderSize]byte
+	if _, err := r.ReadAt(hdr[:], offset); err != nil {
+		return nil, xerrors.Errorf("read frame at offset %d: %v: %w", offset, err, ErrCorrupt)
+	}
+
+	size := binary.LittleEndian.Uint32(hdr[:])
+	if size == 0 || size > maxRecordSize {
+		return nil, xerrors.Errorf("read frame at offset %d: invalid size %d: %w", offset, size, ErrCorrupt)
+	}
+	payload := make([]byte, size)
+	if _, err := r.ReadAt(payload, offset+frameHeaderSize); err != nil {
+		return nil, xerrors.Errorf("read frame at offset %d: %v: %w", offset, err, ErrCorrupt)
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(hdr[4:]) {
+		return nil, xerrors.Errorf("read frame at offset %d: checksum mismatch: %w", offset, ErrCorrupt)
+	}
+	return Unmarshal(payload)
+}
@@ -0,0 +1,291 @@
+package graphlog
+
+import (
+	"encoding/binary"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"math"
+	"time"
+)
+
+// RecordType identifies the mutation described by a log record.
+type RecordType uint8
+
+const (
+	// LinkPut stores the complete state of a link after an upsert.
+	LinkPut RecordType = iota + 1
+
+	// LinkRemove removes a link together with its edges and aliases.
+	LinkRemove
+
+	// AliasPut records that a link is an alias of another link.
+	AliasPut
+
+	// EdgePut stores the complete state of an edge after an upsert.
+	EdgePut
+
+	// StaleEdgesRemove removes the edges originating from a link that
+	// were updated before a particular point in time.
+	StaleEdgesRemove
+
+	// End marks the end of a complete snapshot. It allows readers to tell
+	// a truncated snapshot apart from a snapshot of a smaller graph.
+	End
+)
+
+// Flags for encoding the boolean edge attributes as a single byte.
+const (
+	edgeFlagNofollow = 1 << iota
+	edgeFlagSponsored
+	edgeFlagUGC
+)
+
+// edgeFlags returns the flag byte for the boolean attributes of edge.
+func edgeFlags(edge *graph.Edge) byte {
+	var flags byte
+	if edge.Nofollow {
+		flags |= edgeFlagNofollow
+	}
+	if edge.Sponsored {
+		flags |= edgeFlagSponsored
+	}
+	if edge.UGC {
+		flags |= edgeFlagUGC
+	}
+	return flags
+}
+
+// Record describes a single mutation of the graph. Records capture the
+// outcome of each mutation (e.g. the IDs and timestamps assigned by the
+// graph) so that replaying them always reproduces the same state.
+type Record struct {
+	Type RecordType
+
+	Link *graph.Link
+	Edge *graph.Edge
+
+	// ID is the removed link for LinkRemove, the alias for AliasPut and
+	// the source link for StaleEdgesRemove.
+	ID uuid.UUID
+
+	// CanonicalID is only set for AliasPut.
+	CanonicalID uuid.UUID
+
+	// Before is only set for StaleEdgesRemove.
+	Before time.Time
+}
+
+// Marshal appends the binary encoding of r to buf.
+func (r *Record) Marshal(buf []byte) []byte {
+	enc := encoder{buf: append(buf, byte(r.Type))}
+	switch r.Type {
+	case LinkPut:
+		enc.putUUID(r.Link.ID)
+		enc.putString(r.Link.URL)
+		enc.putTime(r.Link.RetrievedAt)
+		enc.putString(r.Link.ETag)
+		enc.putTime(r.Link.LastModified)
+		enc.putString(r.Link.ContentHash)
+		enc.putVarint(int64(r.Link.HTTPStatus))
+		enc.putVarint(int64(r.Link.FailureCount))
+		enc.buf = append(enc.buf, byte(r.Link.Status))
+		enc.putTime(r.Link.NextCrawlAt)
+	case LinkRemove:
+		enc.putUUID(r.ID)
+	case AliasPut:
+		enc.putUUID(r.ID)
+		enc.putUUID(r.CanonicalID)
+	case EdgePut:
+		enc.putUUID(r.Edge.ID)
+		enc.putUUID(r.Edge.Source)
+		enc.putUUID(r.Edge.Destination)
+		enc.putTime(r.Edge.UpdatedAt)
+		enc.putString(r.Edge.AnchorText)
+		enc.buf = append(enc.buf, edgeFlags(r.Edge))
+		enc.putFloat64(r.Edge.Weight)
+	case StaleEdgesRemove:
+		enc.putUUID(r.ID)
+		enc.putTime(r.Before)
+	}
+	return enc.buf
+}
+
+// Unmarshal decodes a record previously encoded by Marshal.
+func Unmarshal(payload []byte) (*Record, error) {
+	if len(payload) == 0 {
+		return nil, xerrors.Errorf("unmarshal record: %w", ErrCorrupt)
+	}
+
+	var (
+		r   = &Record{Type: RecordType(payload[0])}
+		dec = decoder{buf: payload[1:]}
+	)
+	switch r.Type {
+	case LinkPut:
+		r.Link = &graph.Link{
+			ID:           dec.uuid(),
+			URL:          dec.string(),
+			RetrievedAt:  dec.time(),
+			ETag:         dec.string(),
+			LastModified: dec.time(),
+			ContentHash:  dec.string(),
+			HTTPStatus:   int(dec.varint()),
+			FailureCount: int(dec.varint()),
+			Status:       graph.LinkStatus(dec.byte()),
+			NextCrawlAt:  dec.time(),
+		}
+	case LinkRemove:
+		r.ID = dec.uuid()
+	case AliasPut:
+		r.ID = dec.uuid()
+		r.CanonicalID = dec.uuid()
+	case EdgePut:
+		r.Edge = &graph.Edge{
+			ID:          dec.uuid(),
+			Source:      dec.uuid(),
+			Destination: dec.uuid(),
+			UpdatedAt:   dec.time(),
+			AnchorText:  dec.string(),
+		}
+		flags := dec.byte()
+		r.Edge.Nofollow = flags&edgeFlagNofollow != 0
+		r.Edge.Sponsored = flags&edgeFlagSponsored != 0
+		r.Edge.UGC = flags&edgeFlagUGC != 0
+		r.Edge.Weight = dec.float64()
+	case StaleEdgesRemove:
+		r.ID = dec.uuid()
+		r.Before = dec.time()
+	case End:
+	default:
+		return nil, xerrors.Errorf("unmarshal record: unknown record type %d: %w", r.Type, ErrCorrupt)
+	}
+
+	if dec.short {
+		return nil, xerrors.Errorf("unmarshal record: truncated payload: %w", ErrCorrupt)
+	}
+	if len(dec.buf) != 0 {
+		return nil, xerrors.Errorf("unmarshal record: %d trailing bytes: %w", len(dec.buf), ErrCorrupt)
+	}
+	return r, nil
+}
+
+// encoder appends primitive values to a byte slice.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) putUUID(id uuid.UUID) {
+	e.buf = append(e.buf, id[:]...)
+}
+
+func (e *encoder) putUvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func (e *encoder) putVarint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, tmp[:binary.PutVarint(tmp[:], v)]...)
+}
+
+func (e *encoder) putString(s string) {
+	e.putUvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) putFloat64(v float64) {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v))
+	e.buf = append(e.buf, tmp[:]...)
+}
+
+// putTime encodes t as seconds and nanoseconds since the Unix epoch. The
+// location of t is not preserved; decoded timestamps are always in UTC.
+func (e *encoder) putTime(t time.Time) {
+	e.putVarint(t.Unix())
+	e.putUvarint(uint64(t.Nanosecond()))
+}
+
+// decoder reads primitive values from a byte slice. Reading past the end of
+// the slice sets short and yields zero values.
+type decoder struct {
+	buf   []byte
+	short bool
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.short || len(d.buf) < n {
+		d.short = true
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uuid() uuid.UUID {
+	var id uuid.UUID
+	if b := d.take(len(id)); b != nil {
+		copy(id[:], b)
+	}
+	return id
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.short {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.short = true
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.short {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.short = true
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.short = true
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *decoder) float64() float64 {
+	if b := d.take(8); b != nil {
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) time() time.Time {
+	sec := d.varint()
+	nsec := d.uvarint()
+	if d.short {
+		return time.Time{}
+	}
+	return time.Unix(sec, int64(nsec)).UTC()
+}
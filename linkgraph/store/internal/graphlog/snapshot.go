@@ -0,0 +1,89 @@
+package graphlog
+
+import (
+	"bufio"
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/partition"
+	"io"
+	"time"
+)
+
+// maxTime is used for iterating links and edges regardless of their
+// timestamps.
+var maxTime = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// WriteSnapshot writes a log header followed by the records that restore
+// the contents of snap to w.
+func WriteSnapshot(ctx context.Context, w io.Writer, snap graph.Snapshot) error {
+	var (
+		bw      = bufio.NewWriter(w)
+		frame   []byte
+		aliases []*Record
+	)
+	put := func(r *Record) error {
+		frame = AppendFrame(frame[:0], r)
+		_, err := bw.Write(frame)
+		return err
+	}
+
+	if _, err := bw.Write(Header()); err != nil {
+		return err
+	}
+
+	// Links must be restored before the aliases and edges that refer to
+	// them. Aliases are detected by looking up each link by its ID.
+	linkIt, err := snap.Links(ctx, partition.MinUUID, partition.MaxUUID, maxTime)
+	if err != nil {
+		return err
+	}
+	for linkIt.Next() {
+		link := linkIt.Link()
+		if err = put(&Record{Type: LinkPut, Link: link}); err != nil {
+			_ = linkIt.Close()
+			return err
+		}
+
+		resolved, err := snap.FindLink(ctx, link.ID)
+		if err != nil {
+			_ = linkIt.Close()
+			return err
+		}
+		if resolved.ID != link.ID {
+			aliases = append(aliases, &Record{Type: AliasPut, ID: link.ID, CanonicalID: resolved.ID})
+		}
+	}
+	if err = linkIt.Error(); err != nil {
+		_ = linkIt.Close()
+		return err
+	}
+	if err = linkIt.Close(); err != nil {
+		return err
+	}
+
+	for _, r := range aliases {
+		if err = put(r); err != nil {
+			return err
+		}
+	}
+
+	edgeIt, err := snap.Edges(ctx, partition.MinUUID, partition.MaxUUID, maxTime)
+	if err != nil {
+		return err
+	}
+	for edgeIt.Next() {
+		if err = put(&Record{Type: EdgePut, Edge: edgeIt.Edge()}); err != nil {
+			_ = edgeIt.Close()
+			return err
+		}
+	}
+	if err = edgeIt.Error(); err != nil {
+		_ = edgeIt.Close()
+		return err
+	}
+	if err = edgeIt.Close(); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
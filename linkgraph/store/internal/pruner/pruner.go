@@ -0,0 +1,51 @@
+// Package pruner periodically removes the mutation events that the SQL graph
+// stores record for replaying them to watchers.
+package pruner
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PruneFunc removes the events that were recorded before olderThan.
+type PruneFunc func(ctx context.Context, olderThan time.Time) error
+
+// Pruner invokes a PruneFunc in the background.
+type Pruner struct {
+	cancelFn context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// Start returns a Pruner that invokes pruneFn every interval to remove the
+// events that are older than retention.
+func Start(pruneFn PruneFunc, retention, interval time.Duration) *Pruner {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	p := &Pruner{cancelFn: cancelFn}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Failed attempts are retried on the next tick; events
+				// are only ever retained for longer than requested.
+				_ = pruneFn(ctx, time.Now().Add(-retention))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Stop aborts any running invocation of the PruneFunc and waits for the
+// background goroutine to exit.
+func (p *Pruner) Stop() {
+	p.cancelFn()
+	p.wg.Wait()
+}
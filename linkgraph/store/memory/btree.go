@@ -0,0 +1,423 @@
+package memory
+
+import (
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"sort"
+)
+
+const (
+	// btreeDegree controls the fan-out of linkTree nodes. Each node other
+	// than the root holds between btreeDegree-1 and 2*btreeDegree-1 items.
+	btreeDegree = 32
+
+	maxNodeItems = 2*btreeDegree - 1
+	minNodeItems = btreeDegree - 1
+)
+
+// btreeOwner identifies the tree that is allowed to modify a node in place.
+// It must not be a zero-sized type so that each allocation yields a
+// distinct pointer.
+type btreeOwner struct{ _ byte }
+
+// vertex is a linkTree item that holds a link along with the edges that
+// originate from it sorted by ID. Like links and edges, vertices are never
+// modified in place; updates always replace them with a fresh copy.
+type vertex struct {
+	link  *graph.Link
+	edges []*graph.Edge
+}
+
+// btreeNode is a linkTree node. The items of a node are sorted by link ID;
+// when the node has children, children[i] holds the items that sort between
+// items[i-1] and items[i].
+type btreeNode struct {
+	owner    *btreeOwner
+	items    []*vertex
+	children []*btreeNode
+}
+
+// linkTree is an ordered index of vertices keyed by the raw 16-byte IDs of
+// their links. It is implemented as a B-tree so that range scans cost
+// O(log n + k).
+//
+// Cloning a tree is an O(1) operation as the clone shares all nodes with
+// the original tree. Nodes are copied the first time the clone modifies
+// them (copy-on-write); the original tree must not be modified once it has
+// been cloned.
+type linkTree struct {
+	root  *btreeNode
+	size  int
+	owner *btreeOwner
+}
+
+// newLinkTree returns an empty linkTree.
+func newLinkTree() *linkTree {
+	return &linkTree{owner: new(btreeOwner)}
+}
+
+// clone returns a copy of the tree that shares its nodes with t.
+func (t *linkTree) clone() *linkTree {
+	return &linkTree{root: t.root, size: t.size, owner: new(btreeOwner)}
+}
+
+// len returns the number of vertices in the tree.
+func (t *linkTree) len() int {
+	return t.size
+}
+
+// get returns the vertex of the link with the specified ID or nil if no such
+// vertex exists.
+func (t *linkTree) get(id uuid.UUID) *vertex {
+	for n := t.root; n != nil; {
+		i, found := n.find(id)
+		if found {
+			return n.items[i]
+		}
+		if len(n.children) == 0 {
+			return nil
+		}
+		n = n.children[i]
+	}
+	return nil
+}
+
+// set inserts v into the tree, replacing any vertex with the same link ID.
+func (t *linkTree) set(v *vertex) {
+	if t.root == nil {
+		t.root = &btreeNode{owner: t.owner, items: []*vertex{v}}
+		t.size = 1
+		return
+	}
+
+	// Split a full root so that insert never has to propagate a split
+	// back up the tree.
+	t.root = t.mutableNode(t.root)
+	if len(t.root.items) >= maxNodeItems {
+		item, right := t.split(t.root, maxNodeItems/2)
+		t.root = &btreeNode{
+			owner:    t.owner,
+			items:    []*vertex{item},
+			children: []*btreeNode{t.root, right},
+		}
+	}
+	if t.insert(t.root, v) {
+		t.size++
+	}
+}
+
+// remove removes the vertex of the link with the specified ID from the tree
+// and returns true if it was present.
+func (t *linkTree) remove(id uuid.UUID) bool {
+	if t.root == nil {
+		return false
+	}
+
+	t.root = t.mutableNode(t.root)
+	removed := t.removeFrom(t.root, id)
+	if len(t.root.items) == 0 {
+		if len(t.root.children) != 0 {
+			t.root = t.root.children[0]
+		} else {
+			t.root = nil
+		}
+	}
+	if removed {
+		t.size--
+	}
+	return removed
+}
+
+// ascendRange invokes fn for each vertex whose link ID belongs to the
+// [fromID, toID) range in ascending ID order until fn returns false.
+func (t *linkTree) ascendRange(fromID, toID uuid.UUID, fn func(*vertex) bool) {
+	if t.root != nil {
+		t.root.ascendRange(fromID, toID, fn)
+	}
+}
+
+func (n *btreeNode) ascendRange(fromID, toID uuid.UUID, fn func(*vertex) bool) bool {
+	i, _ := n.find(fromID)
+	for ; i < len(n.items); i++ {
+		if len(n.children) != 0 && !n.children[i].ascendRange(fromID, toID, fn) {
+			return false
+		}
+		if !uuidLess(n.items[i].link.ID, toID) || !fn(n.items[i]) {
+			return false
+		}
+	}
+	if len(n.children) != 0 {
+		return n.children[len(n.children)-1].ascendRange(fromID, toID, fn)
+	}
+	return true
+}
+
+// find returns the index of the first item whose link ID is not less than id
+// and whether that item has the requested ID.
+func (n *btreeNode) find(id uuid.UUID) (int, bool) {
+	i := sort.Search(len(n.items), func(i int) bool {
+		return !uuidLess(n.items[i].link.ID, id)
+	})
+	return i, i < len(n.items) && n.items[i].link.ID == id
+}
+
+// mutableNode returns a node that t may modify in place: either n itself,
+// if it is owned by t, or a copy of n.
+func (t *linkTree) mutableNode(n *btreeNode) *btreeNode {
+	if n.owner == t.owner {
+		return n
+	}
+
+	c := &btreeNode{
+		owner: t.owner,
+		items: append(make([]*vertex, 0, len(n.items)+1), n.items...),
+	}
+	if len(n.children) != 0 {
+		c.children = append(make([]*btreeNode, 0, len(n.children)+1), n.children...)
+	}
+	return c
+}
+
+// mutableChild replaces the i-th child of the mutable node n with a node
+// that t may modify in place and returns it.
+func (t *linkTree) mutableChild(n *btreeNode, i int) *btreeNode {
+	c := t.mutableNode(n.children[i])
+	n.children[i] = c
+	return c
+}
+
+// split moves the items following index i of the mutable node n to a new
+// node and returns the item at index i along with the new node.
+func (t *linkTree) split(n *btreeNode, i int) (*vertex, *btreeNode) {
+	item := n.items[i]
+	right := &btreeNode{owner: t.owner}
+	right.items = append(make([]*vertex, 0, maxNodeItems), n.items[i+1:]...)
+	n.items = truncateItems(n.items, i)
+	if len(n.children) != 0 {
+		right.children = append(make([]*btreeNode, 0, maxNodeItems+1), n.children[i+1:]...)
+		n.children = truncateChildren(n.children, i+1)
+	}
+	return item, right
+}
+
+// insert inserts v into the subtree rooted at the mutable, non-full node n
+// and returns true if the tree did not already contain its ID.
+func (t *linkTree) insert(n *btreeNode, v *vertex) bool {
+	i, found := n.find(v.link.ID)
+	if found {
+		n.items[i] = v
+		return false
+	}
+	if len(n.children) == 0 {
+		n.items = insertItem(n.items, i, v)
+		return true
+	}
+
+	// Make sure that the child we descend into has room for one more
+	// item. Splitting it moves its median item into n, which may be the
+	// item we are looking for or require descending to the right.
+	if len(n.children[i].items) >= maxNodeItems {
+		item, right := t.split(t.mutableChild(n, i), maxNodeItems/2)
+		n.items = insertItem(n.items, i, item)
+		n.children = insertChild(n.children, i+1, right)
+
+		switch {
+		case item.link.ID == v.link.ID:
+			n.items[i] = v
+			return false
+		case uuidLess(item.link.ID, v.link.ID):
+			i++
+		}
+	}
+	return t.insert(t.mutableChild(n, i), v)
+}
+
+// removeFrom removes the vertex with the specified ID from the subtree rooted
+// at the mutable node n. Children are grown before descending into them so
+// that they never underflow.
+func (t *linkTree) removeFrom(n *btreeNode, id uuid.UUID) bool {
+	i, found := n.find(id)
+	if len(n.children) == 0 {
+		if found {
+			n.items = removeItem(n.items, i)
+		}
+		return found
+	}
+
+	if len(n.children[i].items) <= minNodeItems {
+		t.growChild(n, i)
+		return t.removeFrom(n, id)
+	}
+
+	child := t.mutableChild(n, i)
+	if found {
+		// Replace the item with its predecessor, i.e. the largest item
+		// of the left subtree.
+		n.items[i] = t.removeMax(child)
+		return true
+	}
+	return t.removeFrom(child, id)
+}
+
+// removeMax removes and returns the largest item from the subtree rooted at
+// the mutable node n.
+func (t *linkTree) removeMax(n *btreeNode) *vertex {
+	if len(n.children) == 0 {
+		item := n.items[len(n.items)-1]
+		n.items = truncateItems(n.items, len(n.items)-1)
+		return item
+	}
+
+	i := len(n.children) - 1
+	if len(n.children[i].items) <= minNodeItems {
+		t.growChild(n, i)
+		return t.removeMax(n)
+	}
+	return t.removeMax(t.mutableChild(n, i))
+}
+
+// growChild ensures that the i-th child of the mutable node n holds more
+// than minNodeItems items by either borrowing an item from one of its
+// siblings or by merging it with a sibling.
+func (t *linkTree) growChild(n *btreeNode, i int) {
+	switch {
+	case i > 0 && len(n.children[i-1].items) > minNodeItems:
+		// Borrow the largest item of the left sibling.
+		child, left := t.mutableChild(n, i), t.mutableChild(n, i-1)
+		child.items = insertItem(child.items, 0, n.items[i-1])
+		n.items[i-1] = left.items[len(left.items)-1]
+		left.items = truncateItems(left.items, len(left.items)-1)
+		if len(left.children) != 0 {
+			child.children = insertChild(child.children, 0, left.children[len(left.children)-1])
+			left.children = truncateChildren(left.children, len(left.children)-1)
+		}
+	case i < len(n.items) && len(n.children[i+1].items) > minNodeItems:
+		// Borrow the smallest item of the right sibling.
+		child, right := t.mutableChild(n, i), t.mutableChild(n, i+1)
+		child.items = append(child.items, n.items[i])
+		n.items[i] = right.items[0]
+		right.items = removeItem(right.items, 0)
+		if len(right.children) != 0 {
+			child.children = append(child.children, right.children[0])
+			right.children = removeChild(right.children, 0)
+		}
+	default:
+		// Merge the child with its right sibling (or the left sibling
+		// into the child if it is the last one) along with the item
+		// that separates them.
+		if i >= len(n.items) {
+			i--
+		}
+		child, right := t.mutableChild(n, i), n.children[i+1]
+		child.items = append(child.items, n.items[i])
+		child.items = append(child.items, right.items...)
+		child.children = append(child.children, right.children...)
+		n.items = removeItem(n.items, i)
+		n.children = removeChild(n.children, i+1)
+	}
+}
+
+// treeIterator walks the vertices of a linkTree in ascending ID order. The
+// nodes of a tree that has been cloned are never modified, so iterating
+// such a tree does not require any locking.
+type treeIterator struct {
+	toID uuid.UUID
+
+	// stack holds the path to the next vertex. For each node on the path,
+	// index points to the next item of the node that is yet to be visited.
+	stack []treeFrame
+}
+
+type treeFrame struct {
+	n     *btreeNode
+	index int
+}
+
+// iterate returns an iterator for the vertices whose link IDs belong to the
+// [fromID, toID) range.
+func (t *linkTree) iterate(fromID, toID uuid.UUID) *treeIterator {
+	it := &treeIterator{toID: toID}
+	for n := t.root; n != nil; {
+		i, found := n.find(fromID)
+		it.stack = append(it.stack, treeFrame{n: n, index: i})
+		if found || len(n.children) == 0 {
+			break
+		}
+		n = n.children[i]
+	}
+	return it
+}
+
+// next returns the next vertex or nil if the iteration is complete.
+func (it *treeIterator) next() *vertex {
+	for len(it.stack) != 0 {
+		top := &it.stack[len(it.stack)-1]
+		if top.index >= len(top.n.items) {
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+
+		v := top.n.items[top.index]
+		top.index++
+		if !uuidLess(v.link.ID, it.toID) {
+			it.stack = nil
+			return nil
+		}
+
+		// The items that follow v are located in the leftmost path of
+		// the subtree to its right.
+		if len(top.n.children) != 0 {
+			for n := top.n.children[top.index]; n != nil; {
+				it.stack = append(it.stack, treeFrame{n: n})
+				if len(n.children) == 0 {
+					break
+				}
+				n = n.children[0]
+			}
+		}
+		return v
+	}
+	return nil
+}
+
+func insertItem(items []*vertex, i int, item *vertex) []*vertex {
+	items = append(items, nil)
+	copy(items[i+1:], items[i:])
+	items[i] = item
+	return items
+}
+
+func removeItem(items []*vertex, i int) []*vertex {
+	copy(items[i:], items[i+1:])
+	return truncateItems(items, len(items)-1)
+}
+
+// truncateItems shortens items to length n and clears the dropped entries
+// so that they can be garbage collected.
+func truncateItems(items []*vertex, n int) []*vertex {
+	for i := n; i < len(items); i++ {
+		items[i] = nil
+	}
+	return items[:n]
+}
+
+func insertChild(children []*btreeNode, i int, child *btreeNode) []*btreeNode {
+	children = append(children, nil)
+	copy(children[i+1:], children[i:])
+	children[i] = child
+	return children
+}
+
+func removeChild(children []*btreeNode, i int) []*btreeNode {
+	copy(children[i:], children[i+1:])
+	return truncateChildren(children, len(children)-1)
+}
+
+// truncateChildren shortens children to length n and clears the dropped
+// entries so that they can be garbage collected.
+func truncateChildren(children []*btreeNode, n int) []*btreeNode {
+	for i := n; i < len(children); i++ {
+		children[i] = nil
+	}
+	return children[:n]
+}
@@ -0,0 +1,243 @@
+package memory
+
+import (
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/partition"
+	"math/rand"
+	"sort"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(LinkTreeTestSuite))
+
+type LinkTreeTestSuite struct{}
+
+func (s *LinkTreeTestSuite) TestRandomOperations(c *gc.C) {
+	var (
+		rnd  = rand.New(rand.NewSource(42))
+		tree = newLinkTree()
+		exp  = make(map[uuid.UUID]*vertex)
+		ids  []uuid.UUID
+	)
+
+	// Mix inserts, updates and removals so that nodes get split, borrow
+	// items from their siblings and get merged.
+	for i := 0; i < 20000; i++ {
+		switch op := rnd.Intn(10); {
+		case op < 6 || len(ids) == 0:
+			id := randomID(rnd)
+			v := &vertex{link: &graph.Link{ID: id}}
+			tree.set(v)
+			exp[id] = v
+			ids = append(ids, id)
+		case op < 7:
+			id := ids[rnd.Intn(len(ids))]
+			v := &vertex{link: &graph.Link{ID: id, ETag: "updated"}}
+			tree.set(v)
+			exp[id] = v
+		default:
+			j := rnd.Intn(len(ids))
+			_, present := exp[ids[j]]
+			c.Assert(tree.remove(ids[j]), gc.Equals, present)
+			delete(exp, ids[j])
+			ids[j] = ids[len(ids)-1]
+			ids = ids[:len(ids)-1]
+		}
+	}
+
+	assertTreeContents(c, tree, exp)
+	c.Assert(tree.remove(randomID(rnd)), gc.Equals, false)
+
+	// Remove everything to exercise shrinking the tree down to nothing.
+	for id := range exp {
+		c.Assert(tree.remove(id), gc.Equals, true)
+		delete(exp, id)
+	}
+	assertTreeContents(c, tree, exp)
+}
+
+func (s *LinkTreeTestSuite) TestAscendRange(c *gc.C) {
+	var (
+		rnd  = rand.New(rand.NewSource(42))
+		tree = newLinkTree()
+		ids  []uuid.UUID
+	)
+	for i := 0; i < 5000; i++ {
+		id := randomID(rnd)
+		tree.set(&vertex{link: &graph.Link{ID: id}})
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return uuidLess(ids[i], ids[j]) })
+
+	r, err := partition.NewFullRange(7)
+	c.Assert(err, gc.IsNil)
+	extents := r.Extents()
+
+	var got []uuid.UUID
+	for i := 0; i < len(extents)-1; i++ {
+		tree.ascendRange(extents[i], extents[i+1], func(v *vertex) bool {
+			c.Assert(uuidLess(v.link.ID, extents[i]), gc.Equals, false)
+			c.Assert(uuidLess(v.link.ID, extents[i+1]), gc.Equals, true)
+			got = append(got, v.link.ID)
+			return true
+		})
+	}
+	c.Assert(got, gc.DeepEquals, ids)
+
+	// Ranges that start at an existing ID include it; fn can stop the
+	// iteration early.
+	got = got[:0]
+	tree.ascendRange(ids[10], partition.MaxUUID, func(v *vertex) bool {
+		got = append(got, v.link.ID)
+		return len(got) < 3
+	})
+	c.Assert(got, gc.DeepEquals, ids[10:13])
+}
+
+func (s *LinkTreeTestSuite) TestIterate(c *gc.C) {
+	var (
+		rnd  = rand.New(rand.NewSource(42))
+		tree = newLinkTree()
+		ids  []uuid.UUID
+	)
+	for i := 0; i < 5000; i++ {
+		id := randomID(rnd)
+		tree.set(&vertex{link: &graph.Link{ID: id}})
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return uuidLess(ids[i], ids[j]) })
+
+	// Iterators must visit the same vertices as ascendRange, both for
+	// ranges that start at an existing ID and for ranges between IDs.
+	ranges := [][2]uuid.UUID{
+		{partition.MinUUID, partition.MaxUUID},
+		{ids[0], ids[1]},
+		{ids[100], ids[4000]},
+		{ids[42], ids[42]},
+	}
+	for i := 0; i < 20; i++ {
+		from, to := randomID(rnd), randomID(rnd)
+		if uuidLess(to, from) {
+			from, to = to, from
+		}
+		ranges = append(ranges, [2]uuid.UUID{from, to})
+	}
+
+	for specIndex, r := range ranges {
+		c.Logf("[spec %d] range [%s, %s)", specIndex, r[0], r[1])
+
+		var exp, got []*vertex
+		tree.ascendRange(r[0], r[1], func(v *vertex) bool {
+			exp = append(exp, v)
+			return true
+		})
+		it := tree.iterate(r[0], r[1])
+		for v := it.next(); v != nil; v = it.next() {
+			got = append(got, v)
+		}
+		c.Assert(it.next(), gc.IsNil)
+		c.Assert(got, gc.DeepEquals, exp)
+	}
+
+	// Modifying a clone must not affect a running iterator.
+	it := tree.iterate(partition.MinUUID, partition.MaxUUID)
+	clone := tree.clone()
+	for _, id := range ids[:2500] {
+		clone.remove(id)
+	}
+	var count int
+	for v := it.next(); v != nil; v = it.next() {
+		count++
+	}
+	c.Assert(count, gc.Equals, len(ids))
+}
+
+func (s *LinkTreeTestSuite) TestCloneIsolation(c *gc.C) {
+	var (
+		rnd  = rand.New(rand.NewSource(42))
+		tree = newLinkTree()
+		exp  = make(map[uuid.UUID]*vertex)
+	)
+	for i := 0; i < 5000; i++ {
+		v := &vertex{link: &graph.Link{ID: randomID(rnd)}}
+		tree.set(v)
+		exp[v.link.ID] = v
+	}
+
+	// Modifying a clone must not affect the original tree.
+	clone := tree.clone()
+	cloneExp := make(map[uuid.UUID]*vertex, len(exp))
+	for id, v := range exp {
+		cloneExp[id] = v
+	}
+	var n int
+	for id := range exp {
+		if n++; n%2 == 0 {
+			c.Assert(clone.remove(id), gc.Equals, true)
+			delete(cloneExp, id)
+		} else {
+			v := &vertex{link: &graph.Link{ID: id, ETag: "updated"}}
+			clone.set(v)
+			cloneExp[id] = v
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		v := &vertex{link: &graph.Link{ID: randomID(rnd)}}
+		clone.set(v)
+		cloneExp[v.link.ID] = v
+	}
+
+	assertTreeContents(c, tree, exp)
+	assertTreeContents(c, clone, cloneExp)
+}
+
+func assertTreeContents(c *gc.C, tree *linkTree, exp map[uuid.UUID]*vertex) {
+	c.Assert(tree.len(), gc.Equals, len(exp))
+	for id, v := range exp {
+		c.Assert(tree.get(id), gc.Equals, v)
+	}
+
+	var prev *vertex
+	var count int
+	tree.ascendRange(partition.MinUUID, partition.MaxUUID, func(v *vertex) bool {
+		if prev != nil {
+			c.Assert(uuidLess(prev.link.ID, v.link.ID), gc.Equals, true)
+		}
+		c.Assert(exp[v.link.ID], gc.Equals, v)
+		prev = v
+		count++
+		return true
+	})
+	c.Assert(count, gc.Equals, len(exp))
+
+	if tree.root != nil {
+		assertNodeInvariants(c, tree.root, true)
+	}
+}
+
+// assertNodeInvariants checks the item counts of the subtree rooted at n
+// and returns its height. All leaves of a B-tree must be at the same depth.
+func assertNodeInvariants(c *gc.C, n *btreeNode, isRoot bool) int {
+	c.Assert(len(n.items) <= maxNodeItems, gc.Equals, true)
+	if !isRoot {
+		c.Assert(len(n.items) >= minNodeItems, gc.Equals, true)
+	}
+	if len(n.children) == 0 {
+		return 1
+	}
+
+	c.Assert(n.children, gc.HasLen, len(n.items)+1)
+	height := assertNodeInvariants(c, n.children[0], false)
+	for _, child := range n.children[1:] {
+		c.Assert(assertNodeInvariants(c, child, false), gc.Equals, height)
+	}
+	return height + 1
+}
+
+func randomID(rnd *rand.Rand) uuid.UUID {
+	var id uuid.UUID
+	_, _ = rnd.Read(id[:])
+	return id
+}
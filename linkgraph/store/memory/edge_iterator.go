@@ -1,41 +1,129 @@
 package memory
 
-import "github.com/kyteproject/search-engine/linkgraph/graph"
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+)
 
 // edgeIterator is a graph.EdgeIterator implementation for the in-memory graph.
+// Edges are fetched lazily from nextFn. As edge entries are never modified in
+// place, they can be accessed without locking the graph.
 type edgeIterator struct {
-	s *InMemoryGraph
+	ctx context.Context
 
-	edges    []*graph.Edge
-	curIndex int
+	// nextFn returns the next edge or nil once the iteration is complete.
+	nextFn func() *graph.Edge
+
+	curEdge *graph.Edge
+	lastErr error
+
+	// startCursor is the cursor that the iteration was resumed from.
+	startCursor graph.Cursor
 }
 
 // Next implements graph.EdgeIterator.
 func (i *edgeIterator) Next() bool {
-	if i.curIndex >= len(i.edges) {
+	if i.lastErr != nil {
+		return false
+	}
+	if err := i.ctx.Err(); err != nil {
+		i.lastErr = err
+		return false
+	}
+
+	edge := i.nextFn()
+	if edge == nil {
 		return false
 	}
-	i.curIndex++
+	i.curEdge = edge
 	return true
 }
 
 // Edge implements graph.EdgeIterator.
-func (i edgeIterator) Edge() *graph.Edge {
-	// The edge pointer contents may be overwritten by a graph update; to
-	// avoid data-races we acquire the read lock first and clone the edge
-	i.s.mu.RLock()
+func (i *edgeIterator) Edge() *graph.Edge {
 	edge := new(graph.Edge)
-	*edge = *i.edges[i.curIndex-1]
-	i.s.mu.RUnlock()
+	*edge = *i.curEdge
 	return edge
 }
 
+// Cursor implements graph.EdgeIterator.
+func (i *edgeIterator) Cursor() graph.Cursor {
+	if i.curEdge == nil {
+		return i.startCursor
+	}
+	return graph.NewCursor(i.curEdge.ID)
+}
+
 // Error implements graph.EdgeIterator.
 func (i *edgeIterator) Error() error {
-	return nil
+	return i.lastErr
 }
 
 // Close implements graph.EdgeIterator.
 func (i *edgeIterator) Close() error {
 	return nil
 }
+
+// edgeSliceFn returns a nextFn that yields the edges in list.
+func edgeSliceFn(list []*graph.Edge) func() *graph.Edge {
+	return func() *graph.Edge {
+		if len(list) == 0 {
+			return nil
+		}
+		edge := list[0]
+		list = list[1:]
+		return edge
+	}
+}
+
+// edgeHeap merges a set of edge lists that are sorted by ID. Its entries
+// are non-empty edge lists that form a min-heap ordered by the ID of their
+// first edge.
+type edgeHeap [][]*graph.Edge
+
+// init establishes the heap ordering of h.
+func (h edgeHeap) init() {
+	for i := len(h)/2 - 1; i >= 0; i-- {
+		h.down(i)
+	}
+}
+
+// next removes and returns the edge with the lowest ID or returns nil if
+// all edges have been consumed.
+func (h *edgeHeap) next() *graph.Edge {
+	old := *h
+	if len(old) == 0 {
+		return nil
+	}
+
+	edge := old[0][0]
+	if rest := old[0][1:]; len(rest) != 0 {
+		old[0] = rest
+	} else {
+		last := len(old) - 1
+		old[0] = old[last]
+		old[last] = nil
+		*h = old[:last]
+	}
+	h.down(0)
+	return edge
+}
+
+// down moves the entry at index i towards the leaves of the heap until
+// neither of its children has a lower ID.
+func (h edgeHeap) down(i int) {
+	for {
+		min, left := i, 2*i+1
+		if left < len(h) && uuidLess(h[left][0].ID, h[min][0].ID) {
+			min = left
+		}
+		if right := left + 1; right < len(h) && uuidLess(h[right][0].ID, h[min][0].ID) {
+			min = right
+		}
+		if min == i {
+			return
+		}
+		h[i], h[min] = h[min], h[i]
+		i = min
+	}
+}
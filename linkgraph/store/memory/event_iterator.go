@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"sync"
+)
+
+// eventIterator is a graph.EventIterator implementation for the in-memory
+// graph.
+type eventIterator struct {
+	ctx context.Context
+	s   *InMemoryGraph
+
+	// nextSeq is the sequence number of the next event to be returned.
+	nextSeq uint64
+	curEv   *graph.Event
+	lastErr error
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// Next implements graph.EventIterator.
+func (i *eventIterator) Next() bool {
+	for {
+		if i.lastErr != nil {
+			return false
+		}
+
+		select {
+		case <-i.closeCh:
+			return false
+		default:
+		}
+
+		i.s.mu.RLock()
+		// If the watcher fell behind, skip over any events that have
+		// been dropped from the event log.
+		if i.nextSeq < i.s.eventBase {
+			i.nextSeq = i.s.eventBase
+		}
+		if index := i.nextSeq - i.s.eventBase; index < uint64(len(i.s.events)) {
+			i.curEv = i.s.events[index]
+			i.nextSeq++
+			i.s.mu.RUnlock()
+			return true
+		}
+		waitCh := i.s.eventsCh
+		i.s.mu.RUnlock()
+
+		// Block until a new event is published.
+		select {
+		case <-waitCh:
+		case <-i.closeCh:
+			return false
+		case <-i.ctx.Done():
+			i.lastErr = i.ctx.Err()
+			return false
+		}
+	}
+}
+
+// Event implements graph.EventIterator.
+func (i *eventIterator) Event() *graph.Event {
+	// Events are shared between watchers so hand out a copy.
+	ev := new(graph.Event)
+	*ev = *i.curEv
+	if ev.Link != nil {
+		link := new(graph.Link)
+		*link = *ev.Link
+		ev.Link = link
+	}
+	if ev.Edge != nil {
+		edge := new(graph.Edge)
+		*edge = *ev.Edge
+		ev.Edge = edge
+	}
+	return ev
+}
+
+// Error implements graph.EventIterator.
+func (i *eventIterator) Error() error {
+	return i.lastErr
+}
+
+// Close implements graph.EventIterator.
+func (i *eventIterator) Close() error {
+	i.closeOnce.Do(func() { close(i.closeCh) })
+	return nil
+}
@@ -1,41 +1,77 @@
 package memory
 
-import "github.com/kyteproject/search-engine/linkgraph/graph"
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+)
 
 // linkIterator is a graph.LinkIterator implementation for the in-memory graph.
+// Links are fetched lazily from nextFn. As link entries are never modified in
+// place, they can be accessed without locking the graph.
 type linkIterator struct {
-	s *InMemoryGraph
+	ctx context.Context
 
-	links    []*graph.Link
-	curIndex int
+	// nextFn returns the next link or nil once the iteration is complete.
+	nextFn func() *graph.Link
+
+	curLink *graph.Link
+	lastErr error
+
+	// startCursor is the cursor that the iteration was resumed from.
+	startCursor graph.Cursor
 }
 
 // Next implements graph.LinkIterator.
 func (i *linkIterator) Next() bool {
-	if i.curIndex >= len(i.links) {
+	if i.lastErr != nil {
+		return false
+	}
+	if err := i.ctx.Err(); err != nil {
+		i.lastErr = err
+		return false
+	}
+
+	link := i.nextFn()
+	if link == nil {
 		return false
 	}
-	i.curIndex++
+	i.curLink = link
 	return true
 }
 
 // Link implements graph.LinkIterator.
-func (i linkIterator) Link() *graph.Link {
-	// The link pointer contents may be overwritten by a graph update; to
-	// avoid data-races we acquire the read lock first and clone the link
-	i.s.mu.RLock()
+func (i *linkIterator) Link() *graph.Link {
 	link := new(graph.Link)
-	*link = *i.links[i.curIndex-1]
-	i.s.mu.RUnlock()
+	*link = *i.curLink
 	return link
 }
 
+// Cursor implements graph.LinkIterator.
+func (i *linkIterator) Cursor() graph.Cursor {
+	if i.curLink == nil {
+		return i.startCursor
+	}
+	return graph.NewCursor(i.curLink.ID)
+}
+
 // Error implements graph.LinkIterator.
 func (i *linkIterator) Error() error {
-	return nil
+	return i.lastErr
 }
 
 // Close implements graph.LinkIterator.
 func (i *linkIterator) Close() error {
 	return nil
 }
+
+// linkSliceFn returns a nextFn that yields the links in list.
+func linkSliceFn(list []*graph.Link) func() *graph.Link {
+	return func() *graph.Link {
+		if len(list) == 0 {
+			return nil
+		}
+		link := list[0]
+		list = list[1:]
+		return link
+	}
+}
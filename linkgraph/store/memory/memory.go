@@ -1,102 +1,322 @@
 package memory
 
 import (
+	"bytes"
+	"context"
 	"github.com/google/uuid"
 	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/store/internal/graphlog"
 	"golang.org/x/xerrors"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Compile-time check for ensuring InMemoryGraph implements Graph.
 var _ graph.Graph = (*InMemoryGraph)(nil)
 
-// edgeList contains the slice of edge UUIDs that originate from a link in the graph.
+const (
+	// ctxCheckInterval controls how often long-running scans check whether
+	// their context has been cancelled.
+	ctxCheckInterval = 1024
+
+	// maxRetainedEvents controls the number of past mutation events that
+	// are retained so they can be replayed to new watchers.
+	maxRetainedEvents = 10000
+)
+
+// edgeList contains the slice of edge UUIDs that originate from or point to
+// a link in the graph.
 type edgeList []uuid.UUID
 
+// without returns a copy of the edge list with edgeID removed.
+func (l edgeList) without(edgeID uuid.UUID) edgeList {
+	var newEdgeList edgeList
+	for _, id := range l {
+		if id != edgeID {
+			newEdgeList = append(newEdgeList, id)
+		}
+	}
+	return newEdgeList
+}
+
 // InMemoryGraph implements an in-memory link graph that can be concurrently
 // accessed by multiple clients.
+//
+// Link and edge entries stored in the graph are never modified in place;
+// updates always replace them with a fresh copy. Snapshots thus only need to
+// hold on to the link index, whose nodes are copied on write, and to the
+// alias map, which the graph clones before its next alias change.
+//
+// Iterators observe the graph as it was at the time they were created and
+// are not affected by any subsequent mutations. Links and edges are indexed
+// by a copy-on-write B-tree whose nodes are shared by the graph and its
+// iterators; the graph copies the nodes it modifies while iterators are
+// active instead of cloning the whole index. Iterators thus fetch links and
+// edges lazily without acquiring any locks. Edge iterators merge the
+// ID-sorted edge lists of the links in their partition, which requires one
+// list reference for each link with matching outgoing edges.
 type InMemoryGraph struct {
 	mu sync.RWMutex
 
 	links map[uuid.UUID]*graph.Link
 	edges map[uuid.UUID]*graph.Edge
 
-	linkURLIndex map[string]*graph.Link
-	linkEdgeMap  map[uuid.UUID]edgeList
+	linkURLIndex  map[string]*graph.Link
+	linkInEdgeMap map[uuid.UUID]edgeList
+
+	// linkIndex holds a vertex for each link ordered by link ID. Vertices
+	// also hold the edges that originate from their link so that partition
+	// scans only visit the links and edges within the partition.
+	linkIndex *linkTree
+
+	// indexShared is set when linkIndex is referenced by an iterator. As
+	// iterators are created while holding the read lock, it is accessed
+	// atomically.
+	indexShared int32
+
+	// aliases maps the ID of each alias link to the ID of its canonical
+	// link. Alias chains are always flattened so that the canonical link
+	// is never an alias itself. aliasesShared is set when the map is
+	// referenced by a snapshot.
+	aliases       map[uuid.UUID]uuid.UUID
+	aliasesShared bool
+
+	// events holds the most recent mutation events and eventBase the
+	// sequence number of the first retained event. eventsCh is closed and
+	// replaced each time a new event gets published to wake up watchers.
+	events    []*graph.Event
+	eventBase uint64
+	eventsCh  chan struct{}
+
+	// wal is only set for graphs created by OpenInMemoryGraph.
+	wal *wal
 }
 
 // NewInMemoryGraph creates a new in-memory link graph.
 func NewInMemoryGraph() *InMemoryGraph {
 	return &InMemoryGraph{
-		links:        make(map[uuid.UUID]*graph.Link),
-		edges:        make(map[uuid.UUID]*graph.Edge),
-		linkURLIndex: make(map[string]*graph.Link),
-		linkEdgeMap:  make(map[uuid.UUID]edgeList),
+		links:         make(map[uuid.UUID]*graph.Link),
+		edges:         make(map[uuid.UUID]*graph.Edge),
+		linkURLIndex:  make(map[string]*graph.Link),
+		linkIndex:     newLinkTree(),
+		linkInEdgeMap: make(map[uuid.UUID]edgeList),
+		aliases:       make(map[uuid.UUID]uuid.UUID),
+		eventsCh:      make(chan struct{}),
 	}
 }
 
 // UpsertLink creates a new link or updates and existing link.
-func (s *InMemoryGraph) UpsertLink(link *graph.Link) error {
+func (s *InMemoryGraph) UpsertLink(ctx context.Context, link *graph.Link) error {
+	if err := graph.ValidateLink(link); err != nil {
+		return xerrors.Errorf("upsert link: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkWritable(); err != nil {
+		return xerrors.Errorf("upsert link: %w", err)
+	}
+	if err := s.upsertLink(link); err != nil {
+		return xerrors.Errorf("upsert link: %w", err)
+	}
+	if err := s.logRecords(&graphlog.Record{Type: graphlog.LinkPut, Link: link}); err != nil {
+		return xerrors.Errorf("upsert link: %w", err)
+	}
+	return nil
+}
+
+// UpsertLinks creates or updates a batch of links while holding the write
+// lock only once.
+func (s *InMemoryGraph) UpsertLinks(ctx context.Context, links []*graph.Link) error {
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("upsert links: %w", err)
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.checkWritable(); err != nil {
+		return xerrors.Errorf("upsert links: %w", err)
+	}
+
+	var (
+		errs    []error
+		records []*graphlog.Record
+	)
+	for i, link := range links {
+		if err := graph.ValidateLink(link); err != nil {
+			if errs == nil {
+				errs = make([]error, len(links))
+			}
+			errs[i] = xerrors.Errorf("upsert links: %w", err)
+			continue
+		}
+		if err := s.upsertLink(link); err != nil {
+			if errs == nil {
+				errs = make([]error, len(links))
+			}
+			errs[i] = xerrors.Errorf("upsert links: %w", err)
+			continue
+		}
+		records = append(records, &graphlog.Record{Type: graphlog.LinkPut, Link: link})
+	}
+
+	if err := s.logRecords(records...); err != nil {
+		return xerrors.Errorf("upsert links: %w", err)
+	}
+	if errs != nil {
+		return &graph.BatchError{Errors: errs}
+	}
+	return nil
+}
+
+// upsertLink implements the link upsert logic. The caller must hold the
+// write lock.
+func (s *InMemoryGraph) upsertLink(link *graph.Link) error {
 	// Check if a link with the same URL already exists. If so, convert
-	// this into an update and point the link ID to the existing link
-	// while retaining the most recent RetrievedAt timestamp.
+	// this into an update and point the link ID to the existing link.
+	// The crawl metadata is only replaced if the submitted link is not
+	// older than the stored one so that the most recent RetrievedAt
+	// timestamp is always retained.
 	if existing := s.linkURLIndex[link.URL]; existing != nil {
 		link.ID = existing.ID
-		origTimestamp := existing.RetrievedAt
-		*existing = *link
-		if origTimestamp.After(existing.RetrievedAt) {
-			existing.RetrievedAt = origTimestamp
+		if link.RetrievedAt.Before(existing.RetrievedAt) {
+			*link = *existing
+			return nil
 		}
+
+		s.cloneIfShared()
+		lCopy := new(graph.Link)
+		*lCopy = *link
+		s.linkURLIndex[lCopy.URL] = lCopy
+		s.links[lCopy.ID] = lCopy
+		s.linkIndex.set(&vertex{link: lCopy, edges: s.linkIndex.get(lCopy.ID).edges})
+		s.publish(graph.LinkUpserted, lCopy, nil)
 		return nil
 	}
 
-	// Assign new ID.
-	for {
-		link.ID = uuid.New()
-		if s.links[link.ID] == nil {
-			break
+	// Keep the ID provided by the caller or assign a new one.
+	if link.ID != uuid.Nil {
+		if s.links[link.ID] != nil {
+			return graph.ErrLinkIDInUse
+		}
+	} else {
+		for {
+			link.ID = uuid.New()
+			if s.links[link.ID] == nil {
+				break
+			}
 		}
 	}
+	s.cloneIfShared()
 
 	// Make copy and insert link into map structure.
 	lCopy := new(graph.Link)
 	*lCopy = *link
 	s.linkURLIndex[lCopy.URL] = lCopy
 	s.links[lCopy.ID] = lCopy
+	s.linkIndex.set(&vertex{link: lCopy})
+	s.publish(graph.LinkUpserted, lCopy, nil)
 	return nil
 }
 
 // UpsertEdge creates a new edge or updates an existing edge.
-func (s *InMemoryGraph) UpsertEdge(edge *graph.Edge) error {
+func (s *InMemoryGraph) UpsertEdge(ctx context.Context, edge *graph.Edge) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.checkWritable(); err != nil {
+		return xerrors.Errorf("upsert edge: %w", err)
+	}
+	if err := s.upsertEdge(edge); err != nil {
+		return xerrors.Errorf("upsert edge: %w", err)
+	}
+	if err := s.logRecords(&graphlog.Record{Type: graphlog.EdgePut, Edge: edge}); err != nil {
+		return xerrors.Errorf("upsert edge: %w", err)
+	}
+	return nil
+}
+
+// UpsertEdges creates or updates a batch of edges while holding the write
+// lock only once.
+func (s *InMemoryGraph) UpsertEdges(ctx context.Context, edges []*graph.Edge) error {
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("upsert edges: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkWritable(); err != nil {
+		return xerrors.Errorf("upsert edges: %w", err)
+	}
+
+	var (
+		errs    []error
+		records []*graphlog.Record
+	)
+	for i, edge := range edges {
+		if err := s.upsertEdge(edge); err != nil {
+			if errs == nil {
+				errs = make([]error, len(edges))
+			}
+			errs[i] = xerrors.Errorf("upsert edges: %w", err)
+			continue
+		}
+		records = append(records, &graphlog.Record{Type: graphlog.EdgePut, Edge: edge})
+	}
+
+	if err := s.logRecords(records...); err != nil {
+		return xerrors.Errorf("upsert edges: %w", err)
+	}
+	if errs != nil {
+		return &graph.BatchError{Errors: errs}
+	}
+	return nil
+}
+
+// upsertEdge implements the edge upsert logic. The caller must hold the
+// write lock.
+func (s *InMemoryGraph) upsertEdge(edge *graph.Edge) error {
 	// Verify source and destination links exist
 	_, sourceExists := s.links[edge.Source]
 	_, destinationExists := s.links[edge.Destination]
 	if !sourceExists || !destinationExists {
-		return xerrors.Errorf("upsert edge: %w", graph.ErrUnknownEdgeLinks)
+		return graph.ErrUnknownEdgeLinks
 	}
 
+	s.cloneIfShared()
+
 	// Scan edge list from source
-	for _, edgeID := range s.linkEdgeMap[edge.Source] {
-		existingEdge := s.edges[edgeID]
-		if existingEdge.Source == edge.Source && existingEdge.Destination == edge.Destination {
-			existingEdge.UpdatedAt = time.Now()
-			*edge = *existingEdge
+	for _, existingEdge := range s.linkIndex.get(edge.Source).edges {
+		if existingEdge.Destination == edge.Destination {
+			eCopy := new(graph.Edge)
+			*eCopy = *edge
+			eCopy.ID = existingEdge.ID
+			eCopy.UpdatedAt = time.Now()
+			s.edges[eCopy.ID] = eCopy
+			s.putOutEdge(eCopy)
+			*edge = *eCopy
+			s.publish(graph.EdgeUpserted, nil, eCopy)
 			return nil
 		}
 	}
 
-	// Insert new edge
-	for {
-		edge.ID = uuid.New()
-		if s.edges[edge.ID] == nil {
-			break
+	// Insert new edge, keeping the ID provided by the caller or assigning
+	// a new one.
+	if edge.ID != uuid.Nil {
+		if s.edges[edge.ID] != nil {
+			return graph.ErrEdgeIDInUse
+		}
+	} else {
+		for {
+			edge.ID = uuid.New()
+			if s.edges[edge.ID] == nil {
+				break
+			}
 		}
 	}
 
@@ -106,18 +326,102 @@ func (s *InMemoryGraph) UpsertEdge(edge *graph.Edge) error {
 	*eCopy = *edge
 	s.edges[eCopy.ID] = eCopy
 
-	// Append the edge ID to the list of edges originating from the
-	// edge's source link.
-	s.linkEdgeMap[edge.Source] = append(s.linkEdgeMap[edge.Source], eCopy.ID)
+	// Add the edge to the list of edges originating from the edge's
+	// source link and the list of edges pointing to the edge's
+	// destination link.
+	s.putOutEdge(eCopy)
+	s.linkInEdgeMap[edge.Destination] = append(s.linkInEdgeMap[edge.Destination], eCopy.ID)
+	s.publish(graph.EdgeUpserted, nil, eCopy)
+	return nil
+}
+
+// restoreEdge inserts a copy of edge while preserving its ID and UpdatedAt
+// timestamp. Any existing edge between the same pair of links is replaced.
+// It allows graphs to be rebuilt from a persisted copy.
+func (s *InMemoryGraph) restoreEdge(ctx context.Context, edge *graph.Edge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkWritable(); err != nil {
+		return xerrors.Errorf("restore edge: %w", err)
+	}
+	if s.links[edge.Source] == nil || s.links[edge.Destination] == nil {
+		return xerrors.Errorf("restore edge: %w", graph.ErrUnknownEdgeLinks)
+	}
+
+	s.cloneIfShared()
+
+	// Drop the edge that currently occupies the ID as well as any edge
+	// between the same pair of links.
+	s.dropEdge(edge.ID)
+	for _, existingEdge := range s.linkIndex.get(edge.Source).edges {
+		if existingEdge.Destination == edge.Destination {
+			s.dropEdge(existingEdge.ID)
+			break
+		}
+	}
+
+	eCopy := new(graph.Edge)
+	*eCopy = *edge
+	s.edges[eCopy.ID] = eCopy
+	s.putOutEdge(eCopy)
+	s.linkInEdgeMap[eCopy.Destination] = append(s.linkInEdgeMap[eCopy.Destination], eCopy.ID)
+	s.publish(graph.EdgeUpserted, nil, eCopy)
+
+	if err := s.logRecords(&graphlog.Record{Type: graphlog.EdgePut, Edge: eCopy}); err != nil {
+		return xerrors.Errorf("restore edge: %w", err)
+	}
 	return nil
 }
 
+// dropEdge removes an edge without publishing an event. The caller must
+// hold the write lock and have called cloneIfShared.
+func (s *InMemoryGraph) dropEdge(edgeID uuid.UUID) {
+	edge := s.edges[edgeID]
+	if edge == nil {
+		return
+	}
+	s.removeOutEdge(edge)
+	s.linkInEdgeMap[edge.Destination] = s.linkInEdgeMap[edge.Destination].without(edgeID)
+	delete(s.edges, edgeID)
+}
+
+// putOutEdge adds edge to the vertex of its source link, replacing any edge
+// with the same ID. The caller must hold the write lock and have called
+// cloneIfShared.
+func (s *InMemoryGraph) putOutEdge(edge *graph.Edge) {
+	v := s.linkIndex.get(edge.Source)
+	i := sort.Search(len(v.edges), func(i int) bool { return !uuidLess(v.edges[i].ID, edge.ID) })
+
+	edges := make([]*graph.Edge, 0, len(v.edges)+1)
+	edges = append(edges, v.edges[:i]...)
+	edges = append(edges, edge)
+	if i < len(v.edges) && v.edges[i].ID == edge.ID {
+		i++
+	}
+	edges = append(edges, v.edges[i:]...)
+	s.linkIndex.set(&vertex{link: v.link, edges: edges})
+}
+
+// removeOutEdge removes edge from the vertex of its source link. The caller
+// must hold the write lock and have called cloneIfShared.
+func (s *InMemoryGraph) removeOutEdge(edge *graph.Edge) {
+	v := s.linkIndex.get(edge.Source)
+	edges := make([]*graph.Edge, 0, len(v.edges))
+	for _, other := range v.edges {
+		if other.ID != edge.ID {
+			edges = append(edges, other)
+		}
+	}
+	s.linkIndex.set(&vertex{link: v.link, edges: edges})
+}
+
 // FindLink looks up a link by ID and returns a copy of the link stored in graph.
-func (s *InMemoryGraph) FindLink(id uuid.UUID) (*graph.Link, error) {
+func (s *InMemoryGraph) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	link := s.links[id]
+	link := s.links[s.resolveAlias(id)]
 	if link == nil {
 		return nil, xerrors.Errorf("find link: %w", graph.ErrNotFound)
 	}
@@ -127,71 +431,478 @@ func (s *InMemoryGraph) FindLink(id uuid.UUID) (*graph.Link, error) {
 	return lCopy, nil
 }
 
+// FindLinkByURL looks up a link by URL and returns a copy of the link stored
+// in the graph.
+func (s *InMemoryGraph) FindLinkByURL(ctx context.Context, url string) (*graph.Link, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	link := s.linkURLIndex[url]
+	if link == nil {
+		return nil, xerrors.Errorf("find link by URL: %w", graph.ErrNotFound)
+	}
+	link = s.links[s.resolveAlias(link.ID)]
+
+	lCopy := new(graph.Link)
+	*lCopy = *link
+	return lCopy, nil
+}
+
+// RemoveLink removes the link with the specified ID as well as all edges
+// that originate from or point to it.
+func (s *InMemoryGraph) RemoveLink(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkWritable(); err != nil {
+		return xerrors.Errorf("remove link: %w", err)
+	}
+	link := s.links[id]
+	if link == nil {
+		return xerrors.Errorf("remove link: %w", graph.ErrNotFound)
+	}
+
+	s.cloneIfShared()
+
+	// Drop all edges originating from the link and remove them from the
+	// inbound edge list of their destination link.
+	for _, edge := range s.linkIndex.get(id).edges {
+		s.linkInEdgeMap[edge.Destination] = s.linkInEdgeMap[edge.Destination].without(edge.ID)
+		delete(s.edges, edge.ID)
+		s.publish(graph.EdgeRemoved, nil, edge)
+	}
+	s.linkIndex.remove(id)
+
+	// Drop all edges pointing to the link and remove them from the
+	// outbound edge list of their source link.
+	for _, edgeID := range s.linkInEdgeMap[id] {
+		edge := s.edges[edgeID]
+		s.removeOutEdge(edge)
+		delete(s.edges, edgeID)
+		s.publish(graph.EdgeRemoved, nil, edge)
+	}
+	delete(s.linkInEdgeMap, id)
+
+	// Drop any aliases that involve the link.
+	s.cloneAliasesIfShared()
+	delete(s.aliases, id)
+	for aliasID, canonicalID := range s.aliases {
+		if canonicalID == id {
+			delete(s.aliases, aliasID)
+		}
+	}
+
+	delete(s.linkURLIndex, link.URL)
+	delete(s.links, id)
+	s.publish(graph.LinkRemoved, link, nil)
+
+	if err := s.logRecords(&graphlog.Record{Type: graphlog.LinkRemove, ID: id}); err != nil {
+		return xerrors.Errorf("remove link: %w", err)
+	}
+	return nil
+}
+
+// AddAlias records that the link with aliasID refers to the same document as
+// the link with canonicalID.
+func (s *InMemoryGraph) AddAlias(ctx context.Context, aliasID, canonicalID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkWritable(); err != nil {
+		return xerrors.Errorf("add alias: %w", err)
+	}
+	if s.links[aliasID] == nil || s.links[canonicalID] == nil {
+		return xerrors.Errorf("add alias: %w", graph.ErrNotFound)
+	}
+
+	// Flatten alias chains by pointing the alias to the link at the end
+	// of the chain.
+	canonicalID = s.resolveAlias(canonicalID)
+	if canonicalID == aliasID {
+		return xerrors.Errorf("add alias: %w", graph.ErrAliasCycle)
+	}
+
+	s.cloneAliasesIfShared()
+	s.aliases[aliasID] = canonicalID
+
+	// Any links that were aliases of aliasID now point to canonicalID.
+	for otherID, otherCanonicalID := range s.aliases {
+		if otherCanonicalID == aliasID {
+			s.aliases[otherID] = canonicalID
+		}
+	}
+
+	if err := s.logRecords(&graphlog.Record{Type: graphlog.AliasPut, ID: aliasID, CanonicalID: canonicalID}); err != nil {
+		return xerrors.Errorf("add alias: %w", err)
+	}
+	return nil
+}
+
+// resolveAlias returns the ID of the canonical link for id or id itself if
+// it is not an alias. The caller must hold the read lock.
+func (s *InMemoryGraph) resolveAlias(id uuid.UUID) uuid.UUID {
+	if canonicalID, aliased := s.aliases[id]; aliased {
+		return canonicalID
+	}
+	return id
+}
+
 // Links returns an iterator for the set of links whose IDs belong to the
 // [fromID, toID] range and were retrieved before the provided timestamp.
-func (s *InMemoryGraph) Links(fromID, toID uuid.UUID, retrievedBefore time.Time) (graph.LinkIterator, error) {
-	from, to := fromID.String(), toID.String()
+func (s *InMemoryGraph) Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (graph.LinkIterator, error) {
+	return s.LinksAfter(ctx, fromID, toID, retrievedBefore, "")
+}
+
+// LinksAfter returns an iterator for the set of links whose IDs belong to
+// the [fromID, toID) range, were retrieved before the provided timestamp and
+// are positioned after the provided cursor.
+func (s *InMemoryGraph) LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("links: %w", err)
+	}
+
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, xerrors.Errorf("links: %w", err)
+	}
 
 	s.mu.RLock()
-	var list []*graph.Link
-	for linkID, link := range s.links {
-		if id := linkID.String(); id >= from && id < to && link.RetrievedAt.Before(retrievedBefore) {
-			list = append(list, link)
+	view := s.indexViewLocked()
+	s.mu.RUnlock()
+
+	return linksInRange(ctx, view, fromID, toID, retrievedBefore, after, afterID), nil
+}
+
+// linksInRange returns an iterator for the links in view whose IDs belong to
+// the [fromID, toID) range.
+func linksInRange(ctx context.Context, view *linkTree, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor, afterID uuid.UUID) *linkIterator {
+	// Resume the scan at the cursor position if it lies within the range.
+	if after != "" && uuidLess(fromID, afterID) {
+		fromID = afterID
+	}
+
+	treeIt := view.iterate(fromID, toID)
+	nextFn := func() *graph.Link {
+		for v := treeIt.next(); v != nil; v = treeIt.next() {
+			if (after == "" || v.link.ID != afterID) && v.link.RetrievedAt.Before(retrievedBefore) {
+				return v.link
+			}
 		}
+		return nil
 	}
+	return &linkIterator{ctx: ctx, nextFn: nextFn, startCursor: after}
+}
+
+// LinksDueForCrawl returns an iterator for the set of links whose IDs belong
+// to the [fromID, toID) range, can be crawled and are scheduled to be crawled
+// before the provided timestamp.
+func (s *InMemoryGraph) LinksDueForCrawl(ctx context.Context, fromID, toID uuid.UUID, dueBefore time.Time) (graph.LinkIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("links due for crawl: %w", err)
+	}
+
+	s.mu.RLock()
+	view := s.indexViewLocked()
 	s.mu.RUnlock()
 
-	return &linkIterator{s: s, links: list}, nil
+	// As links are returned in crawl order, the matching links need to be
+	// collected and sorted up front.
+	var (
+		list    []*graph.Link
+		scanned int
+	)
+	view.ascendRange(fromID, toID, func(v *vertex) bool {
+		if scanned++; scanned%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return false
+		}
+		if v.link.Status.IsCrawlable() && v.link.NextCrawlAt.Before(dueBefore) {
+			list = append(list, v.link)
+		}
+		return true
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("links due for crawl: %w", err)
+	}
+
+	sort.Slice(list, func(l, r int) bool {
+		if !list[l].NextCrawlAt.Equal(list[r].NextCrawlAt) {
+			return list[l].NextCrawlAt.Before(list[r].NextCrawlAt)
+		}
+		return uuidLess(list[l].ID, list[r].ID)
+	})
+	return &linkIterator{ctx: ctx, nextFn: linkSliceFn(list)}, nil
 }
 
 // Edges returns an iterator for the set of edges whose source vertex IDs
 // belong to the [fromID, toID) range and were updated before the provided
 // timestamp.
-func (s *InMemoryGraph) Edges(fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
-	from, to := fromID.String(), toID.String()
+func (s *InMemoryGraph) Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return s.EdgesAfter(ctx, fromID, toID, updatedBefore, "")
+}
+
+// EdgesAfter returns an iterator for the set of edges whose source vertex
+// IDs belong to the [fromID, toID) range, were updated before the provided
+// timestamp and are positioned after the provided cursor.
+func (s *InMemoryGraph) EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (graph.EdgeIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
+
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
 
 	s.mu.RLock()
+	view := s.indexViewLocked()
+	s.mu.RUnlock()
 
-	// Iterate links in the graph
-	var list []*graph.Edge
-	for linkID := range s.links {
-		// Skip links that do not belong to the partition we need
-		if id := linkID.String(); id < from || id >= to {
-			continue
+	it, err := edgesInRange(ctx, view, fromID, toID, updatedBefore, after, afterID)
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
+	return it, nil
+}
+
+// edgesInRange returns an iterator that merges the edge lists of the
+// vertices in view whose IDs belong to the [fromID, toID) range.
+func edgesInRange(ctx context.Context, view *linkTree, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor, afterID uuid.UUID) (*edgeIterator, error) {
+	var (
+		h       edgeHeap
+		scanned int
+	)
+	view.ascendRange(fromID, toID, func(v *vertex) bool {
+		if scanned++; scanned%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return false
 		}
 
-		// Iterate the list of edges (via the linkEdgeMap field)
-		for _, edgeID := range s.linkEdgeMap[linkID] {
-			// append edges that satisfy the updated-before-X predicate
-			if edge := s.edges[edgeID]; edge.UpdatedAt.Before(updatedBefore) {
-				list = append(list, edge)
+		// Skip the edges that precede the cursor position.
+		edges := v.edges
+		if after != "" {
+			edges = edges[sort.Search(len(edges), func(i int) bool { return uuidLess(afterID, edges[i].ID) }):]
+		}
+		if len(edges) != 0 {
+			h = append(h, edges)
+		}
+		return true
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h.init()
+
+	nextFn := func() *graph.Edge {
+		for edge := h.next(); edge != nil; edge = h.next() {
+			if edge.UpdatedAt.Before(updatedBefore) {
+				return edge
 			}
 		}
+		return nil
+	}
+	return &edgeIterator{ctx: ctx, nextFn: nextFn, startCursor: after}, nil
+}
+
+// InEdges returns an iterator for the set of edges that point to the
+// specified destination link and were updated before the provided timestamp.
+func (s *InMemoryGraph) InEdges(ctx context.Context, dstID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("in edges: %w", err)
+	}
+
+	s.mu.RLock()
+	var list []*graph.Edge
+	for _, edgeID := range s.linkInEdgeMap[dstID] {
+		if edge := s.edges[edgeID]; edge.UpdatedAt.Before(updatedBefore) {
+			list = append(list, edge)
+		}
 	}
 	s.mu.RUnlock()
 
-	return &edgeIterator{s: s, edges: list}, nil
+	sort.Slice(list, func(l, r int) bool { return uuidLess(list[l].ID, list[r].ID) })
+	return &edgeIterator{ctx: ctx, nextFn: edgeSliceFn(list)}, nil
+}
+
+// CanonicalEdges returns an iterator for the set of edges whose source vertex
+// IDs belong to the [fromID, toID) range and were updated before the provided
+// timestamp. Edges pointing to an alias are rewritten to point to the
+// canonical link instead.
+func (s *InMemoryGraph) CanonicalEdges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("canonical edges: %w", err)
+	}
+
+	// Copy the aliases while holding the lock so that the iterator
+	// observes them at the same point in time as the edges.
+	s.mu.RLock()
+	view := s.indexViewLocked()
+	aliases := make(map[uuid.UUID]uuid.UUID, len(s.aliases))
+	for aliasID, canonicalID := range s.aliases {
+		aliases[aliasID] = canonicalID
+	}
+	s.mu.RUnlock()
+
+	it, err := edgesInRange(ctx, view, fromID, toID, updatedBefore, "", uuid.Nil)
+	if err != nil {
+		return nil, xerrors.Errorf("canonical edges: %w", err)
+	}
+
+	// As edge entries are never modified in place, return rewritten
+	// copies of aliased edges.
+	nextFn := it.nextFn
+	it.nextFn = func() *graph.Edge {
+		edge := nextFn()
+		if edge == nil {
+			return nil
+		}
+		if canonicalID, aliased := aliases[edge.Destination]; aliased {
+			eCopy := new(graph.Edge)
+			*eCopy = *edge
+			eCopy.Destination = canonicalID
+			return eCopy
+		}
+		return edge
+	}
+	return it, nil
 }
 
 // RemoveStaleEdges removes any edge that originates from the specified link ID
 // and was updated before the specified timestamp.
-func (s *InMemoryGraph) RemoveStaleEdges(fromID uuid.UUID, updatedBefore time.Time) error {
+func (s *InMemoryGraph) RemoveStaleEdges(ctx context.Context, fromID uuid.UUID, updatedBefore time.Time) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.checkWritable(); err != nil {
+		return xerrors.Errorf("remove stale edges: %w", err)
+	}
+	s.cloneIfShared()
+
 	// Iterate list of edges that originate from the specified source link
-	var newEdgeList edgeList
-	for _, edgeID := range s.linkEdgeMap[fromID] {
-		edge := s.edges[edgeID]
-		if edge.UpdatedAt.Before(updatedBefore) {
-			delete(s.edges, edgeID)
-			continue
+	if v := s.linkIndex.get(fromID); v != nil {
+		var newEdgeList []*graph.Edge
+		for _, edge := range v.edges {
+			if edge.UpdatedAt.Before(updatedBefore) {
+				s.linkInEdgeMap[edge.Destination] = s.linkInEdgeMap[edge.Destination].without(edge.ID)
+				delete(s.edges, edge.ID)
+				s.publish(graph.EdgeRemoved, nil, edge)
+				continue
+			}
+
+			newEdgeList = append(newEdgeList, edge)
 		}
 
-		newEdgeList = append(newEdgeList, edgeID)
+		// Replace edge list or origin link with the filtered edge list
+		s.linkIndex.set(&vertex{link: v.link, edges: newEdgeList})
 	}
 
-	// Replace edge list or origin link with the filtered edge list
-	s.linkEdgeMap[fromID] = newEdgeList
+	if err := s.logRecords(&graphlog.Record{Type: graphlog.StaleEdgesRemove, ID: fromID, Before: updatedBefore}); err != nil {
+		return xerrors.Errorf("remove stale edges: %w", err)
+	}
 	return nil
 }
+
+// Snapshot returns a read-only, point-in-time view of the graph. Taking a
+// snapshot is cheap as the snapshot shares the graph maps; the cost of
+// copying them is deferred until the graph is next mutated.
+func (s *InMemoryGraph) Snapshot(ctx context.Context) (graph.Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("snapshot: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.snapshotLocked(), nil
+}
+
+// snapshotLocked returns a snapshot that shares the link index and the
+// aliases with the graph. The caller must hold the write lock.
+func (s *InMemoryGraph) snapshotLocked() *snapshot {
+	s.aliasesShared = true
+	return &snapshot{
+		links:   s.indexViewLocked(),
+		aliases: s.aliases,
+	}
+}
+
+// Watch returns an iterator for the stream of mutation events that occur
+// after the provided timestamp. Only the most recent maxRetainedEvents events
+// are available for replay.
+func (s *InMemoryGraph) Watch(ctx context.Context, since time.Time) (graph.EventIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("watch: %w", err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	first := sort.Search(len(s.events), func(i int) bool {
+		return s.events[i].Timestamp.After(since)
+	})
+	return &eventIterator{
+		ctx:     ctx,
+		s:       s,
+		nextSeq: s.eventBase + uint64(first),
+		closeCh: make(chan struct{}),
+	}, nil
+}
+
+// publish appends a mutation event to the event log and wakes up any
+// blocked watchers. The link and edge arguments must point to entries that
+// are no longer modified. The caller must hold the write lock.
+func (s *InMemoryGraph) publish(evType graph.EventType, link *graph.Link, edge *graph.Edge) {
+	s.events = append(s.events, &graph.Event{
+		Type:      evType,
+		Timestamp: time.Now(),
+		Link:      link,
+		Edge:      edge,
+	})
+
+	// Trim the event log once it grows to twice its maximum size so that
+	// the cost of trimming is amortized across publish calls.
+	if len(s.events) >= 2*maxRetainedEvents {
+		numDropped := len(s.events) - maxRetainedEvents
+		s.events = append([]*graph.Event(nil), s.events[numDropped:]...)
+		s.eventBase += uint64(numDropped)
+	}
+
+	close(s.eventsCh)
+	s.eventsCh = make(chan struct{})
+}
+
+// indexViewLocked returns the link index and marks it as shared so that it
+// gets cloned before the next mutation. The returned index can thus be
+// accessed without holding any locks. The caller must hold the read lock.
+func (s *InMemoryGraph) indexViewLocked() *linkTree {
+	atomic.StoreInt32(&s.indexShared, 1)
+	return s.linkIndex
+}
+
+// cloneIfShared replaces the link index with a copy-on-write clone if it is
+// currently referenced by a snapshot or an iterator. The caller must hold
+// the write lock.
+func (s *InMemoryGraph) cloneIfShared() {
+	if atomic.LoadInt32(&s.indexShared) != 0 {
+		s.linkIndex = s.linkIndex.clone()
+		atomic.StoreInt32(&s.indexShared, 0)
+	}
+}
+
+// cloneAliasesIfShared replaces the alias map with a private copy if it is
+// currently referenced by a snapshot. The caller must hold the write lock.
+func (s *InMemoryGraph) cloneAliasesIfShared() {
+	if !s.aliasesShared {
+		return
+	}
+
+	aliases := make(map[uuid.UUID]uuid.UUID, len(s.aliases))
+	for aliasID, canonicalID := range s.aliases {
+		aliases[aliasID] = canonicalID
+	}
+	s.aliases = aliases
+	s.aliasesShared = false
+}
+
+// uuidLess returns true if UUID a sorts before UUID b.
+func uuidLess(a, b uuid.UUID) bool {
+	return bytes.Compare(a[:], b[:]) < 0
+}
@@ -1,8 +1,16 @@
 package memory
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
 	"github.com/kyteproject/search-engine/linkgraph/graph/graphtest"
+	"github.com/kyteproject/search-engine/linkgraph/partition"
+	"sync"
 	"testing"
+	"time"
 
 	gc "gopkg.in/check.v1"
 )
@@ -20,3 +28,194 @@ type InMemoryGraphTestSuite struct {
 func (s *InMemoryGraphTestSuite) SetUpTest(c *gc.C) {
 	s.SetGraph(NewInMemoryGraph())
 }
+
+var _ = gc.Suite(new(IteratorTestSuite))
+
+type IteratorTestSuite struct{}
+
+func (s *IteratorTestSuite) TestIteratorsObserveStableView(c *gc.C) {
+	g := NewInMemoryGraph()
+	populate(c, g, 500, 2000)
+	exp := graphtest.DumpGraph(c, g)
+
+	linkIt, err := g.Links(context.TODO(), partition.MinUUID, partition.MaxUUID, graphtest.MaxTime)
+	c.Assert(err, gc.IsNil)
+	edgeIt, err := g.Edges(context.TODO(), partition.MinUUID, partition.MaxUUID, graphtest.MaxTime)
+	c.Assert(err, gc.IsNil)
+
+	// Consume part of each iterator before mutating the graph so that
+	// mutations affect both visited and pending entries.
+	got := graphtest.GraphDump{Aliases: exp.Aliases}
+	for i := 0; i < 100 && linkIt.Next(); i++ {
+		got.Links = append(got.Links, linkIt.Link())
+	}
+	for i := 0; i < 500 && edgeIt.Next(); i++ {
+		got.Edges = append(got.Edges, edgeIt.Edge())
+	}
+
+	ctx := context.TODO()
+	for i, link := range exp.Links {
+		switch i % 4 {
+		case 0:
+			c.Assert(g.RemoveLink(ctx, link.ID), gc.IsNil)
+		case 1:
+			c.Assert(g.UpsertLink(ctx, &graph.Link{URL: link.URL, ETag: "updated", RetrievedAt: time.Now()}), gc.IsNil)
+		case 2:
+			c.Assert(g.RemoveStaleEdges(ctx, link.ID, graphtest.MaxTime), gc.IsNil)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		newLink := &graph.Link{URL: fmt.Sprintf("https://example.com/new/%d", i)}
+		c.Assert(g.UpsertLink(ctx, newLink), gc.IsNil)
+		c.Assert(g.UpsertEdge(ctx, &graph.Edge{Source: newLink.ID, Destination: exp.Links[3].ID}), gc.IsNil)
+	}
+
+	for linkIt.Next() {
+		got.Links = append(got.Links, linkIt.Link())
+	}
+	c.Assert(linkIt.Error(), gc.IsNil)
+	for edgeIt.Next() {
+		got.Edges = append(got.Edges, edgeIt.Edge())
+	}
+	c.Assert(edgeIt.Error(), gc.IsNil)
+
+	graphtest.AssertSameGraph(c, got, exp)
+}
+
+func (s *IteratorTestSuite) TestConcurrentMutationsDuringIteration(c *gc.C) {
+	g := NewInMemoryGraph()
+	populate(c, g, 500, 2000)
+
+	r, err := partition.NewFullRange(8)
+	c.Assert(err, gc.IsNil)
+	extents := r.Extents()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctx := context.TODO()
+		for i := 0; i < 1000; i++ {
+			link := &graph.Link{URL: fmt.Sprintf("https://example.com/writer/%d", i%100)}
+			if err := g.UpsertLink(ctx, link); err != nil {
+				c.Error(err)
+				return
+			}
+			if err := g.UpsertEdge(ctx, &graph.Edge{Source: link.ID, Destination: link.ID}); err != nil {
+				c.Error(err)
+				return
+			}
+			if i%10 == 0 {
+				if err := g.RemoveLink(ctx, link.ID); err != nil {
+					c.Error(err)
+					return
+				}
+			}
+		}
+	}()
+
+	// Each iterator must yield the entries of its partition in ID order
+	// while the graph is being mutated.
+	for round := 0; round < 20; round++ {
+		for p := 0; p < len(extents)-1; p++ {
+			linkIt, err := g.Links(context.TODO(), extents[p], extents[p+1], graphtest.MaxTime)
+			c.Assert(err, gc.IsNil)
+			edgeIt, err := g.Edges(context.TODO(), extents[p], extents[p+1], graphtest.MaxTime)
+			c.Assert(err, gc.IsNil)
+
+			var prev []byte
+			for linkIt.Next() {
+				id := linkIt.Link().ID
+				c.Assert(uuidLess(id, extents[p]) || !uuidLess(id, extents[p+1]), gc.Equals, false)
+				c.Assert(prev == nil || bytes.Compare(prev, id[:]) < 0, gc.Equals, true)
+				prev = id[:]
+			}
+			c.Assert(linkIt.Error(), gc.IsNil)
+
+			prev = nil
+			for edgeIt.Next() {
+				edge := edgeIt.Edge()
+				c.Assert(uuidLess(edge.Source, extents[p]) || !uuidLess(edge.Source, extents[p+1]), gc.Equals, false)
+				c.Assert(prev == nil || bytes.Compare(prev, edge.ID[:]) < 0, gc.Equals, true)
+				prev = edge.ID[:]
+			}
+			c.Assert(edgeIt.Error(), gc.IsNil)
+		}
+	}
+	wg.Wait()
+}
+
+func BenchmarkLinksPartitionScan(b *testing.B) {
+	benchmarkPartitionScan(b, func(g *InMemoryGraph, from, to uuid.UUID) (int, error) {
+		it, err := g.Links(context.TODO(), from, to, graphtest.MaxTime)
+		if err != nil {
+			return 0, err
+		}
+		var count int
+		for it.Next() {
+			count++
+		}
+		return count, it.Close()
+	})
+}
+
+func BenchmarkEdgesPartitionScan(b *testing.B) {
+	benchmarkPartitionScan(b, func(g *InMemoryGraph, from, to uuid.UUID) (int, error) {
+		it, err := g.Edges(context.TODO(), from, to, graphtest.MaxTime)
+		if err != nil {
+			return 0, err
+		}
+		var count int
+		for it.Next() {
+			count++
+		}
+		return count, it.Close()
+	})
+}
+
+// benchmarkPartitionScan measures the time it takes to scan a graph with
+// 100k links and 500k edges when it is split into a varying number of
+// partitions.
+func benchmarkPartitionScan(b *testing.B, scanFn func(g *InMemoryGraph, from, to uuid.UUID) (int, error)) {
+	const numLinks, numEdges = 100000, 500000
+
+	g := NewInMemoryGraph()
+	links := make([]*graph.Link, numLinks)
+	for i := range links {
+		links[i] = &graph.Link{URL: fmt.Sprintf("https://example.com/%d", i)}
+	}
+	if err := g.UpsertLinks(context.TODO(), links); err != nil {
+		b.Fatal(err)
+	}
+	edges := make([]*graph.Edge, numEdges)
+	for i := range edges {
+		edges[i] = &graph.Edge{
+			Source:      links[i%numLinks].ID,
+			Destination: links[(i*7+i/numLinks+1)%numLinks].ID,
+		}
+	}
+	if err := g.UpsertEdges(context.TODO(), edges); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, numPartitions := range []int{1, 16, 256} {
+		b.Run(fmt.Sprintf("partitions-%d", numPartitions), func(b *testing.B) {
+			r, err := partition.NewFullRange(numPartitions)
+			if err != nil {
+				b.Fatal(err)
+			}
+			extents := r.Extents()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				// Each iteration scans a single partition so that the
+				// results are comparable across partition counts.
+				p := n % numPartitions
+				if _, err := scanFn(g, extents[p], extents[p+1]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
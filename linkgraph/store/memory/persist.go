@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"bufio"
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/store/internal/graphlog"
+	"golang.org/x/xerrors"
+	"io"
+	"sync/atomic"
+)
+
+var (
+	// ErrCorruptLog is returned when a snapshot or write-ahead log is
+	// incomplete or contains records that cannot be replayed.
+	ErrCorruptLog = graphlog.ErrCorrupt
+
+	// ErrClosed is returned when attempting to mutate a closed persistent
+	// graph.
+	ErrClosed = xerrors.New("graph is closed")
+
+	// ErrNotPersistent is returned when attempting to checkpoint a graph
+	// that was not created by OpenInMemoryGraph.
+	ErrNotPersistent = xerrors.New("graph is not persistent")
+)
+
+// SaveTo writes a snapshot of the graph contents to w. The snapshot uses the
+// same versioned binary format as the graph write-ahead log and is
+// terminated by an end marker so that LoadFrom can detect truncated
+// snapshots. Mutations are not blocked while the snapshot is written.
+func (s *InMemoryGraph) SaveTo(w io.Writer) error {
+	s.mu.Lock()
+	snap := s.snapshotLocked()
+	s.mu.Unlock()
+
+	if err := writeSnapshot(w, snap); err != nil {
+		return xerrors.Errorf("save: %w", err)
+	}
+	return nil
+}
+
+// LoadFrom replaces the graph contents with a snapshot that was written by
+// SaveTo. The graph is left untouched if the snapshot cannot be read in its
+// entirety. Loading a snapshot does not emit any mutation events and is not
+// supported for graphs created by OpenInMemoryGraph.
+func (s *InMemoryGraph) LoadFrom(r io.Reader) error {
+	if s.wal != nil {
+		return xerrors.Errorf("load: cannot replace the contents of a persistent graph")
+	}
+
+	loaded, err := readSnapshot(r)
+	if err != nil {
+		return xerrors.Errorf("load: %w", err)
+	}
+
+	s.mu.Lock()
+	s.links = loaded.links
+	s.edges = loaded.edges
+	s.linkURLIndex = loaded.linkURLIndex
+	s.linkIndex = loaded.linkIndex
+	atomic.StoreInt32(&s.indexShared, 0)
+	s.linkInEdgeMap = loaded.linkInEdgeMap
+	s.aliases = loaded.aliases
+	s.aliasesShared = false
+	s.mu.Unlock()
+	return nil
+}
+
+// writeSnapshot writes the contents of snap to w followed by an end marker.
+func writeSnapshot(w io.Writer, snap *snapshot) error {
+	bw := bufio.NewWriter(w)
+	if err := graphlog.WriteSnapshot(context.Background(), bw, snap); err != nil {
+		return err
+	}
+	if _, err := bw.Write(graphlog.AppendFrame(nil, &graphlog.Record{Type: graphlog.End})); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// readSnapshot replays a snapshot written by writeSnapshot into a new graph.
+func readSnapshot(r io.Reader) (*InMemoryGraph, error) {
+	lr, err := graphlog.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	g := NewInMemoryGraph()
+	for {
+		rec, err := lr.Next()
+		if err == io.EOF || err == graphlog.ErrTornFrame {
+			return nil, xerrors.Errorf("snapshot truncated at offset %d: %w", lr.Offset(), ErrCorruptLog)
+		} else if err != nil {
+			return nil, xerrors.Errorf("read record at offset %d: %w", lr.Offset(), err)
+		}
+
+		if rec.Type == graphlog.End {
+			return g, nil
+		}
+		if err = g.applyRecord(context.Background(), rec); err != nil {
+			return nil, xerrors.Errorf("replay record at offset %d: %v: %w", lr.Offset(), err, ErrCorruptLog)
+		}
+	}
+}
+
+// applyRecord applies the mutation described by r to the graph.
+func (s *InMemoryGraph) applyRecord(ctx context.Context, r *graphlog.Record) error {
+	switch r.Type {
+	case graphlog.LinkPut:
+		return s.UpsertLink(ctx, r.Link)
+	case graphlog.LinkRemove:
+		return s.RemoveLink(ctx, r.ID)
+	case graphlog.AliasPut:
+		return s.AddAlias(ctx, r.ID, r.CanonicalID)
+	case graphlog.EdgePut:
+		return s.restoreEdge(ctx, r.Edge)
+	case graphlog.StaleEdgesRemove:
+		return s.RemoveStaleEdges(ctx, r.ID, r.Before)
+	default:
+		return xerrors.Errorf("unexpected record type %d", r.Type)
+	}
+}
@@ -0,0 +1,329 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/graph/graphtest"
+	"github.com/kyteproject/search-engine/linkgraph/partition"
+	"github.com/kyteproject/search-engine/linkgraph/store/internal/graphlog"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(PersistentGraphTestSuite))
+var _ = gc.Suite(new(PersistTestSuite))
+
+// PersistentGraphTestSuite runs the graph test suite against a graph that
+// is backed by a write-ahead log.
+type PersistentGraphTestSuite struct {
+	graphtest.SuiteBase
+	g *InMemoryGraph
+}
+
+func (s *PersistentGraphTestSuite) SetUpTest(c *gc.C) {
+	g, err := OpenInMemoryGraph(PersistConfig{Dir: c.MkDir()})
+	c.Assert(err, gc.IsNil)
+	s.g = g
+	s.SetGraph(g)
+}
+
+func (s *PersistentGraphTestSuite) TearDownTest(c *gc.C) {
+	c.Assert(s.g.Close(), gc.IsNil)
+}
+
+type PersistTestSuite struct{}
+
+func (s *PersistTestSuite) TestSaveLoadRoundTrip(c *gc.C) {
+	g := NewInMemoryGraph()
+	populate(c, g, 5000, 20000)
+
+	var buf bytes.Buffer
+	c.Assert(g.SaveTo(&buf), gc.IsNil)
+
+	// Loading replaces any existing contents.
+	restored := NewInMemoryGraph()
+	populate(c, restored, 10, 10)
+	c.Assert(restored.LoadFrom(bytes.NewReader(buf.Bytes())), gc.IsNil)
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, restored), graphtest.DumpGraph(c, g))
+
+	// Snapshots of the restored graph must not be affected by mutations.
+	snap, err := restored.Snapshot(context.TODO())
+	c.Assert(err, gc.IsNil)
+	c.Assert(restored.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com/new"}), gc.IsNil)
+	c.Assert(countLinks(c, snap), gc.Equals, len(graphtest.DumpGraph(c, g).Links))
+	c.Assert(snap.Close(), gc.IsNil)
+}
+
+func (s *PersistTestSuite) TestSaveLoadEmptyGraph(c *gc.C) {
+	var buf bytes.Buffer
+	c.Assert(NewInMemoryGraph().SaveTo(&buf), gc.IsNil)
+
+	g := NewInMemoryGraph()
+	c.Assert(g.LoadFrom(&buf), gc.IsNil)
+	c.Assert(graphtest.DumpGraph(c, g).Links, gc.HasLen, 0)
+}
+
+func (s *PersistTestSuite) TestLoadTruncatedSnapshot(c *gc.C) {
+	g := NewInMemoryGraph()
+	populate(c, g, 100, 200)
+
+	var buf bytes.Buffer
+	c.Assert(g.SaveTo(&buf), gc.IsNil)
+	data := buf.Bytes()
+
+	target := NewInMemoryGraph()
+	populate(c, target, 10, 10)
+	before := graphtest.DumpGraph(c, target)
+
+	for _, size := range []int{0, 5, int(graphlog.HeaderSize), len(data) / 2, len(data) - 1} {
+		err := target.LoadFrom(bytes.NewReader(data[:size]))
+		c.Assert(xerrors.Is(err, ErrCorruptLog), gc.Equals, true, gc.Commentf("size %d: %v", size, err))
+	}
+
+	// Failed loads must leave the graph untouched.
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, target), before)
+}
+
+func (s *PersistTestSuite) TestLoadUnsupportedVersion(c *gc.C) {
+	var buf bytes.Buffer
+	c.Assert(NewInMemoryGraph().SaveTo(&buf), gc.IsNil)
+	data := buf.Bytes()
+	data[graphlog.HeaderSize-4]++
+
+	err := NewInMemoryGraph().LoadFrom(bytes.NewReader(data))
+	c.Assert(xerrors.Is(err, ErrCorruptLog), gc.Equals, true)
+}
+
+func (s *PersistTestSuite) TestReopenReplaysLog(c *gc.C) {
+	dir := c.MkDir()
+	g := openGraph(c, PersistConfig{Dir: dir})
+	populate(c, g, 2000, 5000)
+	before := graphtest.DumpGraph(c, g)
+	c.Assert(g.Close(), gc.IsNil)
+
+	g = openGraph(c, PersistConfig{Dir: dir})
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, g), before)
+
+	// Mutations after a restart are persisted as well.
+	c.Assert(g.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com/new"}), gc.IsNil)
+	before = graphtest.DumpGraph(c, g)
+	c.Assert(g.Close(), gc.IsNil)
+
+	g = openGraph(c, PersistConfig{Dir: dir})
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, g), before)
+	c.Assert(g.Close(), gc.IsNil)
+}
+
+func (s *PersistTestSuite) TestCheckpoint(c *gc.C) {
+	dir := c.MkDir()
+	g := openGraph(c, PersistConfig{Dir: dir, SyncWrites: true})
+	populate(c, g, 1000, 2000)
+	c.Assert(g.Checkpoint(), gc.IsNil)
+
+	// Mutations after the checkpoint end up in the log.
+	links := graphtest.DumpGraph(c, g).Links
+	c.Assert(g.RemoveLink(context.TODO(), links[0].ID), gc.IsNil)
+	c.Assert(g.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com/new"}), gc.IsNil)
+	c.Assert(g.Checkpoint(), gc.IsNil)
+	c.Assert(g.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com/newer"}), gc.IsNil)
+	before := graphtest.DumpGraph(c, g)
+	c.Assert(g.Close(), gc.IsNil)
+
+	// Only the most recent checkpoint and the log written since must be
+	// retained.
+	checkpoints, segments, err := listFiles(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checkpoints, gc.HasLen, 1)
+	c.Assert(segments, gc.DeepEquals, []uint64{checkpoints[0]})
+
+	g = openGraph(c, PersistConfig{Dir: dir})
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, g), before)
+	c.Assert(g.Close(), gc.IsNil)
+}
+
+func (s *PersistTestSuite) TestPeriodicCheckpoint(c *gc.C) {
+	dir := c.MkDir()
+	g := openGraph(c, PersistConfig{Dir: dir, CheckpointInterval: 10 * time.Millisecond})
+	populate(c, g, 100, 200)
+	before := graphtest.DumpGraph(c, g)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		checkpoints, _, err := listFiles(dir)
+		c.Assert(err, gc.IsNil)
+		if len(checkpoints) != 0 {
+			break
+		}
+		c.Assert(time.Now().Before(deadline), gc.Equals, true, gc.Commentf("timed out waiting for checkpoint"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(g.Close(), gc.IsNil)
+
+	g = openGraph(c, PersistConfig{Dir: dir})
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, g), before)
+	c.Assert(g.Close(), gc.IsNil)
+}
+
+func (s *PersistTestSuite) TestTornLogTail(c *gc.C) {
+	dir := c.MkDir()
+	g := openGraph(c, PersistConfig{Dir: dir})
+	populate(c, g, 100, 200)
+	before := graphtest.DumpGraph(c, g)
+	c.Assert(g.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com/new"}), gc.IsNil)
+	c.Assert(g.Close(), gc.IsNil)
+
+	// Simulate a crash while appending the last record.
+	_, segments, err := listFiles(dir)
+	c.Assert(err, gc.IsNil)
+	last := segmentPath(dir, segments[len(segments)-1])
+	data, err := ioutil.ReadFile(last)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ioutil.WriteFile(last, data[:len(data)-3], 0644), gc.IsNil)
+
+	g = openGraph(c, PersistConfig{Dir: dir})
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, g), before)
+	c.Assert(g.Close(), gc.IsNil)
+
+	// The torn record must have been discarded for good.
+	g = openGraph(c, PersistConfig{Dir: dir})
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, g), before)
+	c.Assert(g.Close(), gc.IsNil)
+}
+
+func (s *PersistTestSuite) TestCorruptRecordInsideLastSegment(c *gc.C) {
+	dir := c.MkDir()
+	g := openGraph(c, PersistConfig{Dir: dir})
+	populate(c, g, 100, 200)
+	c.Assert(g.Close(), gc.IsNil)
+
+	// Flip a bit in the first record of the last segment; the records
+	// that follow it must not be discarded as if they were a torn tail.
+	_, segments, err := listFiles(dir)
+	c.Assert(err, gc.IsNil)
+	last := segmentPath(dir, segments[len(segments)-1])
+	data, err := ioutil.ReadFile(last)
+	c.Assert(err, gc.IsNil)
+	data[graphlog.HeaderSize+10] ^= 0xff
+	c.Assert(ioutil.WriteFile(last, data, 0644), gc.IsNil)
+
+	_, err = OpenInMemoryGraph(PersistConfig{Dir: dir})
+	c.Assert(xerrors.Is(err, ErrCorruptLog), gc.Equals, true, gc.Commentf("got error: %v", err))
+
+	after, err := ioutil.ReadFile(last)
+	c.Assert(err, gc.IsNil)
+	c.Assert(after, gc.DeepEquals, data, gc.Commentf("log segment was modified"))
+}
+
+func (s *PersistTestSuite) TestLeftoverTempFilesAreRemoved(c *gc.C) {
+	dir := c.MkDir()
+	tmpPath := checkpointPath(dir, 42) + tmpFileSuffix
+	c.Assert(ioutil.WriteFile(tmpPath, []byte("partial"), 0644), gc.IsNil)
+
+	g := openGraph(c, PersistConfig{Dir: dir})
+	c.Assert(g.Close(), gc.IsNil)
+	_, err := os.Stat(tmpPath)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
+
+func (s *PersistTestSuite) TestMutationsAfterClose(c *gc.C) {
+	dir := c.MkDir()
+	g := openGraph(c, PersistConfig{Dir: dir})
+	populate(c, g, 10, 10)
+	before := graphtest.DumpGraph(c, g)
+	c.Assert(g.Close(), gc.IsNil)
+	c.Assert(g.Close(), gc.IsNil)
+
+	err := g.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com/new"})
+	c.Assert(xerrors.Is(err, ErrClosed), gc.Equals, true)
+	err = g.Checkpoint()
+	c.Assert(xerrors.Is(err, ErrClosed), gc.Equals, true)
+
+	// Reads are still served from memory.
+	graphtest.AssertSameGraph(c, graphtest.DumpGraph(c, g), before)
+}
+
+func (s *PersistTestSuite) TestNonPersistentGraph(c *gc.C) {
+	g := NewInMemoryGraph()
+	c.Assert(xerrors.Is(g.Checkpoint(), ErrNotPersistent), gc.Equals, true)
+	c.Assert(g.Close(), gc.IsNil)
+
+	// Loading a snapshot into a persistent graph is not supported.
+	var buf bytes.Buffer
+	c.Assert(g.SaveTo(&buf), gc.IsNil)
+	pg := openGraph(c, PersistConfig{Dir: filepath.Join(c.MkDir(), "graph")})
+	c.Assert(pg.LoadFrom(&buf), gc.NotNil)
+	c.Assert(pg.Close(), gc.IsNil)
+}
+
+func openGraph(c *gc.C, cfg PersistConfig) *InMemoryGraph {
+	g, err := OpenInMemoryGraph(cfg)
+	c.Assert(err, gc.IsNil)
+	return g
+}
+
+// populate fills g with random links and edges that exercise every record
+// type of the log format.
+func populate(c *gc.C, g *InMemoryGraph, numLinks, numEdges int) {
+	ctx := context.TODO()
+	rnd := rand.New(rand.NewSource(42))
+	now := time.Now()
+
+	links := make([]*graph.Link, numLinks)
+	for i := range links {
+		links[i] = &graph.Link{
+			URL:          fmt.Sprintf("https://example.com/%d", i),
+			RetrievedAt:  now.Add(-time.Duration(rnd.Intn(1000)) * time.Minute),
+			ETag:         fmt.Sprintf("etag-%d", i),
+			LastModified: now.Add(-time.Hour),
+			ContentHash:  fmt.Sprintf("hash-%d", i),
+			HTTPStatus:   200,
+			FailureCount: i % 3,
+			Status:       graph.LinkStatus(i % 3),
+			NextCrawlAt:  now.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	c.Assert(g.UpsertLinks(ctx, links), gc.IsNil)
+
+	edges := make([]*graph.Edge, numEdges)
+	for i := range edges {
+		edges[i] = &graph.Edge{
+			Source:      links[rnd.Intn(numLinks)].ID,
+			Destination: links[rnd.Intn(numLinks)].ID,
+			AnchorText:  fmt.Sprintf("anchor-%d", i),
+			Nofollow:    i%2 == 0,
+			Sponsored:   i%3 == 0,
+			UGC:         i%5 == 0,
+			Weight:      rnd.Float64(),
+		}
+	}
+	c.Assert(g.UpsertEdges(ctx, edges), gc.IsNil)
+
+	if numLinks < 10 {
+		return
+	}
+	c.Assert(g.AddAlias(ctx, links[1].ID, links[2].ID), gc.IsNil)
+	c.Assert(g.AddAlias(ctx, links[3].ID, links[1].ID), gc.IsNil)
+	c.Assert(g.RemoveLink(ctx, links[4].ID), gc.IsNil)
+	c.Assert(g.RemoveStaleEdges(ctx, links[5].ID, now.Add(time.Hour)), gc.IsNil)
+}
+
+func countLinks(c *gc.C, snap graph.Snapshot) int {
+	linkIt, err := snap.Links(context.TODO(), partition.MinUUID, partition.MaxUUID, graphtest.MaxTime)
+	c.Assert(err, gc.IsNil)
+
+	var count int
+	for linkIt.Next() {
+		count++
+	}
+	c.Assert(linkIt.Error(), gc.IsNil)
+	c.Assert(linkIt.Close(), gc.IsNil)
+	return count
+}
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+// snapshot is a graph.Snapshot implementation for the in-memory graph. It
+// shares the link index and the alias map with the graph the snapshot was
+// taken from; the graph copies both before modifying them.
+type snapshot struct {
+	links   *linkTree
+	aliases map[uuid.UUID]uuid.UUID
+}
+
+// FindLink implements graph.Snapshot.
+func (s *snapshot) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	if canonicalID, aliased := s.aliases[id]; aliased {
+		id = canonicalID
+	}
+	v := s.links.get(id)
+	if v == nil {
+		return nil, xerrors.Errorf("find link: %w", graph.ErrNotFound)
+	}
+
+	lCopy := new(graph.Link)
+	*lCopy = *v.link
+	return lCopy, nil
+}
+
+// Links implements graph.Snapshot.
+func (s *snapshot) Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (graph.LinkIterator, error) {
+	return s.LinksAfter(ctx, fromID, toID, retrievedBefore, "")
+}
+
+// LinksAfter implements graph.Snapshot.
+func (s *snapshot) LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("links: %w", err)
+	}
+
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, xerrors.Errorf("links: %w", err)
+	}
+	return linksInRange(ctx, s.links, fromID, toID, retrievedBefore, after, afterID), nil
+}
+
+// Edges implements graph.Snapshot.
+func (s *snapshot) Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return s.EdgesAfter(ctx, fromID, toID, updatedBefore, "")
+}
+
+// EdgesAfter implements graph.Snapshot.
+func (s *snapshot) EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (graph.EdgeIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
+
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
+
+	it, err := edgesInRange(ctx, s.links, fromID, toID, updatedBefore, after, afterID)
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
+	return it, nil
+}
+
+// Close implements graph.Snapshot.
+func (s *snapshot) Close() error {
+	return nil
+}
@@ -0,0 +1,463 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/kyteproject/search-engine/linkgraph/store/internal/graphlog"
+	"golang.org/x/xerrors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	checkpointFilePrefix = "checkpoint-"
+	segmentFilePrefix    = "wal-"
+	tmpFileSuffix        = ".tmp"
+)
+
+// PersistConfig encapsulates the settings for an InMemoryGraph that is
+// backed by a write-ahead log.
+type PersistConfig struct {
+	// Dir is the directory where the checkpoint and write-ahead log files
+	// are stored. It is created if it does not exist.
+	Dir string
+
+	// CheckpointInterval controls how often the graph contents are written
+	// to a checkpoint file so that the write-ahead log can be discarded.
+	// If zero, checkpoints are only created by calling Checkpoint.
+	CheckpointInterval time.Duration
+
+	// SyncWrites flushes the write-ahead log to stable storage before each
+	// mutation returns. When disabled, a host crash may lose the most
+	// recent mutations; a process crash does not.
+	SyncWrites bool
+}
+
+// wal maintains the checkpoint and write-ahead log files of a persistent
+// InMemoryGraph.
+//
+// The write-ahead log is split into numbered segments. A checkpoint file
+// with sequence number N contains the graph state captured when segment N
+// was opened, so recovering a graph requires loading the most recent
+// checkpoint and replaying the segments numbered N or higher.
+type wal struct {
+	cfg PersistConfig
+
+	// The following fields are guarded by the graph write lock.
+	f       *os.File
+	seq     uint64
+	buf     []byte
+	closed  bool
+	failErr error
+
+	// checkpointMu prevents concurrent checkpoints.
+	checkpointMu sync.Mutex
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// OpenInMemoryGraph creates an in-memory link graph whose contents are
+// persisted to cfg.Dir. The most recent checkpoint and the write-ahead log
+// written since are replayed to restore the graph contents; a record at the
+// end of the log that was not completely written, e.g. due to a crash, is
+// discarded.
+//
+// Mutation events are not persisted: Watch only reports mutations that occur
+// after the graph was opened. Close must be called to release the log
+// files. A directory must not be opened by more than one graph at a time.
+func OpenInMemoryGraph(cfg PersistConfig) (*InMemoryGraph, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, xerrors.Errorf("open in-memory graph: %w", err)
+	}
+
+	g, seq, err := recoverGraph(cfg.Dir)
+	if err != nil {
+		return nil, xerrors.Errorf("open in-memory graph: %w", err)
+	}
+
+	w := &wal{cfg: cfg, stopCh: make(chan struct{})}
+	if w.f, err = createSegment(cfg.Dir, seq); err != nil {
+		return nil, xerrors.Errorf("open in-memory graph: %w", err)
+	}
+	w.seq = seq
+	g.wal = w
+
+	if cfg.CheckpointInterval > 0 {
+		w.wg.Add(1)
+		go g.checkpointPeriodically()
+	}
+	return g, nil
+}
+
+// recoverGraph restores the graph stored in dir and returns it along with
+// the sequence number of the next log segment.
+func recoverGraph(dir string) (*InMemoryGraph, uint64, error) {
+	checkpoints, segments, err := listFiles(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		g     = NewInMemoryGraph()
+		first uint64
+	)
+	if len(checkpoints) != 0 {
+		first = checkpoints[len(checkpoints)-1]
+		if g, err = loadCheckpoint(dir, first); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	next := first
+	for i, seq := range segments {
+		if seq < first {
+			continue
+		}
+		if err = replaySegment(g, dir, seq, i == len(segments)-1); err != nil {
+			return nil, 0, err
+		}
+		next = seq + 1
+	}
+
+	// Files that precede the checkpoint are left behind if the process
+	// crashes before a checkpoint completes its cleanup.
+	removeFilesBefore(dir, first, checkpoints, segments)
+
+	// Watch must not report the mutations that were replayed.
+	g.events, g.eventBase = nil, 0
+	return g, next, nil
+}
+
+// loadCheckpoint reads the checkpoint with the specified sequence number.
+func loadCheckpoint(dir string, seq uint64) (*InMemoryGraph, error) {
+	f, err := os.Open(checkpointPath(dir, seq))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	g, err := readSnapshot(f)
+	if err != nil {
+		return nil, xerrors.Errorf("load checkpoint %d: %w", seq, err)
+	}
+	return g, nil
+}
+
+// replaySegment applies the records of a log segment to g. A torn record is
+// only expected at the end of the last segment and gets truncated; records
+// that are corrupt in any other way fail the replay.
+func replaySegment(g *InMemoryGraph, dir string, seq uint64, last bool) error {
+	f, err := os.OpenFile(segmentPath(dir, seq), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	lr, err := graphlog.NewReader(f)
+	if err != nil {
+		return xerrors.Errorf("replay log segment %d: %w", seq, err)
+	}
+	for {
+		r, err := lr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err == graphlog.ErrTornFrame {
+			if !last {
+				return xerrors.Errorf("replay log segment %d: torn record at offset %d: %w", seq, lr.Offset(), ErrCorruptLog)
+			}
+			if err = f.Truncate(lr.Offset()); err != nil {
+				return err
+			}
+			return f.Sync()
+		} else if err != nil {
+			return xerrors.Errorf("replay log segment %d at offset %d: %w", seq, lr.Offset(), err)
+		}
+
+		if err = g.applyRecord(context.Background(), r); err != nil {
+			return xerrors.Errorf("replay log segment %d at offset %d: %v: %w", seq, lr.Offset(), err, ErrCorruptLog)
+		}
+	}
+}
+
+// listFiles returns the sorted sequence numbers of the checkpoint and log
+// segment files in dir. Leftover temporary files are removed.
+func listFiles(dir string) (checkpoints, segments []uint64, err error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, tmpFileSuffix) {
+			if err = os.Remove(filepath.Join(dir, name)); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		var list *[]uint64
+		switch {
+		case strings.HasPrefix(name, checkpointFilePrefix):
+			list, name = &checkpoints, strings.TrimPrefix(name, checkpointFilePrefix)
+		case strings.HasPrefix(name, segmentFilePrefix):
+			list, name = &segments, strings.TrimPrefix(name, segmentFilePrefix)
+		default:
+			continue
+		}
+		if seq, err := strconv.ParseUint(name, 10, 64); err == nil {
+			*list = append(*list, seq)
+		}
+	}
+
+	sort.Slice(checkpoints, func(i, j int) bool { return checkpoints[i] < checkpoints[j] })
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return checkpoints, segments, nil
+}
+
+// removeFilesBefore removes the checkpoints and log segments whose sequence
+// numbers are lower than seq. Errors are ignored as the files are no longer
+// needed for recovering the graph.
+func removeFilesBefore(dir string, seq uint64, checkpoints, segments []uint64) {
+	for _, other := range checkpoints {
+		if other < seq {
+			_ = os.Remove(checkpointPath(dir, other))
+		}
+	}
+	for _, other := range segments {
+		if other < seq {
+			_ = os.Remove(segmentPath(dir, other))
+		}
+	}
+}
+
+func checkpointPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d", checkpointFilePrefix, seq))
+}
+
+func segmentPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d", segmentFilePrefix, seq))
+}
+
+// createSegment creates an empty log segment with the specified sequence
+// number. The segment is written to a temporary file first so that a crash
+// cannot leave behind a segment with a partial header.
+func createSegment(dir string, seq uint64) (*os.File, error) {
+	path := segmentPath(dir, seq)
+	f, err := os.OpenFile(path+tmpFileSuffix, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = f.Write(graphlog.Header()); err == nil {
+		if err = f.Sync(); err == nil {
+			if err = os.Rename(f.Name(), path); err == nil {
+				err = syncDir(dir)
+			}
+		}
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// syncDir flushes the directory entries of dir to stable storage.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// checkWritable returns an error if the graph cannot accept mutations. The
+// caller must hold the write lock.
+func (s *InMemoryGraph) checkWritable() error {
+	if s.wal == nil {
+		return nil
+	}
+	if s.wal.closed {
+		return ErrClosed
+	}
+	return s.wal.failErr
+}
+
+// logRecords appends records to the write-ahead log of a persistent graph.
+// Once the log cannot be written, the graph rejects any further mutations
+// as the log no longer reflects its contents. The caller must hold the
+// write lock.
+func (s *InMemoryGraph) logRecords(records ...*graphlog.Record) error {
+	w := s.wal
+	if w == nil || len(records) == 0 {
+		return nil
+	}
+
+	w.buf = w.buf[:0]
+	for _, r := range records {
+		w.buf = graphlog.AppendFrame(w.buf, r)
+	}
+
+	if _, err := w.f.Write(w.buf); err != nil {
+		w.failErr = xerrors.Errorf("write log: %w", err)
+		return w.failErr
+	}
+	if !w.cfg.SyncWrites {
+		return nil
+	}
+	if err := w.f.Sync(); err != nil {
+		w.failErr = xerrors.Errorf("sync log: %w", err)
+		return w.failErr
+	}
+	return nil
+}
+
+// Checkpoint writes the graph contents to a checkpoint file and discards the
+// write-ahead log up to that point. Mutations are only blocked while the
+// log is switched to a new segment.
+func (s *InMemoryGraph) Checkpoint() error {
+	w := s.wal
+	if w == nil {
+		return xerrors.Errorf("checkpoint: %w", ErrNotPersistent)
+	}
+
+	w.checkpointMu.Lock()
+	defer w.checkpointMu.Unlock()
+
+	s.mu.Lock()
+	if err := s.checkWritable(); err != nil {
+		s.mu.Unlock()
+		return xerrors.Errorf("checkpoint: %w", err)
+	}
+	snap := s.snapshotLocked()
+	seq, err := s.rotateLog()
+	s.mu.Unlock()
+	if err != nil {
+		return xerrors.Errorf("checkpoint: %w", err)
+	}
+
+	if err = writeCheckpoint(w.cfg.Dir, seq, snap); err != nil {
+		return xerrors.Errorf("checkpoint: %w", err)
+	}
+
+	checkpoints, segments, err := listFiles(w.cfg.Dir)
+	if err != nil {
+		return xerrors.Errorf("checkpoint: %w", err)
+	}
+	removeFilesBefore(w.cfg.Dir, seq, checkpoints, segments)
+	return nil
+}
+
+// rotateLog switches the write-ahead log to a new segment and returns its
+// sequence number. The caller must hold the write lock.
+func (s *InMemoryGraph) rotateLog() (uint64, error) {
+	w := s.wal
+
+	// The current segment must be flushed before any records are written
+	// to its successor; otherwise, a crash could lose records in the
+	// middle of the log.
+	if err := w.f.Sync(); err != nil {
+		w.failErr = xerrors.Errorf("sync log: %w", err)
+		return 0, w.failErr
+	}
+	f, err := createSegment(w.cfg.Dir, w.seq+1)
+	if err != nil {
+		return 0, err
+	}
+
+	_ = w.f.Close()
+	w.f = f
+	w.seq++
+	return w.seq, nil
+}
+
+// writeCheckpoint atomically writes the contents of snap to the checkpoint
+// file with the specified sequence number.
+func writeCheckpoint(dir string, seq uint64, snap *snapshot) error {
+	path := checkpointPath(dir, seq)
+	f, err := os.OpenFile(path+tmpFileSuffix, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err = writeSnapshot(f, snap); err == nil {
+		if err = f.Sync(); err == nil {
+			err = f.Close()
+		}
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	if err = os.Rename(f.Name(), path); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return syncDir(dir)
+}
+
+// checkpointPeriodically creates a checkpoint every CheckpointInterval until
+// the graph is closed.
+func (s *InMemoryGraph) checkpointPeriodically() {
+	defer s.wal.wg.Done()
+
+	ticker := time.NewTicker(s.wal.cfg.CheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// Failed checkpoints are retried on the next tick; the
+			// write-ahead log remains intact in the meantime.
+			_ = s.Checkpoint()
+		case <-s.wal.stopCh:
+			return
+		}
+	}
+}
+
+// Close flushes the write-ahead log of a persistent graph and releases the
+// underlying file. Any further mutations fail with ErrClosed while reads
+// continue to be served from memory. Calling Close on a graph created by
+// NewInMemoryGraph is a no-op.
+func (s *InMemoryGraph) Close() error {
+	w := s.wal
+	if w == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	if w.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.stopCh)
+
+	var err error
+	if w.failErr == nil {
+		err = w.f.Sync()
+	}
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	s.mu.Unlock()
+
+	w.wg.Wait()
+	return err
+}
@@ -0,0 +1,61 @@
+package sqlite
+
+import (
+	"context"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/xerrors"
+)
+
+// classifiedError associates an error returned by the database driver with
+// one of the graph package errors while still allowing callers to access
+// the original error.
+type classifiedError struct {
+	err  error
+	kind error
+}
+
+// Error implements the error interface.
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the original error.
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether target matches the graph error assigned to e.
+func (e *classifiedError) Is(target error) bool {
+	return target == e.kind
+}
+
+// mapError classifies errors caused by lock contention as graph.ErrConflict.
+// Any other error is returned unchanged.
+func mapError(err error) error {
+	if err == nil || xerrors.Is(err, context.Canceled) || xerrors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	var sqliteErr sqlite3.Error
+	if xerrors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+		// The database remained locked by another connection for longer
+		// than the busy timeout.
+		return &classifiedError{err: err, kind: graph.ErrConflict}
+	}
+	return err
+}
+
+// isForeignKeyViolationError returns true if err indicates a foreign key
+// constraint violation.
+func isForeignKeyViolationError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return xerrors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+}
+
+// isPrimaryKeyViolationError returns true if err indicates that a row with
+// the same primary key already exists.
+func isPrimaryKeyViolationError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return xerrors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
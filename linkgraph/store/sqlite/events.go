@@ -0,0 +1,248 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"sync"
+	"time"
+)
+
+var (
+	appendEventQuery = `
+		INSERT INTO graph_events (event_type, payload, created_at) VALUES (?1, ?2, ?3)`
+	eventsAfterQuery = `
+		SELECT seq, event_type, payload, created_at FROM graph_events
+		WHERE seq > ?1 AND created_at > ?2
+		ORDER BY seq
+		LIMIT ?3`
+	pruneEventsQuery = `
+		DELETE FROM graph_events WHERE created_at < ?1`
+)
+
+const (
+	// eventPollInterval controls how often watchers poll the graph_events
+	// table for new events once they have caught up.
+	eventPollInterval = 100 * time.Millisecond
+
+	// eventPageSize is the maximum number of events fetched by each poll.
+	eventPageSize = 512
+)
+
+// Watch returns an iterator for the stream of mutation events that occur
+// after the provided timestamp. Events are read from the graph_events table
+// which is populated by the same transactions that mutate the graph.
+//
+// Unlike CockroachDB, SQLite serializes write transactions so sequence
+// numbers become visible in commit order and watchers never need to re-scan
+// events they have already returned.
+func (c *SQLiteGraph) Watch(ctx context.Context, since time.Time) (graph.EventIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("watch: %w", err)
+	}
+
+	return &eventIterator{
+		ctx:     ctx,
+		db:      c.db,
+		since:   toDBTime(since),
+		closeCh: make(chan struct{}),
+	}, nil
+}
+
+// PruneEvents removes any events that were recorded before the provided
+// timestamp from the graph_events table.
+func (c *SQLiteGraph) PruneEvents(ctx context.Context, olderThan time.Time) error {
+	if _, err := c.db.ExecContext(ctx, pruneEventsQuery, toDBTime(olderThan)); err != nil {
+		return xerrors.Errorf("prune events: %w", mapError(err))
+	}
+	return nil
+}
+
+// appendEvents records a set of events in the graph_events table as part of
+// the provided transaction.
+func appendEvents(ctx context.Context, tx *sql.Tx, events ...*graph.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.PrepareContext(ctx, appendEventQuery)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = stmt.Close() }()
+
+	now := toDBTime(time.Now())
+	for _, ev := range events {
+		var payload []byte
+		if ev.Link != nil {
+			payload, err = json.Marshal(ev.Link)
+		} else {
+			payload, err = json.Marshal(ev.Edge)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err = stmt.ExecContext(ctx, int(ev.Type), string(payload), now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkEvent returns a new event of the specified type for a link.
+func linkEvent(evType graph.EventType, link *graph.Link) *graph.Event {
+	return &graph.Event{Type: evType, Link: link}
+}
+
+// edgeEvent returns a new event of the specified type for an edge.
+func edgeEvent(evType graph.EventType, edge *graph.Edge) *graph.Event {
+	return &graph.Event{Type: evType, Edge: edge}
+}
+
+// edgeEvents consumes and closes a set of rows whose columns match
+// edgeColumns and returns an event of the specified type for each edge.
+func edgeEvents(evType graph.EventType, rows *sql.Rows) ([]*graph.Event, error) {
+	defer func() { _ = rows.Close() }()
+
+	var events []*graph.Event
+	for rows.Next() {
+		edge, err := scanEdge(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, edgeEvent(evType, edge))
+	}
+	return events, rows.Err()
+}
+
+// eventIterator is a graph.EventIterator implementation for the SQLite graph.
+type eventIterator struct {
+	ctx   context.Context
+	db    *sql.DB
+	since int64
+
+	// lastSeq is the sequence number of the most recent event returned
+	// so far.
+	lastSeq int64
+
+	pending []*graph.Event
+	curEv   *graph.Event
+	lastErr error
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// Next implements graph.EventIterator.
+func (i *eventIterator) Next() bool {
+	for {
+		if i.lastErr != nil {
+			return false
+		}
+
+		select {
+		case <-i.closeCh:
+			return false
+		default:
+		}
+
+		if err := i.ctx.Err(); err != nil {
+			i.lastErr = err
+			return false
+		}
+
+		if len(i.pending) != 0 {
+			i.curEv, i.pending = i.pending[0], i.pending[1:]
+			return true
+		}
+
+		numRows, err := i.fetchPage()
+		if err != nil {
+			i.lastErr = mapError(err)
+			return false
+		}
+		if numRows != 0 {
+			continue
+		}
+
+		select {
+		case <-time.After(eventPollInterval):
+		case <-i.closeCh:
+			return false
+		case <-i.ctx.Done():
+			i.lastErr = i.ctx.Err()
+			return false
+		}
+	}
+}
+
+// fetchPage fetches the next page of events and appends them to the pending
+// list.
+func (i *eventIterator) fetchPage() (int, error) {
+	rows, err := i.db.QueryContext(i.ctx, eventsAfterQuery, i.lastSeq, i.since, eventPageSize)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var numRows int
+	for rows.Next() {
+		var (
+			seq       int64
+			evType    int
+			payload   []byte
+			createdAt int64
+		)
+		if err = rows.Scan(&seq, &evType, &payload, &createdAt); err != nil {
+			return numRows, err
+		}
+		numRows++
+		i.lastSeq = seq
+
+		ev, err := decodeEvent(graph.EventType(evType), payload)
+		if err != nil {
+			return numRows, err
+		}
+		ev.Timestamp = fromDBTime(createdAt)
+		i.pending = append(i.pending, ev)
+	}
+	return numRows, rows.Err()
+}
+
+// decodeEvent unmarshals the payload of an event with the specified type.
+func decodeEvent(evType graph.EventType, payload []byte) (*graph.Event, error) {
+	ev := &graph.Event{Type: evType}
+	switch evType {
+	case graph.LinkUpserted, graph.LinkRemoved:
+		ev.Link = new(graph.Link)
+		if err := json.Unmarshal(payload, ev.Link); err != nil {
+			return nil, err
+		}
+	case graph.EdgeUpserted, graph.EdgeRemoved:
+		ev.Edge = new(graph.Edge)
+		if err := json.Unmarshal(payload, ev.Edge); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, xerrors.Errorf("unknown event type %d", evType)
+	}
+	return ev, nil
+}
+
+// Event implements graph.EventIterator.
+func (i *eventIterator) Event() *graph.Event {
+	return i.curEv
+}
+
+// Error implements graph.EventIterator.
+func (i *eventIterator) Error() error {
+	return i.lastErr
+}
+
+// Close implements graph.EventIterator.
+func (i *eventIterator) Close() error {
+	i.closeOnce.Do(func() { close(i.closeCh) })
+	return nil
+}
@@ -0,0 +1,136 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+)
+
+// linkIterator is a graph.LinkIterator implementation for the SQLite graph.
+type linkIterator struct {
+	ctx         context.Context
+	rows        *sql.Rows
+	lastErr     error
+	latchedLink *graph.Link
+	startCursor graph.Cursor
+}
+
+// Next implements graph.LinkIterator.
+func (i *linkIterator) Next() bool {
+	if i.lastErr != nil {
+		return false
+	}
+
+	// The database driver closes the rows asynchronously when the
+	// context is cancelled so check it explicitly.
+	if err := i.ctx.Err(); err != nil {
+		i.lastErr = err
+		return false
+	}
+
+	if !i.rows.Next() {
+		i.lastErr = mapError(i.rows.Err())
+		return false
+	}
+
+	l, err := scanLink(i.rows)
+	if err != nil {
+		i.lastErr = mapError(err)
+		return false
+	}
+
+	i.latchedLink = l
+	return true
+}
+
+// Error implements graph.LinkIterator.
+func (i *linkIterator) Error() error {
+	return i.lastErr
+}
+
+// Close implements graph.LinkIterator.
+func (i *linkIterator) Close() error {
+	err := i.rows.Close()
+	if err != nil {
+		return xerrors.Errorf("link iterator: %w", err)
+	}
+	return nil
+}
+
+// Link implements graph.LinkIterator.
+func (i *linkIterator) Link() *graph.Link {
+	return i.latchedLink
+}
+
+// Cursor implements graph.LinkIterator.
+func (i *linkIterator) Cursor() graph.Cursor {
+	if i.latchedLink == nil {
+		return i.startCursor
+	}
+	return graph.NewCursor(i.latchedLink.ID)
+}
+
+// edgeIterator is a graph.EdgeIterator implementation for the SQLite graph.
+type edgeIterator struct {
+	ctx         context.Context
+	rows        *sql.Rows
+	lastErr     error
+	latchedEdge *graph.Edge
+	startCursor graph.Cursor
+}
+
+// Next implements graph.EdgeIterator.
+func (i *edgeIterator) Next() bool {
+	if i.lastErr != nil {
+		return false
+	}
+
+	// The database driver closes the rows asynchronously when the
+	// context is cancelled so check it explicitly.
+	if err := i.ctx.Err(); err != nil {
+		i.lastErr = err
+		return false
+	}
+
+	if !i.rows.Next() {
+		i.lastErr = mapError(i.rows.Err())
+		return false
+	}
+
+	e, err := scanEdge(i.rows)
+	if err != nil {
+		i.lastErr = mapError(err)
+		return false
+	}
+
+	i.latchedEdge = e
+	return true
+}
+
+// Error implements graph.EdgeIterator.
+func (i *edgeIterator) Error() error {
+	return i.lastErr
+}
+
+// Close implements graph.EdgeIterator.
+func (i *edgeIterator) Close() error {
+	err := i.rows.Close()
+	if err != nil {
+		return xerrors.Errorf("edge iterator: %w", err)
+	}
+	return nil
+}
+
+// Edge implements graph.EdgeIterator.
+func (i *edgeIterator) Edge() *graph.Edge {
+	return i.latchedEdge
+}
+
+// Cursor implements graph.EdgeIterator.
+func (i *edgeIterator) Cursor() graph.Cursor {
+	if i.latchedEdge == nil {
+		return i.startCursor
+	}
+	return graph.NewCursor(i.latchedEdge.ID)
+}
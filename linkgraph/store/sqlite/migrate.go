@@ -0,0 +1,116 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"golang.org/x/xerrors"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// migrationFS holds the schema migrations. The files follow the naming
+// scheme of the golang-migrate tool so that they can also be applied or
+// rolled back with its sqlite3 driver.
+//
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+var (
+	migrationNameRegex = regexp.MustCompile(`^(\d+)_\w+\.up\.sql$`)
+
+	// The schema_migrations table layout matches the one used by the
+	// golang-migrate tool.
+	createMigrationsTableQuery = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (version uint64, dirty bool);
+		CREATE UNIQUE INDEX IF NOT EXISTS version_unique ON schema_migrations (version);`
+	schemaVersionQuery    = "SELECT version, dirty FROM schema_migrations LIMIT 1"
+	clearVersionQuery     = "DELETE FROM schema_migrations"
+	setSchemaVersionQuery = "INSERT INTO schema_migrations (version, dirty) VALUES (?1, false)"
+)
+
+// ErrDirtySchema is returned when the database schema was left in an
+// inconsistent state by a migration that failed to apply and needs to be
+// fixed manually.
+var ErrDirtySchema = xerrors.New("database schema is dirty")
+
+// migration describes a single schema migration.
+type migration struct {
+	version uint64
+	name    string
+}
+
+// upMigrations returns the list of up migrations sorted by version.
+func upMigrations() ([]migration, error) {
+	entries, err := migrationFS.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var list []migration
+	for _, entry := range entries {
+		match := migrationNameRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, migration{version: version, name: entry.Name()})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].version < list[j].version })
+	return list, nil
+}
+
+// migrate brings the database schema up to date by applying any pending up
+// migrations. Each migration is applied in its own transaction.
+func migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, createMigrationsTableQuery); err != nil {
+		return xerrors.Errorf("migrate: %w", err)
+	}
+
+	var (
+		current uint64
+		dirty   bool
+	)
+	err := db.QueryRowContext(ctx, schemaVersionQuery).Scan(&current, &dirty)
+	if err != nil && err != sql.ErrNoRows {
+		return xerrors.Errorf("migrate: %w", err)
+	}
+	if dirty {
+		return xerrors.Errorf("migrate: version %d: %w", current, ErrDirtySchema)
+	}
+
+	list, err := upMigrations()
+	if err != nil {
+		return xerrors.Errorf("migrate: %w", err)
+	}
+	for _, m := range list {
+		if m.version <= current {
+			continue
+		}
+
+		stmts, err := migrationFS.ReadFile(path.Join("migrations", m.name))
+		if err != nil {
+			return xerrors.Errorf("migrate: %w", err)
+		}
+		err = withTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, string(stmts)); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, clearVersionQuery); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, setSchemaVersionQuery, m.version)
+			return err
+		})
+		if err != nil {
+			return xerrors.Errorf("migrate: apply %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS edges (
+    id TEXT PRIMARY KEY,
+    src TEXT NOT NULL REFERENCES links(id) ON DELETE CASCADE,
+    dst TEXT NOT NULL REFERENCES links(id) ON DELETE CASCADE,
+    updated_at INTEGER NOT NULL,
+    anchor_text TEXT NOT NULL DEFAULT '',
+    nofollow INTEGER NOT NULL DEFAULT 0,
+    sponsored INTEGER NOT NULL DEFAULT 0,
+    ugc INTEGER NOT NULL DEFAULT 0,
+    weight REAL NOT NULL DEFAULT 0,
+    CONSTRAINT edge_links UNIQUE(src, dst)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS edges_dst_idx ON edges (dst);
\ No newline at end of file
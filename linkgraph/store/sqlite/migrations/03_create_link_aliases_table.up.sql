@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS link_aliases (
+    alias TEXT PRIMARY KEY REFERENCES links(id) ON DELETE CASCADE,
+    canonical TEXT NOT NULL REFERENCES links(id) ON DELETE CASCADE
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS link_aliases_canonical_idx ON link_aliases (canonical);
\ No newline at end of file
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS graph_events (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_type INTEGER NOT NULL,
+    payload TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS graph_events_created_at_idx ON graph_events (created_at);
\ No newline at end of file
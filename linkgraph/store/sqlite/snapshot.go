@@ -0,0 +1,95 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+var (
+	// The sqlite3 driver ignores the ReadOnly transaction option and the
+	// _txlock DSN parameter applies to every transaction it starts, so the
+	// snapshot manages its read transaction manually. SQLite only acquires
+	// the WAL read mark once the first statement executes, which is why the
+	// snapshot issues a dummy read right after BEGIN.
+	beginSnapshotQuery    = "BEGIN DEFERRED"
+	pinSnapshotQuery      = "SELECT COUNT(*) FROM sqlite_master"
+	rollbackSnapshotQuery = "ROLLBACK"
+)
+
+// snapshot is a graph.Snapshot implementation for the SQLite graph. It keeps
+// a read transaction open on a dedicated connection; in WAL mode such a
+// transaction observes the database as it was when the transaction started.
+type snapshot struct {
+	conn *sql.Conn
+}
+
+// Snapshot returns a read-only, point-in-time view of the graph. The snapshot
+// holds on to a database connection until it is closed and prevents the
+// write-ahead log from being checkpointed past the snapshot point.
+func (c *SQLiteGraph) Snapshot(ctx context.Context) (graph.Snapshot, error) {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("snapshot: %w", mapError(err))
+	}
+
+	if _, err = conn.ExecContext(ctx, beginSnapshotQuery); err != nil {
+		_ = conn.Close()
+		return nil, xerrors.Errorf("snapshot: %w", mapError(err))
+	}
+	var numObjects int
+	if err = conn.QueryRowContext(ctx, pinSnapshotQuery).Scan(&numObjects); err != nil {
+		_, _ = conn.ExecContext(context.Background(), rollbackSnapshotQuery)
+		_ = conn.Close()
+		return nil, xerrors.Errorf("snapshot: %w", mapError(err))
+	}
+	return &snapshot{conn: conn}, nil
+}
+
+// FindLink implements graph.Snapshot.
+func (s *snapshot) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	link, err := scanLink(s.conn.QueryRowContext(ctx, findLinkQuery, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, xerrors.Errorf("find link: %w", graph.ErrNotFound)
+		}
+		return nil, xerrors.Errorf("find link: %w", mapError(err))
+	}
+	return link, nil
+}
+
+// Links implements graph.Snapshot.
+func (s *snapshot) Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (graph.LinkIterator, error) {
+	return s.LinksAfter(ctx, fromID, toID, retrievedBefore, "")
+}
+
+// LinksAfter implements graph.Snapshot.
+func (s *snapshot) LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	return linksAfter(ctx, s.conn, fromID, toID, retrievedBefore, after)
+}
+
+// Edges implements graph.Snapshot.
+func (s *snapshot) Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return s.EdgesAfter(ctx, fromID, toID, updatedBefore, "")
+}
+
+// EdgesAfter implements graph.Snapshot.
+func (s *snapshot) EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (graph.EdgeIterator, error) {
+	return edgesAfter(ctx, s.conn, fromID, toID, updatedBefore, after)
+}
+
+// Close implements graph.Snapshot. Any iterators obtained from the snapshot
+// must be closed first.
+func (s *snapshot) Close() error {
+	_, err := s.conn.ExecContext(context.Background(), rollbackSnapshotQuery)
+	if cErr := s.conn.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return xerrors.Errorf("snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,709 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/store/internal/pruner"
+	"golang.org/x/xerrors"
+	"time"
+
+	// Register the sqlite3 database/sql driver.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	// linkColumns and edgeColumns list the columns that are read back by
+	// the scanLink and scanEdge helpers.
+	linkColumns = "id, url, retrieved_at, etag, last_modified, content_hash, http_status, failure_count, status, next_crawl_at"
+	edgeColumns = "id, src, dst, updated_at, anchor_text, nofollow, sponsored, ugc, weight"
+
+	// If insert url is duplicate -> update retrieved_at to max of the original and submitted
+	// and only overwrite the crawl metadata if the submitted values are not older than the
+	// stored ones. SQLite lacks GREATEST; its multi-argument MAX is the scalar equivalent.
+	upsertLinkQuery = `
+		INSERT INTO links (` + linkColumns + `)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10)
+		ON CONFLICT (url) DO UPDATE SET
+			etag=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.etag ELSE links.etag END,
+			last_modified=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.last_modified ELSE links.last_modified END,
+			content_hash=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.content_hash ELSE links.content_hash END,
+			http_status=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.http_status ELSE links.http_status END,
+			failure_count=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.failure_count ELSE links.failure_count END,
+			status=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.status ELSE links.status END,
+			next_crawl_at=CASE WHEN excluded.retrieved_at >= links.retrieved_at THEN excluded.next_crawl_at ELSE links.next_crawl_at END,
+			retrieved_at=MAX(links.retrieved_at, excluded.retrieved_at)`
+
+	// The SQLite versions we support predate RETURNING so the mutation
+	// queries are paired with queries that read back the affected rows
+	// within the same transaction.
+	upsertedLinkQuery = "SELECT " + linkColumns + " FROM links WHERE url=?1"
+	findLinkQuery     = "SELECT " + linkColumns + ` FROM links
+		WHERE id=COALESCE((SELECT canonical FROM link_aliases WHERE alias=?1), ?1)`
+	findLinkByURLQuery = "SELECT " + linkColumns + ` FROM links
+		WHERE id=(
+			SELECT COALESCE(a.canonical, l.id) FROM links AS l
+			LEFT JOIN link_aliases AS a ON a.alias=l.id
+			WHERE l.url=?1
+		)`
+	resolveAliasQuery     = "SELECT canonical FROM link_aliases WHERE alias=?1"
+	upsertAliasQuery      = "INSERT INTO link_aliases (alias, canonical) VALUES (?1, ?2) ON CONFLICT (alias) DO UPDATE SET canonical=excluded.canonical"
+	repointAliasesQuery   = "UPDATE link_aliases SET canonical=?2 WHERE canonical=?1"
+	linkByIDQuery         = "SELECT " + linkColumns + " FROM links WHERE id=?1"
+	removeLinkQuery       = "DELETE FROM links WHERE id=?1"
+	linkEdgesQuery        = "SELECT " + edgeColumns + " FROM edges WHERE src=?1 OR dst=?1"
+	removeLinkEdgesQuery  = "DELETE FROM edges WHERE src=?1 OR dst=?1"
+	linksInPartitionQuery = "SELECT " + linkColumns + ` FROM links
+		WHERE id >= ?1 AND id < ?2 AND retrieved_at < ?3
+		ORDER BY id`
+	linksInPartitionAfterQuery = "SELECT " + linkColumns + ` FROM links
+		WHERE id >= ?1 AND id < ?2 AND retrieved_at < ?3 AND id > ?4
+		ORDER BY id`
+	linksDueForCrawlQuery = "SELECT " + linkColumns + ` FROM links
+		WHERE id >= ?1 AND id < ?2 AND next_crawl_at < ?3 AND status NOT IN (?4, ?5)
+		ORDER BY next_crawl_at, id`
+
+	// If insert duplicate change updated_at to current timestamp and replace
+	// the edge attributes with the submitted ones.
+	upsertEdgeQuery = `
+		INSERT INTO edges (` + edgeColumns + `)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9)
+		ON CONFLICT (src, dst) DO UPDATE SET
+			anchor_text=excluded.anchor_text,
+			nofollow=excluded.nofollow,
+			sponsored=excluded.sponsored,
+			ugc=excluded.ugc,
+			weight=excluded.weight,
+			updated_at=excluded.updated_at`
+	upsertedEdgeQuery     = "SELECT " + edgeColumns + " FROM edges WHERE src=?1 AND dst=?2"
+	edgesInPartitionQuery = "SELECT " + edgeColumns + ` FROM edges
+		WHERE src >= ?1 AND src < ?2 AND updated_at < ?3
+		ORDER BY id`
+	edgesInPartitionAfterQuery = "SELECT " + edgeColumns + ` FROM edges
+		WHERE src >= ?1 AND src < ?2 AND updated_at < ?3 AND id > ?4
+		ORDER BY id`
+	// The columns of canonicalEdgesQuery must match edgeColumns.
+	canonicalEdgesQuery = `
+		SELECT e.id, e.src, COALESCE(a.canonical, e.dst), e.updated_at, e.anchor_text, e.nofollow, e.sponsored, e.ugc, e.weight
+		FROM edges AS e LEFT JOIN link_aliases AS a ON a.alias=e.dst
+		WHERE e.src >= ?1 AND e.src < ?2 AND e.updated_at < ?3
+		ORDER BY e.id`
+	inEdgesQuery = "SELECT " + edgeColumns + ` FROM edges
+		WHERE dst = ?1 AND updated_at < ?2
+		ORDER BY id`
+	staleEdgesQuery       = "SELECT " + edgeColumns + " FROM edges WHERE src=?1 AND updated_at < ?2"
+	removeStaleEdgesQuery = "DELETE FROM edges WHERE src=?1 AND updated_at < ?2"
+
+	// Compile-time check for ensuring SQLiteGraph implements Graph.
+	_ graph.Graph = (*SQLiteGraph)(nil)
+)
+
+// SQLiteGraph implements a graph that persists links & edges to a SQLite
+// database file. The schema is created or upgraded when the graph is opened.
+//
+// All timestamps are stored as microseconds since the Unix epoch which
+// matches the precision of the cdb store.
+type SQLiteGraph struct {
+	db     *sql.DB
+	pruner *pruner.Pruner
+}
+
+// Config encapsulates the settings for a SQLiteGraph. Zero values are
+// replaced by the defaults listed next to each field.
+type Config struct {
+	// The time for which the events returned by Watch are retained and
+	// the interval at which older events are pruned. Defaults: 24h and
+	// 10m. A negative EventRetention disables pruning.
+	EventRetention     time.Duration
+	EventPruneInterval time.Duration
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.EventRetention == 0 {
+		cfg.EventRetention = 24 * time.Hour
+	}
+	if cfg.EventPruneInterval <= 0 {
+		cfg.EventPruneInterval = 10 * time.Minute
+	}
+}
+
+// NewSQLiteGraph opens the SQLite database at the specified path, creating
+// it if it does not exist, and applies any pending schema migrations. The
+// graph uses the default Config settings.
+func NewSQLiteGraph(path string) (*SQLiteGraph, error) {
+	return NewSQLiteGraphWithConfig(path, Config{})
+}
+
+// NewSQLiteGraphWithConfig behaves like NewSQLiteGraph but applies the
+// provided settings.
+func NewSQLiteGraphWithConfig(path string, cfg Config) (*SQLiteGraph, error) {
+	// The write-ahead log allows readers to proceed while a write is in
+	// progress. Transactions acquire the write lock upfront so that
+	// concurrent writers queue up on the busy timeout instead of failing
+	// when upgrading a read lock.
+	dsn := path + "?_foreign_keys=1&_journal_mode=WAL&_busy_timeout=10000&_txlock=immediate"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = migrate(context.Background(), db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	g := &SQLiteGraph{db: db}
+	if cfg.setDefaults(); cfg.EventRetention > 0 {
+		g.pruner = pruner.Start(g.PruneEvents, cfg.EventRetention, cfg.EventPruneInterval)
+	}
+	return g, nil
+}
+
+// Close closes the SQLite database or returns an error
+func (c *SQLiteGraph) Close() error {
+	if c.pruner != nil {
+		c.pruner.Stop()
+	}
+	return c.db.Close()
+}
+
+// UpsertLink creates a new link or updates an existing one and persists
+func (c *SQLiteGraph) UpsertLink(ctx context.Context, link *graph.Link) error {
+	if err := graph.ValidateLink(link); err != nil {
+		return xerrors.Errorf("upsert link: %w", err)
+	}
+
+	err := withTx(ctx, c.db, func(tx *sql.Tx) error {
+		stored, err := upsertLink(ctx, tx, link)
+		if err != nil {
+			return err
+		}
+
+		*link = *stored
+		return appendEvents(ctx, tx, linkEvent(graph.LinkUpserted, stored))
+	})
+	if err != nil {
+		return xerrors.Errorf("upsert link: %w", mapError(err))
+	}
+	return nil
+}
+
+// UpsertLinks creates or updates a batch of links within a single
+// transaction.
+func (c *SQLiteGraph) UpsertLinks(ctx context.Context, links []*graph.Link) error {
+	var errs []error
+	setErr := func(i int, err error) {
+		if errs == nil {
+			errs = make([]error, len(links))
+		}
+		errs[i] = xerrors.Errorf("upsert links: %w", err)
+	}
+
+	valid := make([]int, 0, len(links))
+	for i, link := range links {
+		if err := graph.ValidateLink(link); err != nil {
+			setErr(i, err)
+			continue
+		}
+		valid = append(valid, i)
+	}
+
+	// SQLite only rolls back the failing statement when a constraint is
+	// violated which allows the remaining links to be committed.
+	var rejected []int
+	err := withTx(ctx, c.db, func(tx *sql.Tx) error {
+		rejected = rejected[:0]
+		stored := make(map[int]*graph.Link, len(valid))
+		events := make([]*graph.Event, 0, len(valid))
+		for _, i := range valid {
+			link, err := upsertLink(ctx, tx, links[i])
+			if err == graph.ErrLinkIDInUse {
+				rejected = append(rejected, i)
+				continue
+			} else if err != nil {
+				return err
+			}
+			stored[i] = link
+			events = append(events, linkEvent(graph.LinkUpserted, link))
+		}
+
+		if err := appendEvents(ctx, tx, events...); err != nil {
+			return err
+		}
+		for i, link := range stored {
+			*links[i] = *link
+		}
+		return nil
+	})
+
+	if err != nil {
+		for _, i := range valid {
+			setErr(i, mapError(err))
+		}
+	} else {
+		for _, i := range rejected {
+			setErr(i, graph.ErrLinkIDInUse)
+		}
+	}
+
+	if errs != nil {
+		return &graph.BatchError{Errors: errs}
+	}
+	return nil
+}
+
+// upsertLink upserts a link as part of the provided transaction and returns
+// the stored link.
+func upsertLink(ctx context.Context, tx *sql.Tx, link *graph.Link) (*graph.Link, error) {
+	_, err := tx.ExecContext(
+		ctx,
+		upsertLinkQuery,
+		linkIDOrNew(link),
+		link.URL,
+		toDBTime(link.RetrievedAt),
+		link.ETag,
+		toDBTime(link.LastModified),
+		link.ContentHash,
+		link.HTTPStatus,
+		link.FailureCount,
+		link.Status,
+		toDBTime(link.NextCrawlAt),
+	)
+	if err != nil {
+		if isPrimaryKeyViolationError(err) {
+			return nil, graph.ErrLinkIDInUse
+		}
+		return nil, err
+	}
+	return scanLink(tx.QueryRowContext(ctx, upsertedLinkQuery, link.URL))
+}
+
+// FindLink looks up a link by its ID and returns
+func (c *SQLiteGraph) FindLink(ctx context.Context, id uuid.UUID) (*graph.Link, error) {
+	link, err := scanLink(c.db.QueryRowContext(ctx, findLinkQuery, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, xerrors.Errorf("find link: %w", graph.ErrNotFound)
+		}
+		return nil, xerrors.Errorf("find link: %w", mapError(err))
+	}
+	return link, nil
+}
+
+// FindLinkByURL looks up a link by its URL and returns it
+func (c *SQLiteGraph) FindLinkByURL(ctx context.Context, url string) (*graph.Link, error) {
+	link, err := scanLink(c.db.QueryRowContext(ctx, findLinkByURLQuery, url))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, xerrors.Errorf("find link by URL: %w", graph.ErrNotFound)
+		}
+		return nil, xerrors.Errorf("find link by URL: %w", mapError(err))
+	}
+	return link, nil
+}
+
+// RemoveLink removes the link with the specified ID together with any edges
+// that originate from or point to it.
+func (c *SQLiteGraph) RemoveLink(ctx context.Context, id uuid.UUID) error {
+	err := withTx(ctx, c.db, func(tx *sql.Tx) error {
+		// Remove the edges explicitly instead of relying on the ON DELETE
+		// CASCADE constraints so that we can emit an event for each one.
+		rows, err := tx.QueryContext(ctx, linkEdgesQuery, id)
+		if err != nil {
+			return err
+		}
+		events, err := edgeEvents(graph.EdgeRemoved, rows)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, removeLinkEdgesQuery, id); err != nil {
+			return err
+		}
+
+		link, err := scanLink(tx.QueryRowContext(ctx, linkByIDQuery, id))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return graph.ErrNotFound
+			}
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, removeLinkQuery, id); err != nil {
+			return err
+		}
+
+		events = append(events, linkEvent(graph.LinkRemoved, link))
+		return appendEvents(ctx, tx, events...)
+	})
+	if err != nil {
+		return xerrors.Errorf("remove link: %w", mapError(err))
+	}
+	return nil
+}
+
+// AddAlias records that the link with aliasID refers to the same document as
+// the link with canonicalID.
+func (c *SQLiteGraph) AddAlias(ctx context.Context, aliasID, canonicalID uuid.UUID) error {
+	err := withTx(ctx, c.db, func(tx *sql.Tx) error {
+		// Flatten alias chains by pointing the alias to the link at the
+		// end of the chain.
+		err := tx.QueryRowContext(ctx, resolveAliasQuery, canonicalID).Scan(&canonicalID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if canonicalID == aliasID {
+			return graph.ErrAliasCycle
+		}
+
+		if _, err = tx.ExecContext(ctx, upsertAliasQuery, aliasID, canonicalID); err != nil {
+			if isForeignKeyViolationError(err) {
+				err = graph.ErrNotFound
+			}
+			return err
+		}
+
+		// Any links that were aliases of aliasID now point to canonicalID.
+		_, err = tx.ExecContext(ctx, repointAliasesQuery, aliasID, canonicalID)
+		return err
+	})
+	if err != nil {
+		return xerrors.Errorf("add alias: %w", mapError(err))
+	}
+	return nil
+}
+
+// Links returns an iterator for the set of links whose IDs belong to the
+// [fromID, toID) range and were retrieved before the provided timestamp.
+func (c *SQLiteGraph) Links(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time) (graph.LinkIterator, error) {
+	return c.LinksAfter(ctx, fromID, toID, retrievedBefore, "")
+}
+
+// LinksAfter returns an iterator for the set of links whose IDs belong to
+// the [fromID, toID) range, were retrieved before the provided timestamp and
+// are positioned after the provided cursor.
+func (c *SQLiteGraph) LinksAfter(ctx context.Context, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	return linksAfter(ctx, c.db, fromID, toID, retrievedBefore, after)
+}
+
+// LinksDueForCrawl returns an iterator for the set of links whose IDs belong
+// to the [fromID, toID) range, can be crawled and are scheduled to be crawled
+// before the provided timestamp.
+func (c *SQLiteGraph) LinksDueForCrawl(ctx context.Context, fromID, toID uuid.UUID, dueBefore time.Time) (graph.LinkIterator, error) {
+	rows, err := c.db.QueryContext(
+		ctx,
+		linksDueForCrawlQuery,
+		fromID,
+		toID,
+		toDBTime(dueBefore),
+		graph.LinkStatusBlocked,
+		graph.LinkStatusGone,
+	)
+	if err != nil {
+		return nil, xerrors.Errorf("links due for crawl: %w", mapError(err))
+	}
+	return &linkIterator{ctx: ctx, rows: rows}, nil
+}
+
+// UpsertEdge creates a new edge or updates an existing edge.
+func (c *SQLiteGraph) UpsertEdge(ctx context.Context, edge *graph.Edge) error {
+	err := withTx(ctx, c.db, func(tx *sql.Tx) error {
+		stored, err := upsertEdge(ctx, tx, edge)
+		if err != nil {
+			return err
+		}
+
+		*edge = *stored
+		return appendEvents(ctx, tx, edgeEvent(graph.EdgeUpserted, stored))
+	})
+	if err != nil {
+		return xerrors.Errorf("upsert edge: %w", mapError(err))
+	}
+	return nil
+}
+
+// UpsertEdges creates or updates a batch of edges within a single
+// transaction.
+func (c *SQLiteGraph) UpsertEdges(ctx context.Context, edges []*graph.Edge) error {
+	var (
+		errs     []error
+		rejected map[int]error
+	)
+	err := withTx(ctx, c.db, func(tx *sql.Tx) error {
+		rejected = make(map[int]error)
+		stored := make(map[int]*graph.Edge, len(edges))
+		events := make([]*graph.Event, 0, len(edges))
+		for i, edge := range edges {
+			edge, err := upsertEdge(ctx, tx, edge)
+			if err == graph.ErrUnknownEdgeLinks || err == graph.ErrEdgeIDInUse {
+				rejected[i] = err
+				continue
+			} else if err != nil {
+				return err
+			}
+			stored[i] = edge
+			events = append(events, edgeEvent(graph.EdgeUpserted, edge))
+		}
+
+		if err := appendEvents(ctx, tx, events...); err != nil {
+			return err
+		}
+		for i, edge := range stored {
+			*edges[i] = *edge
+		}
+		return nil
+	})
+
+	if err != nil {
+		errs = make([]error, len(edges))
+		for i := range edges {
+			errs[i] = xerrors.Errorf("upsert edges: %w", mapError(err))
+		}
+	} else if len(rejected) != 0 {
+		errs = make([]error, len(edges))
+		for i, err := range rejected {
+			errs[i] = xerrors.Errorf("upsert edges: %w", err)
+		}
+	}
+
+	if errs != nil {
+		return &graph.BatchError{Errors: errs}
+	}
+	return nil
+}
+
+// upsertEdge upserts an edge as part of the provided transaction and returns
+// the stored edge.
+func upsertEdge(ctx context.Context, tx *sql.Tx, edge *graph.Edge) (*graph.Edge, error) {
+	_, err := tx.ExecContext(
+		ctx,
+		upsertEdgeQuery,
+		edgeIDOrNew(edge),
+		edge.Source,
+		edge.Destination,
+		toDBTime(time.Now()),
+		edge.AnchorText,
+		edge.Nofollow,
+		edge.Sponsored,
+		edge.UGC,
+		edge.Weight,
+	)
+	if err != nil {
+		if isForeignKeyViolationError(err) {
+			return nil, graph.ErrUnknownEdgeLinks
+		} else if isPrimaryKeyViolationError(err) {
+			return nil, graph.ErrEdgeIDInUse
+		}
+		return nil, err
+	}
+	return scanEdge(tx.QueryRowContext(ctx, upsertedEdgeQuery, edge.Source, edge.Destination))
+}
+
+// Edges returns an iterator for the set of edges whose source vertex IDs
+// belong to the [fromID, toID) range and were updated before the provided
+// timestamp.
+func (c *SQLiteGraph) Edges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	return c.EdgesAfter(ctx, fromID, toID, updatedBefore, "")
+}
+
+// EdgesAfter returns an iterator for the set of edges whose source vertex
+// IDs belong to the [fromID, toID) range, were updated before the provided
+// timestamp and are positioned after the provided cursor.
+func (c *SQLiteGraph) EdgesAfter(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (graph.EdgeIterator, error) {
+	return edgesAfter(ctx, c.db, fromID, toID, updatedBefore, after)
+}
+
+// CanonicalEdges returns an iterator for the set of edges whose source vertex
+// IDs belong to the [fromID, toID) range and were updated before the provided
+// timestamp. Edges pointing to an alias are rewritten to point to the
+// canonical link instead.
+func (c *SQLiteGraph) CanonicalEdges(ctx context.Context, fromID, toID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	rows, err := c.db.QueryContext(ctx, canonicalEdgesQuery, fromID, toID, toDBTime(updatedBefore))
+	if err != nil {
+		return nil, xerrors.Errorf("canonical edges: %w", mapError(err))
+	}
+	return &edgeIterator{ctx: ctx, rows: rows}, nil
+}
+
+// InEdges returns an iterator for the set of edges that point to the
+// specified destination link and were updated before the provided timestamp.
+func (c *SQLiteGraph) InEdges(ctx context.Context, dstID uuid.UUID, updatedBefore time.Time) (graph.EdgeIterator, error) {
+	rows, err := c.db.QueryContext(ctx, inEdgesQuery, dstID, toDBTime(updatedBefore))
+	if err != nil {
+		return nil, xerrors.Errorf("in edges: %w", mapError(err))
+	}
+	return &edgeIterator{ctx: ctx, rows: rows}, nil
+}
+
+// RemoveStaleEdges removes any edge that originates from the specified link ID
+// and was updated before the specified timestamp.
+func (c *SQLiteGraph) RemoveStaleEdges(ctx context.Context, fromID uuid.UUID, updatedBefore time.Time) error {
+	err := withTx(ctx, c.db, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, staleEdgesQuery, fromID, toDBTime(updatedBefore))
+		if err != nil {
+			return err
+		}
+		events, err := edgeEvents(graph.EdgeRemoved, rows)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, removeStaleEdgesQuery, fromID, toDBTime(updatedBefore)); err != nil {
+			return err
+		}
+		return appendEvents(ctx, tx, events...)
+	})
+	if err != nil {
+		return xerrors.Errorf("remove stale edges: %w", mapError(err))
+	}
+	return nil
+}
+
+// queryer is implemented by *sql.DB and *sql.Conn.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// linksAfter runs a links query against q.
+func linksAfter(ctx context.Context, q queryer, fromID, toID uuid.UUID, retrievedBefore time.Time, after graph.Cursor) (graph.LinkIterator, error) {
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, xerrors.Errorf("links: %w", err)
+	}
+
+	var rows *sql.Rows
+	if after == "" {
+		rows, err = q.QueryContext(ctx, linksInPartitionQuery, fromID, toID, toDBTime(retrievedBefore))
+	} else {
+		rows, err = q.QueryContext(ctx, linksInPartitionAfterQuery, fromID, toID, toDBTime(retrievedBefore), afterID)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("links: %w", mapError(err))
+	}
+	return &linkIterator{ctx: ctx, rows: rows, startCursor: after}, nil
+}
+
+// edgesAfter runs an edges query against q.
+func edgesAfter(ctx context.Context, q queryer, fromID, toID uuid.UUID, updatedBefore time.Time, after graph.Cursor) (graph.EdgeIterator, error) {
+	afterID, err := after.ID()
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", err)
+	}
+
+	var rows *sql.Rows
+	if after == "" {
+		rows, err = q.QueryContext(ctx, edgesInPartitionQuery, fromID, toID, toDBTime(updatedBefore))
+	} else {
+		rows, err = q.QueryContext(ctx, edgesInPartitionAfterQuery, fromID, toID, toDBTime(updatedBefore), afterID)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("edges: %w", mapError(err))
+	}
+	return &edgeIterator{ctx: ctx, rows: rows, startCursor: after}, nil
+}
+
+// withTx runs fn inside a transaction which is committed if fn returns
+// without an error and rolled back otherwise.
+func withTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// linkIDOrNew returns the ID of link or a new random ID if the link has no
+// ID yet. If the link URL already exists, the generated ID is discarded by
+// the ON CONFLICT clause of the upsert statement.
+func linkIDOrNew(link *graph.Link) uuid.UUID {
+	if link.ID != uuid.Nil {
+		return link.ID
+	}
+	return uuid.New()
+}
+
+// edgeIDOrNew returns the ID of edge or a new random ID if the edge has no
+// ID yet. If the edge links are already connected, the generated ID is
+// discarded by the ON CONFLICT clause of the upsert statement.
+func edgeIDOrNew(edge *graph.Edge) uuid.UUID {
+	if edge.ID != uuid.Nil {
+		return edge.ID
+	}
+	return uuid.New()
+}
+
+// toDBTime converts t to the number of microseconds since the Unix epoch.
+func toDBTime(t time.Time) int64 {
+	return t.Unix()*1e6 + int64(t.Nanosecond()/1e3)
+}
+
+// fromDBTime converts a number of microseconds since the Unix epoch to a
+// UTC timestamp.
+func fromDBTime(usec int64) time.Time {
+	sec, usec := usec/1e6, usec%1e6
+	if usec < 0 {
+		sec, usec = sec-1, usec+1e6
+	}
+	return time.Unix(sec, usec*1e3).UTC()
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanLink reads a link from a row whose columns match linkColumns.
+func scanLink(row rowScanner) (*graph.Link, error) {
+	var (
+		link                                   = new(graph.Link)
+		retrievedAt, lastModified, nextCrawlAt int64
+	)
+	err := row.Scan(
+		&link.ID,
+		&link.URL,
+		&retrievedAt,
+		&link.ETag,
+		&lastModified,
+		&link.ContentHash,
+		&link.HTTPStatus,
+		&link.FailureCount,
+		&link.Status,
+		&nextCrawlAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	link.RetrievedAt = fromDBTime(retrievedAt)
+	link.LastModified = fromDBTime(lastModified)
+	link.NextCrawlAt = fromDBTime(nextCrawlAt)
+	return link, nil
+}
+
+// scanEdge reads an edge from a row whose columns match edgeColumns.
+func scanEdge(row rowScanner) (*graph.Edge, error) {
+	var (
+		edge      = new(graph.Edge)
+		updatedAt int64
+	)
+	err := row.Scan(
+		&edge.ID,
+		&edge.Source,
+		&edge.Destination,
+		&updatedAt,
+		&edge.AnchorText,
+		&edge.Nofollow,
+		&edge.Sponsored,
+		&edge.UGC,
+		&edge.Weight,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	edge.UpdatedAt = fromDBTime(updatedAt)
+	return edge, nil
+}
@@ -0,0 +1,151 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"github.com/kyteproject/search-engine/linkgraph/graph"
+	"github.com/kyteproject/search-engine/linkgraph/graph/graphtest"
+	"golang.org/x/xerrors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+var _ = gc.Suite(new(SQLiteGraphTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type SQLiteGraphTestSuite struct {
+	graphtest.SuiteBase
+	path string
+	g    *SQLiteGraph
+}
+
+func (s *SQLiteGraphTestSuite) SetUpTest(c *gc.C) {
+	s.path = filepath.Join(c.MkDir(), "graph.db")
+	g, err := NewSQLiteGraph(s.path)
+	c.Assert(err, gc.IsNil)
+	s.g = g
+	s.SetGraph(g)
+}
+
+func (s *SQLiteGraphTestSuite) TearDownTest(c *gc.C) {
+	c.Assert(s.g.Close(), gc.IsNil)
+}
+
+func (s *SQLiteGraphTestSuite) TestReopenKeepsGraph(c *gc.C) {
+	ctx := context.TODO()
+	link := &graph.Link{URL: "https://example.com", RetrievedAt: time.Now().Truncate(time.Second).UTC()}
+	c.Assert(s.g.UpsertLink(ctx, link), gc.IsNil)
+
+	// Reopening the database must not re-apply the migrations.
+	c.Assert(s.g.Close(), gc.IsNil)
+	g, err := NewSQLiteGraph(s.path)
+	c.Assert(err, gc.IsNil)
+	s.g = g
+
+	var version uint64
+	c.Assert(s.g.db.QueryRow(schemaVersionQuery).Scan(&version, new(bool)), gc.IsNil)
+	list, err := upMigrations()
+	c.Assert(err, gc.IsNil)
+	c.Assert(version, gc.Equals, list[len(list)-1].version)
+
+	got, err := s.g.FindLink(ctx, link.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, gc.DeepEquals, link)
+}
+
+func (s *SQLiteGraphTestSuite) TestDirtySchema(c *gc.C) {
+	_, err := s.g.db.Exec("UPDATE schema_migrations SET dirty=true")
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.g.Close(), gc.IsNil)
+
+	_, err = NewSQLiteGraph(s.path)
+	c.Assert(xerrors.Is(err, ErrDirtySchema), gc.Equals, true, gc.Commentf("got error: %v", err))
+
+	// Provide TearDownTest with an open graph.
+	s.g, err = NewSQLiteGraph(filepath.Join(c.MkDir(), "graph.db"))
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *SQLiteGraphTestSuite) TestConcurrentWriters(c *gc.C) {
+	const (
+		numWriters = 8
+		numLinks   = 50
+	)
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, numWriters)
+	)
+	for w := 0; w < numWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < numLinks; i++ {
+				// All writers share the same URLs to force conflicts.
+				link := &graph.Link{URL: fmt.Sprintf("https://example.com/%d", i)}
+				if err := s.g.UpsertLink(context.TODO(), link); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Fatalf("unexpected error: %v", err)
+	}
+
+	var count int
+	c.Assert(s.g.db.QueryRow("SELECT COUNT(*) FROM links").Scan(&count), gc.IsNil)
+	c.Assert(count, gc.Equals, numLinks)
+}
+
+func (s *SQLiteGraphTestSuite) TestEventPruning(c *gc.C) {
+	countEvents := func() int {
+		var count int
+		c.Assert(s.g.db.QueryRow("SELECT COUNT(*) FROM graph_events").Scan(&count), gc.IsNil)
+		return count
+	}
+
+	c.Assert(s.g.UpsertLink(context.TODO(), &graph.Link{URL: "https://example.com"}), gc.IsNil)
+	c.Assert(countEvents(), gc.Equals, 1)
+
+	// Reopen the graph with a short retention period.
+	c.Assert(s.g.Close(), gc.IsNil)
+	g, err := NewSQLiteGraphWithConfig(s.path, Config{
+		EventRetention:     time.Millisecond,
+		EventPruneInterval: 5 * time.Millisecond,
+	})
+	c.Assert(err, gc.IsNil)
+	s.g = g
+
+	deadline := time.Now().Add(5 * time.Second)
+	for countEvents() != 0 {
+		if time.Now().After(deadline) {
+			c.Fatal("timed out waiting for events to be pruned")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (s *SQLiteGraphTestSuite) TestTimeConversion(c *gc.C) {
+	specs := []time.Time{
+		{},
+		time.Unix(0, 0),
+		time.Unix(-1, 999999000),
+		time.Date(1969, 12, 31, 23, 59, 59, 1000, time.UTC),
+		time.Date(2021, 3, 14, 15, 9, 26, 535897000, time.UTC),
+		time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec)
+		c.Assert(fromDBTime(toDBTime(spec)), gc.Equals, spec.UTC())
+	}
+}